@@ -7,6 +7,10 @@ package buffer
 
 import (
 	"goostub/common"
+	"goostub/recovery"
+	"goostub/storage/disk"
+	"goostub/storage/page"
+	"sync"
 )
 
 type CallbackType int
@@ -18,10 +22,251 @@ const (
 
 type bufferpoolCallback func(t CallbackType, pid common.PageID)
 
+/**
+ * BufferPoolManager reads disk pages to and from its internal buffer pool.
+ */
 type BufferPoolManager struct {
-	// TODO
+	poolSize    uint32
+	pages       []*page.PageInstance
+	diskManager *disk.DiskManager
+	logManager  *recovery.LogManager
+	// page id -> frame id of the pages currently in the buffer pool
+	pageTable map[common.PageID]common.FrameID
+	replacer  Replacer
+	// frames that don't hold any page
+	freeList []common.FrameID
+	latch    sync.Mutex
 }
 
+/**
+ * Creates a new BufferPoolManager.
+ * @param poolSize the size of the buffer pool
+ * @param diskManager the disk manager
+ * @param logManager the log manager (for testing only: nil = disable logging)
+ */
+func NewBufferPoolManager(poolSize uint32, diskManager *disk.DiskManager, logManager *recovery.LogManager) *BufferPoolManager {
+	m := &BufferPoolManager{
+		poolSize:    poolSize,
+		pages:       make([]*page.PageInstance, poolSize),
+		diskManager: diskManager,
+		logManager:  logManager,
+		pageTable:   make(map[common.PageID]common.FrameID),
+		replacer:    NewClockReplacer(int64(poolSize)),
+		freeList:    make([]common.FrameID, poolSize),
+	}
+
+	// Initially, every page is in the free list.
+	for i := uint32(0); i < poolSize; i++ {
+		m.pages[i] = page.NewPage().(*page.PageInstance)
+		m.freeList[i] = common.FrameID(i)
+	}
+	return m
+}
+
+/** @return size of the buffer pool */
+func (m *BufferPoolManager) GetPoolSize() uint32 {
+	return m.poolSize
+}
+
+/**
+ * Fetch the requested page from the buffer pool.
+ * @param pid id of page to be fetched
+ * @param fn optional grading callback
+ * @return the requested page, nil if no frame can be freed for it
+ */
+func (m *BufferPoolManager) FetchPage(pid common.PageID, fn bufferpoolCallback) page.Page {
+	grading(fn, BEFORE, pid)
+	defer grading(fn, AFTER, pid)
+
+	m.latch.Lock()
+	defer m.latch.Unlock()
+
+	// 1. If the page is already in the pool, pin it and return it immediately.
+	if frameId, ok := m.pageTable[pid]; ok {
+		p := m.pages[frameId]
+		p.PinCount++
+		m.replacer.Pin(frameId)
+		return p
+	}
+
+	// 2. Otherwise find a replacement frame from the free list or the replacer.
+	frameId, ok := m.findFrame()
+	if !ok {
+		return nil
+	}
+
+	// 3. Read in the page content from disk.
+	p := m.pages[frameId]
+	m.pageTable[pid] = frameId
+	p.PageID = pid
+	p.PinCount = 1
+	p.Dirty = false
+	m.diskManager.ReadPage(pid, p.GetData())
+	m.replacer.Pin(frameId)
+	return p
+}
+
+/**
+ * Unpin the target page from the buffer pool.
+ * @param pid id of page to be unpinned
+ * @param isDirty true if the page should be marked as dirty, false otherwise
+ * @param fn optional grading callback
+ * @return false if the page pin count is <= 0 before this call, true otherwise
+ */
 func (m *BufferPoolManager) UnpinPage(pid common.PageID, isDirty bool, fn bufferpoolCallback) bool {
-	// TODO
+	grading(fn, BEFORE, pid)
+	defer grading(fn, AFTER, pid)
+
+	m.latch.Lock()
+	defer m.latch.Unlock()
+
+	frameId, ok := m.pageTable[pid]
+	if !ok {
+		return false
+	}
+	p := m.pages[frameId]
+	if p.PinCount <= 0 {
+		return false
+	}
+
+	p.Dirty = p.Dirty || isDirty
+	p.PinCount--
+	if p.PinCount == 0 {
+		m.replacer.Unpin(frameId)
+	}
+	return true
+}
+
+/**
+ * Flushes the target page to disk.
+ * @param pid id of page to be flushed, cannot be InvalidPageID
+ * @param fn optional grading callback
+ * @return false if the page could not be found in the page table, true otherwise
+ */
+func (m *BufferPoolManager) FlushPage(pid common.PageID, fn bufferpoolCallback) bool {
+	grading(fn, BEFORE, pid)
+	defer grading(fn, AFTER, pid)
+
+	m.latch.Lock()
+	defer m.latch.Unlock()
+
+	frameId, ok := m.pageTable[pid]
+	if !ok || pid == common.InvalidPageID {
+		return false
+	}
+	m.flushFrame(frameId)
+	return true
+}
+
+/**
+ * Creates a new page in the buffer pool.
+ * @param[out] pid id of created page
+ * @param fn optional grading callback
+ * @return the new page, nil if all frames are currently in use and not evictable (in another word, pinned)
+ */
+func (m *BufferPoolManager) NewPage(pid *common.PageID, fn bufferpoolCallback) page.Page {
+	grading(fn, BEFORE, common.InvalidPageID)
+	defer func() { grading(fn, AFTER, *pid) }()
+
+	m.latch.Lock()
+	defer m.latch.Unlock()
+
+	*pid = common.InvalidPageID
+	frameId, ok := m.findFrame()
+	if !ok {
+		return nil
+	}
+
+	*pid = m.diskManager.AllocatePage()
+	p := m.pages[frameId]
+	m.pageTable[*pid] = frameId
+	p.PageID = *pid
+	p.PinCount = 1
+	p.Dirty = false
+	p.Data = [common.PageSize]byte{}
+	m.replacer.Pin(frameId)
+	return p
+}
+
+/**
+ * Deletes a page from the buffer pool.
+ * @param pid id of page to be deleted
+ * @param fn optional grading callback
+ * @return false if the page exists but could not be deleted, true if the page didn't exist or deletion succeeded
+ */
+func (m *BufferPoolManager) DeletePage(pid common.PageID, fn bufferpoolCallback) bool {
+	grading(fn, BEFORE, pid)
+	defer grading(fn, AFTER, pid)
+
+	m.latch.Lock()
+	defer m.latch.Unlock()
+
+	frameId, ok := m.pageTable[pid]
+	if !ok {
+		m.diskManager.DeallocatePage(pid)
+		return true
+	}
+	p := m.pages[frameId]
+	if p.PinCount > 0 {
+		return false
+	}
+
+	delete(m.pageTable, pid)
+	m.replacer.Pin(frameId)
+	m.diskManager.DeallocatePage(pid)
+	p.PageID = common.InvalidPageID
+	p.Dirty = false
+	p.Data = [common.PageSize]byte{}
+	m.freeList = append(m.freeList, frameId)
+	return true
+}
+
+/**
+ * Flushes all the pages in the buffer pool to disk.
+ * @param fn optional grading callback
+ */
+func (m *BufferPoolManager) FlushAllPages(fn bufferpoolCallback) {
+	grading(fn, BEFORE, common.InvalidPageID)
+	defer grading(fn, AFTER, common.InvalidPageID)
+
+	m.latch.Lock()
+	defer m.latch.Unlock()
+
+	for _, frameId := range m.pageTable {
+		m.flushFrame(frameId)
+	}
+}
+
+// helper functions, latch must be held
+
+// pick a frame from the free list first, otherwise evict a victim
+func (m *BufferPoolManager) findFrame() (common.FrameID, bool) {
+	if n := len(m.freeList); n > 0 {
+		frameId := m.freeList[n-1]
+		m.freeList = m.freeList[:n-1]
+		return frameId, true
+	}
+
+	var frameId common.FrameID
+	if !m.replacer.Victim(&frameId) {
+		return 0, false
+	}
+	p := m.pages[frameId]
+	if p.Dirty {
+		m.flushFrame(frameId)
+	}
+	delete(m.pageTable, p.PageID)
+	return frameId, true
+}
+
+func (m *BufferPoolManager) flushFrame(frameId common.FrameID) {
+	p := m.pages[frameId]
+	m.diskManager.WritePage(p.PageID, p.GetData())
+	p.Dirty = false
+}
+
+func grading(fn bufferpoolCallback, t CallbackType, pid common.PageID) {
+	if fn != nil {
+		fn(t, pid)
+	}
 }
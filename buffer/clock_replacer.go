@@ -1,5 +1,5 @@
 // Copyright (c) 2021 Qitian Zeng
-// 
+//
 // This software is released under the MIT License.
 // https://opensource.org/licenses/MIT
 
@@ -7,16 +7,79 @@ package buffer
 
 import (
 	"goostub/common"
+	"sync"
 )
 
+/**
+ * ClockReplacer implements the clock replacement policy, which approximates
+ * the Least Recently Used policy.
+ */
 type ClockReplacer struct {
-	// Student's code
+	latch sync.Mutex
+	// whether the frame is in the replacer, i.e. unpinned
+	inReplacer []bool
+	// reference bit of each frame
+	ref  []bool
+	hand int64
+	size int64
 }
 
-// Student: implement everything below
+func NewClockReplacer(numPages int64) *ClockReplacer {
+	return &ClockReplacer{
+		inReplacer: make([]bool, numPages),
+		ref:        make([]bool, numPages),
+	}
+}
+
+func (r *ClockReplacer) Victim(frameId *common.FrameID) bool {
+	r.latch.Lock()
+	defer r.latch.Unlock()
+
+	if r.size == 0 {
+		return false
+	}
+
+	// at most two rounds: the first one may only clear reference bits
+	for {
+		cur := r.hand
+		r.hand = (r.hand + 1) % int64(len(r.ref))
+		if !r.inReplacer[cur] {
+			continue
+		}
+		if r.ref[cur] {
+			r.ref[cur] = false
+			continue
+		}
+		r.inReplacer[cur] = false
+		r.size--
+		*frameId = common.FrameID(cur)
+		return true
+	}
+}
+
+func (r *ClockReplacer) Pin(frameId common.FrameID) {
+	r.latch.Lock()
+	defer r.latch.Unlock()
 
-func NewClockReplacer(numPages int64) *ClockReplacer
-func (r ClockReplacer) Victim(frameId *common.FrameID) bool
-func (r ClockReplacer) Pin(frameId common.FrameID)
-func (r ClockReplacer) Unpin(frameId common.FrameID)
-func (r ClockReplacer) Size() int64
+	if r.inReplacer[frameId] {
+		r.inReplacer[frameId] = false
+		r.size--
+	}
+}
+
+func (r *ClockReplacer) Unpin(frameId common.FrameID) {
+	r.latch.Lock()
+	defer r.latch.Unlock()
+
+	if !r.inReplacer[frameId] {
+		r.inReplacer[frameId] = true
+		r.size++
+	}
+	r.ref[frameId] = true
+}
+
+func (r *ClockReplacer) Size() int64 {
+	r.latch.Lock()
+	defer r.latch.Unlock()
+	return r.size
+}
@@ -6,6 +6,8 @@
 package catalog
 
 import (
+	"fmt"
+	"github.com/go-kit/kit/log/level"
 	"goostub/buffer"
 	"goostub/common"
 	"goostub/concurrency"
@@ -14,6 +16,9 @@ import (
 	"goostub/schema"
 	"goostub/storage/index"
 	"goostub/storage/table"
+	"goostub/types"
+	"sort"
+	"sync"
 	"sync/atomic"
 )
 
@@ -22,15 +27,56 @@ type TableInfo struct {
 	Name   string
 	Table  *table.TableHeap
 	Oid    common.TableOID
+	// collected by AnalyzeTable, nil until the table is analyzed
+	Stats *TableStatistics
+}
+
+/** The kind of an index, which the catalog creates it as. */
+type IndexType uint8
+
+const (
+	// an extendible hash table, the default
+	HashTableIndex IndexType = iota
+	// an ordered index that supports range scans, its keys have a fixed size
+	BPlusTreeIndex
+	// a Bloom filter, it only tells which keys are absent, see index.BloomFilterIndex
+	BloomFilterIndex
+	// a full-text index on VARCHAR columns, see index.InvertedIndex
+	InvertedIndex
+)
+
+/** How CreateIndex builds an index. The zero value is a hash index without constraint, hashing with xxhash. */
+type IndexOptions struct {
+	Type IndexType
+	// whether the keys must be unique, checked against the live tuples of the table
+	Constraint index.IndexConstraint
+	// the built-in hash function of a hash index, or of the term dictionary of an inverted index
+	HashSpec hash.HashSpec
+	// how full a bulk build leaves the pages of a B+Tree, 0 = index.DefaultFillFactor
+	FillFactor float64
+	// the order of each key column of a B+Tree, nil = all ASC NULLS FIRST
+	KeyOrders []index.KeyOrder
+	// the false positive rate a Bloom filter is sized for, 0 = index.DefaultFalsePositiveRate
+	FalsePositiveRate float64
 }
 
 type IndexInfo struct {
 	KeySchema schema.Schema // schema for the index key
 	Name      string
-	Index     *index.Index
+	Index     index.Index
 	IndexOid  common.IndexOID
 	TableName string
 	KeySize   uintptr // size of the index key in bytes
+	Type      IndexType
+
+	// guards the build state below, writers and a concurrent build meet there
+	buildLatch sync.Mutex
+	// queries may use the index, false while it is built concurrently
+	valid bool
+	// the build failed and the index was dropped, writes to it are ignored
+	dropped bool
+	// the writes to the table since a concurrent build started, applied once the index is filled
+	sideLog []sideLogEntry
 }
 
 /**
@@ -49,6 +95,8 @@ type Catalog struct {
 	//table name->index name->index oid
 	indexNames   map[string]map[string]common.IndexOID
 	nextIndexOid common.IndexOID //need atomic operation
+	// protects indexes and indexNames, writers look up the indexes of a table while an index is created concurrently
+	indexLatch sync.RWMutex
 }
 
 func NewCatalog(bpm *buffer.BufferPoolManager, lockManager *concurrency.LockManager, logManager *recovery.LogManager) *Catalog {
@@ -87,7 +135,9 @@ func (c *Catalog) CreateTable(txn common.Transaction, name string, schema *schem
 	// update the internal tracking mechanisms
 	c.tables[tableOid] = meta
 	c.tableNames[name] = tableOid
+	c.indexLatch.Lock()
 	c.indexNames[name] = make(map[string]common.IndexOID)
+	c.indexLatch.Unlock()
 
 	return meta
 }
@@ -124,15 +174,313 @@ func (c *Catalog) GetTableByOid(tableOid common.TableOID) *TableInfo {
  * @param schema The schema of the table
  * @param key_schema The schema of the key
  * @param key_attrs Key attributes
- * @param keysize Size of the key
- * @param (optional)hash_function The hash function for the index, none = default
- * @return A (non-owning) pointer to the metadata of the new table
+ * @param keysize Size of the key, 0 for the variable-length keys of a hash index
+ * @param options The kind of the index, its constraint and how to build it
+ * @return A (non-owning) pointer to the metadata of the new table, nil if the table already breaks the constraint,
+ * the index kind can't be built on the key or an option is invalid
  */
-func (c *Catalog) CreateIndex(txn common.Transaction, indexName string, tableName string, schema *schema.Schema, keySchema *schema.Schema, keyAttrs []string, keysize uintptr, hashFunc ...hash.HashFunc) *IndexInfo {
-	// TODO
+func (c *Catalog) CreateIndex(txn common.Transaction, indexName string, tableName string, schema *schema.Schema, keySchema *schema.Schema, keyAttrs []string, keysize uintptr, options IndexOptions) *IndexInfo {
+	info, tableInfo := c.newIndexInfo(indexName, tableName, schema, keyAttrs, keysize, options)
+	if info == nil {
+		return nil
+	}
+
+	// populate the index with the existing data of the table, in one go if the index can
+	if err := populateIndex(info.Index, c.collectEntries(tableInfo, info, txn), txn); err != nil {
+		level.Warn(common.Logger).Log("Failed to create index ", indexName, ": ", err)
+		freeIndexPages(info.Index)
+		return nil
+	}
+	info.valid = true
+	if !c.addIndex(info) {
+		freeIndexPages(info.Index)
+		return nil
+	}
+	return info
+}
+
+// build the metadata and the empty index of a new index, nil if the table or a key column doesn't exist, the index does or an option is invalid
+func (c *Catalog) newIndexInfo(indexName string, tableName string, schema *schema.Schema, keyAttrs []string, keysize uintptr, opts IndexOptions) (*IndexInfo, *TableInfo) {
+	tableOid, ok := c.tableNames[tableName]
+	if !ok {
+		// table doesn't exist
+		return nil, nil
+	}
+	if c.GetIndexByName(indexName, tableName) != nil {
+		// index already exists
+		return nil, nil
+	}
+
+	attrs := make([]uint32, len(keyAttrs))
+	for i, name := range keyAttrs {
+		colIdx := schema.GetColIdx(name)
+		if colIdx < 0 {
+			return nil, nil
+		}
+		attrs[i] = uint32(colIdx)
+	}
+
+	tableInfo := c.tables[tableOid]
+	meta := index.NewIndexMetadata(indexName, tableName, attrs, schema)
+	meta.SetConstraint(opts.Constraint, tableInfo.Table.IsLive)
+	meta.SetHashSpec(opts.HashSpec)
+	if opts.FillFactor != 0 {
+		if opts.FillFactor < 0 || opts.FillFactor > 1 {
+			return nil, nil
+		}
+		meta.SetFillFactor(opts.FillFactor)
+	}
+	if opts.FalsePositiveRate != 0 {
+		if opts.FalsePositiveRate < 0 || opts.FalsePositiveRate >= 1 {
+			return nil, nil
+		}
+		meta.SetFalsePositiveRate(opts.FalsePositiveRate)
+	}
+	if opts.KeyOrders != nil {
+		if len(opts.KeyOrders) != len(attrs) {
+			return nil, nil
+		}
+		meta.SetKeyOrders(opts.KeyOrders)
+	}
+	idx := c.newIndex(meta, opts.Type, keysize)
+	if idx == nil {
+		return nil, nil
+	}
+	return &IndexInfo{
+		KeySchema: *meta.GetKeySchema(),
+		Name:      indexName,
+		Index:     idx,
+		TableName: tableName,
+		KeySize:   keysize,
+		Type:      opts.Type,
+	}, tableInfo
+}
+
+// create an empty index, nil if the key size, the key columns or the hash function of the metadata don't suit its kind
+func (c *Catalog) newIndex(meta *index.IndexMetadata, indexType IndexType, keysize uintptr) index.Index {
+	switch indexType {
+	case HashTableIndex:
+		return index.NewIndex[*index.ExtendibleHashTableIndex](meta, c.bpm, keysize)
+	case BPlusTreeIndex:
+		if keysize == 0 {
+			return nil
+		}
+		return index.NewIndex[*index.BPlusTreeIndex](meta, c.bpm, keysize)
+	case BloomFilterIndex:
+		// sized for the default capacity, a bulk build from a larger table grows it
+		return index.NewIndex[*index.BloomFilterIndex](meta, c.bpm)
+	case InvertedIndex:
+		return index.NewIndex[*index.InvertedIndex](meta, c.bpm)
+	}
+	return nil
+}
+
+// the entries of the tuples of the table in an index
+func (c *Catalog) collectEntries(tableInfo *TableInfo, info *IndexInfo, txn common.Transaction) []index.IndexEntry {
+	var entries []index.IndexEntry
+	keyAttrs := info.Index.GetKeyAttrs()
+	it := tableInfo.Table.Begin(txn)
+	for tuple, ok := it.Next(); ok; tuple, ok = it.Next() {
+		entries = append(entries, index.IndexEntry{Key: tuple.KeyFromTuple(&tableInfo.Schema, &info.KeySchema, keyAttrs), RID: tuple.GetRID()})
+	}
+	return entries
+}
+
+// give the index an oid and register it, false if the table already has an index of its name
+func (c *Catalog) addIndex(info *IndexInfo) bool {
+	c.indexLatch.Lock()
+	defer c.indexLatch.Unlock()
+	if _, ok := c.indexNames[info.TableName][info.Name]; ok {
+		return false
+	}
+	info.IndexOid = common.IndexOID(atomic.AddUint32((*uint32)(&c.nextIndexOid), 1) - 1)
+	c.indexes[info.IndexOid] = info
+	c.indexNames[info.TableName][info.Name] = info.IndexOid
+	return true
+}
+
+func (c *Catalog) removeIndex(info *IndexInfo) {
+	c.indexLatch.Lock()
+	defer c.indexLatch.Unlock()
+	delete(c.indexes, info.IndexOid)
+	delete(c.indexNames[info.TableName], info.Name)
+}
+
+// give the pages of an index nobody uses back to the buffer pool, if it can
+func freeIndexPages(idx index.Index) {
+	if freer, ok := idx.(index.PageFreer); ok {
+		freer.FreePages()
+	}
+}
+
+// add the entries to a new index, by a bulk build if the index supports it
+func populateIndex(idx index.Index, entries []index.IndexEntry, txn common.Transaction) error {
+	if builder, ok := idx.(index.BulkBuilder); ok {
+		return builder.BulkBuild(entries, txn)
+	}
+	for _, e := range entries {
+		if err := idx.InsertEntry(e.Key, e.RID, txn); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+/**
+ * Query index metadata by OID
+ * @param index_oid The OID of the index to query
+ * @return A pointer to the metadata for the index
+ */
 func (c *Catalog) GetIndex(indexOid common.IndexOID) *IndexInfo {
-	//TODO
+	c.indexLatch.RLock()
+	defer c.indexLatch.RUnlock()
+	if info, ok := c.indexes[indexOid]; ok {
+		return info
+	}
+	return nil
+}
+
+/**
+ * Query index metadata by name
+ * @param index_name The name of the index
+ * @param table_name The name of the table on which the index is built
+ * @return A pointer to the metadata for the index
+ */
+func (c *Catalog) GetIndexByName(indexName string, tableName string) *IndexInfo {
+	c.indexLatch.RLock()
+	defer c.indexLatch.RUnlock()
+	if oid, ok := c.indexNames[tableName][indexName]; ok {
+		return c.indexes[oid]
+	}
+	return nil
+}
+
+/**
+ * Get all of the indexes for the table identified by `table_name`, including
+ * the indexes being built concurrently: writers must keep all of them up to date.
+ * @param table_name The name of the table for which indexes should be retrieved
+ * @return A vector of IndexInfo* for each index on the given table, empty vector
+ * in the event that the table exists but no indexes have been created for it
+ */
+func (c *Catalog) GetTableIndexes(tableName string) []*IndexInfo {
+	c.indexLatch.RLock()
+	defer c.indexLatch.RUnlock()
+	var infos []*IndexInfo
+	for _, oid := range c.indexNames[tableName] {
+		infos = append(infos, c.indexes[oid])
+	}
+	// map iteration order is random, keep the creation order
+	sort.Slice(infos, func(i, j int) bool { return infos[i].IndexOid < infos[j].IndexOid })
+	return infos
+}
+
+/**
+ * Get the indexes of a table that queries may use, i.e. without the indexes
+ * that are still being built.
+ * @param tableName The name of the table
+ * @return the valid indexes of the table, in creation order
+ */
+func (c *Catalog) GetValidTableIndexes(tableName string) []*IndexInfo {
+	var infos []*IndexInfo
+	for _, info := range c.GetTableIndexes(tableName) {
+		if info.IsValid() {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+/**
+ * Vacuum a table and move the entries of its indexes along with the tuples.
+ * An index that can't take the new entry of a moved tuple is left without it,
+ * vacuum then stops and reports the error in VacuumStats.Err, see CheckIndex
+ * to repair the index.
+ * @param tableName the name of the table to vacuum
+ * @param blocker stops transactions while tuples move, usually the transaction manager
+ * @return what vacuum did, nil if the table doesn't exist
+ */
+func (c *Catalog) VacuumTable(tableName string, blocker table.TransactionBlocker) *table.VacuumStats {
+	tableInfo := c.GetTableByName(tableName)
+	if tableInfo == nil {
+		return nil
+	}
+	indexes := c.GetTableIndexes(tableName)
+	return tableInfo.Table.Vacuum(blocker, func(from common.RID, to common.RID, tuple *table.Tuple) error {
+		var err error
+		for _, indexInfo := range indexes {
+			key := tuple.KeyFromTuple(&tableInfo.Schema, &indexInfo.KeySchema, indexInfo.Index.GetKeyAttrs())
+			indexInfo.DeleteEntry(key, from, nil)
+			if insertErr := indexInfo.InsertEntry(key, to, nil); insertErr != nil {
+				level.Error(common.Logger).Log("Index ", indexInfo.Name, " lost the entry of tuple ", from, " moved to ", to, ": ", insertErr)
+				if err == nil {
+					err = fmt.Errorf("index %s lost the entry of tuple %v moved to %v: %w", indexInfo.Name, from, to, insertErr)
+				}
+			}
+		}
+		return err
+	})
+}
+
+/**
+ * Add a column at the end of a table. Existing tuples aren't rewritten, they
+ * read the default value until they are updated.
+ * @param tableName the name of the table
+ * @param colName the name of the new column
+ * @param typeId the type of the new column
+ * @param defaultValue the value of the column in the existing tuples, NULL if nil
+ * @param blocker stops transactions while the schema changes, may be nil if nobody else uses the table
+ */
+func (c *Catalog) AlterTableAddColumn(tableName string, colName string, typeId types.TypeID, defaultValue *types.Value, blocker table.TransactionBlocker) error {
+	tableInfo := c.GetTableByName(tableName)
+	if tableInfo == nil {
+		return fmt.Errorf("table %s doesn't exist", tableName)
+	}
+	if blocker != nil {
+		// readers use the schema of the table in place
+		blocker.BlockAllTransactions()
+		defer blocker.ResumeTransactions()
+	}
+	next, err := tableInfo.Schema.AddColumn(colName, typeId, defaultValue)
+	if err != nil {
+		return err
+	}
+	tableInfo.Schema = *next
+	return nil
+}
+
+/**
+ * Drop a column of a table. Existing tuples keep its data until they are
+ * updated, but it can't be read anymore. Indexed columns can't be dropped.
+ * @param tableName the name of the table
+ * @param colName the name of the column
+ * @param blocker stops transactions while the schema changes, may be nil if nobody else uses the table
+ */
+func (c *Catalog) AlterTableDropColumn(tableName string, colName string, blocker table.TransactionBlocker) error {
+	tableInfo := c.GetTableByName(tableName)
+	if tableInfo == nil {
+		return fmt.Errorf("table %s doesn't exist", tableName)
+	}
+	if blocker != nil {
+		// readers use the schema of the table and the key attributes of its indexes in place
+		blocker.BlockAllTransactions()
+		defer blocker.ResumeTransactions()
+	}
+	colIdx := tableInfo.Schema.GetColIdx(colName)
+	indexes := c.GetTableIndexes(tableName)
+	for _, indexInfo := range indexes {
+		for _, attr := range indexInfo.Index.GetKeyAttrs() {
+			if int(attr) == colIdx {
+				return fmt.Errorf("column %s is used by index %s", colName, indexInfo.Name)
+			}
+		}
+	}
+	next, err := tableInfo.Schema.DropColumn(colName)
+	if err != nil {
+		return err
+	}
+	for _, indexInfo := range indexes {
+		indexInfo.Index.GetMetadata().DropColumn(uint32(colIdx))
+	}
+	tableInfo.Schema = *next
 	return nil
 }
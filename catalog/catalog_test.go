@@ -0,0 +1,463 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log/level"
+	"github.com/stretchr/testify/assert"
+	"goostub/buffer"
+	"goostub/common"
+	"goostub/schema"
+	"goostub/storage/disk"
+	"goostub/storage/index"
+	"goostub/storage/table"
+	"goostub/types"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func init() {
+	common.InitLogger(level.AllowNone())
+}
+
+func TestVacuumTable(t *testing.T) {
+	a := assert.New(t)
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	cat := NewCatalog(buffer.NewBufferPoolManager(16, dm, nil), nil, nil)
+	s, err := schema.ParseSchema("id:INTEGER, name:VARCHAR")
+	a.Nil(err)
+	info := cat.CreateTable(nil, "t", s)
+	idx := cat.CreateIndex(nil, "idx_id", "t", s, schema.CopySchema(s, []uint32{0}), []string{"id"}, 8, IndexOptions{})
+	a.NotNil(idx)
+
+	rids := make([]common.RID, 2000)
+	for i := range rids {
+		tuple := table.NewTuple([]*types.Value{
+			types.NewValue(types.INTEGER, int32(i)),
+			types.NewValue(types.VARCHAR, "some padding to fill the pages"),
+		}, s)
+		a.True(info.Table.InsertTuple(tuple, &rids[i], nil))
+		idx.Index.InsertEntry(tuple.KeyFromTuple(s, &idx.KeySchema, idx.Index.GetKeyAttrs()), rids[i], nil)
+	}
+	// deleting everything but every 20th tuple leaves nearly empty pages behind
+	for i := range rids {
+		if i%20 != 0 {
+			a.True(info.Table.MarkDelete(rids[i], nil))
+			info.Table.ApplyDelete(rids[i], nil)
+		}
+	}
+
+	stats := cat.VacuumTable("t", nil)
+	a.Greater(stats.TuplesMoved, 0)
+	a.Greater(stats.PagesFreed, 0)
+	a.Greater(stats.BytesReclaimed, stats.PagesFreed*common.PageSize-1)
+
+	// the index points at where the tuples are now
+	for i := 0; i < len(rids); i += 20 {
+		var found []common.RID
+		key := table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, int32(i))}, &idx.KeySchema)
+		idx.Index.ScanKey(key, &found, nil)
+		a.Len(found, 1)
+		tuple := &table.Tuple{}
+		a.True(info.Table.GetTuple(found[0], tuple, nil))
+		a.Equal(fmt.Sprint(i), tuple.GetValue(s, 0).String())
+	}
+
+	a.Nil(stats.Err)
+	a.Nil(cat.VacuumTable("missing", nil))
+}
+
+// an index refusing every new entry
+type failingIndex struct {
+	index.Index
+}
+
+func (idx *failingIndex) InsertEntry(key *table.Tuple, rid common.RID, txn common.Transaction) error {
+	return errors.New("no space left")
+}
+
+func TestVacuumTableIndexFails(t *testing.T) {
+	a := assert.New(t)
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	cat := NewCatalog(buffer.NewBufferPoolManager(16, dm, nil), nil, nil)
+	s, err := schema.ParseSchema("id:INTEGER, name:VARCHAR")
+	a.Nil(err)
+	info := cat.CreateTable(nil, "t", s)
+	idx := cat.CreateIndex(nil, "idx_id", "t", s, schema.CopySchema(s, []uint32{0}), []string{"id"}, 8, IndexOptions{})
+	a.NotNil(idx)
+
+	rids := make([]common.RID, 2000)
+	for i := range rids {
+		tuple := table.NewTuple([]*types.Value{
+			types.NewValue(types.INTEGER, int32(i)),
+			types.NewValue(types.VARCHAR, "some padding to fill the pages"),
+		}, s)
+		a.True(info.Table.InsertTuple(tuple, &rids[i], nil))
+		idx.Index.InsertEntry(tuple.KeyFromTuple(s, &idx.KeySchema, idx.Index.GetKeyAttrs()), rids[i], nil)
+	}
+	for i := range rids {
+		if i%20 != 0 {
+			a.True(info.Table.MarkDelete(rids[i], nil))
+			info.Table.ApplyDelete(rids[i], nil)
+		}
+	}
+
+	// vacuum stops after the first page that moved tuples
+	healthy := idx.Index
+	idx.Index = &failingIndex{Index: healthy}
+	stats := cat.VacuumTable("t", nil)
+	a.ErrorContains(stats.Err, "index idx_id lost the entry of tuple")
+	a.Equal(1, stats.PagesScanned-stats.PagesFreed)
+
+	// the entries that didn't make it are missing, a repair puts them back
+	idx.Index = healthy
+	report, err := cat.CheckIndex(idx, true, nil)
+	a.Nil(err)
+	a.NotEmpty(report.Missing)
+	a.True(report.Reindexed)
+	report, err = cat.CheckIndex(idx, false, nil)
+	a.Nil(err)
+	a.True(report.IsConsistent())
+}
+
+func TestAlterTable(t *testing.T) {
+	a := assert.New(t)
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	cat := NewCatalog(buffer.NewBufferPoolManager(16, dm, nil), nil, nil)
+	s, err := schema.ParseSchema("name:VARCHAR, id:INTEGER")
+	a.Nil(err)
+	info := cat.CreateTable(nil, "t", s)
+	idx := cat.CreateIndex(nil, "idx_id", "t", s, schema.CopySchema(s, []uint32{1}), []string{"id"}, 8, IndexOptions{})
+	a.NotNil(idx)
+
+	var rid common.RID
+	tuple := table.NewTuple([]*types.Value{types.NewValue(types.VARCHAR, "goose"), types.NewValue(types.INTEGER, int32(1))}, s)
+	a.True(info.Table.InsertTuple(tuple, &rid, nil))
+
+	a.Nil(cat.AlterTableAddColumn("t", "score", types.BIGINT, types.NewValue(types.INTEGER, int32(10)), nil))
+	a.NotNil(cat.AlterTableAddColumn("t", "score", types.BIGINT, nil, nil))
+	a.NotNil(cat.AlterTableDropColumn("t", "id", nil))
+	a.Nil(cat.AlterTableDropColumn("t", "name", nil))
+	a.NotNil(cat.AlterTableDropColumn("missing", "name", nil))
+
+	// the old tuple is read through the new schema, and the index follows the key column
+	a.Equal(uint16(2), info.Schema.GetVersion())
+	a.Equal([]uint32{0}, idx.Index.GetKeyAttrs())
+	a.True(info.Table.GetTuple(rid, tuple, nil))
+	a.Equal("(1, 10)", tuple.String(&info.Schema))
+	a.Equal(uint16(0), tuple.GetSchemaVersion())
+	a.Equal("1", tuple.KeyFromTuple(&info.Schema, &idx.KeySchema, idx.Index.GetKeyAttrs()).GetValue(&idx.KeySchema, 0).String())
+
+	// and written in the new layout when it is updated
+	a.True(info.Table.UpdateTuple(tuple.Upgrade(&info.Schema), rid, nil))
+	a.True(info.Table.GetTuple(rid, tuple, nil))
+	a.Equal(uint16(2), tuple.GetSchemaVersion())
+	a.Equal("(1, 10)", tuple.String(&info.Schema))
+}
+
+// a blocker the way the transaction manager implements it
+type testBlocker struct {
+	latch sync.RWMutex
+}
+
+func (b *testBlocker) BlockAllTransactions() { b.latch.Lock() }
+func (b *testBlocker) ResumeTransactions()   { b.latch.Unlock() }
+
+func TestAlterTableConcurrently(t *testing.T) {
+	a := assert.New(t)
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	cat := NewCatalog(buffer.NewBufferPoolManager(16, dm, nil), nil, nil)
+	s, err := schema.ParseSchema("id:INTEGER")
+	a.Nil(err)
+	info := cat.CreateTable(nil, "t", s)
+	var rid common.RID
+	a.True(info.Table.InsertTuple(table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, int32(1))}, s), &rid, nil))
+
+	// every reader runs as a "transaction" and sees a whole schema
+	blocker := &testBlocker{}
+	done := make(chan struct{})
+	var wg, started sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		started.Add(1)
+		go func() {
+			defer wg.Done()
+			tuple := &table.Tuple{}
+			started.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				blocker.latch.RLock()
+				a.True(info.Table.GetTuple(rid, tuple, nil))
+				a.Equal("1", tuple.GetValue(&info.Schema, info.Schema.GetColIdx("id")).String())
+				blocker.latch.RUnlock()
+			}
+		}()
+	}
+	started.Wait()
+	for i := 0; i < 50; i++ {
+		a.Nil(cat.AlterTableAddColumn("t", "extra", types.BIGINT, nil, blocker))
+		a.Nil(cat.AlterTableDropColumn("t", "extra", blocker))
+	}
+	close(done)
+	wg.Wait()
+	a.Equal(uint16(100), info.Schema.GetVersion())
+}
+
+func TestCreateBPlusTreeIndex(t *testing.T) {
+	a := assert.New(t)
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	cat := NewCatalog(buffer.NewBufferPoolManager(64, dm, nil), nil, nil)
+	s, err := schema.ParseSchema("ts:BIGINT, name:VARCHAR")
+	a.Nil(err)
+	info := cat.CreateTable(nil, "t", s)
+	rids := make([]common.RID, 1000)
+	for i := range rids {
+		a.True(info.Table.InsertTuple(table.NewTuple([]*types.Value{
+			types.NewValue(types.BIGINT, int64(i*10)),
+			types.NewValue(types.VARCHAR, fmt.Sprint("event", i)),
+		}, s), &rids[i], nil))
+	}
+
+	keySchema := schema.CopySchema(s, []uint32{0})
+	keySize := uintptr(keySchema.GetLength())
+	// the keys of a B+Tree have a fixed size
+	a.Nil(cat.CreateIndex(nil, "idx_ts", "t", s, keySchema, []string{"ts"}, 0, IndexOptions{Type: BPlusTreeIndex}))
+	a.Nil(cat.CreateIndex(nil, "idx_ts", "t", s, keySchema, []string{"ts"}, keySize, IndexOptions{Type: BPlusTreeIndex, KeyOrders: []index.KeyOrder{{}, {}}}))
+	a.Nil(cat.CreateIndex(nil, "idx_ts", "t", s, keySchema, []string{"ts"}, keySize, IndexOptions{Type: BPlusTreeIndex, FillFactor: 1.5}))
+	idx := cat.CreateIndex(nil, "idx_ts", "t", s, keySchema, []string{"ts"}, keySize,
+		IndexOptions{Constraint: index.Unique, Type: BPlusTreeIndex, FillFactor: 0.5, KeyOrders: []index.KeyOrder{{Descending: true}}})
+	a.NotNil(idx)
+	a.Equal(BPlusTreeIndex, idx.Type)
+	a.Equal(0.5, idx.Index.GetMetadata().GetFillFactor())
+	a.Contains(idx.Index.GetMetadata().String(), "Type = B+Tree")
+	hashIdx := cat.CreateIndex(nil, "idx_hash", "t", s, keySchema, []string{"ts"}, keySize, IndexOptions{})
+	a.Equal(HashTableIndex, hashIdx.Type)
+	a.Contains(hashIdx.Index.GetMetadata().String(), "Type = Hash")
+
+	// the index holds the existing tuples
+	ts := func(v int64) *table.Tuple {
+		return table.NewTuple([]*types.Value{types.NewValue(types.BIGINT, v)}, &idx.KeySchema)
+	}
+	var found []common.RID
+	idx.Index.ScanKey(ts(990), &found, nil)
+	a.Equal([]common.RID{rids[99]}, found)
+
+	// and a range of timestamps, in the order of the index
+	it, err := idx.Index.ScanRange(&index.KeyRange{Low: ts(105), LowInclusive: true, High: ts(70), HighInclusive: true}, nil)
+	a.Nil(err)
+	found = nil
+	for rid, ok := it.Next(); ok; rid, ok = it.Next() {
+		found = append(found, rid)
+	}
+	a.Equal([]common.RID{rids[10], rids[9], rids[8], rids[7]}, found)
+	_, err = hashIdx.Index.ScanRange(&index.KeyRange{Low: ts(65), LowInclusive: true}, nil)
+	a.ErrorIs(err, index.ErrRangeScanUnsupported)
+
+	// REINDEX builds a B+Tree again
+	report, err := cat.CheckIndex(idx, false, nil)
+	a.Nil(err)
+	a.True(report.IsConsistent())
+	a.Equal(1000, report.Entries)
+	a.Nil(cat.Reindex(idx, nil))
+	a.Contains(idx.Index.GetMetadata().String(), "Type = B+Tree")
+}
+
+func TestCreateUniqueIndex(t *testing.T) {
+	a := assert.New(t)
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	cat := NewCatalog(buffer.NewBufferPoolManager(16, dm, nil), nil, nil)
+	s, err := schema.ParseSchema("id:INTEGER, name:VARCHAR")
+	a.Nil(err)
+	info := cat.CreateTable(nil, "t", s)
+	insert := func(id int32) common.RID {
+		var rid common.RID
+		tuple := table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, id), types.NewValue(types.VARCHAR, "x")}, s)
+		a.True(info.Table.InsertTuple(tuple, &rid, nil))
+		return rid
+	}
+	keySchema := schema.CopySchema(s, []uint32{0})
+	key := func(id int32) *table.Tuple {
+		return table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, id)}, keySchema)
+	}
+
+	insert(1)
+	dup := insert(1)
+	insert(2)
+	// the existing tuples have a duplicate key, the pages of the index are given back
+	a.Nil(cat.CreateIndex(nil, "idx_id", "t", s, keySchema, []string{"id"}, 8, IndexOptions{Constraint: index.Unique}))
+	numPages := dm.GetNumPages()
+	a.Greater(dm.GetNumFreePages(), 0)
+	a.Nil(cat.CreateIndex(nil, "idx_id", "t", s, keySchema, []string{"id"}, 8, IndexOptions{Constraint: index.Unique}))
+	a.Equal(numPages, dm.GetNumPages())
+	a.True(info.Table.MarkDelete(dup, nil))
+	info.Table.ApplyDelete(dup, nil)
+	idx := cat.CreateIndex(nil, "idx_id", "t", s, keySchema, []string{"id"}, 8, IndexOptions{Constraint: index.PrimaryKey})
+	a.NotNil(idx)
+	a.Equal(index.PrimaryKey, idx.Index.GetMetadata().GetConstraint())
+
+	rid := insert(2)
+	err = idx.Index.InsertEntry(key(2), rid, nil)
+	var violation *index.ConstraintViolationError
+	a.True(errors.As(err, &violation))
+	a.True(info.Table.MarkDelete(rid, nil))
+
+	// the entry of a deleted tuple doesn't count, even before it leaves the index
+	var old []common.RID
+	idx.Index.ScanKey(key(1), &old, nil)
+	a.Len(old, 1)
+	a.True(info.Table.MarkDelete(old[0], nil))
+	a.Nil(idx.Index.InsertEntry(key(1), insert(1), nil))
+}
+
+func TestCreateIndexOnExistingData(t *testing.T) {
+	a := assert.New(t)
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	cat := NewCatalog(buffer.NewBufferPoolManager(64, dm, nil), nil, nil)
+	s, err := schema.ParseSchema("id:INTEGER, name:VARCHAR")
+	a.Nil(err)
+	info := cat.CreateTable(nil, "t", s)
+
+	const n = 20000
+	rids := make([]common.RID, n)
+	for i := range rids {
+		tuple := table.NewTuple([]*types.Value{
+			types.NewValue(types.INTEGER, int32(i)),
+			types.NewValue(types.VARCHAR, fmt.Sprint("name", i)),
+		}, s)
+		a.True(info.Table.InsertTuple(tuple, &rids[i], nil))
+	}
+
+	// the index is built from the tuples in one go
+	byId := cat.CreateIndex(nil, "idx_id", "t", s, schema.CopySchema(s, []uint32{0}), []string{"id"}, 8, IndexOptions{Constraint: index.PrimaryKey})
+	a.NotNil(byId)
+	byName := cat.CreateIndex(nil, "idx_name", "t", s, schema.CopySchema(s, []uint32{1}), []string{"name"}, 0, IndexOptions{Constraint: index.Unique})
+	a.NotNil(byName)
+	for i := 0; i < n; i += 7 {
+		var found []common.RID
+		key := table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, int32(i))}, &byId.KeySchema)
+		byId.Index.ScanKey(key, &found, nil)
+		a.Equal([]common.RID{rids[i]}, found)
+
+		found = nil
+		key = table.NewTuple([]*types.Value{types.NewValue(types.VARCHAR, fmt.Sprint("name", i))}, &byName.KeySchema)
+		byName.Index.ScanKey(key, &found, nil)
+		a.Equal([]common.RID{rids[i]}, found)
+	}
+}
+
+func TestCreateBloomFilterIndex(t *testing.T) {
+	a := assert.New(t)
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	cat := NewCatalog(buffer.NewBufferPoolManager(64, dm, nil), nil, nil)
+	s, err := schema.ParseSchema("id:INTEGER, name:VARCHAR")
+	a.Nil(err)
+	info := cat.CreateTable(nil, "t", s)
+	tuple := func(id int32) *table.Tuple {
+		return table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, id), types.NewValue(types.VARCHAR, fmt.Sprint("name", id))}, s)
+	}
+	const n = 15000
+	for id := int32(0); id < n; id++ {
+		var rid common.RID
+		a.True(info.Table.InsertTuple(tuple(id), &rid, nil))
+	}
+
+	keySchema := schema.CopySchema(s, []uint32{1})
+	// a filter can't enforce a constraint
+	a.Nil(cat.CreateIndex(nil, "bloom_name", "t", s, keySchema, []string{"name"}, 0, IndexOptions{Constraint: index.Unique, Type: BloomFilterIndex}))
+	a.Nil(cat.CreateIndex(nil, "bloom_name", "t", s, keySchema, []string{"name"}, 0, IndexOptions{Type: BloomFilterIndex, FalsePositiveRate: 1}))
+	a.NotNil(cat.CreateIndex(nil, "bloom_name", "t", s, keySchema, []string{"name"}, 0, IndexOptions{Type: BloomFilterIndex, FalsePositiveRate: 0.001}))
+
+	idx := cat.GetIndexByName("bloom_name", "t")
+	a.NotNil(idx)
+	a.Equal(BloomFilterIndex, idx.Type)
+	a.Equal(0.001, idx.Index.GetMetadata().GetFalsePositiveRate())
+	key := func(id int32) *table.Tuple {
+		return tuple(id).KeyFromTuple(s, &idx.KeySchema, idx.Index.GetKeyAttrs())
+	}
+	filter := idx.Index.(*index.BloomFilterIndex)
+	// the bulk build sized the filter for the table
+	a.Greater(filter.GetCapacity(), uint32(n))
+	a.False(filter.IsSaturated())
+	absent := 0
+	for id := int32(0); id < n; id++ {
+		a.True(filter.MayContain(key(id), nil))
+		if !filter.MayContain(key(n+id), nil) {
+			absent++
+		}
+	}
+	a.Greater(absent, n*99/100)
+
+	// writes through the catalog saturate it, REINDEX sizes it again
+	for id := int32(n); id < 4*n; id++ {
+		var rid common.RID
+		a.True(info.Table.InsertTuple(tuple(id), &rid, nil))
+		a.Nil(idx.InsertEntry(key(id), rid, nil))
+	}
+	a.True(filter.IsSaturated())
+	report, err := cat.CheckIndex(idx, false, nil)
+	a.Nil(err)
+	a.True(report.IsConsistent())
+	a.Equal(-1, report.Entries)
+
+	a.Nil(cat.Reindex(idx, nil))
+	filter = idx.Index.(*index.BloomFilterIndex)
+	a.False(filter.IsSaturated())
+	a.Equal(uint32(4*n), filter.GetCount())
+	a.Equal(0.001, filter.GetMetadata().GetFalsePositiveRate())
+}
+
+func TestCreateInvertedIndex(t *testing.T) {
+	a := assert.New(t)
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	cat := NewCatalog(buffer.NewBufferPoolManager(64, dm, nil), nil, nil)
+	s, err := schema.ParseSchema("id:INTEGER, description:VARCHAR")
+	a.Nil(err)
+	info := cat.CreateTable(nil, "t", s)
+
+	var rids []common.RID
+	for i, description := range []string{"Red USB cable", "Blue USB charger", "", "A red cable tie"} {
+		var rid common.RID
+		a.True(info.Table.InsertTuple(table.NewTuple([]*types.Value{
+			types.NewValue(types.INTEGER, int32(i)),
+			types.NewValue(types.VARCHAR, description),
+		}, s), &rid, nil))
+		rids = append(rids, rid)
+	}
+
+	// a full-text index needs VARCHAR columns
+	a.Nil(cat.CreateIndex(nil, "idx_id", "t", s, schema.CopySchema(s, []uint32{0}), []string{"id"}, 8, IndexOptions{Type: InvertedIndex}))
+	idx := cat.CreateIndex(nil, "idx_description", "t", s, schema.CopySchema(s, []uint32{1}), []string{"description"}, 0, IndexOptions{Type: InvertedIndex})
+	a.NotNil(idx)
+	a.Equal(InvertedIndex, idx.Type)
+	searcher := idx.Index.(index.TextSearcher)
+	a.Equal([]common.RID{rids[0], rids[3]}, searcher.MatchAll("red cables", nil))
+	a.Equal([]common.RID{rids[0], rids[1]}, searcher.MatchPhrase("usb", nil))
+
+	// the tuple without words has no entries to miss
+	report, err := cat.CheckIndex(idx, false, nil)
+	a.Nil(err)
+	a.True(report.IsConsistent())
+	a.Equal(4, report.Tuples)
+
+	a.Nil(cat.Reindex(idx, nil))
+	a.Equal([]common.RID{rids[3]}, idx.Index.(index.TextSearcher).MatchPhrase("red cable tie", nil))
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package catalog
+
+import (
+	"github.com/go-kit/kit/log/level"
+	"goostub/common"
+	"goostub/schema"
+	"goostub/storage/table"
+)
+
+// once the side log is down to this many writes, the build applies them while holding off the writers
+const sideLogFinalBatch = 256
+
+// a write to the table while its index is built concurrently
+type sideLogEntry struct {
+	insert bool
+	key    *table.Tuple
+	rid    common.RID
+}
+
+/**
+ * @return whether queries may use the index. An index being built concurrently
+ * isn't valid until it holds every tuple of its table.
+ */
+func (info *IndexInfo) IsValid() bool {
+	info.buildLatch.Lock()
+	defer info.buildLatch.Unlock()
+	return info.valid
+}
+
+/**
+ * Add an entry to the index. Writers go through here rather than through the
+ * index, so that an index being built concurrently catches the write in its
+ * side log. The tuple must be written to the table first.
+ * @return a *index.ConstraintViolationError if the index is unique and already has the key, the transaction is then aborted
+ */
+func (info *IndexInfo) InsertEntry(key *table.Tuple, rid common.RID, txn common.Transaction) error {
+	if info.logWrite(true, key, rid) {
+		return nil
+	}
+	return info.Index.InsertEntry(key, rid, txn)
+}
+
+/**
+ * Delete an entry of the index, see InsertEntry.
+ */
+func (info *IndexInfo) DeleteEntry(key *table.Tuple, rid common.RID, txn common.Transaction) {
+	if info.logWrite(false, key, rid) {
+		return
+	}
+	info.Index.DeleteEntry(key, rid, txn)
+}
+
+// append a write to the side log while the index is built, return whether the write is taken care of
+func (info *IndexInfo) logWrite(insert bool, key *table.Tuple, rid common.RID) bool {
+	info.buildLatch.Lock()
+	defer info.buildLatch.Unlock()
+	if info.dropped {
+		return true
+	}
+	if info.valid {
+		return false
+	}
+	info.sideLog = append(info.sideLog, sideLogEntry{insert: insert, key: key, rid: rid})
+	return true
+}
+
+/**
+ * Create a new index without stopping the writers of the table. The index is
+ * registered invalid first, from then on the writers add their writes to its
+ * side log. The build then waits for the transactions that began before,
+ * their writes aren't in the side log and may still roll back. It is then
+ * filled from a scan of the table, and the side log is applied on top:
+ * replaying a write the scan already saw changes nothing. The index turns
+ * valid once the side log is empty, queries must not use it before, see
+ * IndexInfo.IsValid.
+ *
+ * The other parameters are the ones of CreateIndex. The scan reads the latest
+ * version of the tuples, whatever the snapshot of txn.
+ * @param blocker waits for the running transactions, may be nil if nobody
+ * else writes to the table. txn must not be one of them.
+ * @return A (non-owning) pointer to the metadata of the new index, nil if it
+ * can't be created or the table breaks the constraint, the index is then dropped
+ */
+func (c *Catalog) CreateIndexConcurrently(txn common.Transaction, indexName string, tableName string, schema *schema.Schema, keySchema *schema.Schema, keyAttrs []string, keysize uintptr, options IndexOptions, blocker table.TransactionBlocker) *IndexInfo {
+	info, tableInfo := c.newIndexInfo(indexName, tableName, schema, keyAttrs, keysize, options)
+	if info == nil || !c.addIndex(info) {
+		return nil
+	}
+	if blocker != nil {
+		// the transactions beginning from now on see the index
+		blocker.BlockAllTransactions()
+		blocker.ResumeTransactions()
+	}
+
+	err := populateIndex(info.Index, c.collectEntries(tableInfo, info, nil), txn)
+	if err == nil {
+		err = info.catchUp(txn)
+	}
+	if err != nil {
+		level.Warn(common.Logger).Log("Failed to create index ", indexName, ": ", err)
+		c.removeIndex(info)
+		info.buildLatch.Lock()
+		info.dropped = true
+		info.sideLog = nil
+		info.buildLatch.Unlock()
+		// the writers skip a dropped index
+		freeIndexPages(info.Index)
+		return nil
+	}
+	return info
+}
+
+/**
+ * Apply the side log to the index and make it valid. The writers keep adding
+ * to the log meanwhile, so it is applied in rounds, the last one holding the
+ * build latch so that no write slips between the log and the index.
+ */
+func (info *IndexInfo) catchUp(txn common.Transaction) error {
+	for {
+		info.buildLatch.Lock()
+		pending := info.sideLog
+		info.sideLog = nil
+		if len(pending) <= sideLogFinalBatch {
+			defer info.buildLatch.Unlock()
+			if err := info.applySideLog(pending, txn); err != nil {
+				return err
+			}
+			info.valid = true
+			return nil
+		}
+		info.buildLatch.Unlock()
+		if err := info.applySideLog(pending, txn); err != nil {
+			return err
+		}
+	}
+}
+
+func (info *IndexInfo) applySideLog(entries []sideLogEntry, txn common.Transaction) error {
+	for _, e := range entries {
+		if !e.insert {
+			info.Index.DeleteEntry(e.key, e.rid, txn)
+		} else if err := info.Index.InsertEntry(e.key, e.rid, txn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
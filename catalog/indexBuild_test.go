@@ -0,0 +1,206 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package catalog
+
+import (
+	"github.com/stretchr/testify/assert"
+	"goostub/buffer"
+	"goostub/common"
+	"goostub/schema"
+	"goostub/storage/disk"
+	"goostub/storage/index"
+	"goostub/storage/table"
+	"goostub/types"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newIndexBuildCatalog(t *testing.T) (*Catalog, *TableInfo, *disk.DiskManager) {
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	cat := NewCatalog(buffer.NewBufferPoolManager(64, dm, nil), nil, nil)
+	s, err := schema.ParseSchema("id:INTEGER, name:VARCHAR")
+	assert.Nil(t, err)
+	return cat, cat.CreateTable(nil, "t", s), dm
+}
+
+func TestIndexSideLog(t *testing.T) {
+	a := assert.New(t)
+	cat, info, _ := newIndexBuildCatalog(t)
+	s := &info.Schema
+	tuple := func(id int32) *table.Tuple {
+		return table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, id), types.NewValue(types.VARCHAR, "x")}, s)
+	}
+
+	// an index registered for a concurrent build, before it is filled
+	idx, _ := cat.newIndexInfo("idx_id", "t", s, []string{"id"}, 8, IndexOptions{Constraint: index.Unique})
+	a.True(cat.addIndex(idx))
+	a.False(idx.IsValid())
+	a.Len(cat.GetTableIndexes("t"), 1)
+	a.Empty(cat.GetValidTableIndexes("t"))
+
+	// the writes wait in the side log
+	key := func(id int32) *table.Tuple {
+		return tuple(id).KeyFromTuple(s, &idx.KeySchema, idx.Index.GetKeyAttrs())
+	}
+	a.Nil(idx.InsertEntry(key(1), common.NewRID(1, 0), nil))
+	a.Nil(idx.InsertEntry(key(2), common.NewRID(2, 0), nil))
+	idx.DeleteEntry(key(1), common.NewRID(1, 0), nil)
+	var found []common.RID
+	idx.Index.ScanKey(key(2), &found, nil)
+	a.Empty(found)
+
+	a.Nil(idx.catchUp(nil))
+	a.True(idx.IsValid())
+	a.Len(cat.GetValidTableIndexes("t"), 1)
+	idx.Index.ScanKey(key(1), &found, nil)
+	a.Empty(found)
+	idx.Index.ScanKey(key(2), &found, nil)
+	a.Equal([]common.RID{common.NewRID(2, 0)}, found)
+
+	// then they go to the index
+	a.Nil(idx.InsertEntry(key(3), common.NewRID(3, 0), nil))
+	found = nil
+	idx.Index.ScanKey(key(3), &found, nil)
+	a.Equal([]common.RID{common.NewRID(3, 0)}, found)
+}
+
+func TestCreateIndexConcurrently(t *testing.T) {
+	a := assert.New(t)
+	cat, info, _ := newIndexBuildCatalog(t)
+	s := &info.Schema
+	tuple := func(id int32) *table.Tuple {
+		return table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, id), types.NewValue(types.VARCHAR, "some padding")}, s)
+	}
+	for id := int32(0); id < 5000; id++ {
+		var rid common.RID
+		a.True(info.Table.InsertTuple(tuple(id), &rid, nil))
+	}
+
+	// writers insert, update and delete tuples while the index is built
+	const workers, perWorker = 4, 1000
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	live := make(map[int32]common.RID)
+	gone := make(map[int32]bool)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				id := int32(10000 + w*perWorker + i)
+				var rid common.RID
+				if !info.Table.InsertTuple(tuple(id), &rid, nil) {
+					t.Error("insert failed")
+					return
+				}
+				for _, idx := range cat.GetTableIndexes("t") {
+					idx.InsertEntry(tuple(id).KeyFromTuple(s, &idx.KeySchema, idx.Index.GetKeyAttrs()), rid, nil)
+				}
+
+				switch i % 3 {
+				case 1:
+					info.Table.MarkDelete(rid, nil)
+					info.Table.ApplyDelete(rid, nil)
+					for _, idx := range cat.GetTableIndexes("t") {
+						idx.DeleteEntry(tuple(id).KeyFromTuple(s, &idx.KeySchema, idx.Index.GetKeyAttrs()), rid, nil)
+					}
+					mutex.Lock()
+					gone[id] = true
+					mutex.Unlock()
+					continue
+				case 2:
+					// the same size, the tuple stays in place
+					info.Table.UpdateTuple(tuple(-id), rid, nil)
+					for _, idx := range cat.GetTableIndexes("t") {
+						idx.DeleteEntry(tuple(id).KeyFromTuple(s, &idx.KeySchema, idx.Index.GetKeyAttrs()), rid, nil)
+						idx.InsertEntry(tuple(-id).KeyFromTuple(s, &idx.KeySchema, idx.Index.GetKeyAttrs()), rid, nil)
+					}
+					mutex.Lock()
+					gone[id] = true
+					id = -id
+					mutex.Unlock()
+				}
+				mutex.Lock()
+				live[id] = rid
+				mutex.Unlock()
+			}
+		}(w)
+	}
+	idx := cat.CreateIndexConcurrently(nil, "idx_id", "t", s, schema.CopySchema(s, []uint32{0}), []string{"id"}, 8, IndexOptions{Constraint: index.PrimaryKey}, nil)
+	wg.Wait()
+	a.NotNil(idx)
+	a.True(idx.IsValid())
+
+	scan := func(id int32) []common.RID {
+		var found []common.RID
+		idx.Index.ScanKey(tuple(id).KeyFromTuple(s, &idx.KeySchema, idx.Index.GetKeyAttrs()), &found, nil)
+		return found
+	}
+	for id := int32(0); id < 5000; id++ {
+		a.Len(scan(id), 1)
+	}
+	for id, rid := range live {
+		a.Equal([]common.RID{rid}, scan(id), "id %d", id)
+	}
+	for id := range gone {
+		a.Empty(scan(id), "id %d", id)
+	}
+}
+
+func TestCreateIndexConcurrentlyFails(t *testing.T) {
+	a := assert.New(t)
+	cat, info, dm := newIndexBuildCatalog(t)
+	s := &info.Schema
+	for _, id := range []int32{1, 2, 1} {
+		var rid common.RID
+		a.True(info.Table.InsertTuple(table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, id), types.NewValue(types.VARCHAR, "x")}, s), &rid, nil))
+	}
+	keySchema := schema.CopySchema(s, []uint32{0})
+
+	// the index is dropped with its pages, its name is free again
+	a.Nil(cat.CreateIndexConcurrently(nil, "idx_id", "t", s, keySchema, []string{"id"}, 8, IndexOptions{Constraint: index.Unique}, nil))
+	a.Nil(cat.GetIndexByName("idx_id", "t"))
+	a.Empty(cat.GetTableIndexes("t"))
+	a.Greater(dm.GetNumFreePages(), 0)
+	a.NotNil(cat.CreateIndexConcurrently(nil, "idx_id", "t", s, keySchema, []string{"id"}, 8, IndexOptions{}, nil))
+}
+
+func TestCreateIndexConcurrentlyWaitsForTransactions(t *testing.T) {
+	a := assert.New(t)
+	cat, info, _ := newIndexBuildCatalog(t)
+	s := &info.Schema
+	rids := make([]common.RID, 2)
+	for id := range rids {
+		a.True(info.Table.InsertTuple(table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, int32(id)), types.NewValue(types.VARCHAR, "x")}, s), &rids[id], nil))
+	}
+
+	// a transaction running before the build deletes a tuple, then rolls back
+	blocker := &testBlocker{}
+	blocker.latch.RLock()
+	a.True(info.Table.MarkDelete(rids[1], nil))
+	built := make(chan *IndexInfo)
+	go func() {
+		built <- cat.CreateIndexConcurrently(nil, "idx_id", "t", s, schema.CopySchema(s, []uint32{0}), []string{"id"}, 8, IndexOptions{Constraint: index.Unique}, blocker)
+	}()
+	select {
+	case <-built:
+		t.Fatal("the build didn't wait for the transaction")
+	case <-time.After(50 * time.Millisecond):
+	}
+	info.Table.RollbackDelete(rids[1], nil)
+	blocker.latch.RUnlock()
+
+	idx := <-built
+	a.NotNil(idx)
+	a.True(idx.IsValid())
+	report, err := cat.CheckIndex(idx, false, nil)
+	a.Nil(err)
+	a.True(report.IsConsistent(), "%+v", report)
+	a.Equal(2, report.Tuples)
+}
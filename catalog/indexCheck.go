@@ -0,0 +1,185 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package catalog
+
+import (
+	"fmt"
+	"goostub/common"
+	"goostub/storage/index"
+	"goostub/storage/table"
+	"sort"
+)
+
+/** What CheckIndex found out about an index. */
+type IndexCheckReport struct {
+	IndexName string
+	// live tuples of the table
+	Tuples int
+	// entries of the index, -1 if the index can't list them, dangling entries then go unnoticed
+	Entries int
+	// tuples of the table without their entry in the index
+	Missing []IndexCheckEntry
+	// entries of the index that no tuple of the table has
+	Dangling []IndexCheckEntry
+	// the index didn't match and was rebuilt
+	Reindexed bool
+}
+
+/** An entry that is in only one of an index and its table. */
+type IndexCheckEntry struct {
+	// the key, formatted with the key schema
+	Key string
+	RID common.RID
+}
+
+/** @return whether the index had every entry of its table and nothing else */
+func (r *IndexCheckReport) IsConsistent() bool {
+	return len(r.Missing) == 0 && len(r.Dangling) == 0
+}
+
+// an entry as the index stores it
+type storedEntry struct {
+	key string
+	rid common.RID
+}
+
+/**
+ * Check that an index matches its table: every tuple of the table has an
+ * entry under the key made of its key attributes, and every entry of the index
+ * belongs to such a tuple. Indexes that can't list their entries are only
+ * checked for missing entries.
+ * @param info the index to check
+ * @param repair rebuild the index if it doesn't match, see Reindex
+ * @param blocker stops transactions during the check, may be nil if nobody else uses the table
+ * @return what the check found, an error if the table doesn't exist or the repair failed
+ */
+func (c *Catalog) CheckIndex(info *IndexInfo, repair bool, blocker table.TransactionBlocker) (*IndexCheckReport, error) {
+	tableInfo := c.GetTableByName(info.TableName)
+	if tableInfo == nil {
+		return nil, fmt.Errorf("table %s of index %s doesn't exist", info.TableName, info.Name)
+	}
+	if blocker != nil {
+		blocker.BlockAllTransactions()
+		defer blocker.ResumeTransactions()
+	}
+
+	report := &IndexCheckReport{IndexName: info.Name, Entries: -1}
+	keySchema := &info.KeySchema
+	scanner, listable := info.Index.(index.EntryScanner)
+	// the entries the tuples expect, with their keys
+	expected := make(map[storedEntry]*table.Tuple)
+	for _, e := range c.collectEntries(tableInfo, info, nil) {
+		report.Tuples++
+		if listable {
+			if stored, ok := scanner.StoredKey(e.Key); ok {
+				expected[storedEntry{key: string(stored), rid: e.RID}] = e.Key
+				continue
+			}
+		} else if filter, ok := info.Index.(index.MembershipFilter); ok {
+			// a filter has no RIDs, it can only tell the key is missing
+			if filter.MayContain(e.Key, nil) {
+				continue
+			}
+		} else {
+			if searcher, ok := info.Index.(index.TextSearcher); ok && len(searcher.Tokens(e.Key)) == 0 {
+				// a text without words has no entries
+				continue
+			}
+			var rids []common.RID
+			info.Index.ScanKey(e.Key, &rids, nil)
+			if containsRID(rids, e.RID) {
+				continue
+			}
+		}
+		report.Missing = append(report.Missing, IndexCheckEntry{Key: e.Key.String(keySchema), RID: e.RID})
+	}
+
+	if listable {
+		report.Entries = 0
+		scanner.ScanEntries(func(key []byte, rid common.RID) {
+			report.Entries++
+			stored := storedEntry{key: string(key), rid: rid}
+			if _, ok := expected[stored]; ok {
+				delete(expected, stored)
+				return
+			}
+			report.Dangling = append(report.Dangling, IndexCheckEntry{Key: table.NewTuple(key).String(keySchema), RID: rid})
+		})
+		for stored, key := range expected {
+			report.Missing = append(report.Missing, IndexCheckEntry{Key: key.String(keySchema), RID: stored.rid})
+		}
+	}
+	sortCheckEntries(report.Missing)
+	sortCheckEntries(report.Dangling)
+
+	if repair && !report.IsConsistent() {
+		if err := c.reindex(tableInfo, info); err != nil {
+			return report, err
+		}
+		report.Reindexed = true
+	}
+	return report, nil
+}
+
+/**
+ * Rebuild an index from its table (REINDEX). A new index with the same
+ * metadata is filled from the tuples and replaces the old one, whose pages
+ * are then deleted.
+ * @param info the index to rebuild
+ * @param blocker stops transactions while the index is rebuilt, may be nil if nobody else uses the table
+ * @return an error if the table breaks the constraint of the index, the old index is then kept
+ */
+func (c *Catalog) Reindex(info *IndexInfo, blocker table.TransactionBlocker) error {
+	tableInfo := c.GetTableByName(info.TableName)
+	if tableInfo == nil {
+		return fmt.Errorf("table %s of index %s doesn't exist", info.TableName, info.Name)
+	}
+	if blocker != nil {
+		blocker.BlockAllTransactions()
+		defer blocker.ResumeTransactions()
+	}
+	return c.reindex(tableInfo, info)
+}
+
+func (c *Catalog) reindex(tableInfo *TableInfo, info *IndexInfo) error {
+	if !info.IsValid() {
+		return fmt.Errorf("index %s is being built", info.Name)
+	}
+	idx := c.newIndex(info.Index.GetMetadata(), info.Type, info.KeySize)
+	if idx == nil {
+		return fmt.Errorf("failed to create index %s again", info.Name)
+	}
+	if err := populateIndex(idx, c.collectEntries(tableInfo, info, nil), nil); err != nil {
+		freeIndexPages(idx)
+		return err
+	}
+	c.indexLatch.Lock()
+	old := info.Index
+	info.Index = idx
+	c.indexLatch.Unlock()
+	// the transactions are held off, nobody uses the old index anymore
+	freeIndexPages(old)
+	return nil
+}
+
+func containsRID(rids []common.RID, rid common.RID) bool {
+	for _, other := range rids {
+		if other == rid {
+			return true
+		}
+	}
+	return false
+}
+
+func sortCheckEntries(entries []IndexCheckEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].RID, entries[j].RID
+		if a.GetPageId() != b.GetPageId() {
+			return a.GetPageId() < b.GetPageId()
+		}
+		return a.GetSlotNum() < b.GetSlotNum()
+	})
+}
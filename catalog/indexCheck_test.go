@@ -0,0 +1,125 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"goostub/buffer"
+	"goostub/common"
+	"goostub/schema"
+	"goostub/storage/disk"
+	"goostub/storage/index"
+	"goostub/storage/table"
+	"goostub/types"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckIndex(t *testing.T) {
+	a := assert.New(t)
+	cat, info, _ := newIndexBuildCatalog(t)
+	s := &info.Schema
+	tuple := func(id int32) *table.Tuple {
+		return table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, id), types.NewValue(types.VARCHAR, fmt.Sprint("name", id))}, s)
+	}
+	rids := make([]common.RID, 1000)
+	for i := range rids {
+		a.True(info.Table.InsertTuple(tuple(int32(i)), &rids[i], nil))
+	}
+	byId := cat.CreateIndex(nil, "idx_id", "t", s, schema.CopySchema(s, []uint32{0}), []string{"id"}, 8, IndexOptions{Constraint: index.Unique})
+	byName := cat.CreateIndex(nil, "idx_name", "t", s, schema.CopySchema(s, []uint32{1}), []string{"name"}, 0, IndexOptions{})
+	a.NotNil(byId)
+	a.NotNil(byName)
+
+	for _, idx := range []*IndexInfo{byId, byName} {
+		report, err := cat.CheckIndex(idx, false, nil)
+		a.Nil(err)
+		a.True(report.IsConsistent(), idx.Name)
+		a.Equal(1000, report.Tuples)
+		a.Equal(1000, report.Entries)
+	}
+
+	// lose an entry, add one for a tuple that doesn't exist and one under the wrong key
+	key := func(idx *IndexInfo, id int32) *table.Tuple {
+		return tuple(id).KeyFromTuple(s, &idx.KeySchema, idx.Index.GetKeyAttrs())
+	}
+	for _, idx := range []*IndexInfo{byId, byName} {
+		idx.Index.DeleteEntry(key(idx, 5), rids[5], nil)
+		idx.Index.InsertEntry(key(idx, 5000), common.NewRID(9999, 0), nil)
+		idx.Index.DeleteEntry(key(idx, 8), rids[8], nil)
+		idx.Index.InsertEntry(key(idx, 5), rids[8], nil)
+	}
+	report, err := cat.CheckIndex(byId, false, nil)
+	a.Nil(err)
+	a.False(report.IsConsistent())
+	a.Equal(1000, report.Entries)
+	a.Equal([]IndexCheckEntry{{Key: "(5)", RID: rids[5]}, {Key: "(8)", RID: rids[8]}}, report.Missing)
+	a.Equal([]IndexCheckEntry{{Key: "(5)", RID: rids[8]}, {Key: "(5000)", RID: common.NewRID(9999, 0)}}, report.Dangling)
+
+	// the repair rebuilds the index
+	report, err = cat.CheckIndex(byName, true, nil)
+	a.Nil(err)
+	a.Len(report.Missing, 2)
+	a.Equal([]IndexCheckEntry{{Key: "(name5)", RID: rids[8]}, {Key: "(name5000)", RID: common.NewRID(9999, 0)}}, report.Dangling)
+	a.True(report.Reindexed)
+	report, err = cat.CheckIndex(byName, false, nil)
+	a.Nil(err)
+	a.True(report.IsConsistent())
+	var found []common.RID
+	byName.Index.ScanKey(key(byName, 5), &found, nil)
+	a.Equal([]common.RID{rids[5]}, found)
+
+	// a table that breaks the constraint keeps the old index
+	var dup common.RID
+	a.True(info.Table.InsertTuple(tuple(1), &dup, nil))
+	old := byId.Index
+	report, err = cat.CheckIndex(byId, true, nil)
+	var violation *index.ConstraintViolationError
+	a.True(errors.As(err, &violation))
+	a.False(report.Reindexed)
+	a.Equal(old, byId.Index)
+}
+
+func TestReindexFreesPages(t *testing.T) {
+	a := assert.New(t)
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	cat := NewCatalog(buffer.NewBufferPoolManager(64, dm, nil), nil, nil)
+	s, err := schema.ParseSchema("id:INTEGER, name:VARCHAR")
+	a.Nil(err)
+	info := cat.CreateTable(nil, "t", s)
+	for id := int32(0); id < 3000; id++ {
+		var rid common.RID
+		// long names overflow the buckets of the hash index
+		name := fmt.Sprintf("name%d %s", id, strings.Repeat("x", int(id%1000)))
+		a.True(info.Table.InsertTuple(table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, id), types.NewValue(types.VARCHAR, name)}, s), &rid, nil))
+	}
+	byId := schema.CopySchema(s, []uint32{0})
+	byName := schema.CopySchema(s, []uint32{1})
+	indexes := []*IndexInfo{
+		cat.CreateIndex(nil, "hash_id", "t", s, byId, []string{"id"}, uintptr(byId.GetLength()), IndexOptions{Constraint: index.Unique}),
+		cat.CreateIndex(nil, "hash_name", "t", s, byName, []string{"name"}, 0, IndexOptions{}),
+		cat.CreateIndex(nil, "tree_id", "t", s, byId, []string{"id"}, uintptr(byId.GetLength()), IndexOptions{Constraint: index.Unique, Type: BPlusTreeIndex}),
+		cat.CreateIndex(nil, "bloom_name", "t", s, byName, []string{"name"}, 0, IndexOptions{Type: BloomFilterIndex}),
+		cat.CreateIndex(nil, "text_name", "t", s, byName, []string{"name"}, 0, IndexOptions{Type: InvertedIndex}),
+	}
+	for _, idx := range indexes {
+		a.NotNil(idx)
+		// the first rebuild takes new pages, the next ones reuse the pages of the index they replace
+		a.Nil(cat.Reindex(idx, nil))
+		numPages := dm.GetNumPages()
+		for i := 0; i < 3; i++ {
+			a.Nil(cat.Reindex(idx, nil))
+			a.Equal(numPages, dm.GetNumPages(), idx.Name)
+		}
+		report, err := cat.CheckIndex(idx, false, nil)
+		a.Nil(err)
+		a.True(report.IsConsistent(), idx.Name)
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package catalog
+
+import (
+	"fmt"
+	"goostub/schema"
+	"goostub/storage/table"
+	"goostub/types"
+	"math/rand"
+	"sort"
+)
+
+const (
+	defaultSampleSize       = 30000
+	defaultHistogramBuckets = 100
+
+	// selectivities used when the statistics can't tell
+	defaultEqualitySelectivity = 0.005
+	defaultRangeSelectivity    = 1.0 / 3
+)
+
+type AnalyzeOptions struct {
+	// number of rows kept to build the statistics, the whole table if it has fewer. Default 30000.
+	SampleSize int
+	// number of buckets of the histograms, default 100
+	HistogramBuckets int
+	// seed of the sampling
+	Seed int64
+}
+
+/** What ANALYZE found out about a table. */
+type TableStatistics struct {
+	RowCount  int
+	PageCount int
+	// number of rows the column statistics were built from
+	SampleSize int
+	// statistics of each column, in the order of the schema
+	Columns []*ColumnStatistics
+}
+
+type ColumnStatistics struct {
+	// fraction of the rows where the column is NULL
+	NullFraction float64
+	// estimated number of distinct non-NULL values
+	DistinctCount float64
+	// equi-depth histogram of the non-NULL values: each bucket between two
+	// consecutive bounds holds about the same number of values. Bounds[0] is
+	// the minimum and the last bound the maximum. Empty if all values are NULL.
+	Bounds []*types.Value
+}
+
+/**
+ * Collect the statistics of a table and keep them in its TableInfo.
+ * Every tuple is read to count the rows, but only a random sample of them is
+ * kept to build the column statistics.
+ * @param tableName the name of the table
+ * @param opts how to sample, the zero value picks the defaults
+ * @return the statistics
+ */
+func (c *Catalog) AnalyzeTable(tableName string, opts AnalyzeOptions) (*TableStatistics, error) {
+	tableInfo := c.GetTableByName(tableName)
+	if tableInfo == nil {
+		return nil, fmt.Errorf("table %s doesn't exist", tableName)
+	}
+	tableInfo.Stats = Analyze(tableInfo.Table, &tableInfo.Schema, opts)
+	return tableInfo.Stats, nil
+}
+
+/**
+ * Collect the statistics of a table heap.
+ * @param heap the table
+ * @param s the schema of the table
+ * @param opts how to sample, the zero value picks the defaults
+ */
+func Analyze(heap *table.TableHeap, s *schema.Schema, opts AnalyzeOptions) *TableStatistics {
+	if opts.SampleSize <= 0 {
+		opts.SampleSize = defaultSampleSize
+	}
+	if opts.HistogramBuckets <= 0 {
+		opts.HistogramBuckets = defaultHistogramBuckets
+	}
+
+	// reservoir sampling: row i replaces a random sampled row with probability size/i
+	rng := rand.New(rand.NewSource(opts.Seed))
+	stats := &TableStatistics{PageCount: heap.GetFreeSpaceMap().GetPageCount()}
+	var sample [][]*types.Value
+	it := heap.Begin(nil)
+	for tuple, ok := it.Next(); ok; tuple, ok = it.Next() {
+		stats.RowCount++
+		slot := len(sample)
+		if slot == opts.SampleSize {
+			if slot = rng.Intn(stats.RowCount); slot >= opts.SampleSize {
+				continue
+			}
+		}
+		row := make([]*types.Value, s.GetColumnCount())
+		for i := range row {
+			row[i] = tuple.GetValue(s, i)
+		}
+		if slot == len(sample) {
+			sample = append(sample, row)
+		} else {
+			sample[slot] = row
+		}
+	}
+	stats.SampleSize = len(sample)
+
+	stats.Columns = make([]*ColumnStatistics, s.GetColumnCount())
+	for i := range stats.Columns {
+		vals := make([]*types.Value, 0, len(sample))
+		for _, row := range sample {
+			if !row[i].IsNull() {
+				vals = append(vals, row[i])
+			}
+		}
+		stats.Columns[i] = analyzeColumn(vals, len(sample), stats.RowCount, opts.HistogramBuckets)
+	}
+	return stats
+}
+
+/**
+ * @param vals the non-NULL values of the column in the sample
+ * @param sampleSize number of rows in the sample
+ * @param rowCount number of rows in the table
+ */
+func analyzeColumn(vals []*types.Value, sampleSize int, rowCount int, buckets int) *ColumnStatistics {
+	stats := &ColumnStatistics{}
+	if sampleSize == 0 {
+		return stats
+	}
+	stats.NullFraction = 1 - float64(len(vals))/float64(sampleSize)
+	if len(vals) == 0 {
+		return stats
+	}
+
+	sort.SliceStable(vals, func(i, j int) bool {
+		res, err := vals[i].CompareTo(vals[j])
+		return err == nil && res == types.CmpLess
+	})
+
+	// distinct values and values seen once in the sample, they are sorted
+	distinct, once := 0, 0
+	for i := 0; i < len(vals); {
+		j := i + 1
+		for j < len(vals) && compare(vals[i], vals[j]) == types.CmpEqual {
+			j++
+		}
+		distinct++
+		if j-i == 1 {
+			once++
+		}
+		i = j
+	}
+	stats.DistinctCount = estimateDistinct(len(vals), distinct, once, float64(rowCount)*(1-stats.NullFraction))
+
+	if buckets > len(vals)-1 {
+		buckets = len(vals) - 1
+	}
+	if buckets == 0 {
+		stats.Bounds = []*types.Value{vals[0]}
+		return stats
+	}
+	stats.Bounds = make([]*types.Value, buckets+1)
+	for i := range stats.Bounds {
+		stats.Bounds[i] = vals[i*(len(vals)-1)/buckets]
+	}
+	return stats
+}
+
+/**
+ * Estimate the number of distinct values of a column from a sample, with the
+ * Duj1 estimator of Haas and Stokes as PostgreSQL does.
+ * @param n number of values in the sample
+ * @param d number of distinct values in the sample
+ * @param f1 number of values seen exactly once in the sample
+ * @param total number of values in the table
+ */
+func estimateDistinct(n int, d int, f1 int, total float64) float64 {
+	if float64(n) >= total {
+		return float64(d)
+	}
+	if f1 == n {
+		// no value was seen twice, the column is probably unique
+		return total
+	}
+	estimate := float64(n) * float64(d) / (float64(n-f1) + float64(f1)*float64(n)/total)
+	if estimate < float64(d) {
+		estimate = float64(d)
+	}
+	if estimate > total {
+		estimate = total
+	}
+	return estimate
+}
+
+/**
+ * Estimate the fraction of the rows where the column equals a value.
+ * @param val the value, the predicate is never true for NULL
+ */
+func (cs *ColumnStatistics) EstimateEquality(val *types.Value) float64 {
+	if val.IsNull() || len(cs.Bounds) == 0 {
+		return 0
+	}
+	min, max := cs.Bounds[0], cs.Bounds[len(cs.Bounds)-1]
+	lo, hi := compare(val, min), compare(val, max)
+	if lo == cmpError || hi == cmpError {
+		return defaultEqualitySelectivity
+	}
+	if lo == types.CmpLess || hi == types.CmpGreater {
+		return 0
+	}
+	return (1 - cs.NullFraction) / cs.DistinctCount
+}
+
+/**
+ * Estimate the fraction of the rows where the column is in a range.
+ * @param low the lower bound, nil if there is none
+ * @param high the upper bound, nil if there is none
+ * @param lowInclusive whether the range includes low
+ * @param highInclusive whether the range includes high
+ */
+func (cs *ColumnStatistics) EstimateRange(low *types.Value, high *types.Value, lowInclusive bool, highInclusive bool) float64 {
+	if (low != nil && low.IsNull()) || (high != nil && high.IsNull()) || len(cs.Bounds) == 0 {
+		return 0
+	}
+
+	// fraction of the non-NULL values in the range
+	from, to := 0.0, 1.0
+	if low != nil {
+		below, ok := cs.fractionBelow(low)
+		if !ok {
+			return defaultRangeSelectivity
+		}
+		from = below
+		if !lowInclusive {
+			from += cs.EstimateEquality(low) / (1 - cs.NullFraction)
+		}
+	}
+	if high != nil {
+		below, ok := cs.fractionBelow(high)
+		if !ok {
+			return defaultRangeSelectivity
+		}
+		to = below
+		if highInclusive {
+			to += cs.EstimateEquality(high) / (1 - cs.NullFraction)
+		}
+	}
+	if to <= from {
+		return 0
+	}
+	if to > 1 {
+		to = 1
+	}
+	return (to - from) * (1 - cs.NullFraction)
+}
+
+// the fraction of the non-NULL values less than val according to the histogram
+func (cs *ColumnStatistics) fractionBelow(val *types.Value) (float64, bool) {
+	failed := false
+	// the first bound not less than val
+	j := sort.Search(len(cs.Bounds), func(i int) bool {
+		res := compare(cs.Bounds[i], val)
+		failed = failed || res == cmpError
+		return res != types.CmpLess
+	})
+	if failed {
+		return 0, false
+	}
+	if j == 0 {
+		return 0, true
+	}
+	if j == len(cs.Bounds) {
+		return 1, true
+	}
+	// val is in the bucket between bounds j-1 and j
+	buckets := float64(len(cs.Bounds) - 1)
+	return (float64(j-1) + interpolate(cs.Bounds[j-1], cs.Bounds[j], val)) / buckets, true
+}
+
+// where val is between lo and hi, as a fraction of the way from lo to hi
+func interpolate(lo *types.Value, hi *types.Value, val *types.Value) float64 {
+	// timestamps are numbers too, in nanoseconds
+	l, lok := lo.ToFloat64()
+	h, hok := hi.ToFloat64()
+	v, vok := val.ToFloat64()
+	if !lok || !hok || !vok {
+		// no notion of distance, assume the middle of the bucket
+		return 0.5
+	}
+	if h <= l {
+		return 0
+	}
+	return (v - l) / (h - l)
+}
+
+// CompareTo result for values that can't be compared
+const cmpError = types.CmpResult(2)
+
+func compare(l *types.Value, r *types.Value) types.CmpResult {
+	res, err := l.CompareTo(r)
+	if err != nil {
+		return cmpError
+	}
+	return res
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package catalog
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"goostub/buffer"
+	"goostub/common"
+	"goostub/schema"
+	"goostub/storage/disk"
+	"goostub/storage/table"
+	"goostub/types"
+	"path/filepath"
+	"testing"
+)
+
+func TestAnalyzeTable(t *testing.T) {
+	a := assert.New(t)
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	cat := NewCatalog(buffer.NewBufferPoolManager(16, dm, nil), nil, nil)
+	s, err := schema.ParseSchema("id:INTEGER, grp:SMALLINT, val:BIGINT, name:VARCHAR")
+	a.Nil(err)
+	info := cat.CreateTable(nil, "t", s)
+
+	const n = 10000
+	var rid common.RID
+	for i := 0; i < n; i++ {
+		val := types.NewValue(types.BIGINT)
+		if i%4 != 0 {
+			val = types.NewValue(types.BIGINT, int64(i))
+		}
+		a.True(info.Table.InsertTuple(table.NewTuple([]*types.Value{
+			types.NewValue(types.INTEGER, int32(i)),
+			types.NewValue(types.SMALLINT, int16(i%10)),
+			val,
+			types.NewValue(types.VARCHAR, fmt.Sprintf("user%05d", i)),
+		}, s), &rid, nil))
+	}
+
+	_, err = cat.AnalyzeTable("missing", AnalyzeOptions{})
+	a.Error(err)
+	a.Nil(info.Stats)
+	stats, err := cat.AnalyzeTable("t", AnalyzeOptions{})
+	a.Nil(err)
+	a.Same(stats, info.Stats)
+	a.Equal(n, stats.RowCount)
+	a.Equal(n, stats.SampleSize)
+	a.Equal(info.Table.GetFreeSpaceMap().GetPageCount(), stats.PageCount)
+	a.Greater(stats.PageCount, 1)
+
+	// with the whole table, the counts are exact
+	id, grp, val, name := stats.Columns[0], stats.Columns[1], stats.Columns[2], stats.Columns[3]
+	a.Equal(0.0, id.NullFraction)
+	a.Equal(float64(n), id.DistinctCount)
+	a.Equal(10.0, grp.DistinctCount)
+	a.Equal(0.25, val.NullFraction)
+	a.Len(id.Bounds, 101)
+	a.Equal("0", id.Bounds[0].String())
+	a.Equal(fmt.Sprint(n-1), id.Bounds[100].String())
+
+	a.InDelta(1.0/n, id.EstimateEquality(types.NewValue(types.INTEGER, int32(42))), 1e-9)
+	a.Equal(0.0, id.EstimateEquality(types.NewValue(types.INTEGER, int32(-1))))
+	a.Equal(0.0, id.EstimateEquality(types.NewValue(types.INTEGER)))
+	a.InDelta(0.1, grp.EstimateEquality(types.NewValue(types.INTEGER, int32(3))), 1e-9)
+	a.InDelta(0.75/7500, val.EstimateEquality(types.NewValue(types.BIGINT, int64(5))), 1e-9)
+
+	low, high := types.NewValue(types.INTEGER, int32(1000)), types.NewValue(types.INTEGER, int32(3000))
+	a.InDelta(0.2, id.EstimateRange(low, high, true, false), 0.01)
+	a.InDelta(0.1, id.EstimateRange(nil, low, false, false), 0.01)
+	a.InDelta(0.7, id.EstimateRange(high, nil, true, false), 0.01)
+	a.InDelta(0.15, val.EstimateRange(low, high, true, true), 0.01)
+	a.Equal(0.0, id.EstimateRange(high, low, true, true))
+	a.InDelta(1.0, id.EstimateRange(nil, nil, false, false), 1e-9)
+	a.InDelta(0.5, grp.EstimateRange(types.NewValue(types.INTEGER, int32(4)), nil, false, false), 0.02)
+	a.InDelta(0.1, name.EstimateRange(
+		types.NewValue(types.VARCHAR, "user02000"), types.NewValue(types.VARCHAR, "user03000"), true, false), 0.02)
+
+	// a sample gives estimates
+	stats, err = cat.AnalyzeTable("t", AnalyzeOptions{SampleSize: 1000, HistogramBuckets: 20, Seed: 1})
+	a.Nil(err)
+	a.Equal(n, stats.RowCount)
+	a.Equal(1000, stats.SampleSize)
+	a.Len(stats.Columns[0].Bounds, 21)
+	a.Equal(float64(n), stats.Columns[0].DistinctCount)
+	a.Equal(10.0, stats.Columns[1].DistinctCount)
+	a.InDelta(0.25, stats.Columns[2].NullFraction, 0.05)
+	a.InDelta(0.2, stats.Columns[0].EstimateRange(low, high, true, false), 0.05)
+}
+
+func TestEstimateRangeTimestamp(t *testing.T) {
+	a := assert.New(t)
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	s, err := schema.ParseSchema("ts:TIMESTAMP")
+	a.Nil(err)
+	heap := table.NewTableHeap(buffer.NewBufferPoolManager(16, dm, nil), nil, nil, nil)
+
+	// one row a second
+	const n, start = 1000, uint64(1650000000000000000)
+	second := func(i int) *types.Value {
+		return types.NewValue(types.TIMESTAMP, start+uint64(i)*1000000000)
+	}
+	var rid common.RID
+	for i := 0; i < n; i++ {
+		a.True(heap.InsertTuple(table.NewTuple([]*types.Value{second(i)}, s), &rid, nil))
+	}
+	ts := Analyze(heap, s, AnalyzeOptions{HistogramBuckets: 2}).Columns[0]
+
+	// the bounds are spread within the buckets by their time
+	a.InDelta(0.1, ts.EstimateRange(nil, second(100), false, false), 0.01)
+	a.InDelta(0.3, ts.EstimateRange(second(600), second(900), true, false), 0.01)
+}
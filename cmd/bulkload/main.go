@@ -0,0 +1,180 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+// bulkload creates a table in a GoosTub database file and fills it from a CSV file
+// or from a binary dump written by export.
+//
+// usage: bulkload -db test.db -schema "id:INTEGER,name:VARCHAR" [-table t] [-index idx_id=id] [-hash fnv1a] [-csv data.csv]
+//
+//	bulkload -db test.db -format dump [-csv data.dump]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"goostub/buffer"
+	"goostub/catalog"
+	"goostub/hash"
+	"goostub/schema"
+	"goostub/storage/disk"
+	"goostub/tools/bulkload"
+	"goostub/tools/export"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// repeatable -index flag
+type indexFlags []string
+
+func (f *indexFlags) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *indexFlags) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+func main() {
+	dbFile := flag.String("db", "", "database file to load into")
+	tableName := flag.String("table", "t", "name of the table to create")
+	schemaSpec := flag.String("schema", "", "columns of the table, e.g. \"id:INTEGER,name:VARCHAR\", taken from the dump if empty")
+	csvFile := flag.String("csv", "", "file to load, standard input if empty")
+	format := flag.String("format", "csv", "input format: csv or dump")
+	delim := flag.String("delim", ",", "field delimiter")
+	nullString := flag.String("null", "", "fields equal to this string are loaded as NULL")
+	skipBad := flag.Bool("skip-bad-rows", false, "report and skip rows that fail to convert instead of stopping")
+	poolSize := flag.Uint("pool", 64, "number of frames of the buffer pool")
+	keySize := flag.Uint("keysize", 0, "key size in bytes of the hash indexes, 0 to derive it from the key columns, which gives variable-length keys for VARCHAR columns")
+	hashName := flag.String("hash", "xxhash", "hash function of the hash indexes: xxhash, fnv1a, crc32c or seeded_xxhash")
+	hashSeed := flag.Uint64("hashseed", 0, "seed of the seeded hash functions")
+	var indexes indexFlags
+	flag.Var(&indexes, "index", "hash index to build after the load, as name=col1+col2, can be repeated")
+	flag.Parse()
+
+	if *dbFile == "" || (*schemaSpec == "" && *format != "dump") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *format != "csv" && *format != "dump" {
+		fail(fmt.Errorf("unknown input format %q", *format))
+	}
+
+	hashId, err := hash.ParseHashFuncId(*hashName)
+	if err != nil {
+		fail(err)
+	}
+	hashSpec := hash.HashSpec{Id: hashId, Seed: *hashSeed}
+
+	comma, size := utf8.DecodeRuneInString(*delim)
+	if size == 0 || size != len(*delim) {
+		fail(fmt.Errorf("the delimiter must be a single character, got %q", *delim))
+	}
+
+	var in io.Reader = os.Stdin
+	if *csvFile != "" {
+		f, err := os.Open(*csvFile)
+		if err != nil {
+			fail(err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var dump *export.DumpReader
+	var s *schema.Schema
+	if *format == "dump" {
+		if dump, err = export.NewDumpReader(in); err != nil {
+			fail(err)
+		}
+		s = dump.Schema()
+	}
+	if *schemaSpec != "" {
+		if s, err = schema.ParseSchema(*schemaSpec); err != nil {
+			fail(err)
+		}
+	}
+
+	dm := disk.NewDiskManager(*dbFile)
+	if dm == nil {
+		fail(fmt.Errorf("the database file name needs an extension, got %q", *dbFile))
+	}
+	defer dm.ShutDown()
+	bpm := buffer.NewBufferPoolManager(uint32(*poolSize), dm, nil)
+	cat := catalog.NewCatalog(bpm, nil, nil)
+	info := cat.CreateTable(nil, *tableName, s)
+	if info == nil {
+		fail(fmt.Errorf("failed to create table %s", *tableName))
+	}
+	for _, spec := range indexes {
+		createIndex(cat, *tableName, s, spec, uintptr(*keySize), hashSpec)
+	}
+
+	opts := bulkload.Options{
+		Comma:       comma,
+		NullString:  *nullString,
+		SkipBadRows: *skipBad,
+	}
+	var stats *bulkload.Stats
+	if dump != nil {
+		stats, err = bulkload.LoadDump(cat, *tableName, dump, opts)
+	} else {
+		stats, err = bulkload.Load(cat, *tableName, in, opts)
+	}
+	if stats != nil {
+		for _, rowErr := range stats.BadRows {
+			fmt.Fprintln(os.Stderr, "skipped", rowErr)
+		}
+	}
+	bpm.FlushAllPages(nil)
+	if err != nil {
+		fail(err)
+	}
+
+	fmt.Printf("loaded %d rows into %s, %d pages starting at page %d\n",
+		stats.Rows, *tableName, info.Table.GetFreeSpaceMap().GetPageCount(), info.Table.GetFirstPageId())
+	for _, idx := range cat.GetTableIndexes(*tableName) {
+		fmt.Printf("built index %s on (%s)\n", idx.Name, strings.Join(columnNames(&idx.KeySchema), ", "))
+	}
+}
+
+func createIndex(cat *catalog.Catalog, tableName string, s *schema.Schema, spec string, keySize uintptr, hashSpec hash.HashSpec) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		fail(fmt.Errorf("malformed index %q, expected name=col1+col2", spec))
+	}
+	keyCols := strings.Split(parts[1], "+")
+	attrs := make([]uint32, len(keyCols))
+	for i, name := range keyCols {
+		colIdx := s.GetColIdx(name)
+		if colIdx < 0 {
+			fail(fmt.Errorf("index %s: unknown column %q", parts[0], name))
+		}
+		attrs[i] = uint32(colIdx)
+	}
+
+	keySchema := schema.CopySchema(s, attrs)
+	if keySize == 0 && keySchema.IsInlined() {
+		keySize = uintptr(keySchema.GetLength())
+	}
+	if cat.CreateIndex(nil, parts[0], tableName, s, keySchema, keyCols, keySize, catalog.IndexOptions{HashSpec: hashSpec}) == nil {
+		fail(fmt.Errorf("failed to create index %s", parts[0]))
+	}
+}
+
+func columnNames(s *schema.Schema) []string {
+	names := make([]string, s.GetColumnCount())
+	for i := range names {
+		names[i] = s.GetColumn(i).GetName()
+	}
+	return names
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "bulkload:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+// export writes a table of a GoosTub database file as CSV, JSON Lines or a binary dump.
+//
+// usage: export -db test.db -schema "id:INTEGER,name:VARCHAR" -page 0 [-format csv|jsonl|dump] [-out data.csv]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"goostub/buffer"
+	"goostub/common"
+	"goostub/schema"
+	"goostub/storage/disk"
+	"goostub/storage/table"
+	"goostub/tools/export"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+func main() {
+	dbFile := flag.String("db", "", "database file containing the table")
+	schemaSpec := flag.String("schema", "", "columns of the table, e.g. \"id:INTEGER,name:VARCHAR\"")
+	firstPage := flag.Int("page", common.HeaderPageID, "id of the first page of the table")
+	format := flag.String("format", "csv", "output format: csv, jsonl or dump")
+	outFile := flag.String("out", "", "file to write, standard output if empty")
+	delim := flag.String("delim", ",", "CSV field delimiter")
+	nullString := flag.String("null", "", "how NULL is written in CSV")
+	poolSize := flag.Uint("pool", 64, "number of frames of the buffer pool")
+	flag.Parse()
+
+	if *dbFile == "" || *schemaSpec == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	s, err := schema.ParseSchema(*schemaSpec)
+	if err != nil {
+		fail(err)
+	}
+	f, err := export.ParseFormat(*format)
+	if err != nil {
+		fail(err)
+	}
+	comma, size := utf8.DecodeRuneInString(*delim)
+	if size == 0 || size != len(*delim) {
+		fail(fmt.Errorf("the delimiter must be a single character, got %q", *delim))
+	}
+	if _, err := os.Stat(*dbFile); err != nil {
+		fail(err)
+	}
+
+	var out io.Writer = os.Stdout
+	if *outFile != "" {
+		file, err := os.Create(*outFile)
+		if err != nil {
+			fail(err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	dm := disk.NewDiskManager(*dbFile)
+	if dm == nil {
+		fail(fmt.Errorf("the database file name needs an extension, got %q", *dbFile))
+	}
+	defer dm.ShutDown()
+	bpm := buffer.NewBufferPoolManager(uint32(*poolSize), dm, nil)
+	heap := table.OpenTableHeap(bpm, nil, nil, common.PageID(*firstPage))
+	if heap == nil {
+		fail(fmt.Errorf("failed to open the table at page %d", *firstPage))
+	}
+
+	count, err := export.ExportTable(heap, s, out, f, export.Options{Comma: comma, NullString: *nullString})
+	if err != nil {
+		fail(err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d rows as %s\n", count, f)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "export:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+// pageinspect prints the content of a page of a GoosTub database file.
+//
+// usage: pageinspect -db test.db -page 3 [-type table|header|directory|bucket] [-keysize 8] [-format text|json]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"goostub/common"
+	"goostub/tools/pageinspect"
+	"os"
+)
+
+func main() {
+	dbFile := flag.String("db", "", "database file to inspect")
+	pid := flag.Int("page", common.HeaderPageID, "id of the page to inspect")
+	pageType := flag.String("type", "table", "type of the page: table, header, directory or bucket")
+	keySize := flag.Uint("keysize", 8, "key size in bytes, for hash bucket pages, 0 for variable-length keys")
+	format := flag.String("format", "text", "output format: text or json")
+	followPages := flag.Bool("follow", false, "for header and directory pages, also inspect every page they point to")
+	flag.Parse()
+
+	if *dbFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	t, err := pageinspect.ParsePageType(*pageType)
+	if err != nil {
+		fail(err)
+	}
+	if *format != "text" && *format != "json" {
+		fail(fmt.Errorf("unknown output format %q", *format))
+	}
+
+	f, err := os.Open(*dbFile)
+	if err != nil {
+		fail(err)
+	}
+	defer f.Close()
+
+	info := inspect(f, common.PageID(*pid), t, uint32(*keySize), *format)
+	if *followPages {
+		follow(f, info, uint32(*keySize), *format)
+	}
+}
+
+// inspect the pages a hash header or directory page points to, down to the buckets
+func follow(f *os.File, info pageinspect.PageInfo, keySize uint32, format string) {
+	switch info := info.(type) {
+	case *pageinspect.HeaderPageInfo:
+		for _, e := range info.Entries {
+			follow(f, inspect(f, e.DirectoryPageID, pageinspect.HashDirectoryPageType, keySize, format), keySize, format)
+		}
+	case *pageinspect.DirectoryPageInfo:
+		for _, bucketPid := range info.BucketIDs {
+			inspect(f, bucketPid, pageinspect.HashBucketPageType, keySize, format)
+		}
+	}
+}
+
+func inspect(f *os.File, pid common.PageID, t pageinspect.PageType, keySize uint32, format string) pageinspect.PageInfo {
+	p, err := pageinspect.ReadPage(f, pid)
+	if err != nil {
+		fail(err)
+	}
+	info, err := pageinspect.Inspect(p, t, keySize)
+	if err != nil {
+		fail(err)
+	}
+
+	if format == "json" {
+		if err := pageinspect.WriteJSON(os.Stdout, info); err != nil {
+			fail(err)
+		}
+	} else {
+		info.WriteText(os.Stdout)
+	}
+	return info
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "pageinspect:", err)
+	os.Exit(1)
+}
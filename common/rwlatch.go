@@ -15,6 +15,8 @@ type ReaderWriterLatch interface {
 	WUnlock()
 	RLock()
 	RUnlock()
+	// take a read latch if it is free, return whether it was
+	TryRLock() bool
 }
 
 func NewRWLatch() ReaderWriterLatch {
@@ -76,6 +78,18 @@ func (l *readerWriterLatch) RLock() {
 	l.readerCount++
 }
 
+func (l *readerWriterLatch) TryRLock() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.writerEntered || l.readerCount == MaxReaders {
+		return false
+	}
+
+	l.readerCount++
+	return true
+}
+
 func (l *readerWriterLatch) RUnlock() {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
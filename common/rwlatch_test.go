@@ -50,3 +50,18 @@ func TestRWLatch(t *testing.T) {
 	wg.Wait()
 	assert.Equal(t, 55, c.read())
 }
+
+func TestRWLatchTryRLock(t *testing.T) {
+	a := assert.New(t)
+	l := NewRWLatch()
+	a.True(l.TryRLock())
+	a.True(l.TryRLock())
+	l.RUnlock()
+	l.RUnlock()
+
+	l.WLock()
+	a.False(l.TryRLock())
+	l.WUnlock()
+	a.True(l.TryRLock())
+	l.RUnlock()
+}
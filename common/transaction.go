@@ -29,6 +29,17 @@ type Transaction interface {
 	SetPrevLSN(LSN)
 }
 
+/**
+ * Implemented by transactions that run under SnapshotIsolation. They read
+ * the tuple versions committed before they began plus their own writes, and
+ * take no shared locks. See concurrency/transaction/mvcc.go.
+ */
+type SnapshotTransaction interface {
+	Transaction
+	// whether the writes of txnId are visible to the transaction, InvalidTxnID is visible to all
+	IsVisible(txnId TxnID) bool
+}
+
 /**
  * Transaction states for 2PL:
  *
@@ -59,6 +70,7 @@ const (
 	ReadUncommitted IsolationLevel = iota
 	RepeatableRead
 	ReadCommitted
+	SnapshotIsolation
 )
 
 // Type of write operation.
@@ -0,0 +1,134 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package transaction
+
+import (
+	"goostub/common"
+	"goostub/storage/table"
+)
+
+/**
+ * Snapshot taken when a transaction begins. Transaction ids grow with every
+ * Begin, so a transaction that is neither running nor started after the
+ * snapshot is over. It committed, since an abort removes the versions it
+ * created before the transaction stops running. A snapshot therefore sees
+ * the writes of a transaction iff it is older than xmax and wasn't running
+ * when the snapshot was taken.
+ */
+type snapshot struct {
+	txnId common.TxnID
+	// the first transaction that began after the snapshot
+	xmax common.TxnID
+	// transactions running when the snapshot was taken
+	running map[common.TxnID]struct{}
+}
+
+func (s *snapshot) isVisible(txnId common.TxnID) bool {
+	if txnId == common.InvalidTxnID || txnId == s.txnId {
+		return true
+	}
+	if txnId >= s.xmax {
+		return false
+	}
+	_, running := s.running[txnId]
+	return !running
+}
+
+// the oldest transaction whose writes the snapshot may not see
+func (s *snapshot) xmin() common.TxnID {
+	xmin := s.txnId
+	for txnId := range s.running {
+		if txnId < xmin {
+			xmin = txnId
+		}
+	}
+	return xmin
+}
+
+// take a snapshot for txn, needs the active latch
+func (tm *TransactionManager) takeSnapshot(txnId common.TxnID) *snapshot {
+	s := &snapshot{
+		txnId:   txnId,
+		xmax:    tm.nextTxnId,
+		running: make(map[common.TxnID]struct{}, len(tm.active)),
+	}
+	if s.xmax <= txnId {
+		s.xmax = txnId + 1
+	}
+	for running := range tm.active {
+		s.running[running] = struct{}{}
+	}
+	return s
+}
+
+/**
+ * Every transaction before the horizon is over and seen by all running
+ * transactions, so the versions it replaced or deleted can go.
+ * Needs the active latch.
+ */
+func (tm *TransactionManager) horizon() common.TxnID {
+	horizon := tm.nextTxnId
+	for _, s := range tm.active {
+		if xmin := s.xmin(); xmin < horizon {
+			horizon = xmin
+		}
+	}
+	return horizon
+}
+
+/**
+ * Drop the tuple versions no running transaction can see anymore, in every
+ * table a finished transaction wrote to and that may still have some.
+ */
+func (tm *TransactionManager) GarbageCollect() {
+	tm.activeLatch.Lock()
+	horizon := tm.horizon()
+	tables := make(map[*table.TableHeap]uint64, len(tm.versionedTables))
+	for t, finished := range tm.versionedTables {
+		tables[t] = finished
+	}
+	tm.activeLatch.Unlock()
+	tm.collect(horizon, tables)
+}
+
+/**
+ * The transaction is over, forget its snapshot and collect the versions
+ * nobody needs anymore. Only the tables it wrote to are collected, unless it
+ * was holding back the horizon: the versions of every table may go then.
+ */
+func (tm *TransactionManager) finish(txn common.Transaction, written map[*table.TableHeap]struct{}) {
+	tm.activeLatch.Lock()
+	before := tm.horizon()
+	delete(tm.active, txn.GetTransactionId())
+	tm.numFinished++
+	tables := make(map[*table.TableHeap]uint64, len(written))
+	for t := range written {
+		tm.versionedTables[t] = tm.numFinished
+		tables[t] = tm.numFinished
+	}
+	horizon := tm.horizon()
+	if horizon > before {
+		for t, finished := range tm.versionedTables {
+			tables[t] = finished
+		}
+	}
+	tm.activeLatch.Unlock()
+	tm.collect(horizon, tables)
+}
+
+// collect the tables, and forget those left without versions unless a transaction wrote to them since
+func (tm *TransactionManager) collect(horizon common.TxnID, tables map[*table.TableHeap]uint64) {
+	for t, finished := range tables {
+		if t.CollectGarbage(horizon) > 0 {
+			continue
+		}
+		tm.activeLatch.Lock()
+		if tm.versionedTables[t] == finished {
+			delete(tm.versionedTables, t)
+		}
+		tm.activeLatch.Unlock()
+	}
+}
@@ -0,0 +1,303 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package transaction
+
+import (
+	"github.com/go-kit/kit/log/level"
+	"github.com/stretchr/testify/assert"
+	"goostub/buffer"
+	"goostub/common"
+	"goostub/concurrency"
+	"goostub/schema"
+	"goostub/storage/disk"
+	"goostub/storage/table"
+	"goostub/types"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func init() {
+	common.InitLogger(level.AllowNone())
+}
+
+var testSchema, _ = schema.ParseSchema("id:INTEGER, val:INTEGER")
+
+func newTestEnv(t *testing.T) (*TransactionManager, *table.TableHeap) {
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	lm := &concurrency.LockManager{}
+	heap := table.NewTableHeap(buffer.NewBufferPoolManager(16, dm, nil), lm, nil, nil)
+	return NewTransactionManager(lm, nil), heap
+}
+
+func row(id int, val int) *table.Tuple {
+	return table.NewTuple([]*types.Value{
+		types.NewValue(types.INTEGER, int32(id)),
+		types.NewValue(types.INTEGER, int32(val)),
+	}, testSchema)
+}
+
+func column(tuple *table.Tuple, colIdx int) int {
+	v, _ := strconv.Atoi(tuple.GetValue(testSchema, colIdx).String())
+	return v
+}
+
+// val of the tuple at rid as txn sees it, -1 if it doesn't
+func readVal(heap *table.TableHeap, rid common.RID, txn common.Transaction) int {
+	tuple := &table.Tuple{}
+	if !heap.GetTuple(rid, tuple, txn) {
+		return -1
+	}
+	return column(tuple, 1)
+}
+
+// id -> val of all tuples txn sees
+func scan(heap *table.TableHeap, txn common.Transaction) map[int]int {
+	vals := make(map[int]int)
+	it := heap.Begin(txn)
+	for tuple, ok := it.Next(); ok; tuple, ok = it.Next() {
+		vals[column(tuple, 0)] = column(tuple, 1)
+	}
+	return vals
+}
+
+func insertRows(a *assert.Assertions, tm *TransactionManager, heap *table.TableHeap, n int) []common.RID {
+	txn := tm.Begin(nil, common.SnapshotIsolation)
+	rids := make([]common.RID, n)
+	for i := range rids {
+		a.True(heap.InsertTuple(row(i, 0), &rids[i], txn))
+	}
+	tm.Commit(txn)
+	return rids
+}
+
+func TestSnapshotIsolation(t *testing.T) {
+	a := assert.New(t)
+	tm, heap := newTestEnv(t)
+	rids := insertRows(a, tm, heap, 10)
+	a.Equal(0, heap.GetVersionCount())
+
+	reader := tm.Begin(nil, common.SnapshotIsolation)
+	writer := tm.Begin(nil, common.SnapshotIsolation)
+	a.True(heap.UpdateTuple(row(1, 100), rids[1], writer))
+	a.True(heap.UpdateTuple(row(1, 101), rids[1], writer))
+	a.True(heap.MarkDelete(rids[2], writer))
+	var newRid common.RID
+	a.True(heap.InsertTuple(row(10, 0), &newRid, writer))
+
+	// the writer sees its own writes, the reader doesn't
+	a.Equal(101, readVal(heap, rids[1], writer))
+	a.Equal(-1, readVal(heap, rids[2], writer))
+	a.Equal(0, readVal(heap, newRid, writer))
+	a.Equal(0, readVal(heap, rids[1], reader))
+	a.Equal(0, readVal(heap, rids[2], reader))
+	a.Equal(-1, readVal(heap, newRid, reader))
+	a.Len(scan(heap, writer), 10)
+	a.Len(scan(heap, reader), 10)
+	a.Equal(0, scan(heap, reader)[1])
+
+	// nor after the writer commits, the old versions stay for it
+	tm.Commit(writer)
+	a.Equal(2, heap.GetVersionCount())
+	a.Equal(0, readVal(heap, rids[1], reader))
+	a.Equal(0, readVal(heap, rids[2], reader))
+	a.Equal(-1, readVal(heap, newRid, reader))
+
+	// a transaction that begins now sees the commit, with or without locks
+	later := tm.Begin(nil, common.SnapshotIsolation)
+	locking := tm.Begin(nil, common.RepeatableRead)
+	for _, txn := range []common.Transaction{later, locking} {
+		vals := scan(heap, txn)
+		a.Len(vals, 10)
+		a.Equal(101, vals[1])
+		_, ok := vals[2]
+		a.False(ok)
+		a.Equal(0, vals[10])
+	}
+	tm.Commit(locking)
+
+	// the old versions and the deleted tuple go once the reader is done, and
+	// so is the transaction that began while the reader was running
+	tm.Commit(reader)
+	a.Equal(2, heap.GetVersionCount())
+	tm.Commit(later)
+	a.Equal(0, heap.GetVersionCount())
+	a.Equal(0, heap.CollectGarbage(common.InvalidTxnID))
+	a.Len(scan(heap, nil), 10)
+}
+
+func TestSnapshotWriteConflict(t *testing.T) {
+	a := assert.New(t)
+	tm, heap := newTestEnv(t)
+	rids := insertRows(a, tm, heap, 2)
+
+	// first updater wins
+	t1 := tm.Begin(nil, common.SnapshotIsolation)
+	t2 := tm.Begin(nil, common.SnapshotIsolation)
+	a.True(heap.UpdateTuple(row(0, 1), rids[0], t1))
+	a.False(heap.UpdateTuple(row(0, 2), rids[0], t2))
+	a.Equal(common.Aborted, t2.GetState())
+	tm.Abort(t2)
+	tm.Commit(t1)
+
+	// also when the winner already committed
+	t3 := tm.Begin(nil, common.SnapshotIsolation)
+	t4 := tm.Begin(nil, common.SnapshotIsolation)
+	a.True(heap.UpdateTuple(row(1, 1), rids[1], t4))
+	tm.Commit(t4)
+	a.False(heap.MarkDelete(rids[1], t3))
+	a.Equal(common.Aborted, t3.GetState())
+	tm.Abort(t3)
+
+	t5 := tm.Begin(nil, common.SnapshotIsolation)
+	a.Equal(map[int]int{0: 1, 1: 1}, scan(heap, t5))
+	tm.Commit(t5)
+}
+
+func TestSnapshotAbort(t *testing.T) {
+	a := assert.New(t)
+	tm, heap := newTestEnv(t)
+	rids := insertRows(a, tm, heap, 3)
+
+	reader := tm.Begin(nil, common.SnapshotIsolation)
+	txn := tm.Begin(nil, common.SnapshotIsolation)
+	a.True(heap.UpdateTuple(row(0, 1), rids[0], txn))
+	a.True(heap.UpdateTuple(row(0, 2), rids[0], txn))
+	a.True(heap.MarkDelete(rids[1], txn))
+	var rid common.RID
+	a.True(heap.InsertTuple(row(3, 0), &rid, txn))
+	tm.Abort(txn)
+
+	expected := map[int]int{0: 0, 1: 0, 2: 0}
+	a.Equal(expected, scan(heap, reader))
+	tm.Commit(reader)
+	a.Equal(0, heap.GetVersionCount())
+	a.Equal(0, heap.CollectGarbage(common.InvalidTxnID))
+
+	after := tm.Begin(nil, common.SnapshotIsolation)
+	a.Equal(expected, scan(heap, after))
+	tm.Commit(after)
+}
+
+func TestBeginWithTransaction(t *testing.T) {
+	a := assert.New(t)
+	tm, heap := newTestEnv(t)
+
+	// the ids handed out next come after the one of the given transaction
+	given := tm.Begin(NewTransaction(100, common.SnapshotIsolation))
+	var rid common.RID
+	a.True(heap.InsertTuple(row(0, 0), &rid, given))
+	next := tm.Begin(nil, common.SnapshotIsolation)
+	a.Greater(next.GetTransactionId(), common.TxnID(100))
+	// so the snapshots taken meanwhile don't see its writes
+	a.Equal(-1, readVal(heap, rid, next))
+	tm.Commit(given)
+	a.Equal(-1, readVal(heap, rid, next))
+	tm.Commit(next)
+
+	later := tm.Begin(nil, common.SnapshotIsolation)
+	a.Equal(0, readVal(heap, rid, later))
+	tm.Commit(later)
+}
+
+func TestGarbageCollectWrittenTables(t *testing.T) {
+	a := assert.New(t)
+	tm, heap := newTestEnv(t)
+	_, other := newTestEnv(t)
+	rids := insertRows(a, tm, heap, 2)
+	otherRids := insertRows(a, tm, other, 2)
+	// tables without versions are forgotten
+	a.Empty(tm.versionedTables)
+
+	reader := tm.Begin(nil, common.SnapshotIsolation)
+	for _, update := range []struct {
+		heap *table.TableHeap
+		rid  common.RID
+	}{{heap, rids[0]}, {other, otherRids[0]}} {
+		txn := tm.Begin(nil, common.SnapshotIsolation)
+		a.True(update.heap.UpdateTuple(row(0, 1), update.rid, txn))
+		tm.Commit(txn)
+	}
+	// the old versions stay for the reader
+	a.Equal(1, heap.GetVersionCount())
+	a.Equal(1, other.GetVersionCount())
+	a.Len(tm.versionedTables, 2)
+
+	// a transaction that doesn't hold back the horizon only collects what it wrote
+	txn := tm.Begin(nil, common.SnapshotIsolation)
+	a.True(heap.UpdateTuple(row(1, 1), rids[1], txn))
+	tm.Commit(txn)
+	a.Equal(2, heap.GetVersionCount())
+	a.Len(tm.versionedTables, 2)
+
+	// the reader did, every table is collected once it is done
+	tm.Commit(reader)
+	a.Equal(0, heap.GetVersionCount())
+	a.Equal(0, other.GetVersionCount())
+	a.Empty(tm.versionedTables)
+}
+
+func TestSnapshotConcurrentTransfers(t *testing.T) {
+	a := assert.New(t)
+	tm, heap := newTestEnv(t)
+	const accounts, initial = 8, 100
+
+	setup := tm.Begin(nil, common.SnapshotIsolation)
+	rids := make([]common.RID, accounts)
+	for i := range rids {
+		a.True(heap.InsertTuple(row(i, initial), &rids[i], setup))
+	}
+	tm.Commit(setup)
+
+	var wg sync.WaitGroup
+	// writers move money around, retrying on conflicts
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				from, to := (w+i)%accounts, (w+3*i+1)%accounts
+				if from == to {
+					continue
+				}
+				txn := tm.Begin(nil, common.SnapshotIsolation)
+				fromVal, toVal := readVal(heap, rids[from], txn), readVal(heap, rids[to], txn)
+				if heap.UpdateTuple(row(from, fromVal-1), rids[from], txn) &&
+					heap.UpdateTuple(row(to, toVal+1), rids[to], txn) {
+					tm.Commit(txn)
+				} else {
+					tm.Abort(txn)
+				}
+			}
+		}(w)
+	}
+	// readers always see the same total
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				txn := tm.Begin(nil, common.SnapshotIsolation)
+				total := 0
+				for _, val := range scan(heap, txn) {
+					total += val
+				}
+				tm.Commit(txn)
+				a.Equal(accounts*initial, total)
+			}
+		}()
+	}
+	wg.Wait()
+
+	a.Equal(0, heap.GetVersionCount())
+	total := 0
+	for _, val := range scan(heap, nil) {
+		total += val
+	}
+	a.Equal(accounts*initial, total)
+}
@@ -12,12 +12,8 @@ import (
 	"goostub/storage/table"
 )
 
-type TableWriteRecord struct {
-	Rid   common.RID
-	Wtype common.WType
-	Tuple table.Tuple      // only used for update operation
-	Table *table.TableHeap // specify which table the record is for
-}
+// defined in storage/table since the table heap is the one creating it
+type TableWriteRecord = table.TableWriteRecord
 
 type IndexWriteRecord struct {
 	Rid      common.RID //value stored in the index
@@ -56,6 +52,8 @@ type txnInstance struct {
 	prevLSN          common.LSN
 	sharedLockSet    map[common.RID]struct{}
 	exclusiveLockSet map[common.RID]struct{}
+	// taken by TransactionManager.Begin
+	snapshot *snapshot
 }
 
 func (t *txnInstance) GetTransactionId() common.TxnID {
@@ -96,3 +94,14 @@ func (t *txnInstance) GetPrevLSN() common.LSN {
 func (t *txnInstance) SetPrevLSN(lsn common.LSN) {
 	t.prevLSN = lsn
 }
+
+/**
+ * Whether the transaction sees the writes of txnId, see mvcc.go.
+ * Without a snapshot, i.e. before Begin, everything is visible.
+ */
+func (t *txnInstance) IsVisible(txnId common.TxnID) bool {
+	if t.snapshot == nil {
+		return true
+	}
+	return t.snapshot.isVisible(txnId)
+}
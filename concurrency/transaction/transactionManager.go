@@ -9,26 +9,55 @@ import (
 	"goostub/common"
 	"goostub/concurrency"
 	"goostub/recovery"
+	"goostub/storage/table"
 	"sync"
-	"sync/atomic"
 )
 
 var TxnMap = make(map[common.TxnID]common.Transaction)
 var TxnMapMutex = sync.RWMutex{}
 
 type TransactionManager struct {
-	nextTxnId      common.TxnID //operation needs to be atomic on this
 	lockManager    *concurrency.LockManager
 	logManager     *recovery.LogManager
 	globalTxnLatch sync.RWMutex
+
+	// protects the fields below
+	activeLatch sync.Mutex
+	nextTxnId   common.TxnID
+	// snapshots of the running transactions
+	active map[common.TxnID]*snapshot
+	// tables that may hold old tuple versions, with the number of the last finish that wrote to them, see mvcc.go
+	versionedTables map[*table.TableHeap]uint64
+	// transactions finished so far
+	numFinished uint64
+}
+
+func NewTransactionManager(lockManager *concurrency.LockManager, logManager *recovery.LogManager) *TransactionManager {
+	return &TransactionManager{
+		lockManager:     lockManager,
+		logManager:      logManager,
+		active:          make(map[common.TxnID]*snapshot),
+		versionedTables: make(map[*table.TableHeap]uint64),
+	}
 }
 
 func (tm *TransactionManager) Begin(txn common.Transaction, isoLevel ...common.IsolationLevel) common.Transaction {
 	tm.globalTxnLatch.RLock()
+	tm.activeLatch.Lock()
 	if txn == nil {
 		txn = NewTransaction(tm.nextTxnId, isoLevel...)
-		atomic.AddInt32((*int32)(&tm.nextTxnId), 1)
+		tm.nextTxnId++
+	} else if txn.GetTransactionId() >= tm.nextTxnId {
+		// later transactions must get larger ids, snapshots rely on it
+		tm.nextTxnId = txn.GetTransactionId() + 1
+	}
+	s := tm.takeSnapshot(txn.GetTransactionId())
+	tm.active[s.txnId] = s
+	if t, ok := txn.(*txnInstance); ok {
+		t.snapshot = s
 	}
+	tm.activeLatch.Unlock()
+
 	TxnMapMutex.Lock()
 	TxnMap[txn.GetTransactionId()] = txn
 	TxnMapMutex.Unlock()
@@ -39,13 +68,13 @@ func (tm *TransactionManager) Commit(txn common.Transaction) {
 	txn.SetState(common.Committed)
 	writeSet := txn.GetWriteSet()
 
-	// Perform all deletes before we commit.
+	// Deletes are applied by the garbage collection once no snapshot sees the deleted tuples.
+	tables := make(map[*table.TableHeap]struct{})
 	for writeSet.Len() > 0 {
 		item := writeSet.Back().(TableWriteRecord)
-		table := item.Table
+		tables[item.Table] = struct{}{}
 		if item.Wtype == common.Delete {
-			// Note that this also releases the lock when holding the page latch.
-			table.ApplyDelete(item.Rid, txn)
+			item.Table.CommitDelete(item.Rid, txn)
 		}
 		writeSet.PopBack()
 	}
@@ -53,6 +82,7 @@ func (tm *TransactionManager) Commit(txn common.Transaction) {
 
 	// Release all the locks
 	tm.releaseLocks(txn)
+	tm.finish(txn, tables)
 	tm.globalTxnLatch.RUnlock()
 }
 
@@ -61,9 +91,11 @@ func (tm *TransactionManager) Abort(txn common.Transaction) {
 
 	// Rollback before releasing the lock
 	tableWriteSet := txn.GetWriteSet()
+	tables := make(map[*table.TableHeap]struct{})
 	for tableWriteSet.Len() > 0 {
 		item := tableWriteSet.Back().(TableWriteRecord)
 		table := item.Table
+		tables[table] = struct{}{}
 		if item.Wtype == common.Delete {
 			table.RollbackDelete(item.Rid, txn)
 		} else if item.Wtype == common.Insert {
@@ -82,11 +114,43 @@ func (tm *TransactionManager) Abort(txn common.Transaction) {
 		item := indexWriteSet.Back().(IndexWriteRecord)
 		catalog := item.Catalog
 		// Metadata identifying the table that should be deleted from.
-		tableInfo := catalog.GetTable(item.TableOid)
+		tableInfo := catalog.GetTableByOid(item.TableOid)
 		indexInfo := catalog.GetIndex(item.IndexOid)
-		// TODO: left here
+		if tableInfo == nil || indexInfo == nil {
+			// dropped meanwhile, there is nothing left to roll back
+			indexWriteSet.PopBack()
+			continue
+		}
+		keyAttrs := indexInfo.Index.GetKeyAttrs()
+		newKey := item.Tuple.KeyFromTuple(&tableInfo.Schema, &indexInfo.KeySchema, keyAttrs)
+		if item.Wtype == common.Delete {
+			indexInfo.InsertEntry(newKey, item.Rid, txn)
+		} else if item.Wtype == common.Insert {
+			indexInfo.DeleteEntry(newKey, item.Rid, txn)
+		} else if item.Wtype == common.Update {
+			// Delete the new key and insert the old key
+			indexInfo.DeleteEntry(newKey, item.Rid, txn)
+			oldKey := item.OldTuple.KeyFromTuple(&tableInfo.Schema, &indexInfo.KeySchema, keyAttrs)
+			indexInfo.InsertEntry(oldKey, item.Rid, txn)
+		}
+		indexWriteSet.PopBack()
 	}
+	indexWriteSet.Clear()
+
+	// Release all the locks.
+	tm.releaseLocks(txn)
+	tm.finish(txn, tables)
+	tm.globalTxnLatch.RUnlock()
+}
+
+/** Prevents all transactions from performing operations, used for checkpointing and vacuum. */
+func (tm *TransactionManager) BlockAllTransactions() {
+	tm.globalTxnLatch.Lock()
+}
 
+/** Resumes all transactions, used for checkpointing and vacuum. */
+func (tm *TransactionManager) ResumeTransactions() {
+	tm.globalTxnLatch.Unlock()
 }
 
 func (tm *TransactionManager) releaseLocks(txn common.Transaction) {
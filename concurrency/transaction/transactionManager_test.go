@@ -0,0 +1,114 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package transaction
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"goostub/buffer"
+	"goostub/catalog"
+	"goostub/common"
+	"goostub/storage/disk"
+	"goostub/storage/index"
+	"goostub/storage/table"
+	"goostub/types"
+	"path/filepath"
+	"testing"
+)
+
+func TestUniqueViolationAborts(t *testing.T) {
+	a := assert.New(t)
+	tm, heap := newTestEnv(t)
+	m := index.NewIndexMetadata("idx_id", "t", []uint32{0}, testSchema)
+	m.SetConstraint(index.Unique, heap.IsLive)
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "index.db"))
+	t.Cleanup(dm.ShutDown)
+	idx := index.NewIndex[*index.ExtendibleHashTableIndex](m, buffer.NewBufferPoolManager(8, dm, nil), uintptr(8))
+	key := func(tuple *table.Tuple) *table.Tuple {
+		return tuple.KeyFromTuple(testSchema, m.GetKeySchema(), m.GetKeyAttrs())
+	}
+
+	txn1 := tm.Begin(nil)
+	var rid1 common.RID
+	a.True(heap.InsertTuple(row(1, 10), &rid1, txn1))
+	a.Nil(idx.InsertEntry(key(row(1, 10)), rid1, txn1))
+	tm.Commit(txn1)
+
+	txn2 := tm.Begin(nil)
+	var rid2 common.RID
+	a.True(heap.InsertTuple(row(1, 20), &rid2, txn2))
+	a.NotNil(idx.InsertEntry(key(row(1, 20)), rid2, txn2))
+	a.Equal(common.Aborted, txn2.GetState())
+	tm.Abort(txn2)
+	a.False(heap.IsLive(rid2))
+
+	var found []common.RID
+	idx.ScanKey(table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, int32(1))}, m.GetKeySchema()), &found, nil)
+	a.Equal([]common.RID{rid1}, found)
+}
+
+func TestUniqueKeyOfPendingDelete(t *testing.T) {
+	a := assert.New(t)
+	tm, heap := newTestEnv(t)
+	m := index.NewIndexMetadata("idx_id", "t", []uint32{0}, testSchema)
+	m.SetConstraint(index.Unique, heap.IsLive)
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "index.db"))
+	t.Cleanup(dm.ShutDown)
+	idx := index.NewIndex[*index.ExtendibleHashTableIndex](m, buffer.NewBufferPoolManager(8, dm, nil), uintptr(8))
+	key := func(tuple *table.Tuple) *table.Tuple {
+		return tuple.KeyFromTuple(testSchema, m.GetKeySchema(), m.GetKeyAttrs())
+	}
+
+	txn0 := tm.Begin(nil)
+	var rid0 common.RID
+	a.True(heap.InsertTuple(row(1, 10), &rid0, txn0))
+	a.Nil(idx.InsertEntry(key(row(1, 10)), rid0, txn0))
+	tm.Commit(txn0)
+
+	// txn1 deletes the key but may still abort, so txn2 can't take it
+	txn1 := tm.Begin(nil)
+	a.True(heap.MarkDelete(rid0, txn1))
+	a.True(heap.IsLive(rid0))
+	txn2 := tm.Begin(nil)
+	var rid2 common.RID
+	a.True(heap.InsertTuple(row(1, 20), &rid2, txn2))
+	var violation *index.ConstraintViolationError
+	a.True(errors.As(idx.InsertEntry(key(row(1, 20)), rid2, txn2), &violation))
+	a.Equal(rid0, violation.Conflict)
+	tm.Abort(txn2)
+	tm.Abort(txn1)
+	a.True(heap.IsLive(rid0))
+
+	// once the delete commits the key is free, before the garbage collection removes the tuple
+	reader := tm.Begin(nil)
+	txn3 := tm.Begin(nil)
+	a.True(heap.MarkDelete(rid0, txn3))
+	tm.Commit(txn3)
+	a.False(heap.IsLive(rid0))
+	txn4 := tm.Begin(nil)
+	var rid4 common.RID
+	a.True(heap.InsertTuple(row(1, 30), &rid4, txn4))
+	a.Nil(idx.InsertEntry(key(row(1, 30)), rid4, txn4))
+	tm.Commit(txn4)
+	tm.Commit(reader)
+}
+
+func TestAbortSkipsDroppedIndex(t *testing.T) {
+	a := assert.New(t)
+	tm, heap := newTestEnv(t)
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "catalog.db"))
+	t.Cleanup(dm.ShutDown)
+	cat := catalog.NewCatalog(buffer.NewBufferPoolManager(8, dm, nil), nil, nil)
+
+	// the index and its table are gone by the time the transaction aborts
+	txn := tm.Begin(nil)
+	var rid common.RID
+	a.True(heap.InsertTuple(row(1, 10), &rid, txn))
+	txn.GetIndexWriteSet().PushBack(IndexWriteRecord{Rid: rid, Wtype: common.Insert, Tuple: *row(1, 10), Catalog: cat})
+	a.NotPanics(func() { tm.Abort(txn) })
+	a.False(heap.IsLive(rid))
+	a.Equal(0, txn.GetIndexWriteSet().Len())
+}
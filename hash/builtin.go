@@ -0,0 +1,98 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package hash
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/cespare/xxhash/v2"
+	"hash/crc32"
+	"hash/fnv"
+)
+
+// identifies a built-in hash function, it is stored on disk so values must not change
+type HashFuncId uint32
+
+const (
+	XXHash HashFuncId = iota
+	FNV1a
+	CRC32C
+	// xxhash of the seed followed by the key
+	SeededXXHash
+)
+
+/**
+ * A built-in hash function and its seed. Unlike a HashFunc it can be
+ * persisted, so that an index hashes the same way after a restart. The zero
+ * value is xxhash, the same as GoosTubHash.
+ */
+type HashSpec struct {
+	Id HashFuncId
+	// only used by the seeded functions
+	Seed uint64
+}
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+/** @return the hash function, nil if the id is unknown */
+func (s HashSpec) Func() HashFunc {
+	switch s.Id {
+	case XXHash:
+		return GoosTubHash
+	case FNV1a:
+		return func(key []byte) uint64 {
+			h := fnv.New64a()
+			h.Write(key)
+			return h.Sum64()
+		}
+	case CRC32C:
+		return func(key []byte) uint64 {
+			return uint64(crc32.Checksum(key, castagnoliTable))
+		}
+	case SeededXXHash:
+		var seed [8]byte
+		binary.LittleEndian.PutUint64(seed[:], s.Seed)
+		return func(key []byte) uint64 {
+			d := xxhash.New()
+			d.Write(seed[:])
+			d.Write(key)
+			return d.Sum64()
+		}
+	}
+	return nil
+}
+
+func (s HashSpec) String() string {
+	switch s.Id {
+	case XXHash:
+		return "xxhash"
+	case FNV1a:
+		return "fnv1a"
+	case CRC32C:
+		return "crc32c"
+	case SeededXXHash:
+		return fmt.Sprintf("xxhash(seed=%d)", s.Seed)
+	}
+	return fmt.Sprintf("unknown(%d)", s.Id)
+}
+
+/**
+ * Parse the name of a built-in hash function, as String writes it but
+ * without a seed: xxhash, fnv1a, crc32c or seeded_xxhash.
+ */
+func ParseHashFuncId(name string) (HashFuncId, error) {
+	switch name {
+	case "xxhash":
+		return XXHash, nil
+	case "fnv1a":
+		return FNV1a, nil
+	case "crc32c":
+		return CRC32C, nil
+	case "seeded_xxhash":
+		return SeededXXHash, nil
+	}
+	return 0, fmt.Errorf("unknown hash function %q", name)
+}
@@ -20,6 +20,11 @@ type Column struct {
 	columnOffset   uint32 // column offset in the tuple
 
 	expr *expressions.AbstractExpression // expression used to create column
+
+	// identifies the column across the versions of a schema
+	id uint32
+	// value of the column in tuples written before it was added, NULL if nil
+	defaultValue *types.Value
 }
 
 func NewColumn(colName string, id types.TypeID, expr *expressions.AbstractExpression) *Column {
@@ -37,6 +42,10 @@ func (c *Column) GetName() string {
 	return c.columnName
 }
 
+func (c *Column) GetType() types.TypeID {
+	return c.columnType
+}
+
 func (c *Column) GetLength() uint32 {
 	if c.IsInlined() {
 		return c.fixedLength
@@ -76,6 +85,11 @@ func (c *Column) GetExpr() *expressions.AbstractExpression {
 	return c.expr
 }
 
+/** @return the value of the column in tuples written before the column was added, nil for NULL */
+func (c *Column) GetDefaultValue() *types.Value {
+	return c.defaultValue
+}
+
 func typeSize(id types.TypeID) uint32 {
 	switch id {
 	case types.BOOLEAN:
@@ -86,9 +100,7 @@ func typeSize(id types.TypeID) uint32 {
 		return 2
 	case types.INTEGER:
 		return 4
-	case types.BIGINT:
-	case types.DECIMAL:
-	case types.TIMESTAMP:
+	case types.BIGINT, types.DECIMAL, types.TIMESTAMP:
 		return 8
 	case types.VARCHAR:
 		return 4
@@ -7,13 +7,20 @@ package schema
 
 import (
 	"fmt"
+	"goostub/types"
 	"strings"
 )
 
+// every tuple starts with the version of the schema it was written with, see version.go
+const TupleVersionSize = 2
+
 type Schema struct {
-	// size of fixed-length columns, i.e. size of one tuple
+	// size of the version, the null bitmap and the fixed-length columns, i.e. size of one tuple
 	length uint32
 
+	// size of the null bitmap at the start of a tuple, one bit per column
+	nullBitmapSize uint32
+
 	// all columns, inlined and uninlined
 	columns []Column
 
@@ -22,14 +29,32 @@ type Schema struct {
 
 	// indices of all uninlined columns
 	uninlinedColumns []int
+
+	// version of the tuples written with this schema
+	version uint16
+	// all versions of the table's schema, nil if it was never altered
+	history *versionHistory
+	// colMaps[v][colIdx] is the index of column colIdx in version v, -1 if version v doesn't have it
+	colMaps [][]int
 }
 
 func NewSchema(columns []Column) *Schema {
+	schema := newLayout(columns)
+	for i := range schema.columns {
+		schema.columns[i].id = uint32(i)
+	}
+	return schema
+}
+
+// lay out the columns, keeping their ids
+func newLayout(columns []Column) *Schema {
 	schema := &Schema{
 		tupleIsInlined: true,
 	}
 
-	curOffset := uint32(0)
+	// columns are laid out after the version and the null bitmap
+	schema.nullBitmapSize = (uint32(len(columns)) + 7) / 8
+	curOffset := TupleVersionSize + schema.nullBitmapSize
 	for idx := 0; idx < len(columns); idx++ {
 		column := columns[idx]
 		// handle uninlined column
@@ -50,6 +75,27 @@ func NewSchema(columns []Column) *Schema {
 	return schema
 }
 
+/**
+ * Build a schema from a textual description, e.g. "id:INTEGER, name:VARCHAR".
+ * @param spec comma separated list of name:type pairs, type names are case insensitive
+ * @return the schema, or an error describing the first malformed column
+ */
+func ParseSchema(spec string) (*Schema, error) {
+	var columns []Column
+	for _, def := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(def), ":")
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("malformed column %q, expected name:type", def)
+		}
+		id := types.StringToTypeID(strings.TrimSpace(parts[1]))
+		if id == types.INVALID {
+			return nil, fmt.Errorf("unknown type %q of column %s", parts[1], parts[0])
+		}
+		columns = append(columns, *NewColumn(strings.TrimSpace(parts[0]), id, nil))
+	}
+	return NewSchema(columns), nil
+}
+
 func CopySchema(from *Schema, attrs []uint32) *Schema {
 	cols := make([]Column, len(attrs))
 	for i := 0; i < len(attrs); i++ {
@@ -93,6 +139,10 @@ func (s *Schema) GetLength() uint32 {
 	return s.length
 }
 
+func (s *Schema) GetNullBitmapSize() uint32 {
+	return s.nullBitmapSize
+}
+
 func (s *Schema) IsInlined() bool {
 	return s.tupleIsInlined
 }
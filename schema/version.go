@@ -0,0 +1,168 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package schema
+
+import (
+	"encoding/binary"
+	"fmt"
+	"goostub/types"
+	"math"
+)
+
+/**
+ * Schema versions make ALTER TABLE lazy.
+ *
+ * AddColumn and DropColumn don't touch the table, they return a new version
+ * of the schema. Every tuple starts with the version of the schema it was
+ * written with, so a tuple written before an ALTER keeps its old layout until
+ * it is rewritten with the new schema, see Tuple.Upgrade. Reading a column
+ * through the current schema maps it onto the tuple's layout:
+ *
+ *  - a column added after the tuple was written reads as its default value,
+ *  - a dropped column is no longer part of the schema, its data is left
+ *    behind in old tuples but can't be read anymore.
+ *
+ * Columns are matched across versions by an id rather than by name, so that
+ * a column dropped and added again doesn't bring back the old data.
+ */
+
+type versionHistory struct {
+	// versions[v] is the schema of version v
+	versions     []*Schema
+	nextColumnId uint32
+}
+
+/** @return the version of the tuples written with this schema */
+func (s *Schema) GetVersion() uint16 {
+	return s.version
+}
+
+/**
+ * @param version a version of this schema
+ * @return the schema tuples of the version were written with, nil if there is no such version
+ */
+func (s *Schema) GetVersionSchema(version uint16) *Schema {
+	if version == s.version {
+		return s
+	}
+	if s.history == nil || int(version) >= len(s.history.versions) {
+		return nil
+	}
+	return s.history.versions[version]
+}
+
+/**
+ * Find where a column of this schema is in the layout of another version.
+ * @param version version of the tuple
+ * @param colIdx index of the column in this schema
+ * @return the schema of the version and the index of the column in it, -1
+ * if the column was added after the version, an error if the version isn't
+ * one of the earlier versions of this schema
+ */
+func (s *Schema) MapColumn(version uint16, colIdx int) (*Schema, int, error) {
+	if version == s.version {
+		return s, colIdx, nil
+	}
+	if int(version) >= len(s.colMaps) {
+		return nil, -1, fmt.Errorf("version %d of the schema is unknown, the schema is at version %d", version, s.version)
+	}
+	return s.history.versions[version], s.colMaps[version][colIdx], nil
+}
+
+/**
+ * @param tuple data of a tuple
+ * @return the version of the schema the tuple was written with
+ */
+func TupleVersion(tuple []byte) uint16 {
+	return binary.LittleEndian.Uint16(tuple)
+}
+
+/**
+ * Add a column at the end of the schema.
+ * @param name name of the new column
+ * @param id type of the new column
+ * @param defaultValue value of the column in the existing tuples, NULL if nil
+ * @return the next version of the schema
+ */
+func (s *Schema) AddColumn(name string, id types.TypeID, defaultValue *types.Value) (*Schema, error) {
+	if s.GetColIdx(name) >= 0 {
+		return nil, fmt.Errorf("column %s already exists", name)
+	}
+	col := NewColumn(name, id, nil)
+	if defaultValue != nil && !defaultValue.IsNull() {
+		val, err := defaultValue.CastAs(id)
+		if err != nil {
+			return nil, fmt.Errorf("bad default value of column %s: %w", name, err)
+		}
+		col.defaultValue = val
+	}
+
+	columns := make([]Column, len(s.columns), len(s.columns)+1)
+	copy(columns, s.columns)
+	return s.newVersion(append(columns, *col), true)
+}
+
+/**
+ * Remove a column from the schema.
+ * @param name name of the column
+ * @return the next version of the schema
+ */
+func (s *Schema) DropColumn(name string) (*Schema, error) {
+	colIdx := s.GetColIdx(name)
+	if colIdx < 0 {
+		return nil, fmt.Errorf("column %s doesn't exist", name)
+	}
+	if len(s.columns) == 1 {
+		return nil, fmt.Errorf("can't drop %s, the only column", name)
+	}
+
+	columns := make([]Column, 0, len(s.columns)-1)
+	columns = append(columns, s.columns[:colIdx]...)
+	columns = append(columns, s.columns[colIdx+1:]...)
+	return s.newVersion(columns, false)
+}
+
+// the next version of the schema with the given columns, the last one is new if added
+func (s *Schema) newVersion(columns []Column, added bool) (*Schema, error) {
+	h := s.history
+	if h == nil {
+		// the first ALTER, keep a copy since the caller may overwrite s with the new version
+		base := *s
+		h = &versionHistory{versions: []*Schema{&base}, nextColumnId: uint32(len(s.columns))}
+		base.history = h
+	} else if int(s.version) != len(h.versions)-1 {
+		return nil, fmt.Errorf("version %d of the schema is not the latest one", s.version)
+	}
+	if len(h.versions) > math.MaxUint16 {
+		return nil, fmt.Errorf("too many versions of the schema")
+	}
+	if added {
+		columns[len(columns)-1].id = h.nextColumnId
+		h.nextColumnId++
+	}
+
+	next := newLayout(columns)
+	next.version = uint16(len(h.versions))
+	next.history = h
+	next.colMaps = make([][]int, len(h.versions))
+	for v, old := range h.versions {
+		next.colMaps[v] = make([]int, len(columns))
+		for i := range columns {
+			next.colMaps[v][i] = old.columnIdxById(columns[i].id)
+		}
+	}
+	h.versions = append(h.versions, next)
+	return next, nil
+}
+
+func (s *Schema) columnIdxById(id uint32) int {
+	for i := range s.columns {
+		if s.columns[i].id == id {
+			return i
+		}
+	}
+	return -1
+}
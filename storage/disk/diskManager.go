@@ -27,10 +27,12 @@ type DiskManager struct {
 	dbIO       *os.File
 	fileName   string
 	nextPageID common.PageID
-	numFlushes int
-	numWrites  int
-	flushLog   bool
-	flushLogF  *futures.Future
+	// deallocated pages, handed out again before the file grows
+	freePageIDs []common.PageID
+	numFlushes  int
+	numWrites   int
+	flushLog    bool
+	flushLogF   *futures.Future
 }
 
 /**
@@ -203,6 +205,11 @@ func (d *DiskManager) ReadLog(logData []byte, size int, offset int64) bool {
 * @return the id of the allocated page
  */
 func (d *DiskManager) AllocatePage() common.PageID {
+	if n := len(d.freePageIDs); n > 0 {
+		ret := d.freePageIDs[n-1]
+		d.freePageIDs = d.freePageIDs[:n-1]
+		return ret
+	}
 	/* stupid Go! I can't just do
 	 * return d.nextPageID++ */
 	ret := d.nextPageID
@@ -212,10 +219,30 @@ func (d *DiskManager) AllocatePage() common.PageID {
 
 /**
 * Deallocate a page on disk.
+* The page is put on a free list and reused by the next AllocatePage, the
+* file doesn't shrink. The free list is not persisted.
 * @param pageID id of the page to deallocate
-* Does nothing for now
  */
 func (d *DiskManager) DeallocatePage(pageID common.PageID) {
+	if pageID < 0 || pageID >= d.nextPageID {
+		return
+	}
+	for _, pid := range d.freePageIDs {
+		if pid == pageID {
+			return
+		}
+	}
+	d.freePageIDs = append(d.freePageIDs, pageID)
+}
+
+/** @return the number of deallocated pages waiting to be reused */
+func (d *DiskManager) GetNumFreePages() int {
+	return len(d.freePageIDs)
+}
+
+/** @return the number of pages allocated so far, deallocated ones included */
+func (d *DiskManager) GetNumPages() int {
+	return int(d.nextPageID)
 }
 
 /** @return the number of disk flushes */
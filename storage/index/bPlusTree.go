@@ -0,0 +1,876 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package index
+
+import (
+	"errors"
+	"fmt"
+	"goostub/buffer"
+	"goostub/common"
+	"goostub/storage/page"
+	"goostub/storage/page/btree"
+	"math"
+	"sync"
+)
+
+/**
+ * Implementation of a B+Tree backed by a buffer pool manager. Keys are byte
+ * sequences of a fixed size ordered by a comparator. Non-unique keys are
+ * supported: an entry is a key and a RID, and entries are ordered by key then
+ * by RID, so every entry is unique.
+ *
+ * Concurrency uses latch crabbing. Writers latch the pages on their way down
+ * and release the ancestors of a page once it is safe, i.e. once the
+ * operation can't make it split or underflow. The root page id has its own
+ * latch which comes first, as if it were the parent of the root.
+ *
+ * Readers crab too, releasing a page as soon as its child is latched. A
+ * forward scan moves to the next leaf through NextPageId while it still holds
+ * the current one. A writer merging a page into its left sibling latches the
+ * leaves the other way round, so the scan only tries the latch of the next
+ * leaf, and starts over from the root if that fails. The leaves aren't linked
+ * to the left: a reverse scan descends again, below the lowest entry the
+ * current leaf may hold.
+ */
+type bPlusTree struct {
+	bufferManager   *buffer.BufferPoolManager
+	keySize         uint32
+	leafMaxSize     uint32
+	internalMaxSize uint32
+	cmp             btree.KeyComparator
+
+	// protects rootPageId
+	rootLatch  sync.RWMutex
+	rootPageId common.PageID
+}
+
+type bPlusTreeEntry struct {
+	key []byte
+	rid common.RID
+}
+
+/**
+ * Create an empty B+Tree.
+ * @param keySize size of the keys in bytes
+ * @param leafMaxSize number of entries of a leaf, 0 to fill the pages
+ * @param internalMaxSize number of children of an internal page, 0 to fill the pages
+ * @return nil if the sizes don't fit in a page
+ */
+func newBPlusTree(bm *buffer.BufferPoolManager, keySize uint32, leafMaxSize uint32, internalMaxSize uint32, cmp btree.KeyComparator) *bPlusTree {
+	// a page takes one entry more than its max size before it splits
+	if leafMaxSize == 0 {
+		leafMaxSize = btree.LeafMaxSize(keySize) - 1
+	}
+	if internalMaxSize == 0 {
+		internalMaxSize = btree.InternalMaxSize(keySize) - 1
+	}
+	if leafMaxSize < 2 || leafMaxSize >= btree.LeafMaxSize(keySize) ||
+		internalMaxSize < 3 || internalMaxSize >= btree.InternalMaxSize(keySize) {
+		return nil
+	}
+	return &bPlusTree{
+		bufferManager:   bm,
+		keySize:         keySize,
+		leafMaxSize:     leafMaxSize,
+		internalMaxSize: internalMaxSize,
+		cmp:             cmp,
+		rootPageId:      common.InvalidPageID,
+	}
+}
+
+func (t *bPlusTree) isEmpty() bool {
+	t.rootLatch.RLock()
+	defer t.rootLatch.RUnlock()
+	return t.rootPageId == common.InvalidPageID
+}
+
+func (t *bPlusTree) getRootPageId() common.PageID {
+	t.rootLatch.RLock()
+	defer t.rootLatch.RUnlock()
+	return t.rootPageId
+}
+
+/*****************************************************************************
+ * SEARCH
+ *****************************************************************************/
+
+/**
+ * A place in the order of the entries, right before or right after the entry
+ * (key, rid). Without a rid it is before or after all the entries of the key,
+ * and without a key before or after all the entries of the tree.
+ */
+type treePosition struct {
+	key   []byte
+	rid   *common.RID
+	after bool
+}
+
+// the order of an entry relative to a position, never 0 since positions are between entries
+func (t *bPlusTree) compareToPosition(key []byte, rid common.RID, pos *treePosition) int {
+	c := 0
+	if pos.key != nil {
+		c = t.cmp(key, pos.key)
+		if c == 0 && pos.rid != nil {
+			c = btree.CompareRID(rid, *pos.rid)
+		}
+	}
+	if c != 0 {
+		return c
+	}
+	if pos.after {
+		return -1
+	}
+	return 1
+}
+
+// the number of entries of a page from index first on that come before pos
+func (t *bPlusTree) countBefore(node *btree.BPlusTreePage, first uint32, pos *treePosition) uint32 {
+	lo, hi := first, node.GetSize()
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if t.compareToPosition(node.KeyAt(mid), node.RIDAt(mid), pos) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo - first
+}
+
+/**
+ * Collect the RIDs of a key.
+ * @return true if there is at least one
+ */
+func (t *bPlusTree) getValue(key []byte, result *[]common.RID) bool {
+	key, ok := t.normalizeKey(key)
+	if !ok {
+		return false
+	}
+	found := false
+	it := t.newIterator(&treePosition{key: key}, &treePosition{key: key, after: true}, false)
+	for e, ok := it.next(); ok; e, ok = it.next() {
+		*result = append(*result, e.rid)
+		found = true
+	}
+	return found
+}
+
+/**
+ * Iterate over the entries between two positions.
+ * @param from where to start
+ * @param to where to stop
+ * @param reverse whether to go down from a higher position to a lower one
+ */
+func (t *bPlusTree) newIterator(from *treePosition, to *treePosition, reverse bool) *bPlusTreeIterator {
+	return &bPlusTreeIterator{tree: t, from: from, to: to, reverse: reverse}
+}
+
+/**
+ * Iterates over the entries of a range one leaf at a time: the entries of a
+ * leaf are copied, then its latches are released. The tree may change between
+ * two leaves, the next one is looked up from the last entry returned.
+ */
+type bPlusTreeIterator struct {
+	tree    *bPlusTree
+	from    *treePosition
+	to      *treePosition
+	reverse bool
+	batch   []bPlusTreeEntry
+	done    bool
+}
+
+/** @return the RID of the next entry, false at the end of the range */
+func (it *bPlusTreeIterator) Next() (common.RID, bool) {
+	e, ok := it.next()
+	return e.rid, ok
+}
+
+func (it *bPlusTreeIterator) next() (bPlusTreeEntry, bool) {
+	if it.done {
+		return bPlusTreeEntry{}, false
+	}
+	if len(it.batch) == 0 {
+		if it.batch = it.tree.leafEntries(it.from, it.reverse); len(it.batch) == 0 {
+			it.done = true
+			return bPlusTreeEntry{}, false
+		}
+		last := it.batch[len(it.batch)-1]
+		it.from = &treePosition{key: last.key, rid: &last.rid, after: !it.reverse}
+	}
+	e := it.batch[0]
+	if c := it.tree.compareToPosition(e.key, e.rid, it.to); (c > 0) != it.reverse {
+		// past the end of the range
+		it.done, it.batch = true, nil
+		return bPlusTreeEntry{}, false
+	}
+	it.batch = it.batch[1:]
+	return e, true
+}
+
+/**
+ * Copy the entries of the leaf next to a position, in the direction of the scan.
+ * @param pos where to start
+ * @param reverse whether to go down from pos
+ * @return the entries after pos in its leaf, or before pos in reverse order. If
+ * there are none, those of the next leaf in that direction. nil at the end of the tree.
+ */
+func (t *bPlusTree) leafEntries(pos *treePosition, reverse bool) []bPlusTreeEntry {
+descend:
+	for {
+		p, low := t.readLeaf(pos)
+		if p == nil {
+			return nil
+		}
+		leaf := btree.PageAsLeafPage(p, t.keySize)
+		from, to := t.countBefore(&leaf.BPlusTreePage, 0, pos), leaf.GetSize()
+		if reverse {
+			from, to = 0, from
+			if from == to {
+				t.releaseRead(p)
+				if low == nil {
+					return nil
+				}
+				// the entries before pos are in the leaves to the left, all of them below low
+				pos = &treePosition{key: low.key, rid: &low.rid}
+				continue
+			}
+		}
+
+		for from == to {
+			nextPageId := leaf.GetNextPageId()
+			if nextPageId == common.InvalidPageID {
+				t.releaseRead(p)
+				return nil
+			}
+			// the leaf holds the next one in place, it can't be merged away
+			next := t.fetchPage(nextPageId)
+			if !next.TryRLatch() {
+				// a writer may be waiting for the leaf while holding the next one
+				t.bufferManager.UnpinPage(nextPageId, false, nil)
+				t.releaseRead(p)
+				continue descend
+			}
+			t.releaseRead(p)
+			p, leaf = next, btree.PageAsLeafPage(next, t.keySize)
+			from, to = t.countBefore(&leaf.BPlusTreePage, 0, pos), leaf.GetSize()
+		}
+
+		entries := make([]bPlusTreeEntry, 0, to-from)
+		for i := from; i < to; i++ {
+			key := make([]byte, t.keySize)
+			copy(key, leaf.KeyAt(i))
+			entries = append(entries, bPlusTreeEntry{key: key, rid: leaf.RIDAt(i)})
+		}
+		t.releaseRead(p)
+		if reverse {
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+		}
+		return entries
+	}
+}
+
+/**
+ * Descend from the root to the leaf where a position falls, read latching a
+ * page and releasing its parent.
+ * @return the read latched leaf, nil if the tree is empty, and the lowest entry
+ * the leaf may hold, i.e. the separator above it, nil for the leftmost leaf
+ */
+func (t *bPlusTree) readLeaf(pos *treePosition) (page.Page, *bPlusTreeEntry) {
+	t.rootLatch.RLock()
+	if t.rootPageId == common.InvalidPageID {
+		t.rootLatch.RUnlock()
+		return nil, nil
+	}
+	p := t.fetchPage(t.rootPageId)
+	p.RLatch()
+	t.rootLatch.RUnlock()
+
+	var low *bPlusTreeEntry
+	for btree.GetPageType(p) == btree.InternalPage {
+		internal := btree.PageAsInternalPage(p, t.keySize)
+		// the separators are the first entries of their subtrees
+		idx := t.countBefore(&internal.BPlusTreePage, 1, pos)
+		if idx > 0 {
+			key := make([]byte, t.keySize)
+			copy(key, internal.KeyAt(idx))
+			low = &bPlusTreeEntry{key: key, rid: internal.RIDAt(idx)}
+		}
+		child := t.fetchPage(internal.ValueAt(idx))
+		child.RLatch()
+		t.releaseRead(p)
+		p = child
+	}
+	return p, low
+}
+
+// release a read latched page
+func (t *bPlusTree) releaseRead(p page.Page) {
+	p.RUnlatch()
+	t.bufferManager.UnpinPage(p.GetPageID(), false, nil)
+}
+
+/*****************************************************************************
+ * INSERTION
+ *****************************************************************************/
+
+/**
+ * Insert an entry.
+ * @return false if the entry is already in the tree or the key is too long
+ */
+func (t *bPlusTree) insert(key []byte, rid common.RID) bool {
+	key, ok := t.normalizeKey(key)
+	if !ok {
+		return false
+	}
+	c := t.crabDown(key, rid, t.safeForInsert)
+	defer c.release()
+
+	if len(c.pages) == 0 {
+		// the tree is empty, start with a leaf as the root
+		var rootPageId common.PageID
+		p := t.bufferManager.NewPage(&rootPageId, nil)
+		if p == nil {
+			return false
+		}
+		leaf := btree.PageAsLeafPage(p, t.keySize)
+		leaf.Init(rootPageId, t.leafMaxSize)
+		leaf.Insert(key, rid, t.cmp)
+		t.bufferManager.UnpinPage(rootPageId, true, nil)
+		t.rootPageId = rootPageId
+		return true
+	}
+
+	leaf := btree.PageAsLeafPage(c.pages[len(c.pages)-1], t.keySize)
+	if !leaf.Insert(key, rid, t.cmp) {
+		return false
+	}
+	c.dirty = true
+	if leaf.GetSize() <= t.leafMaxSize {
+		return true
+	}
+
+	// split the leaf, the new one goes to its right
+	var newPageId common.PageID
+	p := t.bufferManager.NewPage(&newPageId, nil)
+	common.Assert.NotNil(p, "failed to allocate a B+Tree page")
+	newLeaf := btree.PageAsLeafPage(p, t.keySize)
+	newLeaf.Init(newPageId, t.leafMaxSize)
+	leaf.MoveTailTo(&newLeaf.BPlusTreePage, leaf.GetSize()-leaf.GetSize()/2)
+	newLeaf.SetNextPageId(leaf.GetNextPageId())
+	leaf.SetNextPageId(newPageId)
+	t.insertIntoParent(c, len(c.pages)-1, newLeaf.KeyAt(0), newLeaf.RIDAt(0), newPageId)
+	t.bufferManager.UnpinPage(newPageId, true, nil)
+	return true
+}
+
+/**
+ * A page split, add its new right sibling to its parent, splitting the parent if needed.
+ * @param level index of the page that split in the crabbing path
+ * @param key first key of the new page
+ * @param rid RID of the first key
+ * @param newPageId the new page
+ */
+func (t *bPlusTree) insertIntoParent(c *crabbing, level int, key []byte, rid common.RID, newPageId common.PageID) {
+	oldPageId := c.pages[level].GetPageID()
+	if level == 0 {
+		// the root split, the tree grows by one level. The root latch is held since the root wasn't safe.
+		var rootPageId common.PageID
+		p := t.bufferManager.NewPage(&rootPageId, nil)
+		common.Assert.NotNil(p, "failed to allocate a B+Tree page")
+		root := btree.PageAsInternalPage(p, t.keySize)
+		root.Init(rootPageId, t.internalMaxSize)
+		root.PopulateNewRoot(oldPageId, key, rid, newPageId)
+		t.bufferManager.UnpinPage(rootPageId, true, nil)
+		t.rootPageId = rootPageId
+		return
+	}
+
+	parent := btree.PageAsInternalPage(c.pages[level-1], t.keySize)
+	parent.InsertAt(parent.ValueIndex(oldPageId)+1, key, rid, newPageId)
+	if parent.GetSize() <= t.internalMaxSize {
+		return
+	}
+
+	var siblingPageId common.PageID
+	p := t.bufferManager.NewPage(&siblingPageId, nil)
+	common.Assert.NotNil(p, "failed to allocate a B+Tree page")
+	sibling := btree.PageAsInternalPage(p, t.keySize)
+	sibling.Init(siblingPageId, t.internalMaxSize)
+	parent.MoveTailTo(&sibling.BPlusTreePage, parent.GetSize()-parent.GetSize()/2)
+	// the first key of the sibling is invalid in it, it separates the sibling in the grandparent
+	t.insertIntoParent(c, level-1, sibling.KeyAt(0), sibling.RIDAt(0), siblingPageId)
+	t.bufferManager.UnpinPage(siblingPageId, true, nil)
+}
+
+/*****************************************************************************
+ * BULK LOAD
+ *****************************************************************************/
+
+// a page of the level being built by a bulk load, with the first entry of its subtree
+type bulkNode struct {
+	first  bPlusTreeEntry
+	pageId common.PageID
+}
+
+var errBulkLoadTreePoolFull = errors.New("the buffer pool is full")
+
+/**
+ * Fill an empty tree with entries, bottom-up: the entries are cut into leaves
+ * filled to fillFactor of their max size, then each level of internal pages is
+ * built over the level below until a level has one page, the root. Each page
+ * is written once. Holds the root latch.
+ *
+ * @param entries entries with normalized keys, sorted and distinct
+ * @param fillFactor the fraction of its max size to fill a page to, in (0, 1]
+ * @return an error if the tree isn't empty or the buffer pool is full, the tree is then left empty
+ */
+func (t *bPlusTree) bulkLoad(entries []bPlusTreeEntry, fillFactor float64) error {
+	t.rootLatch.Lock()
+	defer t.rootLatch.Unlock()
+	if t.rootPageId != common.InvalidPageID {
+		return errors.New("can't bulk load a B+Tree that isn't empty")
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	var nodes []bulkNode
+	var prev *btree.BPlusTreeLeafPage
+	start := 0
+	for _, size := range bulkPageSizes(len(entries), t.leafMaxSize, fillFactor) {
+		var pid common.PageID
+		p := t.bufferManager.NewPage(&pid, nil)
+		if p == nil {
+			if prev != nil {
+				t.bufferManager.UnpinPage(prev.GetPageId(), true, nil)
+			}
+			return errBulkLoadTreePoolFull
+		}
+		leaf := btree.PageAsLeafPage(p, t.keySize)
+		leaf.Init(pid, t.leafMaxSize)
+		for j, e := range entries[start : start+size] {
+			leaf.SetKeyAt(uint32(j), e.key, e.rid)
+		}
+		leaf.SetSize(uint32(size))
+		// a leaf stays pinned until the next one is linked to it
+		if prev != nil {
+			prev.SetNextPageId(pid)
+			t.bufferManager.UnpinPage(prev.GetPageId(), true, nil)
+		}
+		prev = leaf
+		nodes = append(nodes, bulkNode{first: entries[start], pageId: pid})
+		start += size
+	}
+	t.bufferManager.UnpinPage(prev.GetPageId(), true, nil)
+
+	for len(nodes) > 1 {
+		var parents []bulkNode
+		start = 0
+		for _, size := range bulkPageSizes(len(nodes), t.internalMaxSize, fillFactor) {
+			var pid common.PageID
+			p := t.bufferManager.NewPage(&pid, nil)
+			if p == nil {
+				return errBulkLoadTreePoolFull
+			}
+			internal := btree.PageAsInternalPage(p, t.keySize)
+			internal.Init(pid, t.internalMaxSize)
+			// the first key of a page is invalid, it is kept as in a split
+			for j, child := range nodes[start : start+size] {
+				internal.SetKeyAt(uint32(j), child.first.key, child.first.rid)
+				internal.SetValueAt(uint32(j), child.pageId)
+			}
+			internal.SetSize(uint32(size))
+			t.bufferManager.UnpinPage(pid, true, nil)
+			parents = append(parents, bulkNode{first: nodes[start].first, pageId: pid})
+			start += size
+		}
+		nodes = parents
+	}
+	t.rootPageId = nodes[0].pageId
+	return nil
+}
+
+/**
+ * Cut n entries into pages filled to fillFactor of maxSize. The last page may
+ * then be below the min size, it then shares the entries of the page before it.
+ * @return the number of entries of each page
+ */
+func bulkPageSizes(n int, maxSize uint32, fillFactor float64) []int {
+	minSize := int(maxSize+1) / 2
+	target := int(math.Ceil(float64(maxSize) * fillFactor))
+	if target < minSize {
+		target = minSize
+	}
+	var sizes []int
+	for ; n > target; n -= target {
+		sizes = append(sizes, target)
+	}
+	sizes = append(sizes, n)
+
+	last := len(sizes) - 1
+	if last > 0 && sizes[last] < minSize {
+		// the two pages fit in one, or have at least maxSize+1 entries and halve into two
+		both := sizes[last-1] + sizes[last]
+		if both <= int(maxSize) {
+			sizes = sizes[:last]
+			sizes[last-1] = both
+		} else {
+			sizes[last-1] = both - both/2
+			sizes[last] = both / 2
+		}
+	}
+	return sizes
+}
+
+/*****************************************************************************
+ * REMOVE
+ *****************************************************************************/
+
+/**
+ * Remove an entry.
+ * @return false if the entry isn't in the tree
+ */
+func (t *bPlusTree) remove(key []byte, rid common.RID) bool {
+	key, ok := t.normalizeKey(key)
+	if !ok {
+		return false
+	}
+	c := t.crabDown(key, rid, t.safeForRemove)
+	defer c.release()
+	if len(c.pages) == 0 {
+		return false
+	}
+
+	leaf := btree.PageAsLeafPage(c.pages[len(c.pages)-1], t.keySize)
+	if !leaf.Remove(key, rid, t.cmp) {
+		return false
+	}
+	c.dirty = true
+	t.rebalance(c, len(c.pages)-1)
+	return true
+}
+
+/**
+ * Fix a page that may hold too few entries by merging it with a sibling or
+ * borrowing an entry from it, which may make its parent underflow in turn.
+ * @param level index of the page in the crabbing path
+ */
+func (t *bPlusTree) rebalance(c *crabbing, level int) {
+	node := treePage(c.pages[level], t.keySize)
+	if level == 0 {
+		if !c.rootLocked {
+			// the highest latched page was safe, it can't underflow
+			return
+		}
+		// the root has no minimum size, but an empty leaf or an internal page with one child go
+		if node.IsLeafPage() && node.GetSize() == 0 {
+			t.rootPageId = common.InvalidPageID
+			c.deleted = append(c.deleted, node.GetPageId())
+		} else if !node.IsLeafPage() && node.GetSize() == 1 {
+			t.rootPageId = btree.PageAsInternalPage(c.pages[0], t.keySize).ValueAt(0)
+			c.deleted = append(c.deleted, node.GetPageId())
+		}
+		return
+	}
+	if node.GetSize() >= node.GetMinSize() {
+		return
+	}
+
+	// the parent is latched, so nobody else can get to the sibling
+	parent := btree.PageAsInternalPage(c.pages[level-1], t.keySize)
+	idx := parent.ValueIndex(node.GetPageId())
+	siblingIdx := idx + 1
+	if idx > 0 {
+		siblingIdx = idx - 1
+	}
+	siblingPageId := parent.ValueAt(siblingIdx)
+	siblingPage := t.fetchPage(siblingPageId)
+	siblingPage.WLatch()
+	defer func() {
+		siblingPage.WUnlatch()
+		t.bufferManager.UnpinPage(siblingPageId, true, nil)
+	}()
+	sibling := treePage(siblingPage, t.keySize)
+
+	// the separator between the pages in the parent
+	leftPage, rightPage, sepIdx := siblingPage, c.pages[level], idx
+	if siblingIdx > idx {
+		leftPage, rightPage, sepIdx = c.pages[level], siblingPage, siblingIdx
+	}
+	left, right := treePage(leftPage, t.keySize), treePage(rightPage, t.keySize)
+
+	if left.GetSize()+right.GetSize() <= left.GetMaxSize() {
+		// merge the right page into the left one
+		if node.IsLeafPage() {
+			rightLeaf := btree.PageAsLeafPage(rightPage, t.keySize)
+			btree.PageAsLeafPage(leftPage, t.keySize).SetNextPageId(rightLeaf.GetNextPageId())
+		} else {
+			// the separator comes down as the first key of the right page
+			right.SetKeyAt(0, parent.KeyAt(sepIdx), parent.RIDAt(sepIdx))
+		}
+		right.MoveTailTo(left, 0)
+		c.deleted = append(c.deleted, right.GetPageId())
+		parent.RemoveAt(sepIdx)
+		t.rebalance(c, level-1)
+		return
+	}
+
+	// borrow one entry from the sibling
+	if siblingIdx < idx {
+		if !node.IsLeafPage() {
+			node.SetKeyAt(0, parent.KeyAt(sepIdx), parent.RIDAt(sepIdx))
+		}
+		sibling.MoveEntryTo(node, sibling.GetSize()-1, 0)
+		parent.SetKeyAt(sepIdx, node.KeyAt(0), node.RIDAt(0))
+	} else {
+		if !node.IsLeafPage() {
+			sibling.SetKeyAt(0, parent.KeyAt(sepIdx), parent.RIDAt(sepIdx))
+		}
+		sibling.MoveEntryTo(node, 0, node.GetSize())
+		parent.SetKeyAt(sepIdx, sibling.KeyAt(0), sibling.RIDAt(0))
+	}
+}
+
+/*****************************************************************************
+ * LATCH CRABBING
+ *****************************************************************************/
+
+// the write latched pages of an operation, from the highest one that may change down to a leaf
+type crabbing struct {
+	tree       *bPlusTree
+	rootLocked bool
+	pages      []page.Page
+	// whether the operation changed the pages
+	dirty bool
+	// pages to delete once they are released
+	deleted []common.PageID
+}
+
+/**
+ * Descend to the leaf of (key, rid), write latching the pages.
+ * @param safe whether the operation can't propagate above a page
+ * @return the latched pages, none if the tree is empty in which case the root latch is held
+ */
+func (t *bPlusTree) crabDown(key []byte, rid common.RID, safe func(btree.BPlusTreePage, bool) bool) *crabbing {
+	c := &crabbing{tree: t, rootLocked: true}
+	t.rootLatch.Lock()
+	if t.rootPageId == common.InvalidPageID {
+		return c
+	}
+
+	pid := t.rootPageId
+	for {
+		p := t.fetchPage(pid)
+		p.WLatch()
+		node := treePage(p, t.keySize)
+		if safe(*node, len(c.pages) == 0 && c.rootLocked) {
+			c.releaseAncestors()
+		}
+		c.pages = append(c.pages, p)
+		if node.IsLeafPage() {
+			return c
+		}
+		internal := btree.PageAsInternalPage(p, t.keySize)
+		pid = internal.ValueAt(internal.Lookup(key, &rid, t.cmp))
+	}
+}
+
+// an insert doesn't split the page
+func (t *bPlusTree) safeForInsert(node btree.BPlusTreePage, isRoot bool) bool {
+	return node.GetSize() < node.GetMaxSize()
+}
+
+// a remove doesn't make the page underflow
+func (t *bPlusTree) safeForRemove(node btree.BPlusTreePage, isRoot bool) bool {
+	if isRoot {
+		if node.IsLeafPage() {
+			return node.GetSize() > 1
+		}
+		return node.GetSize() > 2
+	}
+	return node.GetSize() > node.GetMinSize()
+}
+
+// release the root latch and the pages latched so far, they won't change
+func (c *crabbing) releaseAncestors() {
+	if c.rootLocked {
+		c.tree.rootLatch.Unlock()
+		c.rootLocked = false
+	}
+	for _, p := range c.pages {
+		p.WUnlatch()
+		c.tree.bufferManager.UnpinPage(p.GetPageID(), false, nil)
+	}
+	c.pages = c.pages[:0]
+}
+
+// release everything, deleting the pages the operation emptied
+func (c *crabbing) release() {
+	for _, p := range c.pages {
+		p.WUnlatch()
+		c.tree.bufferManager.UnpinPage(p.GetPageID(), c.dirty, nil)
+	}
+	c.pages = nil
+	// a reader may still have a page pinned for a moment, it is then left behind
+	for _, pid := range c.deleted {
+		c.tree.bufferManager.DeletePage(pid, nil)
+	}
+	if c.rootLocked {
+		c.tree.rootLatch.Unlock()
+		c.rootLocked = false
+	}
+}
+
+/*****************************************************************************
+ * UTILITIES
+ *****************************************************************************/
+
+func treePage(p page.Page, keySize uint32) *btree.BPlusTreePage {
+	if btree.GetPageType(p) == btree.LeafPage {
+		return &btree.PageAsLeafPage(p, keySize).BPlusTreePage
+	}
+	return &btree.PageAsInternalPage(p, keySize).BPlusTreePage
+}
+
+func (t *bPlusTree) fetchPage(pid common.PageID) page.Page {
+	p := t.bufferManager.FetchPage(pid, nil)
+	common.Assert.NotNil(p, "failed to fetch a B+Tree page")
+	return p
+}
+
+// keys are stored with a fixed size, shorter keys are zero padded
+func (t *bPlusTree) normalizeKey(key []byte) ([]byte, bool) {
+	if uint32(len(key)) > t.keySize {
+		return nil, false
+	}
+	if uint32(len(key)) == t.keySize {
+		return key, true
+	}
+	padded := make([]byte, t.keySize)
+	copy(padded, key)
+	return padded, true
+}
+
+// delete every page of the tree, it can't be used anymore
+func (t *bPlusTree) free() {
+	t.rootLatch.Lock()
+	defer t.rootLatch.Unlock()
+	if t.rootPageId != common.InvalidPageID {
+		t.freeSubtree(t.rootPageId)
+	}
+	t.rootPageId = common.InvalidPageID
+}
+
+func (t *bPlusTree) freeSubtree(pid common.PageID) {
+	p := t.fetchPage(pid)
+	var children []common.PageID
+	if !treePage(p, t.keySize).IsLeafPage() {
+		internal := btree.PageAsInternalPage(p, t.keySize)
+		for i := uint32(0); i < internal.GetSize(); i++ {
+			children = append(children, internal.ValueAt(i))
+		}
+	}
+	t.bufferManager.UnpinPage(pid, false, nil)
+	for _, child := range children {
+		t.freeSubtree(child)
+	}
+	t.bufferManager.DeletePage(pid, nil)
+}
+
+/**
+ * Check the invariants of the tree: entries are ordered within and across
+ * pages, pages other than the root hold at least their min size, all leaves
+ * are at the same depth and linked in order. Not thread safe.
+ * @return an error describing the first violation found
+ */
+func (t *bPlusTree) verifyIntegrity() error {
+	rootPageId := t.getRootPageId()
+	if rootPageId == common.InvalidPageID {
+		return nil
+	}
+	v := &treeVerifier{tree: t, leafDepth: -1}
+	if err := v.verify(rootPageId, 0, nil, nil); err != nil {
+		return err
+	}
+	// the leaf chain visits the leaves in the order of the tree
+	for i, pid := range v.leaves {
+		p := t.fetchPage(pid)
+		next := btree.PageAsLeafPage(p, t.keySize).GetNextPageId()
+		t.bufferManager.UnpinPage(pid, false, nil)
+		expected := common.PageID(common.InvalidPageID)
+		if i+1 < len(v.leaves) {
+			expected = v.leaves[i+1]
+		}
+		if next != expected {
+			return fmt.Errorf("leaf %d links to %d instead of %d", pid, next, expected)
+		}
+	}
+	return nil
+}
+
+type treeVerifier struct {
+	tree      *bPlusTree
+	leafDepth int
+	leaves    []common.PageID
+}
+
+// check the subtree at pid, all its entries must be within [low, high)
+func (v *treeVerifier) verify(pid common.PageID, depth int, low *bPlusTreeEntry, high *bPlusTreeEntry) error {
+	t := v.tree
+	p := t.fetchPage(pid)
+	defer t.bufferManager.UnpinPage(pid, false, nil)
+	node := treePage(p, t.keySize)
+
+	if depth > 0 && node.GetSize() < node.GetMinSize() {
+		return fmt.Errorf("page %d has %d entries, less than %d", pid, node.GetSize(), node.GetMinSize())
+	}
+	if node.GetSize() > node.GetMaxSize() {
+		return fmt.Errorf("page %d has %d entries, more than %d", pid, node.GetSize(), node.GetMaxSize())
+	}
+
+	first := uint32(0)
+	if !node.IsLeafPage() {
+		first = 1
+	}
+	for i := first; i < node.GetSize(); i++ {
+		rid := node.RIDAt(i)
+		if low != nil && node.CompareAt(i, low.key, &low.rid, t.cmp) < 0 {
+			return fmt.Errorf("entry %d of page %d is below its lower bound", i, pid)
+		}
+		if high != nil && node.CompareAt(i, high.key, &high.rid, t.cmp) >= 0 {
+			return fmt.Errorf("entry %d of page %d is above its upper bound", i, pid)
+		}
+		if i > first && node.CompareAt(i-1, node.KeyAt(i), &rid, t.cmp) >= 0 {
+			return fmt.Errorf("entries %d and %d of page %d are out of order", i-1, i, pid)
+		}
+	}
+
+	if node.IsLeafPage() {
+		if v.leafDepth >= 0 && v.leafDepth != depth {
+			return fmt.Errorf("leaf %d is at depth %d, other leaves at %d", pid, depth, v.leafDepth)
+		}
+		v.leafDepth = depth
+		v.leaves = append(v.leaves, pid)
+		return nil
+	}
+
+	internal := btree.PageAsInternalPage(p, t.keySize)
+	for i := uint32(0); i < internal.GetSize(); i++ {
+		childLow, childHigh := low, high
+		if i > 0 {
+			childLow = &bPlusTreeEntry{key: internal.KeyAt(i), rid: internal.RIDAt(i)}
+		}
+		if i+1 < internal.GetSize() {
+			childHigh = &bPlusTreeEntry{key: internal.KeyAt(i + 1), rid: internal.RIDAt(i + 1)}
+		}
+		if err := v.verify(internal.ValueAt(i), depth+1, childLow, childHigh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package index
+
+import (
+	"fmt"
+	"goostub/buffer"
+	"goostub/common"
+	"goostub/storage/page/btree"
+	"goostub/storage/table"
+	"sort"
+)
+
+type BPlusTreeIndex struct {
+	baseIndex
+	container *bPlusTree
+}
+
+func (i *BPlusTreeIndex) InsertEntry(key *table.Tuple, rid common.RID, transaction common.Transaction) error {
+	return i.insertEntry(i, key, rid, transaction, func() {
+		i.container.insert(key.GetData(), rid)
+	})
+}
+func (i *BPlusTreeIndex) DeleteEntry(key *table.Tuple, rid common.RID, transaction common.Transaction) {
+	i.container.remove(key.GetData(), rid)
+}
+func (i *BPlusTreeIndex) ScanKey(key *table.Tuple, result *[]common.RID, transaction common.Transaction) {
+	i.container.getValue(key.GetData(), result)
+}
+func (i *BPlusTreeIndex) ScanEntries(fn func(key []byte, rid common.RID)) {
+	it := i.container.newIterator(&treePosition{}, &treePosition{after: true}, false)
+	for e, ok := it.next(); ok; e, ok = it.next() {
+		fn(e.key, e.rid)
+	}
+}
+func (i *BPlusTreeIndex) StoredKey(key *table.Tuple) ([]byte, bool) {
+	return i.container.normalizeKey(key.GetData())
+}
+
+/**
+ * Fill the empty index from its entries, bottom-up from the sorted entries.
+ * The pages are filled to the fill factor of the metadata.
+ */
+func (i *BPlusTreeIndex) BulkBuild(entries []IndexEntry, transaction common.Transaction) error {
+	if err := i.checkEntries(entries, transaction); err != nil {
+		return err
+	}
+	loaded := make([]bPlusTreeEntry, len(entries))
+	for j, e := range entries {
+		key, ok := i.container.normalizeKey(e.Key.GetData())
+		if !ok {
+			return fmt.Errorf("a key is longer than the keys of %s", i.GetName())
+		}
+		loaded[j] = bPlusTreeEntry{key: key, rid: e.RID}
+	}
+	cmp := i.container.cmp
+	sort.Slice(loaded, func(a, b int) bool {
+		if c := cmp(loaded[a].key, loaded[b].key); c != 0 {
+			return c < 0
+		}
+		return btree.CompareRID(loaded[a].rid, loaded[b].rid) < 0
+	})
+	return i.container.bulkLoad(loaded, i.metadata.GetFillFactor())
+}
+func (i *BPlusTreeIndex) ScanRange(keyRange *KeyRange, transaction common.Transaction) (IndexIterator, error) {
+	// the positions of the bounds, nil keys stand for the ends of the tree
+	low, high := &treePosition{}, &treePosition{after: true}
+	if keyRange.Low != nil {
+		key, ok := i.container.normalizeKey(keyRange.Low.GetData())
+		if !ok {
+			return nil, fmt.Errorf("the low bound is longer than the keys of %s", i.GetName())
+		}
+		low = &treePosition{key: key, after: !keyRange.LowInclusive}
+	}
+	if keyRange.High != nil {
+		key, ok := i.container.normalizeKey(keyRange.High.GetData())
+		if !ok {
+			return nil, fmt.Errorf("the high bound is longer than the keys of %s", i.GetName())
+		}
+		high = &treePosition{key: key, after: keyRange.HighInclusive}
+	}
+	if keyRange.Reverse {
+		return i.container.newIterator(high, low, true), nil
+	}
+	return i.container.newIterator(low, high, false), nil
+}
+func (i *BPlusTreeIndex) FreePages() {
+	i.container.free()
+}
+
+/**
+ * args: the key size in bytes (uintptr), optionally followed by the max size
+ * of the leaf pages and of the internal pages (int), which default to as many
+ * entries as fit in a page
+ */
+func (i *BPlusTreeIndex) createIndex(m *IndexMetadata, bm *buffer.BufferPoolManager, args ...any) Index {
+	common.Assert.NotEmpty(args, "key size is required for a B+Tree index")
+	keySize, ok := args[0].(uintptr)
+	common.Assert.True(ok, "key size must be an uintptr")
+	var maxSizes [2]uint32
+	for j := 1; j < len(args) && j <= len(maxSizes); j++ {
+		size, ok := args[j].(int)
+		common.Assert.True(ok && size >= 0, "max sizes must be non-negative ints")
+		maxSizes[j-1] = uint32(size)
+	}
+
+	container := newBPlusTree(bm, uint32(keySize), maxSizes[0], maxSizes[1], NewKeyComparator(m))
+	if container == nil {
+		return nil
+	}
+	m.kind = "B+Tree"
+	return &BPlusTreeIndex{
+		baseIndex: baseIndex{metadata: m},
+		container: container,
+	}
+}
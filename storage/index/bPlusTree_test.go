@@ -0,0 +1,411 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package index
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"goostub/common"
+	"goostub/schema"
+	"goostub/storage/page/btree"
+	"goostub/storage/table"
+	"goostub/types"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// orders the keys of intKey
+func intKeyComparator(a []byte, b []byte) int {
+	l, r := int64(binary.LittleEndian.Uint64(a)), int64(binary.LittleEndian.Uint64(b))
+	if l < r {
+		return -1
+	}
+	if l > r {
+		return 1
+	}
+	return 0
+}
+
+// the keys of the entries an iterator returns
+func scanIntKeys(it *bPlusTreeIterator) []int {
+	var keys []int
+	for e, ok := it.next(); ok; e, ok = it.next() {
+		keys = append(keys, int(binary.LittleEndian.Uint64(e.key)))
+	}
+	return keys
+}
+
+func TestBPlusTreeSplitMerge(t *testing.T) {
+	a := assert.New(t)
+	// small pages so that the tree gets a few levels
+	tree := newBPlusTree(newTestBPM(t, 50), 8, 4, 5, intKeyComparator)
+	a.NotNil(tree)
+	a.Nil(newBPlusTree(newTestBPM(t, 10), 8, 1, 5, intKeyComparator))
+	a.Nil(newBPlusTree(newTestBPM(t, 10), 8, 4, 10000, intKeyComparator))
+
+	const n = 1000
+	keys := rand.New(rand.NewSource(1)).Perm(n)
+	for _, k := range keys {
+		a.True(tree.insert(intKey(k), common.NewRID(common.PageID(k), 0)))
+	}
+	a.Nil(tree.verifyIntegrity())
+	// duplicate entries are rejected, another RID for the same key isn't
+	a.False(tree.insert(intKey(7), common.NewRID(7, 0)))
+	a.True(tree.insert(intKey(7), common.NewRID(7, 1)))
+	a.False(tree.insert(make([]byte, 9), common.NewRID(0, 0)))
+
+	for k := 0; k < n; k++ {
+		var result []common.RID
+		a.True(tree.getValue(intKey(k), &result))
+		if k == 7 {
+			a.Equal([]common.RID{common.NewRID(7, 0), common.NewRID(7, 1)}, result)
+		} else {
+			a.Equal([]common.RID{common.NewRID(common.PageID(k), 0)}, result)
+		}
+	}
+	var result []common.RID
+	a.False(tree.getValue(intKey(n), &result))
+
+	// the leaves hold every entry in order
+	scanned := scanIntKeys(tree.newIterator(&treePosition{}, &treePosition{after: true}, false))
+	a.Len(scanned, n+1)
+	for i := 1; i < len(scanned); i++ {
+		a.LessOrEqual(scanned[i-1], scanned[i])
+	}
+
+	// remove the odd keys, then the rest, checking the tree as it shrinks
+	a.True(tree.remove(intKey(7), common.NewRID(7, 1)))
+	a.False(tree.remove(intKey(7), common.NewRID(7, 1)))
+	for _, k := range keys {
+		if k%2 == 1 {
+			a.True(tree.remove(intKey(k), common.NewRID(common.PageID(k), 0)))
+		}
+	}
+	a.Nil(tree.verifyIntegrity())
+	for k := 0; k < n; k++ {
+		var result []common.RID
+		a.Equal(k%2 == 0, tree.getValue(intKey(k), &result))
+	}
+	for _, k := range keys {
+		if k%2 == 0 {
+			a.True(tree.remove(intKey(k), common.NewRID(common.PageID(k), 0)))
+			if k%100 == 0 {
+				a.Nil(tree.verifyIntegrity())
+			}
+		}
+	}
+	a.True(tree.isEmpty())
+
+	// the tree grows again from empty
+	a.True(tree.insert(intKey(1), common.NewRID(1, 0)))
+	a.True(tree.getValue(intKey(1), &result))
+}
+
+func TestBPlusTreeConcurrent(t *testing.T) {
+	a := assert.New(t)
+	tree := newBPlusTree(newTestBPM(t, 64), 8, 4, 5, intKeyComparator)
+	a.NotNil(tree)
+
+	// every worker inserts its own keys, removes half of them and reads all of them back
+	const workers, perWorker = 8, 300
+	var wg sync.WaitGroup
+	errs := make(chan string, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				k := i*workers + w
+				if !tree.insert(intKey(k), common.NewRID(common.PageID(k), 0)) {
+					errs <- "insert failed"
+					return
+				}
+			}
+			for i := 0; i < perWorker; i += 2 {
+				k := i*workers + w
+				if !tree.remove(intKey(k), common.NewRID(common.PageID(k), 0)) {
+					errs <- "remove failed"
+					return
+				}
+			}
+			for i := 0; i < perWorker; i++ {
+				var result []common.RID
+				if tree.getValue(intKey(i*workers+w), &result) != (i%2 == 1) {
+					errs <- "lookup failed"
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		a.Fail(err)
+	}
+	a.Nil(tree.verifyIntegrity())
+
+	a.Len(scanIntKeys(tree.newIterator(&treePosition{}, &treePosition{after: true}, false)), workers*perWorker/2)
+}
+
+func TestBPlusTreeScanConcurrent(t *testing.T) {
+	a := assert.New(t)
+	tree := newBPlusTree(newTestBPM(t, 64), 8, 4, 5, intKeyComparator)
+	a.NotNil(tree)
+	const n = 600
+	for k := 0; k < n; k += 2 {
+		a.True(tree.insert(intKey(k), common.NewRID(common.PageID(k), 0)))
+	}
+
+	// writers split and merge the leaves with the odd keys, scans see every even key in order
+	done := make(chan struct{})
+	var writers, readers sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				for k := 2*w + 1; k < n; k += 8 {
+					tree.insert(intKey(k), common.NewRID(common.PageID(k), 0))
+				}
+				for k := 2*w + 1; k < n; k += 8 {
+					tree.remove(intKey(k), common.NewRID(common.PageID(k), 0))
+				}
+			}
+		}(w)
+	}
+	errs := make(chan string, 4)
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func(reverse bool) {
+			defer readers.Done()
+			for i := 0; i < 50; i++ {
+				var even []int
+				for _, k := range scanIntKeys(tree.newIterator(&treePosition{after: reverse}, &treePosition{after: !reverse}, reverse)) {
+					if k%2 == 0 {
+						even = append(even, k)
+					}
+				}
+				if len(even) != n/2 {
+					errs <- "missing keys"
+					return
+				}
+				for j, k := range even {
+					if (reverse && k != n-2-2*j) || (!reverse && k != 2*j) {
+						errs <- "keys out of order"
+						return
+					}
+				}
+			}
+		}(r%2 == 1)
+	}
+	readers.Wait()
+	close(done)
+	writers.Wait()
+	close(errs)
+	for err := range errs {
+		a.Fail(err)
+	}
+	a.Nil(tree.verifyIntegrity())
+}
+
+func TestBPlusTreeBulkLoad(t *testing.T) {
+	a := assert.New(t)
+	for _, n := range []int{1, 4, 5, 6, 17, 1000} {
+		for _, fillFactor := range []float64{0.1, 0.5, 0.9, 1} {
+			tree := newBPlusTree(newTestBPM(t, 50), 8, 4, 5, intKeyComparator)
+			// two entries per key
+			var entries []bPlusTreeEntry
+			for k := 0; k < n; k++ {
+				entries = append(entries, bPlusTreeEntry{key: intKey(k / 2), rid: common.NewRID(common.PageID(k), 0)})
+			}
+			a.Nil(tree.bulkLoad(entries, fillFactor))
+			a.Nil(tree.verifyIntegrity(), "%d entries filled to %v", n, fillFactor)
+			keys := scanIntKeys(tree.newIterator(&treePosition{}, &treePosition{after: true}, false))
+			a.Len(keys, n)
+			for k := range keys {
+				a.Equal(k/2, keys[k])
+			}
+			a.Error(tree.bulkLoad(entries, fillFactor))
+
+			// the loaded tree splits and merges as usual
+			for k := 0; k < n; k++ {
+				a.True(tree.insert(intKey(k), common.NewRID(common.PageID(k), 1)))
+				a.True(tree.remove(entries[k].key, entries[k].rid))
+			}
+			a.Nil(tree.verifyIntegrity())
+		}
+	}
+
+	// a lower fill factor spreads the entries over more leaves
+	leaves := func(fillFactor float64) int {
+		tree := newBPlusTree(newTestBPM(t, 50), 8, 0, 0, intKeyComparator)
+		var entries []bPlusTreeEntry
+		for k := 0; k < 10000; k++ {
+			entries = append(entries, bPlusTreeEntry{key: intKey(k), rid: common.NewRID(common.PageID(k), 0)})
+		}
+		a.Nil(tree.bulkLoad(entries, fillFactor))
+		v := &treeVerifier{tree: tree, leafDepth: -1}
+		a.Nil(v.verify(tree.getRootPageId(), 0, nil, nil))
+		return len(v.leaves)
+	}
+	full, half := leaves(1), leaves(0.5)
+	a.Equal(10000/(int(btree.LeafMaxSize(8))-1)+1, full)
+	a.Greater(half, full*3/2)
+}
+
+func TestBPlusTreeIndexBulkBuild(t *testing.T) {
+	a := assert.New(t)
+	s, err := schema.ParseSchema("id:INTEGER")
+	a.Nil(err)
+	key := func(id int32) *table.Tuple {
+		return table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, id)}, s)
+	}
+	var entries []IndexEntry
+	for _, i := range rand.New(rand.NewSource(1)).Perm(5000) {
+		entries = append(entries, IndexEntry{Key: key(int32(i - 2500)), RID: common.NewRID(common.PageID(i), 0)})
+	}
+
+	meta := NewIndexMetadata("idx", "t", []uint32{0}, s)
+	meta.SetFillFactor(0.7)
+	idx := NewIndex[*BPlusTreeIndex](meta, newTestBPM(t, 50), uintptr(16))
+	a.Nil(idx.(BulkBuilder).BulkBuild(entries, nil))
+	a.Nil(idx.(*BPlusTreeIndex).container.verifyIntegrity())
+
+	// negative keys first, the comparator of the key schema orders the entries
+	it, err := idx.ScanRange(&KeyRange{High: key(-2490)}, nil)
+	a.Nil(err)
+	var rids []common.RID
+	for rid, ok := it.Next(); ok; rid, ok = it.Next() {
+		rids = append(rids, rid)
+	}
+	a.Len(rids, 10)
+	a.Equal(common.NewRID(0, 0), rids[0])
+}
+
+func TestBPlusTreeIndex(t *testing.T) {
+	a := assert.New(t)
+	s, err := schema.ParseSchema("name:VARCHAR, id:INTEGER")
+	a.Nil(err)
+	meta := NewIndexMetadata("idx", "t", []uint32{0, 1}, s)
+	idx := NewIndex[*BPlusTreeIndex](meta, newTestBPM(t, 20), uintptr(32), 4, 4)
+	a.NotNil(idx)
+	keySchema := meta.GetKeySchema()
+
+	key := func(name string, id int32) *table.Tuple {
+		nameVal := types.NewValue(types.VARCHAR)
+		if name != "" {
+			nameVal = types.NewValue(types.VARCHAR, name)
+		}
+		return table.NewTuple([]*types.Value{nameVal, types.NewValue(types.INTEGER, id)}, keySchema)
+	}
+	names := []string{"", "apple", "banana", "cherry"}
+	for i := 0; i < 200; i++ {
+		idx.InsertEntry(key(names[i%len(names)], int32(i/len(names))), common.NewRID(common.PageID(i), 0), nil)
+	}
+	a.Nil(idx.(*BPlusTreeIndex).container.verifyIntegrity())
+
+	var result []common.RID
+	idx.ScanKey(key("banana", 10), &result, nil)
+	a.Equal([]common.RID{common.NewRID(42, 0)}, result)
+	result = nil
+	idx.ScanKey(key("", 3), &result, nil)
+	a.Equal([]common.RID{common.NewRID(12, 0)}, result)
+
+	// the keys are ordered by name with NULL first, then by id
+	var prev *table.Tuple
+	it := idx.(*BPlusTreeIndex).container.newIterator(&treePosition{}, &treePosition{after: true}, false)
+	for e, ok := it.next(); ok; e, ok = it.next() {
+		cur := table.NewTuple(e.key)
+		if prev != nil {
+			a.Less(NewKeyComparator(meta)(prev.GetData(), cur.GetData()), 0)
+		}
+		prev = cur
+	}
+	a.Equal("cherry", prev.GetValue(keySchema, 0).String())
+
+	idx.DeleteEntry(key("banana", 10), common.NewRID(42, 0), nil)
+	result = nil
+	idx.ScanKey(key("banana", 10), &result, nil)
+	a.Empty(result)
+}
+
+func TestBPlusTreeRangeScan(t *testing.T) {
+	a := assert.New(t)
+	s, err := schema.ParseSchema("ts:BIGINT")
+	a.Nil(err)
+	meta := NewIndexMetadata("idx_ts", "t", []uint32{0}, s)
+	idx := NewIndex[*BPlusTreeIndex](meta, newTestBPM(t, 20), uintptr(16), 4, 4)
+	a.NotNil(idx)
+	key := func(ts int64) *table.Tuple {
+		return table.NewTuple([]*types.Value{types.NewValue(types.BIGINT, ts)}, meta.GetKeySchema())
+	}
+	// the even timestamps from 0 to 198, twice each
+	for i := 0; i < 200; i++ {
+		idx.InsertEntry(key(int64(i/2*2)), common.NewRID(common.PageID(i/2*2), uint32(i%2)), nil)
+	}
+
+	scan := func(r *KeyRange) []common.RID {
+		it, err := idx.ScanRange(r, nil)
+		a.Nil(err)
+		var rids []common.RID
+		for rid, ok := it.Next(); ok; rid, ok = it.Next() {
+			rids = append(rids, rid)
+		}
+		return rids
+	}
+	rids := func(pids ...int) []common.RID {
+		var res []common.RID
+		for _, pid := range pids {
+			res = append(res, common.NewRID(common.PageID(pid), 0), common.NewRID(common.PageID(pid), 1))
+		}
+		return res
+	}
+
+	a.Equal(rids(10, 12, 14), scan(&KeyRange{Low: key(10), LowInclusive: true, High: key(14), HighInclusive: true}))
+	a.Equal(rids(12), scan(&KeyRange{Low: key(10), High: key(14)}))
+	a.Equal(rids(10, 12), scan(&KeyRange{Low: key(9), High: key(13)}))
+	a.Equal(rids(194, 196, 198), scan(&KeyRange{Low: key(193)}))
+	a.Equal(rids(0, 2), scan(&KeyRange{High: key(2), HighInclusive: true}))
+	a.Len(scan(&KeyRange{}), 200)
+	a.Empty(scan(&KeyRange{Low: key(14), High: key(10)}))
+	a.Empty(scan(&KeyRange{Low: key(198)}))
+
+	// reverse scans go from the high bound down, the RIDs of a key in reverse too
+	reversed := scan(&KeyRange{Low: key(10), LowInclusive: true, High: key(14), Reverse: true})
+	a.Equal([]common.RID{common.NewRID(12, 1), common.NewRID(12, 0), common.NewRID(10, 1), common.NewRID(10, 0)}, reversed)
+	all := scan(&KeyRange{Reverse: true})
+	a.Len(all, 200)
+	a.Equal(common.NewRID(198, 1), all[0])
+	a.Equal(common.NewRID(0, 0), all[199])
+
+	// the iterator reads a leaf at a time: entries removed past its leaf aren't returned
+	it, err := idx.ScanRange(&KeyRange{Low: key(0), LowInclusive: true}, nil)
+	a.Nil(err)
+	first, ok := it.Next()
+	a.True(ok)
+	a.Equal(common.NewRID(0, 0), first)
+	for i := 40; i < 200; i++ {
+		idx.DeleteEntry(key(int64(i/2*2)), common.NewRID(common.PageID(i/2*2), uint32(i%2)), nil)
+	}
+	var rest []common.RID
+	for rid, ok := it.Next(); ok; rid, ok = it.Next() {
+		rest = append(rest, rid)
+	}
+	a.Equal(scan(&KeyRange{})[1:], rest)
+	a.Len(rest, 39)
+
+	// hash indexes don't keep their keys in order
+	hashIdx := NewIndex[*ExtendibleHashTableIndex](meta, newTestBPM(t, 10), uintptr(16))
+	_, err = hashIdx.ScanRange(&KeyRange{}, nil)
+	a.Equal(ErrRangeScanUnsupported, err)
+	a.True(common.CheckErrorType(err, common.NOT_IMPLEMENTED))
+}
@@ -0,0 +1,369 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package index
+
+import (
+	"errors"
+	"fmt"
+	"goostub/buffer"
+	"goostub/common"
+	"goostub/hash"
+	"goostub/storage/page/bloom"
+	"goostub/storage/table"
+	"math"
+	"sync"
+)
+
+// number of keys a new Bloom filter is sized for when the caller doesn't tell
+const DefaultBloomFilterCapacity = 10000
+
+var errBloomFilterPoolFull = errors.New("no room in the buffer pool for the pages of the Bloom filter")
+
+/**
+ * An index that only knows which keys it was given: a Bloom filter backed by
+ * the buffer pool. It answers MayContain without false negatives and with
+ * about the false positive rate of the metadata, as long as it holds no more
+ * keys than its capacity. It can't find the RIDs of a key, nor enforce a
+ * constraint.
+ *
+ * A filter can't forget a key, so deletes leave it as it is and it may only
+ * grow saturated. Rebuild it from the table with a larger capacity then.
+ */
+type BloomFilterIndex struct {
+	baseIndex
+	// writers and readers hold it shared, Rebuild exclusively to swap the filter
+	latch  sync.RWMutex
+	filter *bloomFilter
+}
+
+func (i *BloomFilterIndex) InsertEntry(key *table.Tuple, rid common.RID, transaction common.Transaction) error {
+	i.latch.RLock()
+	defer i.latch.RUnlock()
+	if !i.filter.add(key.GetData()) {
+		return errBloomFilterPoolFull
+	}
+	return nil
+}
+
+// the bits of the key may be shared with other keys, so they stay set until the filter is rebuilt
+func (i *BloomFilterIndex) DeleteEntry(key *table.Tuple, rid common.RID, transaction common.Transaction) {
+}
+
+// a Bloom filter doesn't store RIDs, so it never finds any, see MayContain
+func (i *BloomFilterIndex) ScanKey(key *table.Tuple, result *[]common.RID, transaction common.Transaction) {
+}
+
+func (i *BloomFilterIndex) ScanRange(keyRange *KeyRange, transaction common.Transaction) (IndexIterator, error) {
+	return nil, ErrRangeScanUnsupported
+}
+
+func (i *BloomFilterIndex) MayContain(key *table.Tuple, transaction common.Transaction) bool {
+	i.latch.RLock()
+	defer i.latch.RUnlock()
+	return i.filter.mayContain(key.GetData())
+}
+
+/**
+ * Fill the empty filter from its entries. A filter too small for them is
+ * rebuilt larger first.
+ */
+func (i *BloomFilterIndex) BulkBuild(entries []IndexEntry, transaction common.Transaction) error {
+	if uint64(len(entries)) > uint64(i.GetCapacity()) {
+		return i.Rebuild(entries)
+	}
+	for _, e := range entries {
+		if err := i.InsertEntry(e.Key, e.RID, transaction); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * Replace the filter with a new one holding only the given entries, sized for
+ * twice as many keys or the old capacity, whichever is larger. The pages of
+ * the old filter are freed and the header page id changes. The caller keeps
+ * the table from changing until the filter is rebuilt.
+ * @param entries the entries of the table
+ */
+func (i *BloomFilterIndex) Rebuild(entries []IndexEntry) error {
+	i.latch.Lock()
+	defer i.latch.Unlock()
+
+	capacity := i.filter.capacity
+	if grown := 2 * uint64(len(entries)); grown > uint64(capacity) {
+		capacity = math.MaxUint32
+		if grown < math.MaxUint32 {
+			capacity = uint32(grown)
+		}
+	}
+	filter := newBloomFilter(i.filter.bufferManager, capacity, i.filter.falsePositiveRate, i.filter.hashSeed)
+	if filter == nil {
+		return errBloomFilterPoolFull
+	}
+	for _, e := range entries {
+		if !filter.add(e.Key.GetData()) {
+			filter.free()
+			return errBloomFilterPoolFull
+		}
+	}
+	i.filter.free()
+	i.filter = filter
+	return nil
+}
+
+func (i *BloomFilterIndex) FreePages() {
+	i.latch.Lock()
+	defer i.latch.Unlock()
+	i.filter.free()
+}
+
+/** @return the header page of the filter, to open the index again */
+func (i *BloomFilterIndex) GetHeaderPageId() common.PageID {
+	i.latch.RLock()
+	defer i.latch.RUnlock()
+	return i.filter.headerPageId
+}
+
+/** @return the number of keys the filter is sized for */
+func (i *BloomFilterIndex) GetCapacity() uint32 {
+	i.latch.RLock()
+	defer i.latch.RUnlock()
+	return i.filter.capacity
+}
+
+/** @return the number of keys added to the filter, deleted ones included */
+func (i *BloomFilterIndex) GetCount() uint32 {
+	i.latch.RLock()
+	defer i.latch.RUnlock()
+	return i.filter.count()
+}
+
+/** @return whether the filter holds more keys than its capacity, its false positive rate is then above the target */
+func (i *BloomFilterIndex) IsSaturated() bool {
+	i.latch.RLock()
+	defer i.latch.RUnlock()
+	return i.filter.count() > i.filter.capacity
+}
+
+/** @return the false positive rate expected from the keys added so far */
+func (i *BloomFilterIndex) EstimatedFalsePositiveRate() float64 {
+	i.latch.RLock()
+	defer i.latch.RUnlock()
+	f := i.filter
+	k := float64(len(f.hashFuncs))
+	return math.Pow(1-math.Exp(-k*float64(f.count())/float64(f.numBits)), k)
+}
+
+/**
+ * args: optionally the capacity (uint32) of the new filter, DefaultBloomFilterCapacity
+ * if absent, or the header page id (common.PageID) of an existing filter to
+ * open. A new filter is sized for the false positive rate of the metadata and
+ * hashes with seeds counting up from the seed of its hash spec, an opened one
+ * keeps the seeds it was created with, which are then set in the metadata.
+ * A filter can't enforce a constraint, so nil is returned for unique metadata.
+ */
+func (i *BloomFilterIndex) createIndex(m *IndexMetadata, bm *buffer.BufferPoolManager, args ...any) Index {
+	if m.IsUnique() {
+		return nil
+	}
+	var filter *bloomFilter
+	capacity := uint32(DefaultBloomFilterCapacity)
+	if len(args) > 0 {
+		switch arg := args[0].(type) {
+		case common.PageID:
+			filter = openBloomFilter(bm, arg)
+			if filter == nil {
+				return nil
+			}
+			m.SetHashSpec(hash.HashSpec{Id: hash.SeededXXHash, Seed: filter.hashSeed})
+		case uint32:
+			capacity = arg
+		default:
+			common.Assert.Fail(fmt.Sprintf("a Bloom filter takes a capacity (uint32) or a header page id (common.PageID), not %T", arg))
+		}
+	}
+	if filter == nil {
+		filter = newBloomFilter(bm, capacity, m.GetFalsePositiveRate(), m.GetHashSpec().Seed)
+		if filter == nil {
+			return nil
+		}
+	}
+	m.kind = "Bloom filter"
+	return &BloomFilterIndex{
+		baseIndex: baseIndex{metadata: m},
+		filter:    filter,
+	}
+}
+
+/**
+ * The pages of a Bloom filter, see bloom.BloomFilterHeaderPage for the layout.
+ * Everything but the count of keys is fixed when the filter is created, so it
+ * is kept here rather than read from the header.
+ *
+ * Adding a key holds the write latch of each of its bit pages in turn, then the
+ * write latch of the header to count the key. Bits are only ever set, so
+ * lookups don't need to see the bits of a key all at once.
+ */
+type bloomFilter struct {
+	headerPageId      common.PageID
+	bufferManager     *buffer.BufferPoolManager
+	numBits           uint32
+	capacity          uint32
+	falsePositiveRate float64
+	hashSeed          uint64
+	bitPageIds        []common.PageID
+	// one per bit a key sets
+	hashFuncs []hash.HashFunc
+}
+
+/**
+ * The number of bits and hashes of a filter holding capacity keys at the false
+ * positive rate: m = -n ln p / (ln 2)^2 and k = m / n ln 2. A filter larger
+ * than the header page can address is cut down, it then misses the rate.
+ */
+func bloomFilterSize(capacity uint32, falsePositiveRate float64) (numBits uint32, numHashes uint32) {
+	n := math.Max(float64(capacity), 1)
+	m := math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	m = math.Min(m, float64(bloom.MaxBitPages*bloom.BitsPerPage))
+	k := math.Max(math.Round(m/n*math.Ln2), 1)
+	return uint32(m), uint32(k)
+}
+
+// create an empty filter and all its bit pages, nil if the buffer pool has no room for them
+func newBloomFilter(bm *buffer.BufferPoolManager, capacity uint32, falsePositiveRate float64, hashSeed uint64) *bloomFilter {
+	numBits, numHashes := bloomFilterSize(capacity, falsePositiveRate)
+	f := &bloomFilter{
+		bufferManager:     bm,
+		numBits:           numBits,
+		capacity:          capacity,
+		falsePositiveRate: falsePositiveRate,
+		hashSeed:          hashSeed,
+	}
+	headerPage := bm.NewPage(&f.headerPageId, nil)
+	if headerPage == nil {
+		return nil
+	}
+	header := bloom.PageAsHeaderPage(headerPage)
+	header.Init(f.headerPageId, numBits, numHashes, capacity, falsePositiveRate, hashSeed)
+	for j := uint32(0); j < bloom.NumBitPages(numBits); j++ {
+		var bitPageId common.PageID
+		// a new page is zeroed, which is an empty bitmap
+		if bm.NewPage(&bitPageId, nil) == nil {
+			bm.UnpinPage(f.headerPageId, true, nil)
+			f.free()
+			return nil
+		}
+		bm.UnpinPage(bitPageId, true, nil)
+		header.AddBitPage(bitPageId)
+		f.bitPageIds = append(f.bitPageIds, bitPageId)
+	}
+	bm.UnpinPage(f.headerPageId, true, nil)
+	f.hashFuncs = bloomHashFuncs(numHashes, hashSeed)
+	return f
+}
+
+// open a filter from its header page, nil if it can't be read
+func openBloomFilter(bm *buffer.BufferPoolManager, headerPageId common.PageID) *bloomFilter {
+	headerPage := bm.FetchPage(headerPageId, nil)
+	if headerPage == nil {
+		return nil
+	}
+	defer bm.UnpinPage(headerPageId, false, nil)
+	header := bloom.PageAsHeaderPage(headerPage)
+	f := &bloomFilter{
+		headerPageId:      headerPageId,
+		bufferManager:     bm,
+		numBits:           header.GetNumBits(),
+		capacity:          header.GetCapacity(),
+		falsePositiveRate: header.GetFalsePositiveRate(),
+		hashSeed:          header.GetHashSeed(),
+		hashFuncs:         bloomHashFuncs(header.GetNumHashes(), header.GetHashSeed()),
+	}
+	for j := uint32(0); j < header.GetNumBitPages(); j++ {
+		f.bitPageIds = append(f.bitPageIds, header.GetBitPageId(j))
+	}
+	return f
+}
+
+// the hash functions of a filter, seeded with hashSeed, hashSeed+1 and so on
+func bloomHashFuncs(numHashes uint32, hashSeed uint64) []hash.HashFunc {
+	funcs := make([]hash.HashFunc, numHashes)
+	for j := range funcs {
+		funcs[j] = hash.HashSpec{Id: hash.SeededXXHash, Seed: hashSeed + uint64(j)}.Func()
+	}
+	return funcs
+}
+
+/**
+ * Set the bits of a key and count it.
+ * @return false if a page of the filter couldn't be fetched
+ */
+func (f *bloomFilter) add(key []byte) bool {
+	for _, h := range f.hashFuncs {
+		bit := uint32(h(key) % uint64(f.numBits))
+		bitPageId := f.bitPageIds[bit/bloom.BitsPerPage]
+		bitPage := f.bufferManager.FetchPage(bitPageId, nil)
+		if bitPage == nil {
+			return false
+		}
+		bitPage.WLatch()
+		changed := bloom.PageAsBitPage(bitPage).Set(bit % bloom.BitsPerPage)
+		bitPage.WUnlatch()
+		f.bufferManager.UnpinPage(bitPageId, changed, nil)
+	}
+
+	headerPage := f.bufferManager.FetchPage(f.headerPageId, nil)
+	if headerPage == nil {
+		return false
+	}
+	headerPage.WLatch()
+	bloom.PageAsHeaderPage(headerPage).IncrCount()
+	headerPage.WUnlatch()
+	f.bufferManager.UnpinPage(f.headerPageId, true, nil)
+	return true
+}
+
+// false if a bit of the key is clear, true if all are set or a page couldn't be fetched to tell
+func (f *bloomFilter) mayContain(key []byte) bool {
+	for _, h := range f.hashFuncs {
+		bit := uint32(h(key) % uint64(f.numBits))
+		bitPageId := f.bitPageIds[bit/bloom.BitsPerPage]
+		bitPage := f.bufferManager.FetchPage(bitPageId, nil)
+		if bitPage == nil {
+			return true
+		}
+		bitPage.RLatch()
+		set := bloom.PageAsBitPage(bitPage).IsSet(bit % bloom.BitsPerPage)
+		bitPage.RUnlatch()
+		f.bufferManager.UnpinPage(bitPageId, false, nil)
+		if !set {
+			return false
+		}
+	}
+	return true
+}
+
+// the number of keys added to the filter, 0 if the header couldn't be fetched
+func (f *bloomFilter) count() uint32 {
+	headerPage := f.bufferManager.FetchPage(f.headerPageId, nil)
+	if headerPage == nil {
+		return 0
+	}
+	headerPage.RLatch()
+	count := bloom.PageAsHeaderPage(headerPage).GetCount()
+	headerPage.RUnlatch()
+	f.bufferManager.UnpinPage(f.headerPageId, false, nil)
+	return count
+}
+
+// delete the pages of the filter, it can't be used anymore
+func (f *bloomFilter) free() {
+	for _, bitPageId := range f.bitPageIds {
+		f.bufferManager.DeletePage(bitPageId, nil)
+	}
+	f.bufferManager.DeletePage(f.headerPageId, nil)
+}
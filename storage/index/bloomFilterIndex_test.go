@@ -0,0 +1,127 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package index
+
+import (
+	"github.com/stretchr/testify/assert"
+	"goostub/common"
+	"goostub/hash"
+	"goostub/schema"
+	"goostub/storage/page/bloom"
+	"goostub/storage/table"
+	"goostub/types"
+	"testing"
+)
+
+func TestBloomFilterSize(t *testing.T) {
+	a := assert.New(t)
+	// the textbook 9.6 bits and 7 hashes per key for 1%
+	numBits, numHashes := bloomFilterSize(1000, 0.01)
+	a.Equal(uint32(9586), numBits)
+	a.Equal(uint32(7), numHashes)
+
+	// too many keys for the header, the filter gets all the pages it can address
+	numBits, _ = bloomFilterSize(1<<31, 0.0001)
+	a.Equal(uint32(bloom.MaxBitPages*bloom.BitsPerPage), numBits)
+}
+
+func TestBloomFilterIndex(t *testing.T) {
+	a := assert.New(t)
+	s, err := schema.ParseSchema("name:VARCHAR")
+	a.Nil(err)
+	key := func(i int) *table.Tuple {
+		return table.NewTuple([]*types.Value{types.NewValue(types.VARCHAR, "product-"+string(rune('a'+i%26))+string(intKey(i)))}, s)
+	}
+	bpm := newTestBPM(t, 10)
+
+	// a filter can't enforce a constraint
+	unique := NewIndexMetadata("idx", "t", []uint32{0}, s)
+	unique.SetConstraint(Unique, nil)
+	a.Nil(NewIndex[*BloomFilterIndex](unique, bpm))
+
+	meta := NewIndexMetadata("idx", "t", []uint32{0}, s)
+	meta.SetFalsePositiveRate(0.02)
+	meta.SetHashSpec(hash.HashSpec{Id: hash.SeededXXHash, Seed: 42})
+	const n = 5000
+	idx := NewIndex[*BloomFilterIndex](meta, bpm, uint32(n)).(*BloomFilterIndex)
+	for i := 0; i < n; i++ {
+		a.Nil(idx.InsertEntry(key(i), common.NewRID(common.PageID(i), 0), nil))
+	}
+	a.Equal(uint32(n), idx.GetCount())
+	a.False(idx.IsSaturated())
+	a.InDelta(0.02, idx.EstimatedFalsePositiveRate(), 0.005)
+
+	// no false negatives, deleted keys included
+	idx.DeleteEntry(key(0), common.NewRID(0, 0), nil)
+	for i := 0; i < n; i++ {
+		a.True(idx.MayContain(key(i), nil))
+	}
+	var rids []common.RID
+	idx.ScanKey(key(1), &rids, nil)
+	a.Empty(rids)
+
+	// and about the asked rate of false positives
+	falsePositives := 0
+	for i := n; i < 11*n; i++ {
+		if idx.MayContain(key(i), nil) {
+			falsePositives++
+		}
+	}
+	a.InDelta(0.02, float64(falsePositives)/(10*n), 0.01)
+
+	// the filter is the same once opened again, seeds included
+	other := NewIndexMetadata("idx", "t", []uint32{0}, s)
+	opened := NewIndex[*BloomFilterIndex](other, bpm, idx.GetHeaderPageId()).(*BloomFilterIndex)
+	a.Equal(uint64(42), other.GetHashSpec().Seed)
+	a.Equal(uint32(n), opened.GetCapacity())
+	for i := 0; i < 11*n; i++ {
+		a.Equal(idx.MayContain(key(i), nil), opened.MayContain(key(i), nil))
+	}
+}
+
+func TestBloomFilterRebuild(t *testing.T) {
+	a := assert.New(t)
+	s, err := schema.ParseSchema("id:INTEGER")
+	a.Nil(err)
+	key := func(id int) *table.Tuple {
+		return table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, int32(id))}, s)
+	}
+	meta := NewIndexMetadata("idx", "t", []uint32{0}, s)
+	idx := NewIndex[*BloomFilterIndex](meta, newTestBPM(t, 10), uint32(100)).(*BloomFilterIndex)
+
+	// ten times its capacity, the filter reports most absent keys as present
+	var entries []IndexEntry
+	for i := 0; i < 1000; i++ {
+		entries = append(entries, IndexEntry{Key: key(i), RID: common.NewRID(common.PageID(i), 0)})
+		a.Nil(idx.InsertEntry(key(i), common.NewRID(common.PageID(i), 0), nil))
+	}
+	a.True(idx.IsSaturated())
+	a.Greater(idx.EstimatedFalsePositiveRate(), 0.5)
+
+	oldHeader := idx.GetHeaderPageId()
+	a.Nil(idx.Rebuild(entries[:800]))
+	a.NotEqual(oldHeader, idx.GetHeaderPageId())
+	a.Equal(uint32(1600), idx.GetCapacity())
+	a.Equal(uint32(800), idx.GetCount())
+	a.False(idx.IsSaturated())
+	a.Less(idx.EstimatedFalsePositiveRate(), 0.001)
+	for i := 0; i < 800; i++ {
+		a.True(idx.MayContain(key(i), nil))
+	}
+	falsePositives := 0
+	for i := 800; i < 10800; i++ {
+		if idx.MayContain(key(i), nil) {
+			falsePositives++
+		}
+	}
+	a.Less(falsePositives, 100)
+
+	// a bulk build larger than the capacity sizes the filter for the entries
+	bulk := NewIndex[*BloomFilterIndex](NewIndexMetadata("idx", "t", []uint32{0}, s), newTestBPM(t, 10), uint32(100)).(*BloomFilterIndex)
+	a.Nil(bulk.BulkBuild(entries, nil))
+	a.Equal(uint32(2000), bulk.GetCapacity())
+	a.Equal(uint32(1000), bulk.GetCount())
+}
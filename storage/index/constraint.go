@@ -0,0 +1,159 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package index
+
+import (
+	"fmt"
+	"goostub/common"
+	"goostub/hash"
+	"goostub/storage/table"
+)
+
+type IndexConstraint uint8
+
+const (
+	// any number of entries per key
+	NoConstraint IndexConstraint = iota
+	// at most one live entry per key, keys with NULL columns aren't checked as in SQL
+	Unique
+	// unique and without NULL key columns
+	PrimaryKey
+)
+
+// number of locks the inserts into a unique index spread their keys over
+const keyLockCount = 64
+
+/**
+ * Tells whether the tuple at a RID is live, i.e. it is still in the table
+ * and not deleted. Entries of dead tuples may linger in an index until they
+ * are cleaned up, they don't count for unique indexes.
+ */
+type LivenessCheck func(rid common.RID) bool
+
+/**
+ * Set the constraint of the index, it must be set before the index is created.
+ * @param constraint what the index enforces
+ * @param live tells which RIDs count when looking for a duplicate key, nil if all of them do
+ */
+func (im *IndexMetadata) SetConstraint(constraint IndexConstraint, live LivenessCheck) {
+	im.constraint = constraint
+	im.live = live
+}
+
+func (im *IndexMetadata) GetConstraint() IndexConstraint {
+	return im.constraint
+}
+
+func (im *IndexMetadata) IsUnique() bool {
+	return im.constraint != NoConstraint
+}
+
+/** An insert would break the constraint of an index. The transaction is aborted. */
+type ConstraintViolationError struct {
+	IndexName string
+	// the key, formatted with the key schema
+	Key string
+	// the live entry that already has the key, invalid if a primary key got NULL key columns
+	Conflict common.RID
+}
+
+func (e *ConstraintViolationError) Error() string {
+	if e.Conflict.GetPageId() == common.InvalidPageID {
+		return fmt.Sprintf("primary key %s can't have NULL columns: %s", e.IndexName, e.Key)
+	}
+	return fmt.Sprintf("duplicate key %s in unique index %s, already at %v", e.Key, e.IndexName, e.Conflict)
+}
+
+/**
+ * Insert an entry, checking the constraint of the index first. The check and
+ * the insert happen under a lock of the key, so that two concurrent inserts
+ * of the same key can't both pass the check.
+ * @param idx the index, to look up the key
+ * @param insert adds the entry to the index
+ * @return a *ConstraintViolationError if the entry breaks the constraint
+ */
+func (bi *baseIndex) insertEntry(idx Index, key *table.Tuple, rid common.RID, txn common.Transaction, insert func()) error {
+	m := bi.metadata
+	if !m.IsUnique() {
+		insert()
+		return nil
+	}
+
+	if bi.hasNullKey(key) {
+		if m.GetConstraint() == PrimaryKey {
+			return bi.violation(key, common.DefaultRID(), txn)
+		}
+		// NULL is distinct from everything, NULL included
+		insert()
+		return nil
+	}
+
+	lock := &bi.keyLocks[hash.GoosTubHash(key.GetData())%keyLockCount]
+	lock.Lock()
+	defer lock.Unlock()
+	var rids []common.RID
+	idx.ScanKey(key, &rids, txn)
+	for _, other := range rids {
+		if other != rid && (m.live == nil || m.live(other)) {
+			return bi.violation(key, other, txn)
+		}
+	}
+	insert()
+	return nil
+}
+
+/**
+ * Check the constraint of the index over the entries of a bulk build, as if
+ * they were inserted one by one in order.
+ * @return a *ConstraintViolationError if an entry breaks the constraint
+ */
+func (bi *baseIndex) checkEntries(entries []IndexEntry, txn common.Transaction) error {
+	m := bi.metadata
+	if !m.IsUnique() {
+		return nil
+	}
+	// the first live entry of each key
+	seen := make(map[string]common.RID)
+	for _, e := range entries {
+		if bi.hasNullKey(e.Key) {
+			if m.GetConstraint() == PrimaryKey {
+				return bi.violation(e.Key, common.DefaultRID(), txn)
+			}
+			continue
+		}
+		// a deleted tuple the garbage collection hasn't removed yet doesn't hold its key
+		if m.live != nil && !m.live(e.RID) {
+			continue
+		}
+		k := string(e.Key.GetData())
+		if other, ok := seen[k]; ok {
+			return bi.violation(e.Key, other, txn)
+		}
+		seen[k] = e.RID
+	}
+	return nil
+}
+
+func (bi *baseIndex) hasNullKey(key *table.Tuple) bool {
+	keySchema := bi.metadata.GetKeySchema()
+	for i := 0; i < keySchema.GetColumnCount(); i++ {
+		if key.IsNull(keySchema, i) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bi *baseIndex) violation(key *table.Tuple, conflict common.RID, txn common.Transaction) error {
+	if txn != nil {
+		txn.SetState(common.Aborted)
+	}
+	return &ConstraintViolationError{
+		IndexName: bi.metadata.Name,
+		Key:       key.String(bi.metadata.GetKeySchema()),
+		Conflict:  conflict,
+	}
+}
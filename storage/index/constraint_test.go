@@ -0,0 +1,142 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package index
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"goostub/common"
+	"goostub/schema"
+	"goostub/storage/table"
+	"goostub/types"
+	"sync"
+	"testing"
+)
+
+// a transaction that only keeps its state
+type testTxn struct {
+	common.Transaction
+	state common.TransactionState
+}
+
+func (txn *testTxn) GetState() common.TransactionState {
+	return txn.state
+}
+
+func (txn *testTxn) SetState(state common.TransactionState) {
+	txn.state = state
+}
+
+func TestUniqueIndex(t *testing.T) {
+	s, err := schema.ParseSchema("id:INTEGER, name:VARCHAR")
+	assert.Nil(t, err)
+	newIndexes := func(constraint IndexConstraint, live LivenessCheck) map[string]Index {
+		indexes := make(map[string]Index)
+		for name, create := range map[string]func(*IndexMetadata) Index{
+			"hash": func(m *IndexMetadata) Index {
+				return NewIndex[*ExtendibleHashTableIndex](m, newTestBPM(t, 10), uintptr(16))
+			},
+			"btree": func(m *IndexMetadata) Index {
+				return NewIndex[*BPlusTreeIndex](m, newTestBPM(t, 10), uintptr(16))
+			},
+		} {
+			m := NewIndexMetadata("idx_"+name, "t", []uint32{0}, s)
+			m.SetConstraint(constraint, live)
+			indexes[name] = create(m)
+		}
+		return indexes
+	}
+
+	for name, idx := range newIndexes(Unique, nil) {
+		a := assert.New(t)
+		key := func(id int32) *table.Tuple {
+			return table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, id)}, idx.GetKeySchema())
+		}
+		a.Nil(idx.InsertEntry(key(1), common.NewRID(1, 0), nil), name)
+		// the same entry again is fine, another RID isn't
+		a.Nil(idx.InsertEntry(key(1), common.NewRID(1, 0), nil), name)
+		err := idx.InsertEntry(key(1), common.NewRID(2, 0), nil)
+		var violation *ConstraintViolationError
+		a.True(errors.As(err, &violation), name)
+		a.Equal(common.NewRID(1, 0), violation.Conflict, name)
+		a.Equal("(1)", violation.Key, name)
+
+		// once the entry is gone the key is free
+		idx.DeleteEntry(key(1), common.NewRID(1, 0), nil)
+		a.Nil(idx.InsertEntry(key(1), common.NewRID(2, 0), nil), name)
+
+		// NULL keys are never duplicates
+		null := table.NewTuple([]*types.Value{types.NewValue(types.INTEGER)}, idx.GetKeySchema())
+		a.Nil(idx.InsertEntry(null, common.NewRID(3, 0), nil), name)
+		a.Nil(idx.InsertEntry(null, common.NewRID(4, 0), nil), name)
+	}
+
+	// entries of dead tuples don't count
+	dead := map[common.RID]bool{common.NewRID(1, 0): true}
+	for name, idx := range newIndexes(PrimaryKey, func(rid common.RID) bool { return !dead[rid] }) {
+		a := assert.New(t)
+		key := table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, int32(1))}, idx.GetKeySchema())
+		a.Nil(idx.InsertEntry(key, common.NewRID(1, 0), nil), name)
+		a.Nil(idx.InsertEntry(key, common.NewRID(2, 0), nil), name)
+		a.NotNil(idx.InsertEntry(key, common.NewRID(3, 0), nil), name)
+
+		null := table.NewTuple([]*types.Value{types.NewValue(types.INTEGER)}, idx.GetKeySchema())
+		err := idx.InsertEntry(null, common.NewRID(4, 0), nil)
+		var violation *ConstraintViolationError
+		a.True(errors.As(err, &violation), name)
+		a.Equal(common.DefaultRID(), violation.Conflict, name)
+	}
+
+	// a bulk build checks the entries as if they were inserted in order
+	for name, idx := range newIndexes(PrimaryKey, func(rid common.RID) bool { return !dead[rid] }) {
+		a := assert.New(t)
+		key := func(id int32) *table.Tuple {
+			return table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, id)}, idx.GetKeySchema())
+		}
+		entries := []IndexEntry{{key(1), common.NewRID(1, 0)}, {key(1), common.NewRID(2, 0)}, {key(2), common.NewRID(3, 0)}, {key(2), common.NewRID(4, 0)}}
+		txn := &testTxn{}
+		err := idx.(BulkBuilder).BulkBuild(entries, txn)
+		var violation *ConstraintViolationError
+		a.True(errors.As(err, &violation), name)
+		a.Equal(common.NewRID(3, 0), violation.Conflict, name)
+		a.Equal(common.Aborted, txn.GetState(), name)
+
+		a.Nil(idx.(BulkBuilder).BulkBuild(entries[:3], nil), name)
+		var result []common.RID
+		idx.ScanKey(key(1), &result, nil)
+		a.Len(result, 2, name)
+	}
+
+	// a dead tuple with the key of a live one, before the garbage collection removes it
+	for name, idx := range newIndexes(PrimaryKey, func(rid common.RID) bool { return !dead[rid] }) {
+		key := table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, int32(1))}, idx.GetKeySchema())
+		assert.Nil(t, idx.(BulkBuilder).BulkBuild([]IndexEntry{{key, common.NewRID(2, 0)}, {key, common.NewRID(1, 0)}}, nil), name)
+	}
+
+	// of many concurrent inserts of a key, exactly one wins
+	for name, idx := range newIndexes(Unique, nil) {
+		key := table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, int32(42))}, idx.GetKeySchema())
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		inserted := 0
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if idx.InsertEntry(key, common.NewRID(common.PageID(i), 0), nil) == nil {
+					mutex.Lock()
+					inserted++
+					mutex.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+		assert.Equal(t, 1, inserted, name)
+		var result []common.RID
+		idx.ScanKey(key, &result, nil)
+		assert.Len(t, result, 1, name)
+	}
+}
@@ -6,9 +6,12 @@
 package index
 
 import (
+	"errors"
+	"fmt"
 	"goostub/buffer"
 	"goostub/common"
 	"goostub/hash"
+	"goostub/storage/page"
 	"goostub/storage/page/htable"
 	"goostub/storage/table"
 )
@@ -18,8 +21,10 @@ type ExtendibleHashTableIndex struct {
 	container extendibleHashTable
 }
 
-func (i *ExtendibleHashTableIndex) InsertEntry(key *table.Tuple, rid common.RID, transaction common.Transaction) {
-	i.container.insert(transaction, key.GetData(), rid)
+func (i *ExtendibleHashTableIndex) InsertEntry(key *table.Tuple, rid common.RID, transaction common.Transaction) error {
+	return i.insertEntry(i, key, rid, transaction, func() {
+		i.container.insert(transaction, key.GetData(), rid)
+	})
 }
 func (i *ExtendibleHashTableIndex) DeleteEntry(key *table.Tuple, rid common.RID, transaction common.Transaction) {
 	i.container.remove(transaction, key.GetData(), rid)
@@ -27,38 +32,161 @@ func (i *ExtendibleHashTableIndex) DeleteEntry(key *table.Tuple, rid common.RID,
 func (i *ExtendibleHashTableIndex) ScanKey(key *table.Tuple, result *[]common.RID, transaction common.Transaction) {
 	i.container.getValue(transaction, key.GetData(), result)
 }
+func (i *ExtendibleHashTableIndex) ScanEntries(fn func(key []byte, rid common.RID)) {
+	i.container.forEachEntry(fn)
+}
+func (i *ExtendibleHashTableIndex) StoredKey(key *table.Tuple) ([]byte, bool) {
+	return i.container.normalizeKey(key.GetData())
+}
+
+/**
+ * Fill the empty index from its entries, writing each bucket once.
+ */
+func (i *ExtendibleHashTableIndex) BulkBuild(entries []IndexEntry, transaction common.Transaction) error {
+	if err := i.checkEntries(entries, transaction); err != nil {
+		return err
+	}
+	loaded := make([]hashEntry, len(entries))
+	for j, e := range entries {
+		key, ok := i.container.normalizeKey(e.Key.GetData())
+		if !ok {
+			return fmt.Errorf("a key is longer than the keys of %s", i.GetName())
+		}
+		loaded[j] = hashEntry{key: key, value: e.RID}
+	}
+	return i.container.bulkLoad(loaded)
+}
 
-func (i *ExtendibleHashTableIndex) createIndex(m *IndexMetadata, bm *buffer.BufferPoolManager, args ...any) *ExtendibleHashTableIndex {
+// hashing doesn't keep the keys in order
+func (i *ExtendibleHashTableIndex) ScanRange(keyRange *KeyRange, transaction common.Transaction) (IndexIterator, error) {
+	return nil, ErrRangeScanUnsupported
+}
+
+func (i *ExtendibleHashTableIndex) FreePages() {
+	i.container.free()
+}
+
+/** @return the header page of the hash table, to open the index again */
+func (i *ExtendibleHashTableIndex) GetHeaderPageId() common.PageID {
+	return i.container.headerPageId
+}
+
+/**
+ * args: the key size in bytes (uintptr), 0 for variable-length keys such as
+ * the keys of VARCHAR columns, optionally followed by the header
+ * page id (common.PageID) of an existing index to open. A new index hashes with
+ * the hash function of the metadata, an opened one with the function it was
+ * created with, which is then set in the metadata.
+ */
+func (i *ExtendibleHashTableIndex) createIndex(m *IndexMetadata, bm *buffer.BufferPoolManager, args ...any) Index {
+	common.Assert.NotEmpty(args, "key size is required for a hash index")
+	keySize, ok := args[0].(uintptr)
+	common.Assert.True(ok, "key size must be an uintptr")
+
+	var container *extendibleHashTable
+	if len(args) > 1 {
+		headerPageId, ok := args[1].(common.PageID)
+		common.Assert.True(ok, "the header page id must be a common.PageID")
+		container = openExtendibleHashTable(bm, headerPageId, uint32(keySize))
+		if container != nil {
+			m.SetHashSpec(container.hashSpec)
+		}
+	} else {
+		container = newExtendibleHashTable(bm, uint32(keySize), m.GetHashSpec(), htable.HeaderMaxDepth, htable.DirectoryMaxDepth)
+	}
+	if container == nil {
+		return nil
+	}
+	m.kind = "Hash"
 	return &ExtendibleHashTableIndex{
-		// implement me
+		baseIndex: baseIndex{metadata: m},
+		container: *container,
 	}
 }
 
 /**
 Some simplifying changes comparing to bustub
 
-1. 2 fields are removed: comparator, hash func
-For comparator, hash index doesn't need an ordered comparator (unless you use an ordered data structure to resolve collision which is not the case here), and therefore we can simply compare whether the byte sequence is the same
-Allowing user-defined hash function would be too much trouble for an educational db. we just stick to one specific hash function here.
+1. the comparator field is removed
+Hash index doesn't need an ordered comparator (unless you use an ordered data structure to resolve collision which is not the case here), and therefore we can simply compare whether the byte sequence is the same
 
 2. removed template arguments: KeyType, ValueType, ComparatorType
 ComparatorType is not needed because of the change 1
 ValueType is not needed because we only need RID as value
 KeyType is not needed because it doesn't make sense. key type should be decided at runtime by the key attributes instead of at compile time as a template argument. Besides, we can always treat key as a byte sequence then the only variable is the length of the sequence, and however, length is not a type and generics in Go doesn't support non-type argument
 
-Adding another field for convenience: keySize which indicates the size of the key in bytes
+Adding another field for convenience: keySize which indicates the size of the key in bytes, 0 for variable-length keys
 */
 
 /**
  * Implementation of extendible hash table that is backed by a buffer pool
  * manager. Non-unique keys are supported. Supports insert and delete. The
  * table grows/shrinks dynamically as buckets become full/empty.
+ *
+ * The header page spreads the keys over up to 2^HeaderMaxDepth directory
+ * pages, each of which is an extendible hash directory of its own.
+ *
+ * The page latches are taken from the header down to the buckets. Lookups,
+ * and inserts and removes that don't change the shape of the table, hold the
+ * read latch of the directory and the latch of their bucket, so they only
+ * wait for each other on the same bucket. Splits, merges and directory
+ * growth hold the write latch of the directory, creating a directory holds
+ * the write latch of the header.
  */
 type extendibleHashTable struct {
-	directoryPageId common.PageID
-	bufferManager   *buffer.BufferPoolManager
-	tableLatch      common.ReaderWriterLatch
-	keySize         uint32
+	headerPageId  common.PageID
+	bufferManager *buffer.BufferPoolManager
+	keySize       uint32
+	hashSpec      hash.HashSpec
+	hashFunc      hash.HashFunc
+}
+
+/**
+ * Create a hash table with an empty header, nil if the hash function is unknown
+ * or a depth is too large.
+ * @param headerMaxDepth number of hash bits that pick a directory
+ * @param directoryMaxDepth largest global depth of a directory
+ */
+func newExtendibleHashTable(bm *buffer.BufferPoolManager, keySize uint32, hashSpec hash.HashSpec, headerMaxDepth uint32, directoryMaxDepth uint32) *extendibleHashTable {
+	if hashSpec.Func() == nil || headerMaxDepth > htable.HeaderMaxDepth || directoryMaxDepth > htable.DirectoryMaxDepth {
+		return nil
+	}
+	t := &extendibleHashTable{
+		bufferManager: bm,
+		keySize:       keySize,
+		hashSpec:      hashSpec,
+		hashFunc:      hashSpec.Func(),
+	}
+
+	headerPage := bm.NewPage(&t.headerPageId, nil)
+	if headerPage == nil {
+		return nil
+	}
+	header := htable.PageAsHeaderPage(headerPage)
+	header.Init(t.headerPageId, headerMaxDepth, directoryMaxDepth)
+	header.SetHashSpec(hashSpec)
+	bm.UnpinPage(t.headerPageId, true, nil)
+	return t
+}
+
+// open a hash table from its header page, nil if it can't be read
+func openExtendibleHashTable(bm *buffer.BufferPoolManager, headerPageId common.PageID, keySize uint32) *extendibleHashTable {
+	headerPage := bm.FetchPage(headerPageId, nil)
+	if headerPage == nil {
+		return nil
+	}
+	hashSpec := htable.PageAsHeaderPage(headerPage).GetHashSpec()
+	bm.UnpinPage(headerPageId, false, nil)
+	if hashSpec.Func() == nil {
+		return nil
+	}
+	return &extendibleHashTable{
+		headerPageId:  headerPageId,
+		bufferManager: bm,
+		keySize:       keySize,
+		hashSpec:      hashSpec,
+		hashFunc:      hashSpec.Func(),
+	}
 }
 
 /**
@@ -70,7 +198,26 @@ type extendibleHashTable struct {
  * @return true if insert succeeded, false otherwise
  */
 func (t *extendibleHashTable) insert(transaction common.Transaction, key []byte, value common.RID) bool {
-	return false
+	key, ok := t.normalizeKey(key)
+	if !ok {
+		return false
+	}
+
+	directoryPageId := t.keyToDirectoryPageId(key, true)
+	if directoryPageId == common.InvalidPageID {
+		return false
+	}
+	dirPage := t.fetchLatched(directoryPageId, false)
+	bucketPage := t.fetchLatched(t.keyToPageId(key, htable.PageAsDirectoryPage(dirPage)), true)
+	inserted, full := t.asBucket(bucketPage).insert(key, value)
+	t.releaseLatched(bucketPage, true, inserted)
+	t.releaseLatched(dirPage, false, false)
+
+	if full {
+		// the bucket may have been split or emptied meanwhile, splitInsert checks it again
+		return t.splitInsert(transaction, directoryPageId, key, value)
+	}
+	return inserted
 }
 
 /**
@@ -82,7 +229,27 @@ func (t *extendibleHashTable) insert(transaction common.Transaction, key []byte,
  * @return true if remove succeeded, false otherwise
  */
 func (t *extendibleHashTable) remove(transaction common.Transaction, key []byte, value common.RID) bool {
-	return false
+	key, ok := t.normalizeKey(key)
+	if !ok {
+		return false
+	}
+
+	directoryPageId := t.keyToDirectoryPageId(key, false)
+	if directoryPageId == common.InvalidPageID {
+		return false
+	}
+	dirPage := t.fetchLatched(directoryPageId, false)
+	bucketPage := t.fetchLatched(t.keyToPageId(key, htable.PageAsDirectoryPage(dirPage)), true)
+	bucket := t.asBucket(bucketPage)
+	removed := bucket.remove(key, value)
+	empty := bucket.isEmpty()
+	t.releaseLatched(bucketPage, true, removed)
+	t.releaseLatched(dirPage, false, false)
+
+	if removed && empty {
+		t.merge(transaction, directoryPageId, key, value)
+	}
+	return removed
 }
 
 /**
@@ -94,28 +261,116 @@ func (t *extendibleHashTable) remove(transaction common.Transaction, key []byte,
  * @return true if lookup succeeded, false otherwise
  */
 func (t *extendibleHashTable) getValue(transaction common.Transaction, key []byte, result *[]common.RID) bool {
-	return false
+	key, ok := t.normalizeKey(key)
+	if !ok {
+		return false
+	}
+
+	directoryPageId := t.keyToDirectoryPageId(key, false)
+	if directoryPageId == common.InvalidPageID {
+		return false
+	}
+	dirPage := t.fetchLatched(directoryPageId, false)
+	bucketPage := t.fetchLatched(t.keyToPageId(key, htable.PageAsDirectoryPage(dirPage)), false)
+	found := t.asBucket(bucketPage).getValue(key, result)
+	t.releaseLatched(bucketPage, false, false)
+	t.releaseLatched(dirPage, false, false)
+	return found
 }
 
 /**
- * Returns the global depth.  Do not touch.
+ * Returns the largest global depth of the directories.  Do not touch.
  */
 func (t *extendibleHashTable) getGlobalDepth() uint32 {
-	t.tableLatch.RLock()
-	defer t.tableLatch.RUnlock()
-	defer common.Assert.True(t.bufferManager.UnpinPage(t.directoryPageId, false, nil))
-	return t.fetchDirectoryPage().GetGlobalDepth()
+	var globalDepth uint32
+	t.forEachDirectory(func(header *htable.HashTableHeaderPage, directoryIdx uint32, dirPage *htable.HashTableDirectoryPage) {
+		if dirPage.GetGlobalDepth() > globalDepth {
+			globalDepth = dirPage.GetGlobalDepth()
+		}
+	})
+	return globalDepth
 }
 
 /**
- * Helper function to verify the integrity of the extendible hash table's directory.
+ * Helper function to verify the integrity of the extendible hash table: every
+ * directory is consistent, no bucket is shared by two directories and every
+ * key is in the bucket its hash leads to.
  * Do not touch.
  */
 func (t *extendibleHashTable) verifyIntegrity() {
-	t.tableLatch.RLock()
-	defer t.tableLatch.RUnlock()
-	t.fetchDirectoryPage().VerifyIntegrity()
-	common.Assert.True(t.bufferManager.UnpinPage(t.directoryPageId, false, nil))
+	owners := make(map[common.PageID]uint32)
+	t.forEachDirectory(func(header *htable.HashTableHeaderPage, directoryIdx uint32, dirPage *htable.HashTableDirectoryPage) {
+		dirPage.VerifyIntegrity()
+		for idx := uint32(0); idx < dirPage.Size(); idx++ {
+			bucketPageId := dirPage.GetBucketPageId(idx)
+			if owner, ok := owners[bucketPageId]; ok {
+				common.Assert.Equal(directoryIdx, owner, "bucket %d is in two directories", bucketPageId)
+				continue
+			}
+			owners[bucketPageId] = directoryIdx
+
+			mask := dirPage.GetLocalDepthMask(idx)
+			bucketPage := t.fetchLatched(bucketPageId, false)
+			for _, h := range t.asBucket(bucketPage).hashes() {
+				common.Assert.Equal(directoryIdx, header.HashToDirectoryIndex(h), "key of bucket %d in the wrong directory", bucketPageId)
+				common.Assert.Equal(idx&mask, h&mask, "key of bucket %d in the wrong bucket", bucketPageId)
+			}
+			t.releaseLatched(bucketPage, false, false)
+		}
+	})
+}
+
+// call fn on every entry of the table, with the header, the directory and the bucket of the entry read latched
+func (t *extendibleHashTable) forEachEntry(fn func(key []byte, value common.RID)) {
+	t.forEachDirectory(func(header *htable.HashTableHeaderPage, directoryIdx uint32, dirPage *htable.HashTableDirectoryPage) {
+		// a bucket has as many slots as 2^(global depth - local depth), visit it from its lowest one
+		for idx := uint32(0); idx < dirPage.Size(); idx++ {
+			if idx&dirPage.GetLocalDepthMask(idx) != idx {
+				continue
+			}
+			bucketPage := t.fetchLatched(dirPage.GetBucketPageId(idx), false)
+			t.asBucket(bucketPage).forEach(fn)
+			t.releaseLatched(bucketPage, false, false)
+		}
+	})
+}
+
+// delete every page of the table, the overflow pages of the keys included, the table can't be used anymore
+func (t *extendibleHashTable) free() {
+	var pageIds []common.PageID
+	t.forEachDirectory(func(header *htable.HashTableHeaderPage, directoryIdx uint32, dirPage *htable.HashTableDirectoryPage) {
+		for idx := uint32(0); idx < dirPage.Size(); idx++ {
+			if idx&dirPage.GetLocalDepthMask(idx) != idx {
+				continue
+			}
+			bucketPageId := dirPage.GetBucketPageId(idx)
+			bucketPage := t.fetchLatched(bucketPageId, false)
+			t.asBucket(bucketPage).freeOverflows()
+			t.releaseLatched(bucketPage, false, false)
+			pageIds = append(pageIds, bucketPageId)
+		}
+		pageIds = append(pageIds, header.GetDirectoryPageId(directoryIdx))
+	})
+	// the pages are unpinned by now
+	for _, pid := range append(pageIds, t.headerPageId) {
+		t.bufferManager.DeletePage(pid, nil)
+	}
+}
+
+// call fn on every directory of the table, with the header and the directory read latched
+func (t *extendibleHashTable) forEachDirectory(fn func(header *htable.HashTableHeaderPage, directoryIdx uint32, dirPage *htable.HashTableDirectoryPage)) {
+	headerPage := t.fetchLatched(t.headerPageId, false)
+	defer t.releaseLatched(headerPage, false, false)
+	header := htable.PageAsHeaderPage(headerPage)
+	for directoryIdx := uint32(0); directoryIdx < header.MaxSize(); directoryIdx++ {
+		directoryPageId := header.GetDirectoryPageId(directoryIdx)
+		if directoryPageId == common.InvalidPageID {
+			continue
+		}
+		dirPage := t.fetchLatched(directoryPageId, false)
+		fn(header, directoryIdx, htable.PageAsDirectoryPage(dirPage))
+		t.releaseLatched(dirPage, false, false)
+	}
 }
 
 /**
@@ -126,7 +381,7 @@ func (t *extendibleHashTable) verifyIntegrity() {
  * @return the downcasted 32-bit hash
  */
 func (t *extendibleHashTable) hash(key []byte) uint32 {
-	return uint32(hash.GoosTubHash(key))
+	return uint32(t.hashFunc(key))
 }
 
 /**
@@ -145,8 +400,8 @@ func (t *extendibleHashTable) hash(key []byte) uint32 {
  * @param dir_page to use for lookup of global depth
  * @return the directory index
  */
-func (t *extendibleHashTable) keyToDirectoryIndex(key []byte, dirPage *htable.HashTableDirectoryPage) common.PageID {
-
+func (t *extendibleHashTable) keyToDirectoryIndex(key []byte, dirPage *htable.HashTableDirectoryPage) uint32 {
+	return t.hash(key) & dirPage.GetGlobalDepthMask()
 }
 
 /**
@@ -157,39 +412,173 @@ func (t *extendibleHashTable) keyToDirectoryIndex(key []byte, dirPage *htable.Ha
  * @return the bucket page_id corresponding to the input key
  */
 func (t *extendibleHashTable) keyToPageId(key []byte, dirPage *htable.HashTableDirectoryPage) common.PageID {
+	return dirPage.GetBucketPageId(t.keyToDirectoryIndex(key, dirPage))
+}
+
+/**
+ * Get the directory page_id corresponding to a key.
+ *
+ * @param key the key for lookup
+ * @param create whether to create the directory, with one empty bucket, if the key has none yet
+ * @return the directory page_id, common.InvalidPageID if there is none
+ */
+func (t *extendibleHashTable) keyToDirectoryPageId(key []byte, create bool) common.PageID {
+	headerPage := t.fetchLatched(t.headerPageId, false)
+	header := htable.PageAsHeaderPage(headerPage)
+	directoryIdx := header.HashToDirectoryIndex(t.hash(key))
+	directoryPageId := header.GetDirectoryPageId(directoryIdx)
+	t.releaseLatched(headerPage, false, false)
+	if directoryPageId != common.InvalidPageID || !create {
+		return directoryPageId
+	}
+
+	// another thread may create the directory between the two latches
+	headerPage = t.fetchLatched(t.headerPageId, true)
+	header = htable.PageAsHeaderPage(headerPage)
+	directoryPageId = header.GetDirectoryPageId(directoryIdx)
+	created := false
+	if directoryPageId == common.InvalidPageID {
+		directoryPageId = t.newDirectory(header.GetDirectoryMaxDepth())
+		header.SetDirectoryPageId(directoryIdx, directoryPageId)
+		created = directoryPageId != common.InvalidPageID
+	}
+	t.releaseLatched(headerPage, true, created)
+	return directoryPageId
+}
+
+// create a directory of global depth 0 pointing to one empty bucket, common.InvalidPageID if the buffer pool is full
+func (t *extendibleHashTable) newDirectory(maxDepth uint32) common.PageID {
+	var directoryPageId, bucketPageId common.PageID
+	dirPage := t.bufferManager.NewPage(&directoryPageId, nil)
+	if dirPage == nil {
+		return common.InvalidPageID
+	}
+	if t.bufferManager.NewPage(&bucketPageId, nil) == nil {
+		t.bufferManager.UnpinPage(directoryPageId, false, nil)
+		t.bufferManager.DeletePage(directoryPageId, nil)
+		return common.InvalidPageID
+	}
+
+	dir := htable.PageAsDirectoryPage(dirPage)
+	dir.SetPageId(directoryPageId)
+	dir.SetMaxDepth(maxDepth)
+	dir.SetBucketPageId(0, bucketPageId)
+	dir.SetLocalDepth(0, 0)
+	t.bufferManager.UnpinPage(bucketPageId, true, nil)
+	t.bufferManager.UnpinPage(directoryPageId, true, nil)
+	return directoryPageId
 }
 
 /**
- * Fetches the directory page from the buffer pool manager.
+ * Fetches a page from the buffer pool manager and latches it.
  *
- * @return a pointer to the directory page
+ * @param pid the page_id to fetch
+ * @param write whether to take the write latch rather than the read latch
+ * @return the latched page
  */
-func (t *extendibleHashTable) fetchDirectoryPage() *htable.HashTableDirectoryPage {
+func (t *extendibleHashTable) fetchLatched(pid common.PageID, write bool) page.Page {
+	p := t.bufferManager.FetchPage(pid, nil)
+	common.Assert.NotNil(p, "failed to fetch page %d", pid)
+	if write {
+		p.WLatch()
+	} else {
+		p.RLatch()
+	}
+	return p
+}
+
+// unlatch a page of fetchLatched and unpin it
+func (t *extendibleHashTable) releaseLatched(p page.Page, write bool, dirty bool) {
+	if write {
+		p.WUnlatch()
+	} else {
+		p.RUnlatch()
+	}
+	t.bufferManager.UnpinPage(p.GetPageID(), dirty, nil)
 }
 
 /**
  * Fetches the a bucket page from the buffer pool manager using the bucket's page_id.
+ * The bucket isn't latched, the caller must hold the write latch of its directory.
  *
  * @param bucket_page_id the page_id to fetch
  * @return a pointer to a bucket page
  */
-func (t *extendibleHashTable) fetchBucketPage(bucketPageId common.PageID) *htable.HashTableBucketPage {
+func (t *extendibleHashTable) fetchBucketPage(bucketPageId common.PageID) hashBucket {
+	p := t.bufferManager.FetchPage(bucketPageId, nil)
+	common.Assert.NotNil(p, "failed to fetch a bucket page")
+	return t.asBucket(p)
 }
 
 /**
  * Performs insertion with an optional bucket splitting.
+ * Holds the write latch of the directory.
  *
  * @param transaction a pointer to the current transaction
+ * @param directoryPageId the directory of the key
  * @param key the key to insert
  * @param value the value to insert
  * @return whether or not the insertion was successful
  */
-func (t *extendibleHashTable) splitInsert(transaction common.Transaction, key []byte, value common.RID) bool {
+func (t *extendibleHashTable) splitInsert(transaction common.Transaction, directoryPageId common.PageID, key []byte, value common.RID) bool {
+	p := t.fetchLatched(directoryPageId, true)
+	defer t.releaseLatched(p, true, true)
+	dirPage := htable.PageAsDirectoryPage(p)
+
+	// a split may leave every entry on one side, so keep splitting until the key fits
+	for {
+		bucketIdx := t.keyToDirectoryIndex(key, dirPage)
+		bucketPageId := dirPage.GetBucketPageId(bucketIdx)
+		bucket := t.fetchBucketPage(bucketPageId)
+		if inserted, full := bucket.insert(key, value); !full {
+			t.bufferManager.UnpinPage(bucketPageId, inserted, nil)
+			return inserted
+		}
+
+		localDepth := uint32(dirPage.GetLocalDepth(bucketIdx))
+		if localDepth == dirPage.GetGlobalDepth() {
+			if dirPage.GetGlobalDepth() == dirPage.GetMaxDepth() {
+				// the directory can't grow any more
+				t.bufferManager.UnpinPage(bucketPageId, false, nil)
+				return false
+			}
+			dirPage.IncrGlobalDepth()
+		}
+
+		var imagePageId common.PageID
+		imagePage := t.bufferManager.NewPage(&imagePageId, nil)
+		if imagePage == nil {
+			t.bufferManager.UnpinPage(bucketPageId, false, nil)
+			return false
+		}
+		image := t.asBucket(imagePage)
+
+		// every directory slot of the old bucket with the new high bit set now points to the image
+		highBit := uint32(1) << localDepth
+		for idx := uint32(0); idx < dirPage.Size(); idx++ {
+			if dirPage.GetBucketPageId(idx) != bucketPageId {
+				continue
+			}
+			dirPage.IncrLocalDepth(idx)
+			if idx&highBit != 0 {
+				dirPage.SetBucketPageId(idx, imagePageId)
+			}
+		}
+
+		// move the entries that belong to the image
+		bucket.moveEntries(image, func(hash uint32) bool {
+			return hash&highBit != 0
+		})
+
+		t.bufferManager.UnpinPage(imagePageId, true, nil)
+		t.bufferManager.UnpinPage(bucketPageId, true, nil)
+	}
 }
 
 /**
  * Optionally merges an empty bucket into it's pair.  This is called by Remove,
  * if Remove makes a bucket empty.
+ * Holds the write latch of the directory.
  *
  * There are three conditions under which we skip the merge:
  * 1. The bucket is no longer empty.
@@ -197,8 +586,200 @@ func (t *extendibleHashTable) splitInsert(transaction common.Transaction, key []
  * 3. The bucket's local depth doesn't match its split image's local depth.
  *
  * @param transaction a pointer to the current transaction
+ * @param directoryPageId the directory of the key
  * @param key the key that was removed
  * @param value the value that was removed
  */
-func (t *extendibleHashTable) merge(transaction common.Transaction, key []byte, value common.RID) {
+func (t *extendibleHashTable) merge(transaction common.Transaction, directoryPageId common.PageID, key []byte, value common.RID) {
+	p := t.fetchLatched(directoryPageId, true)
+	defer t.releaseLatched(p, true, true)
+	dirPage := htable.PageAsDirectoryPage(p)
+
+	// the merged bucket may be empty too, keep merging it with its own split image
+	for t.mergeOnce(dirPage, t.keyToDirectoryIndex(key, dirPage)) {
+	}
+
+	for dirPage.CanShrink() {
+		dirPage.DecrGlobalDepth()
+	}
+}
+
+// merge the bucket at bucketIdx and its split image if one of them is empty, return whether they were merged
+func (t *extendibleHashTable) mergeOnce(dirPage *htable.HashTableDirectoryPage, bucketIdx uint32) bool {
+	bucketPageId := dirPage.GetBucketPageId(bucketIdx)
+	localDepth := dirPage.GetLocalDepth(bucketIdx)
+	if localDepth == 0 {
+		return false
+	}
+	imageIdx := dirPage.GetSplitImageIndex(bucketIdx)
+	if dirPage.GetLocalDepth(imageIdx) != localDepth {
+		return false
+	}
+
+	imagePageId := dirPage.GetBucketPageId(imageIdx)
+	if !t.isEmptyBucket(bucketPageId) {
+		// the split image may have been left empty by an earlier remove
+		if !t.isEmptyBucket(imagePageId) {
+			return false
+		}
+		bucketPageId, imagePageId = imagePageId, bucketPageId
+	}
+
+	for idx := uint32(0); idx < dirPage.Size(); idx++ {
+		pid := dirPage.GetBucketPageId(idx)
+		if pid == bucketPageId || pid == imagePageId {
+			dirPage.SetBucketPageId(idx, imagePageId)
+			dirPage.DecrLocalDepth(idx)
+		}
+	}
+	t.bufferManager.DeletePage(bucketPageId, nil)
+	return true
+}
+
+func (t *extendibleHashTable) isEmptyBucket(bucketPageId common.PageID) bool {
+	empty := t.fetchBucketPage(bucketPageId).isEmpty()
+	t.bufferManager.UnpinPage(bucketPageId, false, nil)
+	return empty
+}
+
+/*****************************************************************************
+ * BULK LOAD
+ *****************************************************************************/
+
+type hashEntry struct {
+	key   []byte
+	value common.RID
+	hash  uint32
+}
+
+// the entries of a bucket of a bulk load, their hashes end with the localDepth low bits of bucketIdx
+type bucketPart struct {
+	bucketIdx  uint32
+	localDepth uint32
+	entries    []hashEntry
+}
+
+var errBulkLoadPoolFull = errors.New("the buffer pool is full")
+
+/**
+ * Fill an empty table with entries. The entries are spread over the
+ * directories by the header, then the entries of a directory are split on the
+ * low bits of their hashes until every part fits in a bucket, which gives the
+ * buckets and local depths inserts would have split their way to. Each page is
+ * written once. Holds the write latch of the header.
+ *
+ * @param entries entries with normalized keys and distinct values
+ * @return an error if the table isn't empty, a directory can't hold its
+ * entries or the buffer pool is full, the table is then partly loaded
+ */
+func (t *extendibleHashTable) bulkLoad(entries []hashEntry) error {
+	headerPage := t.fetchLatched(t.headerPageId, true)
+	header := htable.PageAsHeaderPage(headerPage)
+	dirty := false
+	defer func() {
+		t.releaseLatched(headerPage, true, dirty)
+	}()
+	for directoryIdx := uint32(0); directoryIdx < header.MaxSize(); directoryIdx++ {
+		if header.GetDirectoryPageId(directoryIdx) != common.InvalidPageID {
+			return errors.New("can't bulk load a hash table that isn't empty")
+		}
+	}
+
+	groups := make([][]hashEntry, header.MaxSize())
+	for _, e := range entries {
+		e.hash = t.hash(e.key)
+		directoryIdx := header.HashToDirectoryIndex(e.hash)
+		groups[directoryIdx] = append(groups[directoryIdx], e)
+	}
+	for directoryIdx, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		directoryPageId, err := t.loadDirectory(group, header.GetDirectoryMaxDepth())
+		if err != nil {
+			return err
+		}
+		header.SetDirectoryPageId(uint32(directoryIdx), directoryPageId)
+		dirty = true
+	}
+	return nil
+}
+
+// write a directory holding entries and its buckets
+func (t *extendibleHashTable) loadDirectory(entries []hashEntry, maxDepth uint32) (common.PageID, error) {
+	var parts []bucketPart
+	globalDepth := uint32(0)
+	var split func(part bucketPart) error
+	split = func(part bucketPart) error {
+		if t.fitsInBucket(part.entries) {
+			parts = append(parts, part)
+			if part.localDepth > globalDepth {
+				globalDepth = part.localDepth
+			}
+			return nil
+		}
+		if part.localDepth == maxDepth {
+			return fmt.Errorf("too many entries share the low %d bits of their hashes", maxDepth)
+		}
+		highBit := uint32(1) << part.localDepth
+		low := bucketPart{bucketIdx: part.bucketIdx, localDepth: part.localDepth + 1}
+		high := bucketPart{bucketIdx: part.bucketIdx | highBit, localDepth: part.localDepth + 1}
+		for _, e := range part.entries {
+			if e.hash&highBit != 0 {
+				high.entries = append(high.entries, e)
+			} else {
+				low.entries = append(low.entries, e)
+			}
+		}
+		if err := split(low); err != nil {
+			return err
+		}
+		return split(high)
+	}
+	if err := split(bucketPart{entries: entries}); err != nil {
+		return common.InvalidPageID, err
+	}
+
+	var directoryPageId common.PageID
+	dirPage := t.bufferManager.NewPage(&directoryPageId, nil)
+	if dirPage == nil {
+		return common.InvalidPageID, errBulkLoadPoolFull
+	}
+	dir := htable.PageAsDirectoryPage(dirPage)
+	dir.SetPageId(directoryPageId)
+	dir.SetMaxDepth(maxDepth)
+	for dir.GetGlobalDepth() < globalDepth {
+		dir.IncrGlobalDepth()
+	}
+	for _, part := range parts {
+		bucketPageId := t.loadBucket(part.entries)
+		if bucketPageId == common.InvalidPageID {
+			t.bufferManager.UnpinPage(directoryPageId, false, nil)
+			t.bufferManager.DeletePage(directoryPageId, nil)
+			return common.InvalidPageID, errBulkLoadPoolFull
+		}
+		// the slots ending with the bits of the part point to its bucket
+		for idx := part.bucketIdx; idx < dir.Size(); idx += 1 << part.localDepth {
+			dir.SetBucketPageId(idx, bucketPageId)
+			dir.SetLocalDepth(idx, uint8(part.localDepth))
+		}
+	}
+	t.bufferManager.UnpinPage(directoryPageId, true, nil)
+	return directoryPageId, nil
+}
+
+// keys are stored with a fixed size, shorter keys are zero padded, unless the keys have variable lengths
+func (t *extendibleHashTable) normalizeKey(key []byte) ([]byte, bool) {
+	if t.keySize == 0 {
+		return key, true
+	}
+	if uint32(len(key)) > t.keySize {
+		return nil, false
+	}
+	if uint32(len(key)) == t.keySize {
+		return key, true
+	}
+	padded := make([]byte, t.keySize)
+	copy(padded, key)
+	return padded, true
 }
@@ -0,0 +1,429 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/go-kit/kit/log/level"
+	"github.com/stretchr/testify/assert"
+	"goostub/buffer"
+	"goostub/common"
+	"goostub/hash"
+	"goostub/schema"
+	"goostub/storage/disk"
+	"goostub/storage/page/htable"
+	"goostub/storage/table"
+	"goostub/types"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func init() {
+	common.InitLogger(level.AllowNone())
+}
+
+func newTestBPM(t *testing.T, poolSize uint32) *buffer.BufferPoolManager {
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	return buffer.NewBufferPoolManager(poolSize, dm, nil)
+}
+
+func intKey(i int) []byte {
+	key := make([]byte, 8)
+	binary.LittleEndian.PutUint64(key, uint64(i))
+	return key
+}
+
+func TestExtendibleHashTableSplitMerge(t *testing.T) {
+	a := assert.New(t)
+	// a header of depth 0 has a single directory
+	ht := newExtendibleHashTable(newTestBPM(t, 10), 8, hash.HashSpec{}, 0, htable.DirectoryMaxDepth)
+	a.NotNil(ht)
+	a.Nil(newExtendibleHashTable(newTestBPM(t, 10), 8, hash.HashSpec{}, htable.HeaderMaxDepth+1, htable.DirectoryMaxDepth))
+
+	// 496 entries of 8+8 bytes fit in a bucket, so this takes several splits
+	const n = 5000
+	for i := 0; i < n; i++ {
+		a.True(ht.insert(nil, intKey(i), common.NewRID(common.PageID(i), uint32(i))))
+	}
+	// duplicate pairs are rejected, another value for the same key isn't
+	a.False(ht.insert(nil, intKey(7), common.NewRID(7, 7)))
+	a.True(ht.insert(nil, intKey(7), common.NewRID(7, 8)))
+	a.Greater(ht.getGlobalDepth(), uint32(3))
+	ht.verifyIntegrity()
+
+	for i := 0; i < n; i++ {
+		var result []common.RID
+		a.True(ht.getValue(nil, intKey(i), &result))
+		if i == 7 {
+			a.Len(result, 2)
+		} else {
+			a.Equal([]common.RID{common.NewRID(common.PageID(i), uint32(i))}, result)
+		}
+	}
+	var result []common.RID
+	a.False(ht.getValue(nil, intKey(n), &result))
+
+	// shorter keys are zero padded, longer ones are rejected
+	a.True(ht.getValue(nil, []byte{1}, &result))
+	a.False(ht.insert(nil, make([]byte, 9), common.NewRID(0, 0)))
+
+	a.True(ht.remove(nil, intKey(7), common.NewRID(7, 8)))
+	a.False(ht.remove(nil, intKey(7), common.NewRID(7, 8)))
+	for i := 0; i < n; i++ {
+		a.True(ht.remove(nil, intKey(i), common.NewRID(common.PageID(i), uint32(i))))
+	}
+	ht.verifyIntegrity()
+	// buckets were merged as they became empty
+	a.Less(ht.getGlobalDepth(), uint32(3))
+	a.False(ht.getValue(nil, intKey(0), &result))
+}
+
+func TestExtendibleHashTableDirectories(t *testing.T) {
+	a := assert.New(t)
+	// 4 directories of at most 2 buckets
+	ht := newExtendibleHashTable(newTestBPM(t, 10), 8, hash.HashSpec{}, 2, 1)
+	a.NotNil(ht)
+	var result []common.RID
+	a.False(ht.getValue(nil, intKey(0), &result))
+	a.False(ht.remove(nil, intKey(0), common.NewRID(0, 0)))
+
+	// inserts fail once the directory of a key is full, the other directories still take keys
+	const n = 5000
+	inserted, count := make(map[int]bool), 0
+	for i := 0; i < n; i++ {
+		inserted[i] = ht.insert(nil, intKey(i), common.NewRID(common.PageID(i), 0))
+		if inserted[i] {
+			count++
+		}
+	}
+	a.Equal(uint32(1), ht.getGlobalDepth())
+	a.Greater(count, 8*496/2)
+	a.Less(count, n)
+	ht.verifyIntegrity()
+	for i := 0; i < n; i++ {
+		var result []common.RID
+		a.Equal(inserted[i], ht.getValue(nil, intKey(i), &result))
+	}
+
+	for i := 0; i < n; i++ {
+		a.Equal(inserted[i], ht.remove(nil, intKey(i), common.NewRID(common.PageID(i), 0)))
+	}
+	ht.verifyIntegrity()
+	a.Equal(uint32(0), ht.getGlobalDepth())
+}
+
+func TestExtendibleHashTableVarKeys(t *testing.T) {
+	a := assert.New(t)
+	ht := newExtendibleHashTable(newTestBPM(t, 20), 0, hash.HashSpec{}, 0, htable.DirectoryMaxDepth)
+	a.NotNil(ht)
+
+	// short keys of different lengths, and keys too long for a bucket, one of them over several overflow pages
+	var keys [][]byte
+	for i := 0; i < 3000; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("user%d@example.com", i)))
+	}
+	for _, n := range []int{htable.MaxInlineKeySize + 1, 1000, 3 * common.PageSize} {
+		keys = append(keys, bytes.Repeat([]byte{byte(n)}, n))
+	}
+	for i, k := range keys {
+		a.True(ht.insert(nil, k, common.NewRID(common.PageID(i), 0)))
+	}
+	a.False(ht.insert(nil, keys[len(keys)-1], common.NewRID(common.PageID(len(keys)-1), 0)))
+	a.True(ht.insert(nil, keys[len(keys)-1], common.NewRID(0, 1)))
+	a.Greater(ht.getGlobalDepth(), uint32(2))
+	ht.verifyIntegrity()
+
+	for i, k := range keys {
+		var result []common.RID
+		a.True(ht.getValue(nil, k, &result))
+		a.Contains(result, common.NewRID(common.PageID(i), 0))
+	}
+	var result []common.RID
+	// a prefix of a key, or a long key of the same length, is another key
+	a.False(ht.getValue(nil, keys[0][:5], &result))
+	a.False(ht.getValue(nil, bytes.Repeat([]byte{0}, 1000), &result))
+
+	a.True(ht.remove(nil, keys[len(keys)-1], common.NewRID(0, 1)))
+	for i, k := range keys {
+		a.True(ht.remove(nil, k, common.NewRID(common.PageID(i), 0)))
+	}
+	ht.verifyIntegrity()
+	a.Less(ht.getGlobalDepth(), uint32(2))
+	a.False(ht.getValue(nil, keys[len(keys)-1], &result))
+}
+
+func TestHashIndexVarchar(t *testing.T) {
+	a := assert.New(t)
+	s, err := schema.ParseSchema("email:VARCHAR, id:INTEGER")
+	a.Nil(err)
+	meta := NewIndexMetadata("idx_email", "users", []uint32{0}, s)
+	key := func(email string) *table.Tuple {
+		return table.NewTuple([]*types.Value{types.NewValue(types.VARCHAR, email)}, meta.GetKeySchema())
+	}
+
+	varIdx := NewIndex[*ExtendibleHashTableIndex](meta, newTestBPM(t, 20), uintptr(0))
+	a.NotNil(varIdx)
+	// the same keys in single directory tables, padded to 128 bytes or as they are
+	padded := newExtendibleHashTable(newTestBPM(t, 20), 128, hash.HashSpec{}, 0, htable.DirectoryMaxDepth)
+	unpadded := newExtendibleHashTable(newTestBPM(t, 20), 0, hash.HashSpec{}, 0, htable.DirectoryMaxDepth)
+	for i := 0; i < 3000; i++ {
+		k := key(fmt.Sprintf("someone.%d@example.com", i))
+		a.Nil(varIdx.InsertEntry(k, common.NewRID(common.PageID(i), 0), nil))
+		a.True(padded.insert(nil, k.GetData(), common.NewRID(common.PageID(i), 0)))
+		a.True(unpadded.insert(nil, k.GetData(), common.NewRID(common.PageID(i), 0)))
+	}
+	for i := 0; i < 3000; i += 7 {
+		var result []common.RID
+		varIdx.ScanKey(key(fmt.Sprintf("someone.%d@example.com", i)), &result, nil)
+		a.Equal([]common.RID{common.NewRID(common.PageID(i), 0)}, result)
+	}
+	// the variable-length buckets hold more keys, so the directory stays smaller
+	a.Less(unpadded.getGlobalDepth(), padded.getGlobalDepth())
+
+	// keys longer than the padded size only fit in the variable-length index
+	long := strings.Repeat("x", 200) + "@example.com"
+	a.Nil(varIdx.InsertEntry(key(long), common.NewRID(1, 1), nil))
+	var result []common.RID
+	varIdx.ScanKey(key(long), &result, nil)
+	a.Equal([]common.RID{common.NewRID(1, 1)}, result)
+}
+
+func TestExtendibleHashTableConcurrent(t *testing.T) {
+	a := assert.New(t)
+	// 2 directories, so that splits and merges of both run alongside lookups
+	ht := newExtendibleHashTable(newTestBPM(t, 64), 8, hash.HashSpec{}, 1, htable.DirectoryMaxDepth)
+	a.NotNil(ht)
+
+	// every worker inserts its own keys, removes half of them and reads all of them back,
+	// twice so that buckets are split, merged and split again
+	const workers, perWorker = 8, 2000
+	var wg sync.WaitGroup
+	errs := make(chan string, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for round := 0; round < 2; round++ {
+				for i := 0; i < perWorker; i++ {
+					k := i*workers + w
+					if !ht.insert(nil, intKey(k), common.NewRID(common.PageID(k), uint32(round))) {
+						errs <- "insert failed"
+						return
+					}
+				}
+				for i := 0; i < perWorker; i++ {
+					var result []common.RID
+					if !ht.getValue(nil, intKey(i*workers+w), &result) {
+						errs <- "lookup of an inserted key failed"
+						return
+					}
+				}
+				for i := 0; i < perWorker; i++ {
+					k := i*workers + w
+					if i%2 == round && !ht.remove(nil, intKey(k), common.NewRID(common.PageID(k), uint32(round))) {
+						errs <- "remove failed"
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		a.Fail(err)
+	}
+	ht.verifyIntegrity()
+
+	// the keys removed in the first round are left with the RID of the second one and the other way round
+	for k := 0; k < workers*perWorker; k++ {
+		var result []common.RID
+		a.True(ht.getValue(nil, intKey(k), &result))
+		a.Len(result, 1)
+	}
+}
+
+func TestExtendibleHashTableLarge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("inserts 300000 keys")
+	}
+	a := assert.New(t)
+	ht := newExtendibleHashTable(newTestBPM(t, 2048), 8, hash.HashSpec{}, htable.HeaderMaxDepth, htable.DirectoryMaxDepth)
+	a.NotNil(ht)
+
+	// more keys than the buckets of a single full directory could hold
+	const n = 300000
+	a.Greater(n, htable.DirectoryArraySize*496)
+	for i := 0; i < n; i++ {
+		if !ht.insert(nil, intKey(i), common.NewRID(common.PageID(i), 0)) {
+			a.FailNow("insert failed", "key %d", i)
+		}
+	}
+	ht.verifyIntegrity()
+	for i := 0; i < n; i += 97 {
+		var result []common.RID
+		a.True(ht.getValue(nil, intKey(i), &result))
+	}
+}
+
+func TestExtendibleHashTableBulkLoad(t *testing.T) {
+	a := assert.New(t)
+	const n = 20000
+	entries := make([]hashEntry, 0, n)
+	for i := 0; i < n; i++ {
+		// every tenth key twice
+		entries = append(entries, hashEntry{key: intKey(i), value: common.NewRID(common.PageID(i), 0)})
+		if i%10 == 0 {
+			entries = append(entries, hashEntry{key: intKey(i), value: common.NewRID(common.PageID(i), 1)})
+		}
+	}
+
+	bulk := newExtendibleHashTable(newTestBPM(t, 64), 8, hash.HashSpec{}, 2, htable.DirectoryMaxDepth)
+	inserted := newExtendibleHashTable(newTestBPM(t, 64), 8, hash.HashSpec{}, 2, htable.DirectoryMaxDepth)
+	a.Nil(bulk.bulkLoad(entries))
+	for _, e := range entries {
+		a.True(inserted.insert(nil, e.key, e.value))
+	}
+	bulk.verifyIntegrity()
+	// buckets split only when they overflow, so both tables have the same shape
+	a.Equal(inserted.getGlobalDepth(), bulk.getGlobalDepth())
+	for i := 0; i < n; i++ {
+		var result []common.RID
+		a.True(bulk.getValue(nil, intKey(i), &result))
+		if i%10 == 0 {
+			a.Len(result, 2)
+		} else {
+			a.Len(result, 1)
+		}
+	}
+	a.Error(bulk.bulkLoad(entries))
+
+	// the loaded table splits and merges as usual
+	for i := n; i < n+1000; i++ {
+		a.True(bulk.insert(nil, intKey(i), common.NewRID(common.PageID(i), 0)))
+	}
+	for i := 0; i < n; i += 2 {
+		a.True(bulk.remove(nil, intKey(i), common.NewRID(common.PageID(i), 0)))
+	}
+	bulk.verifyIntegrity()
+
+	// variable-length keys, some of them in overflow pages
+	varTable := newExtendibleHashTable(newTestBPM(t, 64), 0, hash.HashSpec{}, 0, htable.DirectoryMaxDepth)
+	var varEntries []hashEntry
+	for i := 0; i < 2000; i++ {
+		key := []byte(strings.Repeat("k", i%40) + fmt.Sprint(i))
+		if i%100 == 0 {
+			key = []byte(strings.Repeat("long", htable.MaxInlineKeySize) + fmt.Sprint(i))
+		}
+		varEntries = append(varEntries, hashEntry{key: key, value: common.NewRID(common.PageID(i), 0)})
+	}
+	a.Nil(varTable.bulkLoad(varEntries))
+	varTable.verifyIntegrity()
+	a.Greater(varTable.getGlobalDepth(), uint32(0))
+	for _, e := range varEntries {
+		var result []common.RID
+		a.True(varTable.getValue(nil, e.key, &result))
+		a.Equal([]common.RID{e.value}, result)
+		a.True(varTable.remove(nil, e.key, e.value))
+	}
+}
+
+func TestIndexScanEntries(t *testing.T) {
+	a := assert.New(t)
+	s, err := schema.ParseSchema("name:VARCHAR")
+	a.Nil(err)
+	meta := NewIndexMetadata("idx", "t", []uint32{0}, s)
+	key := func(i int) *table.Tuple {
+		name := fmt.Sprint("name", i)
+		if i%50 == 0 {
+			name = strings.Repeat("long", htable.MaxInlineKeySize) + name
+		}
+		return table.NewTuple([]*types.Value{types.NewValue(types.VARCHAR, name)}, s)
+	}
+	for name, idx := range map[string]Index{
+		"hash":     NewIndex[*ExtendibleHashTableIndex](meta, newTestBPM(t, 20), uintptr(64)),
+		"var hash": NewIndex[*ExtendibleHashTableIndex](meta, newTestBPM(t, 20), uintptr(0)),
+		"btree":    NewIndex[*BPlusTreeIndex](meta, newTestBPM(t, 20), uintptr(64)),
+	} {
+		expected := make(map[common.RID]string)
+		for i := 0; i < 2000; i++ {
+			k := key(i)
+			stored, ok := idx.(EntryScanner).StoredKey(k)
+			if !ok {
+				// too long for the fixed keys
+				continue
+			}
+			a.Nil(idx.InsertEntry(k, common.NewRID(common.PageID(i), 0), nil))
+			expected[common.NewRID(common.PageID(i), 0)] = string(stored)
+		}
+		found := make(map[common.RID]string)
+		idx.(EntryScanner).ScanEntries(func(key []byte, rid common.RID) {
+			found[rid] = string(key)
+		})
+		a.Equal(expected, found, name)
+	}
+}
+
+func TestHashIndexBuiltinHashFuncs(t *testing.T) {
+	a := assert.New(t)
+	specs := []hash.HashSpec{
+		{Id: hash.XXHash},
+		{Id: hash.FNV1a},
+		{Id: hash.CRC32C},
+		{Id: hash.SeededXXHash, Seed: 1},
+		{Id: hash.SeededXXHash, Seed: 2},
+	}
+	seen := make(map[uint64]hash.HashSpec)
+	for _, spec := range specs {
+		f := spec.Func()
+		a.NotNil(f, spec.String())
+		a.Equal(f([]byte("goostub")), spec.Func()([]byte("goostub")))
+		h := f([]byte("goostub"))
+		_, dup := seen[h]
+		a.False(dup, spec.String())
+		seen[h] = spec
+	}
+	a.Nil(hash.HashSpec{Id: 100}.Func())
+	id, err := hash.ParseHashFuncId("crc32c")
+	a.Nil(err)
+	a.Equal(hash.CRC32C, id)
+	_, err = hash.ParseHashFuncId("md5")
+	a.Error(err)
+
+	s, err := schema.ParseSchema("id:INTEGER")
+	a.Nil(err)
+	key := func(i int) *table.Tuple {
+		return table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, int32(i))}, s)
+	}
+	meta := NewIndexMetadata("idx", "t", []uint32{0}, s)
+	meta.SetHashSpec(hash.HashSpec{Id: hash.SeededXXHash, Seed: 7})
+	bpm := newTestBPM(t, 10)
+	idx := NewIndex[*ExtendibleHashTableIndex](meta, bpm, uintptr(8))
+	a.NotNil(idx)
+	for i := 0; i < 2000; i++ {
+		a.Nil(idx.InsertEntry(key(i), common.NewRID(common.PageID(i), 0), nil))
+	}
+	bpm.FlushAllPages(nil)
+
+	// the reopened index hashes with the function it was created with, whatever the metadata says
+	reopenedMeta := NewIndexMetadata("idx", "t", []uint32{0}, s)
+	reopened := NewIndex[*ExtendibleHashTableIndex](reopenedMeta, bpm, uintptr(8), idx.(*ExtendibleHashTableIndex).GetHeaderPageId())
+	a.NotNil(reopened)
+	a.Equal(hash.HashSpec{Id: hash.SeededXXHash, Seed: 7}, reopenedMeta.GetHashSpec())
+	for i := 0; i < 2000; i++ {
+		var result []common.RID
+		reopened.ScanKey(key(i), &result, nil)
+		a.Equal([]common.RID{common.NewRID(common.PageID(i), 0)}, result)
+	}
+
+	meta.SetHashSpec(hash.HashSpec{Id: 100})
+	a.Nil(NewIndex[*ExtendibleHashTableIndex](meta, bpm, uintptr(8)))
+}
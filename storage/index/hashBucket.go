@@ -0,0 +1,316 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package index
+
+import (
+	"bytes"
+	"goostub/common"
+	"goostub/storage/page"
+	"goostub/storage/page/htable"
+)
+
+/**
+ * A bucket page as the extendible hash table sees it. Tables with a key size
+ * store their keys padded in fixed slots, tables of variable-length keys
+ * (key size 0) in slotted pages, with the keys too long for a bucket in
+ * overflow pages.
+ */
+type hashBucket interface {
+	getValue(key []byte, result *[]common.RID) bool
+	// insert an entry, false if it is already there or there's no room, which full tells
+	insert(key []byte, value common.RID) (inserted bool, full bool)
+	remove(key []byte, value common.RID) bool
+	isEmpty() bool
+	// move the entries whose hash pick returns true to image, an empty bucket of the same table
+	moveEntries(image hashBucket, pick func(hash uint32) bool)
+	// the hashes of the entries
+	hashes() []uint32
+	// call fn on every entry, with the whole key of the overflowed ones
+	forEach(fn func(key []byte, value common.RID))
+	// delete the overflow pages of the keys, before the bucket is deleted
+	freeOverflows()
+}
+
+// view a bucket page with the layout of the table
+func (t *extendibleHashTable) asBucket(p page.Page) hashBucket {
+	if t.keySize == 0 {
+		return &varBucket{table: t, page: htable.PageAsVarBucketPage(p)}
+	}
+	return &fixedBucket{table: t, page: htable.PageAsBucketPage(p, t.keySize)}
+}
+
+type fixedBucket struct {
+	table *extendibleHashTable
+	page  *htable.HashTableBucketPage
+}
+
+func (b *fixedBucket) getValue(key []byte, result *[]common.RID) bool {
+	return b.page.GetValue(key, result)
+}
+
+func (b *fixedBucket) insert(key []byte, value common.RID) (bool, bool) {
+	if b.page.IsFull() {
+		return false, true
+	}
+	return b.page.Insert(key, value), false
+}
+
+func (b *fixedBucket) remove(key []byte, value common.RID) bool {
+	return b.page.Remove(key, value)
+}
+
+func (b *fixedBucket) isEmpty() bool {
+	return b.page.IsEmpty()
+}
+
+func (b *fixedBucket) moveEntries(image hashBucket, pick func(hash uint32) bool) {
+	dst := image.(*fixedBucket).page
+	for idx := uint32(0); idx < b.page.BucketArraySize(); idx++ {
+		if !b.page.IsOccupied(idx) {
+			break
+		}
+		if !b.page.IsReadable(idx) {
+			continue
+		}
+		if k := b.page.KeyAt(idx); pick(b.table.hash(k)) {
+			dst.Insert(k, b.page.ValueAt(idx))
+			b.page.RemoveAt(idx)
+		}
+	}
+}
+
+func (b *fixedBucket) hashes() []uint32 {
+	var hashes []uint32
+	for idx := uint32(0); idx < b.page.BucketArraySize() && b.page.IsOccupied(idx); idx++ {
+		if b.page.IsReadable(idx) {
+			hashes = append(hashes, b.table.hash(b.page.KeyAt(idx)))
+		}
+	}
+	return hashes
+}
+
+func (b *fixedBucket) forEach(fn func(key []byte, value common.RID)) {
+	for idx := uint32(0); idx < b.page.BucketArraySize() && b.page.IsOccupied(idx); idx++ {
+		if b.page.IsReadable(idx) {
+			fn(b.page.KeyAt(idx), b.page.ValueAt(idx))
+		}
+	}
+}
+
+// fixed slots never overflow
+func (b *fixedBucket) freeOverflows() {}
+
+type varBucket struct {
+	table *extendibleHashTable
+	page  *htable.HashTableVarBucketPage
+}
+
+// whether the entry at slotIdx has the key, whose hash is h
+func (b *varBucket) matches(slotIdx uint32, key []byte, h uint32) bool {
+	if b.page.HashAt(slotIdx) != h {
+		return false
+	}
+	if !b.page.IsOverflowAt(slotIdx) {
+		return bytes.Equal(b.page.KeyAt(slotIdx), key)
+	}
+	first, keyLen := htable.ParseOverflowRef(b.page.KeyAt(slotIdx))
+	return keyLen == uint32(len(key)) && bytes.Equal(b.table.readOverflow(first, keyLen), key)
+}
+
+func (b *varBucket) getValue(key []byte, result *[]common.RID) bool {
+	h := b.table.hash(key)
+	found := false
+	for idx := uint32(0); idx < b.page.NumSlots(); idx++ {
+		if b.matches(idx, key, h) {
+			*result = append(*result, b.page.ValueAt(idx))
+			found = true
+		}
+	}
+	return found
+}
+
+func (b *varBucket) insert(key []byte, value common.RID) (bool, bool) {
+	h := b.table.hash(key)
+	for idx := uint32(0); idx < b.page.NumSlots(); idx++ {
+		if b.page.ValueAt(idx).IsEqual(value) && b.matches(idx, key, h) {
+			return false, false
+		}
+	}
+
+	if len(key) <= htable.MaxInlineKeySize {
+		if !b.page.Fits(uint32(len(key))) {
+			return false, true
+		}
+		return b.page.Insert(key, false, h, value), false
+	}
+	if !b.page.Fits(htable.SizeOverflowRef) {
+		return false, true
+	}
+	first := b.table.writeOverflow(key)
+	if first == common.InvalidPageID {
+		return false, false
+	}
+	return b.page.Insert(htable.OverflowRef(first, uint32(len(key))), true, h, value), false
+}
+
+func (b *varBucket) remove(key []byte, value common.RID) bool {
+	h := b.table.hash(key)
+	for idx := uint32(0); idx < b.page.NumSlots(); idx++ {
+		if !b.page.ValueAt(idx).IsEqual(value) || !b.matches(idx, key, h) {
+			continue
+		}
+		if b.page.IsOverflowAt(idx) {
+			first, _ := htable.ParseOverflowRef(b.page.KeyAt(idx))
+			b.table.freeOverflow(first)
+		}
+		b.page.RemoveAt(idx)
+		return true
+	}
+	return false
+}
+
+func (b *varBucket) isEmpty() bool {
+	return b.page.IsEmpty()
+}
+
+// overflowed keys keep their overflow pages, only the reference moves
+func (b *varBucket) moveEntries(image hashBucket, pick func(hash uint32) bool) {
+	dst := image.(*varBucket).page
+	for idx := uint32(0); idx < b.page.NumSlots(); {
+		if !pick(b.page.HashAt(idx)) {
+			idx++
+			continue
+		}
+		dst.Insert(b.page.KeyAt(idx), b.page.IsOverflowAt(idx), b.page.HashAt(idx), b.page.ValueAt(idx))
+		// the last entry moves into the slot, look at it next
+		b.page.RemoveAt(idx)
+	}
+}
+
+func (b *varBucket) hashes() []uint32 {
+	hashes := make([]uint32, b.page.NumSlots())
+	for idx := range hashes {
+		hashes[idx] = b.page.HashAt(uint32(idx))
+	}
+	return hashes
+}
+
+func (b *varBucket) forEach(fn func(key []byte, value common.RID)) {
+	for idx := uint32(0); idx < b.page.NumSlots(); idx++ {
+		key := b.page.KeyAt(idx)
+		if b.page.IsOverflowAt(idx) {
+			key = b.table.readOverflow(htable.ParseOverflowRef(key))
+		}
+		fn(key, b.page.ValueAt(idx))
+	}
+}
+
+func (b *varBucket) freeOverflows() {
+	for idx := uint32(0); idx < b.page.NumSlots(); idx++ {
+		if b.page.IsOverflowAt(idx) {
+			first, _ := htable.ParseOverflowRef(b.page.KeyAt(idx))
+			b.table.freeOverflow(first)
+		}
+	}
+}
+
+// whether the entries of a bulk load fit in one bucket page
+func (t *extendibleHashTable) fitsInBucket(entries []hashEntry) bool {
+	if t.keySize != 0 {
+		return uint32(len(entries)) <= htable.BucketArraySize(t.keySize)
+	}
+	size := uint32(htable.SizeVarBucketHeader)
+	for _, e := range entries {
+		size += htable.SizeVarBucketSlot
+		if len(e.key) <= htable.MaxInlineKeySize {
+			size += uint32(len(e.key))
+		} else {
+			size += htable.SizeOverflowRef
+		}
+	}
+	return size <= common.PageSize
+}
+
+// write a bucket page holding entries that fit in it, common.InvalidPageID if the buffer pool is full
+func (t *extendibleHashTable) loadBucket(entries []hashEntry) common.PageID {
+	var bucketPageId common.PageID
+	p := t.bufferManager.NewPage(&bucketPageId, nil)
+	if p == nil {
+		return common.InvalidPageID
+	}
+	if t.keySize != 0 {
+		bucket := htable.PageAsBucketPage(p, t.keySize)
+		for idx, e := range entries {
+			bucket.InsertAt(uint32(idx), e.key, e.value)
+		}
+		t.bufferManager.UnpinPage(bucketPageId, true, nil)
+		return bucketPageId
+	}
+
+	bucket := htable.PageAsVarBucketPage(p)
+	for _, e := range entries {
+		if len(e.key) <= htable.MaxInlineKeySize {
+			bucket.Insert(e.key, false, e.hash, e.value)
+			continue
+		}
+		first := t.writeOverflow(e.key)
+		if first == common.InvalidPageID {
+			t.bufferManager.UnpinPage(bucketPageId, true, nil)
+			return common.InvalidPageID
+		}
+		bucket.Insert(htable.OverflowRef(first, uint32(len(e.key))), true, e.hash, e.value)
+	}
+	t.bufferManager.UnpinPage(bucketPageId, true, nil)
+	return bucketPageId
+}
+
+// write a key too long for a bucket into a chain of overflow pages, common.InvalidPageID if the buffer pool is full
+func (t *extendibleHashTable) writeOverflow(key []byte) common.PageID {
+	// the chain is written from its end, so that each page knows the next one
+	next := common.PageID(common.InvalidPageID)
+	for end := len(key); end > 0; {
+		start := (end - 1) / htable.OverflowCapacity * htable.OverflowCapacity
+		var pid common.PageID
+		p := t.bufferManager.NewPage(&pid, nil)
+		if p == nil {
+			t.freeOverflow(next)
+			return common.InvalidPageID
+		}
+		overflow := htable.PageAsOverflowPage(p)
+		overflow.SetNextPageId(next)
+		overflow.SetKeyBytes(key[start:end])
+		t.bufferManager.UnpinPage(pid, true, nil)
+		next, end = pid, start
+	}
+	return next
+}
+
+// read a key from its overflow pages
+func (t *extendibleHashTable) readOverflow(first common.PageID, keyLen uint32) []byte {
+	key := make([]byte, 0, keyLen)
+	for pid := first; pid != common.InvalidPageID; {
+		p := t.bufferManager.FetchPage(pid, nil)
+		common.Assert.NotNil(p, "failed to fetch an overflow page")
+		overflow := htable.PageAsOverflowPage(p)
+		key = append(key, overflow.GetKeyBytes()...)
+		next := overflow.GetNextPageId()
+		t.bufferManager.UnpinPage(pid, false, nil)
+		pid = next
+	}
+	return key
+}
+
+// delete the overflow pages of a key
+func (t *extendibleHashTable) freeOverflow(first common.PageID) {
+	for pid := first; pid != common.InvalidPageID; {
+		p := t.bufferManager.FetchPage(pid, nil)
+		common.Assert.NotNil(p, "failed to fetch an overflow page")
+		next := htable.PageAsOverflowPage(p).GetNextPageId()
+		t.bufferManager.UnpinPage(pid, false, nil)
+		t.bufferManager.DeletePage(pid, nil)
+		pid = next
+	}
+}
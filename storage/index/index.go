@@ -8,17 +8,39 @@ package index
 import (
 	"goostub/buffer"
 	"goostub/common"
+	"goostub/hash"
 	"goostub/schema"
 	"goostub/storage/table"
+	"sync"
 )
 
 type IndexMetadata struct {
 	// strings in Go are immutable
 	// so there's no need for making them private and use getter
-	Name      string         // index name
-	TableName string         // table name
-	keyAttrs  []uint32       // mapping relation between index and table schema
-	keySchema *schema.Schema // schema of index key
+	Name       string         // index name
+	TableName  string         // table name
+	keyAttrs   []uint32       // mapping relation between index and table schema
+	keySchema  *schema.Schema // schema of index key
+	keyOrders  []KeyOrder     // order of each key column in an ordered index, nil = all ASC NULLS FIRST
+	constraint IndexConstraint
+	live       LivenessCheck // which RIDs count for the constraint, nil = all of them
+	hashSpec   hash.HashSpec // hash function of a hash index
+	fillFactor float64       // how full a bulk build leaves the pages of an ordered index, 0 = DefaultFillFactor
+	fpRate     float64       // false positive rate a Bloom filter is sized for, 0 = DefaultFalsePositiveRate
+	kind       string        // the kind of the index, set when the index is created
+}
+
+// fill factor of the pages of an ordered index built in bulk, leaves room for a few inserts as in PostgreSQL
+const DefaultFillFactor = 0.9
+
+// false positive rate of a Bloom filter, about 10 bits per key
+const DefaultFalsePositiveRate = 0.01
+
+/** How an ordered index sorts a key column. The zero value is ASC NULLS FIRST. */
+type KeyOrder struct {
+	Descending bool
+	// NULL sorts after the other values, whatever the direction
+	NullsLast bool
 }
 
 func NewIndexMetadata(name string, tableName string, keyAttrs []uint32, s *schema.Schema) *IndexMetadata {
@@ -35,6 +57,76 @@ func (im *IndexMetadata) GetKeyAttrs() []uint32 {
 	return im.keyAttrs
 }
 
+/**
+ * A column of the table was dropped, key attributes after it move one column left.
+ * @param colIdx index of the dropped column, it must not be a key column
+ */
+func (im *IndexMetadata) DropColumn(colIdx uint32) {
+	for i, attr := range im.keyAttrs {
+		if attr > colIdx {
+			im.keyAttrs[i] = attr - 1
+		}
+	}
+}
+
+/**
+ * Set the order of the key columns, it must be set before the index is created.
+ * @param orders one per key column
+ */
+func (im *IndexMetadata) SetKeyOrders(orders []KeyOrder) {
+	common.Assert.Len(orders, len(im.keyAttrs), "one order per key column")
+	im.keyOrders = orders
+}
+
+/** @return the order of the key column at index i */
+func (im *IndexMetadata) GetKeyOrder(i int) KeyOrder {
+	if im.keyOrders == nil {
+		return KeyOrder{}
+	}
+	return im.keyOrders[i]
+}
+
+/** Pick the hash function of a hash index, it must be set before the index is created. */
+func (im *IndexMetadata) SetHashSpec(spec hash.HashSpec) {
+	im.hashSpec = spec
+}
+
+func (im *IndexMetadata) GetHashSpec() hash.HashSpec {
+	return im.hashSpec
+}
+
+/**
+ * Set how full a bulk build of an ordered index leaves its pages, it must be set before the index is built.
+ * @param fillFactor the fraction of each page to fill, in (0, 1]
+ */
+func (im *IndexMetadata) SetFillFactor(fillFactor float64) {
+	common.Assert.True(fillFactor > 0 && fillFactor <= 1, "the fill factor must be in (0, 1]")
+	im.fillFactor = fillFactor
+}
+
+func (im *IndexMetadata) GetFillFactor() float64 {
+	if im.fillFactor == 0 {
+		return DefaultFillFactor
+	}
+	return im.fillFactor
+}
+
+/**
+ * Set the false positive rate a Bloom filter is sized for, it must be set before the filter is created.
+ * @param rate the fraction of absent keys the filter may report as present, in (0, 1)
+ */
+func (im *IndexMetadata) SetFalsePositiveRate(rate float64) {
+	common.Assert.True(rate > 0 && rate < 1, "the false positive rate must be in (0, 1)")
+	im.fpRate = rate
+}
+
+func (im *IndexMetadata) GetFalsePositiveRate() float64 {
+	if im.fpRate == 0 {
+		return DefaultFalsePositiveRate
+	}
+	return im.fpRate
+}
+
 func (im *IndexMetadata) GetKeySchema() *schema.Schema {
 	return im.keySchema
 }
@@ -44,7 +136,11 @@ func (im *IndexMetadata) GetIndexColumnCount() uint32 {
 }
 
 func (im IndexMetadata) String() string {
-	return "IndexMetadata[Name = " + im.Name + ", Type = B+Tree, Table name = " + im.TableName + "] :: " + im.keySchema.String()
+	kind := im.kind
+	if kind == "" {
+		kind = "none"
+	}
+	return "IndexMetadata[Name = " + im.Name + ", Type = " + kind + ", Table name = " + im.TableName + "] :: " + im.keySchema.String()
 }
 
 type Index interface {
@@ -58,10 +154,11 @@ type Index interface {
 	/**
 	 * Insert an entry into the index.
 	 * @param key The index key
-	 * @param rid The RID associated with the key (unused)
+	 * @param rid The RID associated with the key
 	 * @param transaction The transaction context
+	 * @return A *ConstraintViolationError if the index is unique and already has the key, the transaction is then aborted
 	 */
-	InsertEntry(key *table.Tuple, rid common.RID, transaction common.Transaction)
+	InsertEntry(key *table.Tuple, rid common.RID, transaction common.Transaction) error
 
 	/**
 	 * Delete an index entry by key.
@@ -78,11 +175,106 @@ type Index interface {
 	 * @param transaction The transaction context
 	 */
 	ScanKey(key *table.Tuple, result *[]common.RID, transaction common.Transaction)
+
+	/**
+	 * Search the index for the keys in a range, in key order.
+	 * @param keyRange The bounds and the direction of the scan
+	 * @param transaction The transaction context
+	 * @return An iterator over the RIDs, or ErrRangeScanUnsupported if the index isn't ordered
+	 */
+	ScanRange(keyRange *KeyRange, transaction common.Transaction) (IndexIterator, error)
+}
+
+/** An entry of an index, as a bulk build takes them. */
+type IndexEntry struct {
+	Key *table.Tuple
+	RID common.RID
+}
+
+/**
+ * An index that can be built from all its entries at once, much faster than
+ * inserting them one by one.
+ */
+type BulkBuilder interface {
+	/**
+	 * Fill an empty index with entries. The RIDs of the entries must be distinct.
+	 * @param entries the entries, in any order
+	 * @param transaction The transaction context
+	 * @return A *ConstraintViolationError if the index is unique and two entries have the same key, the transaction is then aborted
+	 */
+	BulkBuild(entries []IndexEntry, transaction common.Transaction) error
+}
+
+/** An index that can list its entries, to check it against its table. */
+type EntryScanner interface {
+	/**
+	 * Call fn on every entry of the index, in no particular order.
+	 * @param fn gets the key as the index stores it, see StoredKey
+	 */
+	ScanEntries(fn func(key []byte, rid common.RID))
+
+	/** @return a key as the index stores it, false if the index can't store it */
+	StoredKey(key *table.Tuple) ([]byte, bool)
+}
+
+/** An index that can tell a key is absent without finding its entries, such as a Bloom filter. */
+type MembershipFilter interface {
+	/**
+	 * @param key The index key
+	 * @param transaction The transaction context
+	 * @return false if no entry has the key, true if one may have it
+	 */
+	MayContain(key *table.Tuple, transaction common.Transaction) bool
+}
+
+/**
+ * A full-text index, it finds the tuples by the words of their text. The
+ * queries are tokenized as the indexed text is, see Tokenize. The RIDs come
+ * back in RID order.
+ */
+type TextSearcher interface {
+	/** @return the tuples that have every term of the query */
+	MatchAll(query string, transaction common.Transaction) []common.RID
+
+	/** @return the tuples that have a term of the query */
+	MatchAny(query string, transaction common.Transaction) []common.RID
+
+	/** @return the tuples that have the terms of the query next to each other, in its order */
+	MatchPhrase(query string, transaction common.Transaction) []common.RID
+
+	/** @return the tokens the index keeps for a key, no entries are made for a key without any */
+	Tokens(key *table.Tuple) []Token
+}
+
+/** An index that can give its pages back to the buffer pool once it is replaced or dropped. */
+type PageFreer interface {
+	/** Delete every page of the index, it must not be used anymore. */
+	FreePages()
+}
+
+var ErrRangeScanUnsupported = common.NewError(common.NOT_IMPLEMENTED, "the index doesn't support range scans")
+
+/** The keys of a range scan. A nil bound leaves that end of the range open. */
+type KeyRange struct {
+	Low           *table.Tuple
+	LowInclusive  bool
+	High          *table.Tuple
+	HighInclusive bool
+	// go from the high bound down to the low one
+	Reverse bool
+}
+
+/** Returns the RIDs of a range scan one at a time, reading the index as it goes. */
+type IndexIterator interface {
+	/** @return the RID of the next entry, false once there are no more */
+	Next() (common.RID, bool)
 }
 
 // base struct for all indexes
 type baseIndex struct {
 	metadata *IndexMetadata
+	// serialize the inserts of the same key into a unique index, see constraint.go
+	keyLocks [keyLockCount]sync.Mutex
 }
 
 func (bi *baseIndex) GetMetadata() *IndexMetadata {
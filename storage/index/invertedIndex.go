@@ -0,0 +1,478 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package index
+
+import (
+	"errors"
+	"goostub/buffer"
+	"goostub/common"
+	"goostub/storage/page/btree"
+	"goostub/storage/page/htable"
+	"goostub/storage/page/inverted"
+	"goostub/storage/table"
+	"goostub/types"
+	"sort"
+	"sync"
+)
+
+var errInvertedIndexPoolFull = errors.New("no room in the buffer pool for the pages of the inverted index")
+
+/**
+ * A full-text index on VARCHAR columns. The text of a key is tokenized, see
+ * Tokenize, and every token makes a posting, the RID of the tuple and the
+ * position of the word, in the posting list of its term. A posting list is a
+ * chain of posting pages, a hash table maps each term to the first page of
+ * its list.
+ *
+ * The text of the key columns follows one another, with a gap so that a
+ * phrase doesn't run from a column into the next. NULL columns have no text.
+ *
+ * Writers are serialized by the index, each holds the latches of the posting
+ * pages one at a time. Readers only latch the pages, a list grows at its end
+ * and a new page is filled before it is linked, so they see every posting of
+ * the writes that are done.
+ */
+type InvertedIndex struct {
+	baseIndex
+	writeLatch sync.Mutex
+	// term -> the first page of its posting list, as the page id of the value
+	dictionary extendibleHashTable
+}
+
+func (i *InvertedIndex) InsertEntry(key *table.Tuple, rid common.RID, transaction common.Transaction) error {
+	i.writeLatch.Lock()
+	defer i.writeLatch.Unlock()
+	lists := make(map[string]common.PageID)
+	created := make(map[string]bool)
+	for _, token := range i.Tokens(key) {
+		head, ok := lists[token.Term]
+		if !ok {
+			head = i.lookup(token.Term)
+			if head == common.InvalidPageID {
+				if head = i.newList(token.Term); head == common.InvalidPageID {
+					i.undoInsert(lists, created, rid)
+					return errInvertedIndexPoolFull
+				}
+				created[token.Term] = true
+			}
+			lists[token.Term] = head
+		}
+		if !i.appendPosting(head, inverted.Posting{RID: rid, Position: token.Position}) {
+			i.undoInsert(lists, created, rid)
+			return errInvertedIndexPoolFull
+		}
+	}
+	return nil
+}
+
+// the emptied posting pages stay in their lists, and the terms in the dictionary, until the index is rebuilt
+func (i *InvertedIndex) DeleteEntry(key *table.Tuple, rid common.RID, transaction common.Transaction) {
+	i.writeLatch.Lock()
+	defer i.writeLatch.Unlock()
+	removed := make(map[string]bool)
+	for _, token := range i.Tokens(key) {
+		if removed[token.Term] {
+			continue
+		}
+		removed[token.Term] = true
+		if !i.removePostings(i.lookup(token.Term), rid) {
+			return
+		}
+	}
+}
+
+// finds the tuples that have every term of the key, nothing for a key without terms
+func (i *InvertedIndex) ScanKey(key *table.Tuple, result *[]common.RID, transaction common.Transaction) {
+	*result = append(*result, i.matchAll(i.Tokens(key))...)
+}
+
+// the postings are kept by term, not in the order of the text
+func (i *InvertedIndex) ScanRange(keyRange *KeyRange, transaction common.Transaction) (IndexIterator, error) {
+	return nil, ErrRangeScanUnsupported
+}
+
+func (i *InvertedIndex) MatchAll(query string, transaction common.Transaction) []common.RID {
+	return i.matchAll(Tokenize(query))
+}
+
+func (i *InvertedIndex) MatchAny(query string, transaction common.Transaction) []common.RID {
+	found := make(map[common.RID]bool)
+	for _, token := range Tokenize(query) {
+		for rid := range i.postings(token.Term) {
+			found[rid] = true
+		}
+	}
+	return sortedRIDs(found)
+}
+
+func (i *InvertedIndex) MatchPhrase(query string, transaction common.Transaction) []common.RID {
+	tokens := Tokenize(query)
+	if len(tokens) == 0 {
+		return nil
+	}
+	lists := make(map[string]map[common.RID][]uint32)
+	for _, token := range tokens {
+		if _, ok := lists[token.Term]; !ok {
+			lists[token.Term] = i.postings(token.Term)
+		}
+	}
+
+	found := make(map[common.RID]bool)
+	for rid, starts := range lists[tokens[0].Term] {
+		for _, start := range starts {
+			// the other words of the phrase are as far from the first one as in the query
+			match := true
+			for _, token := range tokens[1:] {
+				if !containsPosition(lists[token.Term][rid], start+token.Position-tokens[0].Position) {
+					match = false
+					break
+				}
+			}
+			if match {
+				found[rid] = true
+				break
+			}
+		}
+	}
+	return sortedRIDs(found)
+}
+
+func (i *InvertedIndex) Tokens(key *table.Tuple) []Token {
+	keySchema := i.GetKeySchema()
+	var tokens []Token
+	position := uint32(0)
+	for col := 0; col < keySchema.GetColumnCount(); col++ {
+		if key.IsNull(keySchema, col) {
+			continue
+		}
+		text, err := key.GetValue(keySchema, col).ToString()
+		if err != nil {
+			continue
+		}
+		var colTokens []Token
+		colTokens, position = tokenize(text, position)
+		tokens = append(tokens, colTokens...)
+		position++
+	}
+	return tokens
+}
+
+/**
+ * Fill the empty index from its entries, writing each posting list once.
+ */
+func (i *InvertedIndex) BulkBuild(entries []IndexEntry, transaction common.Transaction) error {
+	i.writeLatch.Lock()
+	defer i.writeLatch.Unlock()
+	var terms []string
+	lists := make(map[string][]inverted.Posting)
+	for _, e := range entries {
+		for _, token := range i.Tokens(e.Key) {
+			if _, ok := lists[token.Term]; !ok {
+				terms = append(terms, token.Term)
+			}
+			lists[token.Term] = append(lists[token.Term], inverted.Posting{RID: e.RID, Position: token.Position})
+		}
+	}
+
+	loaded := make([]hashEntry, len(terms))
+	for j, term := range terms {
+		head := i.writeList(lists[term])
+		if head == common.InvalidPageID {
+			return errInvertedIndexPoolFull
+		}
+		loaded[j] = hashEntry{key: []byte(term), value: common.NewRID(head, 0)}
+	}
+	return i.dictionary.bulkLoad(loaded)
+}
+
+// the posting lists first, the dictionary knows where they start
+func (i *InvertedIndex) FreePages() {
+	i.writeLatch.Lock()
+	defer i.writeLatch.Unlock()
+	var heads []common.PageID
+	i.dictionary.forEachEntry(func(key []byte, value common.RID) {
+		heads = append(heads, value.GetPageId())
+	})
+	for _, head := range heads {
+		i.freeList(head)
+	}
+	i.dictionary.free()
+}
+
+/** @return the header page of the dictionary, to open the index again */
+func (i *InvertedIndex) GetHeaderPageId() common.PageID {
+	return i.dictionary.headerPageId
+}
+
+/**
+ * args: optionally the header page id (common.PageID) of the dictionary of an
+ * existing index to open. The key columns must all be VARCHAR, and an inverted
+ * index can't enforce a constraint, nil is returned otherwise. The dictionary
+ * of a new index hashes with the hash function of the metadata.
+ */
+func (i *InvertedIndex) createIndex(m *IndexMetadata, bm *buffer.BufferPoolManager, args ...any) Index {
+	if m.IsUnique() {
+		return nil
+	}
+	for _, col := range m.GetKeySchema().GetColumns() {
+		if col.GetType() != types.VARCHAR {
+			return nil
+		}
+	}
+
+	var dictionary *extendibleHashTable
+	if len(args) > 0 {
+		headerPageId, ok := args[0].(common.PageID)
+		common.Assert.True(ok, "the header page id must be a common.PageID")
+		dictionary = openExtendibleHashTable(bm, headerPageId, 0)
+		if dictionary != nil {
+			m.SetHashSpec(dictionary.hashSpec)
+		}
+	} else {
+		dictionary = newExtendibleHashTable(bm, 0, m.GetHashSpec(), htable.HeaderMaxDepth, htable.DirectoryMaxDepth)
+	}
+	if dictionary == nil {
+		return nil
+	}
+	m.kind = "Inverted"
+	return &InvertedIndex{
+		baseIndex:  baseIndex{metadata: m},
+		dictionary: *dictionary,
+	}
+}
+
+// the first page of the posting list of a term, common.InvalidPageID if the term has none
+func (i *InvertedIndex) lookup(term string) common.PageID {
+	var heads []common.RID
+	i.dictionary.getValue(nil, []byte(term), &heads)
+	if len(heads) == 0 {
+		return common.InvalidPageID
+	}
+	return heads[0].GetPageId()
+}
+
+// start the empty posting list of a term, common.InvalidPageID if the buffer pool is full
+func (i *InvertedIndex) newList(term string) common.PageID {
+	bm := i.dictionary.bufferManager
+	var head common.PageID
+	p := bm.NewPage(&head, nil)
+	if p == nil {
+		return common.InvalidPageID
+	}
+	inverted.PageAsPostingPage(p).Init(head)
+	bm.UnpinPage(head, true, nil)
+	if !i.dictionary.insert(nil, []byte(term), common.NewRID(head, 0)) {
+		bm.DeletePage(head, nil)
+		return common.InvalidPageID
+	}
+	return head
+}
+
+/**
+ * Add a posting at the end of a list, in a new page if the last one is full.
+ * @return false if a page couldn't be fetched or created
+ */
+func (i *InvertedIndex) appendPosting(head common.PageID, posting inverted.Posting) bool {
+	bm := i.dictionary.bufferManager
+	headPage := bm.FetchPage(head, nil)
+	if headPage == nil {
+		return false
+	}
+	// only writers change it, and they are serialized
+	lastId := inverted.PageAsPostingPage(headPage).GetLastPageId()
+	headDirty := lastId == head
+	defer func() {
+		bm.UnpinPage(head, headDirty, nil)
+	}()
+	lastPage := headPage
+	if lastId != head {
+		if lastPage = bm.FetchPage(lastId, nil); lastPage == nil {
+			return false
+		}
+		defer bm.UnpinPage(lastId, true, nil)
+	}
+
+	lastPage.WLatch()
+	last := inverted.PageAsPostingPage(lastPage)
+	if !last.IsFull() {
+		last.Append(posting)
+		lastPage.WUnlatch()
+		return true
+	}
+	var newId common.PageID
+	newPage := bm.NewPage(&newId, nil)
+	if newPage == nil {
+		lastPage.WUnlatch()
+		return false
+	}
+	postingPage := inverted.PageAsPostingPage(newPage)
+	postingPage.Init(newId)
+	postingPage.Append(posting)
+	bm.UnpinPage(newId, true, nil)
+	last.SetNextPageId(newId)
+	lastPage.WUnlatch()
+
+	headPage.WLatch()
+	inverted.PageAsPostingPage(headPage).SetLastPageId(newId)
+	headPage.WUnlatch()
+	headDirty = true
+	return true
+}
+
+// write a new posting list, common.InvalidPageID if the buffer pool is full
+func (i *InvertedIndex) writeList(postings []inverted.Posting) common.PageID {
+	bm := i.dictionary.bufferManager
+	var head common.PageID
+	headPage := bm.NewPage(&head, nil)
+	if headPage == nil {
+		return common.InvalidPageID
+	}
+	defer bm.UnpinPage(head, true, nil)
+	headPosting := inverted.PageAsPostingPage(headPage)
+	headPosting.Init(head)
+
+	current := headPosting
+	for _, posting := range postings {
+		if current.IsFull() {
+			var newId common.PageID
+			newPage := bm.NewPage(&newId, nil)
+			if newPage == nil {
+				if current != headPosting {
+					bm.UnpinPage(current.GetPageId(), true, nil)
+				}
+				return common.InvalidPageID
+			}
+			next := inverted.PageAsPostingPage(newPage)
+			next.Init(newId)
+			current.SetNextPageId(newId)
+			if current != headPosting {
+				bm.UnpinPage(current.GetPageId(), true, nil)
+			}
+			current = next
+		}
+		current.Append(posting)
+	}
+	if current != headPosting {
+		headPosting.SetLastPageId(current.GetPageId())
+		bm.UnpinPage(current.GetPageId(), true, nil)
+	}
+	return head
+}
+
+// remove the postings of a tuple from a list, false if a page couldn't be fetched
+func (i *InvertedIndex) removePostings(head common.PageID, rid common.RID) bool {
+	bm := i.dictionary.bufferManager
+	for pid := head; pid != common.InvalidPageID; {
+		p := bm.FetchPage(pid, nil)
+		if p == nil {
+			return false
+		}
+		p.WLatch()
+		postingPage := inverted.PageAsPostingPage(p)
+		dirty := postingPage.RemoveRID(rid) > 0
+		next := postingPage.GetNextPageId()
+		p.WUnlatch()
+		bm.UnpinPage(pid, dirty, nil)
+		pid = next
+	}
+	return true
+}
+
+/**
+ * Take back the postings a failed insert wrote, the lists it started go with
+ * their terms. Appending only fails for want of pages, so this may not get
+ * all the pages it needs either, the postings it leaves behind are then
+ * dropped by the next rebuild.
+ */
+func (i *InvertedIndex) undoInsert(lists map[string]common.PageID, created map[string]bool, rid common.RID) {
+	for term, head := range lists {
+		if created[term] {
+			i.dictionary.remove(nil, []byte(term), common.NewRID(head, 0))
+			i.freeList(head)
+			continue
+		}
+		i.removePostings(head, rid)
+	}
+}
+
+// delete the pages of a posting list
+func (i *InvertedIndex) freeList(head common.PageID) {
+	bm := i.dictionary.bufferManager
+	for pid := head; pid != common.InvalidPageID; {
+		p := bm.FetchPage(pid, nil)
+		if p == nil {
+			return
+		}
+		next := inverted.PageAsPostingPage(p).GetNextPageId()
+		bm.UnpinPage(pid, false, nil)
+		bm.DeletePage(pid, nil)
+		pid = next
+	}
+}
+
+// the positions of a term in each tuple that has it
+func (i *InvertedIndex) postings(term string) map[common.RID][]uint32 {
+	positions := make(map[common.RID][]uint32)
+	bm := i.dictionary.bufferManager
+	for pid := i.lookup(term); pid != common.InvalidPageID; {
+		p := bm.FetchPage(pid, nil)
+		if p == nil {
+			break
+		}
+		p.RLatch()
+		postingPage := inverted.PageAsPostingPage(p)
+		for idx := uint32(0); idx < postingPage.GetSize(); idx++ {
+			posting := postingPage.GetPosting(idx)
+			positions[posting.RID] = append(positions[posting.RID], posting.Position)
+		}
+		next := postingPage.GetNextPageId()
+		p.RUnlatch()
+		bm.UnpinPage(pid, false, nil)
+		pid = next
+	}
+	return positions
+}
+
+// the tuples that have every term of the tokens
+func (i *InvertedIndex) matchAll(tokens []Token) []common.RID {
+	if len(tokens) == 0 {
+		return nil
+	}
+	var found map[common.RID]bool
+	for _, token := range tokens {
+		matched := make(map[common.RID]bool)
+		for rid := range i.postings(token.Term) {
+			if found == nil || found[rid] {
+				matched[rid] = true
+			}
+		}
+		found = matched
+		if len(found) == 0 {
+			break
+		}
+	}
+	return sortedRIDs(found)
+}
+
+func sortedRIDs(set map[common.RID]bool) []common.RID {
+	rids := make([]common.RID, 0, len(set))
+	for rid := range set {
+		rids = append(rids, rid)
+	}
+	sort.Slice(rids, func(a, b int) bool {
+		return btree.CompareRID(rids[a], rids[b]) < 0
+	})
+	return rids
+}
+
+func containsPosition(positions []uint32, position uint32) bool {
+	for _, p := range positions {
+		if p == position {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package index
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"goostub/common"
+	"goostub/schema"
+	"goostub/storage/page/inverted"
+	"goostub/storage/table"
+	"goostub/types"
+	"strings"
+	"testing"
+)
+
+var descriptions = []string{
+	"USB cable for charging phones",
+	"Wireless charger, charges two phones at once",
+	"Phone case with a charging cable holder",
+	"Braided USB-C cables",
+	"",
+}
+
+func TestInvertedIndex(t *testing.T) {
+	a := assert.New(t)
+	s, err := schema.ParseSchema("title:VARCHAR, description:VARCHAR")
+	a.Nil(err)
+	key := func(title string, description string) *table.Tuple {
+		titleVal := types.NewValue(types.VARCHAR)
+		if title != "" {
+			titleVal = types.NewValue(types.VARCHAR, title)
+		}
+		return table.NewTuple([]*types.Value{titleVal, types.NewValue(types.VARCHAR, description)}, s)
+	}
+	bpm := newTestBPM(t, 20)
+
+	// only VARCHAR keys, and no constraint
+	ids, err := schema.ParseSchema("id:INTEGER")
+	a.Nil(err)
+	a.Nil(NewIndex[*InvertedIndex](NewIndexMetadata("idx", "t", []uint32{0}, ids), bpm))
+	unique := NewIndexMetadata("idx", "t", []uint32{1}, s)
+	unique.SetConstraint(Unique, nil)
+	a.Nil(NewIndex[*InvertedIndex](unique, bpm))
+
+	meta := NewIndexMetadata("idx", "t", []uint32{0, 1}, s)
+	idx := NewIndex[*InvertedIndex](meta, bpm).(*InvertedIndex)
+	rid := func(i int) common.RID {
+		return common.NewRID(common.PageID(i), 0)
+	}
+	for i, description := range descriptions {
+		a.Nil(idx.InsertEntry(key("", description), rid(i), nil))
+	}
+	a.Nil(idx.InsertEntry(key("Charging", "dock"), rid(5), nil))
+
+	a.Equal([]common.RID{rid(0), rid(2), rid(5)}, idx.MatchAll("charging", nil))
+	a.Equal([]common.RID{rid(0), rid(2)}, idx.MatchAll("Cables CHARGING", nil))
+	a.Equal([]common.RID{rid(0), rid(1), rid(2), rid(3)}, idx.MatchAny("phone cable", nil))
+	a.Empty(idx.MatchAll("phone toaster", nil))
+	a.Empty(idx.MatchAll("the", nil))
+
+	// the words in order, stopwords taking their places
+	a.Equal([]common.RID{rid(0)}, idx.MatchPhrase("usb cables", nil))
+	a.Equal([]common.RID{rid(3)}, idx.MatchPhrase("USB-C cable", nil))
+	a.Equal([]common.RID{rid(0)}, idx.MatchPhrase("cable for charging", nil))
+	a.Empty(idx.MatchPhrase("cable charging", nil))
+	a.Empty(idx.MatchPhrase("charging usb", nil))
+	// a phrase doesn't run from a column into the next
+	a.Empty(idx.MatchPhrase("charging dock", nil))
+
+	// ScanKey finds the tuples with every term of the key
+	var found []common.RID
+	idx.ScanKey(key("", "charging cables"), &found, nil)
+	a.Equal([]common.RID{rid(0), rid(2)}, found)
+
+	idx.DeleteEntry(key("", descriptions[0]), rid(0), nil)
+	a.Equal([]common.RID{rid(2), rid(5)}, idx.MatchAll("charging", nil))
+	a.Empty(idx.MatchPhrase("usb cables", nil))
+
+	// the index is the same once opened again
+	opened := NewIndex[*InvertedIndex](NewIndexMetadata("idx", "t", []uint32{0, 1}, s), bpm, idx.GetHeaderPageId()).(TextSearcher)
+	a.Equal(idx.MatchAny("phone cable dock", nil), opened.MatchAny("phone cable dock", nil))
+}
+
+func TestInvertedIndexLongPostingLists(t *testing.T) {
+	a := assert.New(t)
+	s, err := schema.ParseSchema("description:VARCHAR")
+	a.Nil(err)
+	key := func(i int) *table.Tuple {
+		return table.NewTuple([]*types.Value{types.NewValue(types.VARCHAR, fmt.Sprintf("product %d in red, %d in blue", i, i%10))}, s)
+	}
+
+	// "product" spans several pages of postings
+	const n = 3 * inverted.PostingsPerPage
+	var entries []IndexEntry
+	idx := NewIndex[*InvertedIndex](NewIndexMetadata("idx", "t", []uint32{0}, s), newTestBPM(t, 20)).(*InvertedIndex)
+	for i := 0; i < n; i++ {
+		entries = append(entries, IndexEntry{Key: key(i), RID: common.NewRID(common.PageID(i), 0)})
+		a.Nil(idx.InsertEntry(key(i), common.NewRID(common.PageID(i), 0), nil))
+	}
+	bulk := NewIndex[*InvertedIndex](NewIndexMetadata("idx", "t", []uint32{0}, s), newTestBPM(t, 20)).(*InvertedIndex)
+	a.Nil(bulk.BulkBuild(entries, nil))
+
+	for _, searcher := range []TextSearcher{idx, bulk} {
+		a.Len(searcher.MatchAll("products", nil), n)
+		a.Len(searcher.MatchPhrase("7 in blue", nil), n/10)
+		a.Equal([]common.RID{common.NewRID(7, 0)}, searcher.MatchPhrase("7 in red, 7 in blue", nil))
+		a.Equal([]common.RID{common.NewRID(123, 0)}, searcher.MatchAll("product 123", nil))
+	}
+
+	// deletes empty some pages of the list, appends go on at its end
+	for i := 0; i < n; i += 2 {
+		idx.DeleteEntry(key(i), common.NewRID(common.PageID(i), 0), nil)
+	}
+	a.Len(idx.MatchAll("product", nil), n/2)
+	a.Nil(idx.InsertEntry(key(0), common.NewRID(common.PageID(n), 0), nil))
+	a.Len(idx.MatchAll("product", nil), n/2+1)
+}
+
+func TestInvertedIndexFailedInsert(t *testing.T) {
+	a := assert.New(t)
+	s, err := schema.ParseSchema("description:VARCHAR")
+	a.Nil(err)
+	key := func(text string) *table.Tuple {
+		return table.NewTuple([]*types.Value{types.NewValue(types.VARCHAR, text)}, s)
+	}
+	bpm := newTestBPM(t, 10)
+	idx := NewIndex[*InvertedIndex](NewIndexMetadata("idx", "t", []uint32{0}, s), bpm).(*InvertedIndex)
+	// terms enough for most directories of the dictionary to exist
+	words := []string{"alpha"}
+	for i := 0; i < 300; i++ {
+		words = append(words, fmt.Sprint("word", i))
+	}
+	a.Nil(idx.InsertEntry(key(strings.Join(words, " ")), common.NewRID(1, 0), nil))
+
+	// leave two frames: "alpha" gets its posting and "hotel" a list in an
+	// existing directory, but "zulu" needs a new directory
+	var held []common.PageID
+	for {
+		var pid common.PageID
+		if bpm.NewPage(&pid, nil) == nil {
+			break
+		}
+		held = append(held, pid)
+	}
+	a.True(bpm.UnpinPage(held[0], false, nil))
+	a.True(bpm.UnpinPage(held[1], false, nil))
+	a.ErrorIs(idx.InsertEntry(key("alpha hotel zulu"), common.NewRID(2, 0), nil), errInvertedIndexPoolFull)
+
+	// nothing of the insert is left
+	a.Equal([]common.RID{common.NewRID(1, 0)}, idx.MatchAny("alpha hotel zulu", nil))
+	a.Equal(common.PageID(common.InvalidPageID), idx.lookup("hotel"))
+	a.Equal(common.PageID(common.InvalidPageID), idx.lookup("zulu"))
+
+	// and it goes through once there is room
+	for _, pid := range held[2:] {
+		bpm.UnpinPage(pid, false, nil)
+	}
+	a.Nil(idx.InsertEntry(key("alpha hotel zulu"), common.NewRID(2, 0), nil))
+	a.Equal([]common.RID{common.NewRID(2, 0)}, idx.MatchPhrase("alpha hotel zulu", nil))
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package index
+
+import (
+	"encoding/binary"
+	"goostub/common"
+	"goostub/schema"
+	"goostub/storage/page/btree"
+	"goostub/storage/table"
+	"goostub/types"
+)
+
+/**
+ * Build the comparator of the keys of an ordered index. Keys are tuples
+ * serialized with the key schema, they are compared column by column in the
+ * order of the key, each column with its own direction and NULL placement.
+ *
+ * When all the key columns are integers the values are read straight from the
+ * keys, otherwise they are deserialized and compared with Value.CompareTo.
+ * @param m the metadata of the index
+ */
+func NewKeyComparator(m *IndexMetadata) btree.KeyComparator {
+	keySchema := m.GetKeySchema()
+	orders := make([]KeyOrder, keySchema.GetColumnCount())
+	for i := range orders {
+		orders[i] = m.GetKeyOrder(i)
+	}
+	if cols := integerKeyColumns(keySchema); cols != nil {
+		return func(a []byte, b []byte) int {
+			for i, col := range cols {
+				if c := orderNulls(isNullAt(a, i), isNullAt(b, i), orders[i]); c != 0 {
+					return c
+				}
+				if isNullAt(a, i) {
+					continue
+				}
+				if c := col.compare(a, b); c != 0 {
+					return orderValues(c, orders[i])
+				}
+			}
+			return 0
+		}
+	}
+
+	return func(a []byte, b []byte) int {
+		l, r := table.NewTuple(a), table.NewTuple(b)
+		for i := range orders {
+			lv, rv := l.GetValue(keySchema, i), r.GetValue(keySchema, i)
+			if c := orderNulls(lv.IsNull(), rv.IsNull(), orders[i]); c != 0 {
+				return c
+			}
+			if lv.IsNull() {
+				continue
+			}
+			res, err := lv.CompareTo(rv)
+			common.Assert.Nil(err, "key values must be comparable")
+			if res != types.CmpEqual {
+				return orderValues(int(res), orders[i])
+			}
+		}
+		return 0
+	}
+}
+
+// the order of two values of which at least one is NULL, 0 if neither or both are
+func orderNulls(lnull bool, rnull bool, order KeyOrder) int {
+	if lnull == rnull {
+		return 0
+	}
+	if lnull != order.NullsLast {
+		return -1
+	}
+	return 1
+}
+
+// the order of two non-NULL values given their ascending order
+func orderValues(c int, order KeyOrder) int {
+	if order.Descending {
+		return -c
+	}
+	return c
+}
+
+func isNullAt(key []byte, colIdx int) bool {
+	return key[schema.TupleVersionSize+colIdx/8]&(1<<(colIdx%8)) != 0
+}
+
+// where an integer column is in a key and how to read it
+type integerKeyColumn struct {
+	offset uint32
+	width  uint32
+	signed bool
+}
+
+// the integer columns of a key schema, nil if some column isn't an integer
+func integerKeyColumns(keySchema *schema.Schema) []integerKeyColumn {
+	cols := make([]integerKeyColumn, keySchema.GetColumnCount())
+	for i := range cols {
+		col := keySchema.GetColumn(i)
+		switch col.GetType() {
+		case types.TINYINT, types.SMALLINT, types.INTEGER, types.BIGINT:
+			cols[i] = integerKeyColumn{offset: col.GetOffset(), width: col.GetFixedLength(), signed: true}
+		case types.TIMESTAMP:
+			cols[i] = integerKeyColumn{offset: col.GetOffset(), width: col.GetFixedLength()}
+		default:
+			return nil
+		}
+	}
+	return cols
+}
+
+func (col integerKeyColumn) compare(a []byte, b []byte) int {
+	if col.signed {
+		l, r := col.signedAt(a), col.signedAt(b)
+		if l < r {
+			return -1
+		}
+		if l > r {
+			return 1
+		}
+		return 0
+	}
+	l, r := binary.LittleEndian.Uint64(a[col.offset:]), binary.LittleEndian.Uint64(b[col.offset:])
+	if l < r {
+		return -1
+	}
+	if l > r {
+		return 1
+	}
+	return 0
+}
+
+func (col integerKeyColumn) signedAt(key []byte) int64 {
+	data := key[col.offset:]
+	switch col.width {
+	case 1:
+		return int64(int8(data[0]))
+	case 2:
+		return int64(int16(binary.LittleEndian.Uint16(data)))
+	case 4:
+		return int64(int32(binary.LittleEndian.Uint32(data)))
+	default:
+		return int64(binary.LittleEndian.Uint64(data))
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package index
+
+import (
+	"github.com/stretchr/testify/assert"
+	"goostub/schema"
+	"goostub/storage/table"
+	"goostub/types"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestKeyComparator(t *testing.T) {
+	a := assert.New(t)
+	s, err := schema.ParseSchema("a:INTEGER, b:VARCHAR, c:DECIMAL, d:SMALLINT, e:BIGINT")
+	a.Nil(err)
+	key := func(m *IndexMetadata, vals ...*types.Value) []byte {
+		return table.NewTuple(vals, m.GetKeySchema()).GetData()
+	}
+	null := func(id types.TypeID) *types.Value {
+		return types.NewValue(id)
+	}
+
+	// signed integers, decimals and strings compare by value, not by their bytes
+	m := NewIndexMetadata("idx", "t", []uint32{0, 1, 2}, s)
+	cmp := NewKeyComparator(m)
+	k := func(i int32, str string, d float64) []byte {
+		return key(m, types.NewValue(types.INTEGER, i), types.NewValue(types.VARCHAR, str), types.NewValue(types.DECIMAL, d))
+	}
+	a.Less(cmp(k(-1, "a", 0), k(1, "a", 0)), 0)
+	a.Less(cmp(k(1, "ab", 0), k(1, "b", 0)), 0)
+	a.Less(cmp(k(1, "b", -2.5), k(1, "b", 1)), 0)
+	a.Greater(cmp(k(1, "b", 10), k(1, "b", 9.5)), 0)
+	a.Equal(0, cmp(k(1, "b", 1), k(1, "b", 1)))
+	a.Less(cmp(key(m, null(types.INTEGER), types.NewValue(types.VARCHAR, "z"), null(types.DECIMAL)), k(-100, "a", 0)), 0)
+
+	// per-column direction and NULL placement
+	m.SetKeyOrders([]KeyOrder{{Descending: true}, {NullsLast: true}, {Descending: true, NullsLast: true}})
+	cmp = NewKeyComparator(m)
+	a.Greater(cmp(k(-1, "a", 0), k(1, "a", 0)), 0)
+	a.Less(cmp(key(m, null(types.INTEGER), null(types.VARCHAR), null(types.DECIMAL)), k(1, "a", 0)), 0)
+	a.Greater(cmp(key(m, types.NewValue(types.INTEGER, int32(1)), null(types.VARCHAR), null(types.DECIMAL)), k(1, "a", 0)), 0)
+	a.Greater(cmp(k(1, "a", 1), k(1, "a", 2)), 0)
+	a.Greater(cmp(key(m, types.NewValue(types.INTEGER, int32(1)), types.NewValue(types.VARCHAR, "a"), null(types.DECIMAL)), k(1, "a", 2)), 0)
+
+	// the integer fast path sorts like Value.CompareTo
+	intMeta := NewIndexMetadata("idx_int", "t", []uint32{3, 0, 4}, s)
+	intMeta.SetKeyOrders([]KeyOrder{{}, {Descending: true, NullsLast: true}, {Descending: true}})
+	a.NotNil(integerKeyColumns(intMeta.GetKeySchema()))
+	a.Nil(integerKeyColumns(m.GetKeySchema()))
+	rng := rand.New(rand.NewSource(1))
+	rows := make([][]*types.Value, 500)
+	for i := range rows {
+		rows[i] = []*types.Value{
+			types.NewValue(types.SMALLINT, int16(rng.Intn(5)-2)),
+			types.NewValue(types.INTEGER, int32(rng.Intn(7)-3)),
+			types.NewValue(types.BIGINT, rng.Int63()-rng.Int63()),
+		}
+		if rng.Intn(5) == 0 {
+			rows[i][1] = null(types.INTEGER)
+		}
+	}
+	expected := func(l []*types.Value, r []*types.Value) int {
+		for i, order := range []KeyOrder{{}, {Descending: true, NullsLast: true}, {Descending: true}} {
+			if c := orderNulls(l[i].IsNull(), r[i].IsNull(), order); c != 0 {
+				return c
+			}
+			if l[i].IsNull() {
+				continue
+			}
+			res, _ := l[i].CompareTo(r[i])
+			if res != types.CmpEqual {
+				return orderValues(int(res), order)
+			}
+		}
+		return 0
+	}
+	cmp = NewKeyComparator(intMeta)
+	sort.Slice(rows, func(i, j int) bool {
+		return cmp(key(intMeta, rows[i]...), key(intMeta, rows[j]...)) < 0
+	})
+	for i := 1; i < len(rows); i++ {
+		a.LessOrEqual(expected(rows[i-1], rows[i]), 0)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package index
+
+import (
+	"strings"
+	"unicode"
+)
+
+/** A term of a text and the position of its word, counting every word of the text. */
+type Token struct {
+	Term     string
+	Position uint32
+}
+
+// the English stopwords of Lucene, too common to be worth indexing
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true,
+	"by": true, "for": true, "if": true, "in": true, "into": true, "is": true, "it": true, "no": true,
+	"not": true, "of": true, "on": true, "or": true, "such": true, "that": true, "the": true, "their": true,
+	"then": true, "there": true, "these": true, "they": true, "this": true, "to": true, "was": true,
+	"will": true, "with": true,
+}
+
+/**
+ * Split a text into the terms a full-text index keeps. Words are the runs of
+ * letters and digits, they are lowercased, stopwords are dropped and the
+ * others stemmed. Stopwords still take a position, so that a phrase matches
+ * only the words it is made of.
+ */
+func Tokenize(text string) []Token {
+	tokens, _ := tokenize(text, 0)
+	return tokens
+}
+
+// tokenize a text whose first word is at position start, also return the position after its last word
+func tokenize(text string, start uint32) ([]Token, uint32) {
+	var tokens []Token
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		word = strings.ToLower(word)
+		if stopwords[word] {
+			continue
+		}
+		tokens = append(tokens, Token{Term: stem(word), Position: start + uint32(i)})
+	}
+	return tokens, start + uint32(len(words))
+}
+
+/**
+ * A light stemmer after the first step of Porter's: it strips plurals and the
+ * -ed and -ing endings, so that "cables" and "cable" or "charging" and
+ * "charged" give the same term. It doesn't try to return real words.
+ */
+func stem(word string) string {
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		word = word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") && len(word) > 3:
+		word = word[:len(word)-1]
+	}
+
+	for _, suffix := range []string{"ing", "ed"} {
+		if !strings.HasSuffix(word, suffix) || strings.HasSuffix(word, "eed") {
+			continue
+		}
+		base := word[:len(word)-len(suffix)]
+		if len(base) < 3 || !strings.ContainsAny(base, "aeiouy") {
+			continue
+		}
+		// running -> run, but not falling -> fal
+		last := base[len(base)-1]
+		if base[len(base)-2] == last && !strings.ContainsRune("aeioulsz", rune(last)) {
+			base = base[:len(base)-1]
+		}
+		return base
+	}
+	return word
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package index
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	a := assert.New(t)
+	// stopwords are dropped but keep their positions
+	a.Equal([]Token{{"usb", 0}, {"cable", 1}, {"charg", 4}, {"phone", 6}}, Tokenize("USB-Cables, for the Charging of phones"))
+	a.Empty(Tokenize(" -- it is the ..."))
+	a.Equal([]Token{{"café", 0}, {"42", 1}}, Tokenize("Café 42"))
+
+	for word, stemmed := range map[string]string{
+		"cables":    "cable",
+		"glasses":   "glass",
+		"batteries": "battery",
+		"wireless":  "wireless",
+		"bus":       "bus",
+		"running":   "run",
+		"charging":  "charg",
+		"charged":   "charg",
+		"falling":   "fall",
+		"speed":     "speed",
+		"red":       "red",
+		"sing":      "sing",
+	} {
+		a.Equal(stemmed, stem(word), word)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package bloom
+
+import (
+	"goostub/common"
+	"goostub/storage/page"
+)
+
+// the number of bits of the filter a bit page holds
+const BitsPerPage = common.PageSize * 8
+
+/**
+ * A page of the bits of a Bloom filter, the whole page is a bitmap. A zeroed
+ * page has no bit set.
+ */
+type BloomFilterBitPage struct {
+	data []byte
+}
+
+// get a bit page pointer to existing page
+func PageAsBitPage(page page.Page) *BloomFilterBitPage {
+	return &BloomFilterBitPage{data: page.GetData()}
+}
+
+/** @return whether bit i of the page is set */
+func (p *BloomFilterBitPage) IsSet(i uint32) bool {
+	return p.data[i/8]&(1<<(i%8)) != 0
+}
+
+/**
+ * Set bit i of the page.
+ * @return false if it was already set
+ */
+func (p *BloomFilterBitPage) Set(i uint32) bool {
+	if p.IsSet(i) {
+		return false
+	}
+	p.data[i/8] |= 1 << (i % 8)
+	return true
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package bloom
+
+import (
+	"goostub/common"
+	"goostub/storage/page"
+	"unsafe"
+)
+
+// size of the header page before the bit page ids
+const sizeHeaderFields = 44
+
+// the largest number of bit pages a filter can have
+const MaxBitPages = (common.PageSize - sizeHeaderFields) / 4
+
+/**
+ *
+ * Header Page of a Bloom filter.
+ *
+ * Header format (size in byte):
+ * -----------------------------------------------------------------------------------------
+ * | PageId (4) | LSN(4) | NumBits(4) | NumHashes(4) | Capacity(4) | Count(4) | FalsePositiveRate(8) |
+ * -----------------------------------------------------------------------------------------
+ * ------------------------------------------------------------
+ * | HashSeed(8) | NumBitPages(4) | BitPageIds(4 * MaxBitPages) |
+ * ------------------------------------------------------------
+ *
+ * The bits of the filter are spread over its bit pages, bit i is bit
+ * i % BitsPerPage of the bit page i / BitsPerPage. A key sets NumHashes bits,
+ * picked by hashes seeded with HashSeed, HashSeed+1 and so on.
+ *
+ * The filter is sized for Capacity keys at FalsePositiveRate, Count is the
+ * number of keys added so far. Past its capacity the filter is saturated and
+ * its false positive rate grows.
+ */
+type BloomFilterHeaderPage struct {
+	pageId            common.PageID
+	lsn               common.LSN
+	numBits           uint32
+	numHashes         uint32
+	capacity          uint32
+	count             uint32
+	falsePositiveRate float64
+	hashSeed          uint64
+	numBitPages       uint32
+	bitPageIds        [MaxBitPages]common.PageID
+}
+
+// get a header page pointer to existing page
+func PageAsHeaderPage(page page.Page) *BloomFilterHeaderPage {
+	return (*BloomFilterHeaderPage)(unsafe.Pointer(&page.GetData()[0]))
+}
+
+/**
+ * Initialize a header page, without any bit page yet.
+ * @param numBits the number of bits of the filter, at most MaxBitPages * BitsPerPage
+ * @param numHashes the number of bits a key sets
+ * @param capacity the number of keys the filter is sized for
+ * @param falsePositiveRate the false positive rate the filter is sized for
+ * @param hashSeed the seed of the first hash
+ */
+func (p *BloomFilterHeaderPage) Init(pageId common.PageID, numBits uint32, numHashes uint32, capacity uint32, falsePositiveRate float64, hashSeed uint64) {
+	common.Assert.LessOrEqual(NumBitPages(numBits), uint32(MaxBitPages), "too many bits for a Bloom filter")
+	p.pageId = pageId
+	p.numBits = numBits
+	p.numHashes = numHashes
+	p.capacity = capacity
+	p.count = 0
+	p.falsePositiveRate = falsePositiveRate
+	p.hashSeed = hashSeed
+	p.numBitPages = 0
+}
+
+func (p *BloomFilterHeaderPage) GetPageId() common.PageID {
+	return p.pageId
+}
+
+func (p *BloomFilterHeaderPage) GetLSN() common.LSN {
+	return p.lsn
+}
+
+func (p *BloomFilterHeaderPage) SetLSN(lsn common.LSN) {
+	p.lsn = lsn
+}
+
+func (p *BloomFilterHeaderPage) GetNumBits() uint32 {
+	return p.numBits
+}
+
+func (p *BloomFilterHeaderPage) GetNumHashes() uint32 {
+	return p.numHashes
+}
+
+func (p *BloomFilterHeaderPage) GetCapacity() uint32 {
+	return p.capacity
+}
+
+func (p *BloomFilterHeaderPage) GetFalsePositiveRate() float64 {
+	return p.falsePositiveRate
+}
+
+func (p *BloomFilterHeaderPage) GetHashSeed() uint64 {
+	return p.hashSeed
+}
+
+/** @return the number of keys added to the filter */
+func (p *BloomFilterHeaderPage) GetCount() uint32 {
+	return p.count
+}
+
+func (p *BloomFilterHeaderPage) IncrCount() {
+	p.count++
+}
+
+func (p *BloomFilterHeaderPage) SetCount(count uint32) {
+	p.count = count
+}
+
+/** @return the number of bit pages added so far */
+func (p *BloomFilterHeaderPage) GetNumBitPages() uint32 {
+	return p.numBitPages
+}
+
+/** Add the next bit page of the filter. */
+func (p *BloomFilterHeaderPage) AddBitPage(bitPageId common.PageID) {
+	common.Assert.Less(p.numBitPages, uint32(MaxBitPages), "the Bloom filter has all its bit pages")
+	p.bitPageIds[p.numBitPages] = bitPageId
+	p.numBitPages++
+}
+
+/** @return the bit page at idx */
+func (p *BloomFilterHeaderPage) GetBitPageId(idx uint32) common.PageID {
+	return p.bitPageIds[idx]
+}
+
+/** @return the number of bit pages a filter of numBits bits needs */
+func NumBitPages(numBits uint32) uint32 {
+	return (numBits + BitsPerPage - 1) / BitsPerPage
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package btree
+
+import (
+	"goostub/common"
+	"goostub/storage/page"
+)
+
+/**
+ * Store n separators and n+1 child pointers within an internal page.
+ * Pointer PAGE_ID(i) points to a subtree in which all entries E satisfy:
+ * K(i) <= E < K(i+1).
+ * NOTE: since the number of separators does not equal the number of child
+ * pointers, the first key always remains invalid. That is to say, any search
+ * or lookup should ignore the first key.
+ *
+ * Internal page format (keys are stored in increasing order):
+ *  --------------------------------------------------------------------------
+ * | HEADER | KEY(1)+RID(1)+PAGE_ID(1) | KEY(2)+RID(2)+PAGE_ID(2) | ... | KEY(n)+RID(n)+PAGE_ID(n) |
+ *  --------------------------------------------------------------------------
+ *
+ * The size of an internal page is its number of children.
+ */
+type BPlusTreeInternalPage struct {
+	BPlusTreePage
+}
+
+// get an internal page pointer to existing page
+func PageAsInternalPage(p page.Page, keySize uint32) *BPlusTreeInternalPage {
+	return &BPlusTreeInternalPage{BPlusTreePage: newTreePage(p, keySize, keySize+sizeRID+sizePageId)}
+}
+
+/** @return the number of children an internal page with keys of keySize bytes can have */
+func InternalMaxSize(keySize uint32) uint32 {
+	return entriesPerPage(keySize + sizeRID + sizePageId)
+}
+
+/**
+ * Initialize an empty internal page.
+ * @param maxSize the number of children it holds before it has to split
+ */
+func (p *BPlusTreeInternalPage) Init(pageId common.PageID, maxSize uint32) {
+	p.init(pageId, InternalPage, maxSize)
+}
+
+/** @return the child at index i */
+func (p *BPlusTreeInternalPage) ValueAt(i uint32) common.PageID {
+	return common.PageID(p.getUint32(p.entryOffset(i) + p.keySize + sizeRID))
+}
+
+func (p *BPlusTreeInternalPage) SetValueAt(i uint32, child common.PageID) {
+	p.setUint32(p.entryOffset(i)+p.keySize+sizeRID, uint32(child))
+}
+
+/** @return the index of a child, the size of the page if it isn't one */
+func (p *BPlusTreeInternalPage) ValueIndex(child common.PageID) uint32 {
+	for i := uint32(0); i < p.GetSize(); i++ {
+		if p.ValueAt(i) == child {
+			return i
+		}
+	}
+	return p.GetSize()
+}
+
+/**
+ * Find the child whose subtree holds (key, rid).
+ * @param rid nil to find the first entry with the key
+ * @return the index of the child
+ */
+func (p *BPlusTreeInternalPage) Lookup(key []byte, rid *common.RID, cmp KeyComparator) uint32 {
+	// the first separator greater than (key, rid), the first key is invalid
+	lo, hi := uint32(1), p.GetSize()
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if p.CompareAt(mid, key, rid, cmp) <= 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo - 1
+}
+
+/**
+ * Make the page a new root with two children.
+ * @param left the old root
+ * @param key separator of the new child
+ * @param rid RID of the separator
+ * @param right the new child
+ */
+func (p *BPlusTreeInternalPage) PopulateNewRoot(left common.PageID, key []byte, rid common.RID, right common.PageID) {
+	p.SetSize(2)
+	p.SetValueAt(0, left)
+	p.SetKeyAt(1, key, rid)
+	p.SetValueAt(1, right)
+}
+
+/**
+ * Insert a child at index i. The page must not be full.
+ * @param key the separator of the child
+ * @param rid the RID of the separator
+ */
+func (p *BPlusTreeInternalPage) InsertAt(i uint32, key []byte, rid common.RID, child common.PageID) {
+	p.insertAt(i)
+	p.SetKeyAt(i, key, rid)
+	p.SetValueAt(i, child)
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package btree
+
+import (
+	"goostub/common"
+	"goostub/storage/page"
+)
+
+/**
+ * Store indexed keys and RIDs together within a leaf page, in order.
+ *
+ * Leaf page format (keys are stored in order):
+ *  ----------------------------------------------------------------------
+ * | HEADER | KEY(1) + RID(1) | KEY(2) + RID(2) | ... | KEY(n) + RID(n)
+ *  ----------------------------------------------------------------------
+ *
+ * The NextPageId of the header links the leaves from left to right.
+ */
+type BPlusTreeLeafPage struct {
+	BPlusTreePage
+}
+
+// get a leaf page pointer to existing page
+func PageAsLeafPage(p page.Page, keySize uint32) *BPlusTreeLeafPage {
+	return &BPlusTreeLeafPage{BPlusTreePage: newTreePage(p, keySize, keySize+sizeRID)}
+}
+
+/** @return the number of entries with keys of keySize bytes that fit in a leaf page */
+func LeafMaxSize(keySize uint32) uint32 {
+	return entriesPerPage(keySize + sizeRID)
+}
+
+/**
+ * Initialize an empty leaf page.
+ * @param maxSize the number of entries it holds before it has to split
+ */
+func (p *BPlusTreeLeafPage) Init(pageId common.PageID, maxSize uint32) {
+	p.init(pageId, LeafPage, maxSize)
+}
+
+func (p *BPlusTreeLeafPage) GetNextPageId() common.PageID {
+	return common.PageID(p.getUint32(offsetNextPageId))
+}
+
+func (p *BPlusTreeLeafPage) SetNextPageId(nextPageId common.PageID) {
+	p.setUint32(offsetNextPageId, uint32(nextPageId))
+}
+
+/**
+ * Insert an entry in order. The page must not be full.
+ * @return false if the entry is already there
+ */
+func (p *BPlusTreeLeafPage) Insert(key []byte, rid common.RID, cmp KeyComparator) bool {
+	i := p.LowerBound(key, &rid, cmp)
+	if i < p.GetSize() && p.CompareAt(i, key, &rid, cmp) == 0 {
+		return false
+	}
+	p.insertAt(i)
+	p.SetKeyAt(i, key, rid)
+	return true
+}
+
+/**
+ * Remove an entry.
+ * @return false if the entry isn't there
+ */
+func (p *BPlusTreeLeafPage) Remove(key []byte, rid common.RID, cmp KeyComparator) bool {
+	i := p.LowerBound(key, &rid, cmp)
+	if i == p.GetSize() || p.CompareAt(i, key, &rid, cmp) != 0 {
+		return false
+	}
+	p.RemoveAt(i)
+	return true
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package btree
+
+import (
+	"goostub/common"
+	"goostub/storage/page"
+	"unsafe"
+)
+
+type IndexPageType uint32
+
+const (
+	InvalidIndexPage IndexPageType = iota
+	LeafPage
+	InternalPage
+)
+
+const (
+	SizeBPlusTreePageHeader = 24
+
+	offsetPageId     = 0
+	offsetPageType   = 8
+	offsetSize       = 12
+	offsetMaxSize    = 16
+	offsetNextPageId = 20
+
+	sizeRID    = 8
+	sizePageId = 4
+)
+
+/**
+ * Orders two keys of a B+Tree.
+ * @return a negative number if a < b, 0 if they are equal and a positive number if a > b
+ */
+type KeyComparator func(a []byte, b []byte) int
+
+/**
+ * Both internal and leaf pages inherit from this page.
+ *
+ * It actually serves as a header part for each B+ tree page and
+ * contains information shared by both leaf page and internal page.
+ *
+ * Header format (size in bytes, 24 bytes in total):
+ * ----------------------------------------------------------------------------
+ * | PageId (4) | LSN (4) | PageType (4) | CurrentSize (4) | MaxSize (4) |
+ * ----------------------------------------------------------------------------
+ * | NextPageId (4) |
+ * ---------------------
+ *
+ * The header is followed by an array of entries. Keys are stored with a fixed
+ * size, every key comes with the RID of its tuple so that entries are unique
+ * even when keys aren't: entries are ordered by key, then by RID.
+ */
+type BPlusTreePage struct {
+	data      []byte
+	keySize   uint32
+	entrySize uint32
+}
+
+func newTreePage(p page.Page, keySize uint32, entrySize uint32) BPlusTreePage {
+	return BPlusTreePage{data: p.GetData(), keySize: keySize, entrySize: entrySize}
+}
+
+/** @return the type of a B+Tree page, to know how to read it */
+func GetPageType(p page.Page) IndexPageType {
+	data := p.GetData()
+	return IndexPageType(*(*uint32)(unsafe.Pointer(&data[offsetPageType])))
+}
+
+func (p *BPlusTreePage) IsLeafPage() bool {
+	return p.GetPageType() == LeafPage
+}
+
+func (p *BPlusTreePage) GetPageType() IndexPageType {
+	return IndexPageType(p.getUint32(offsetPageType))
+}
+
+func (p *BPlusTreePage) GetPageId() common.PageID {
+	return common.PageID(p.getUint32(offsetPageId))
+}
+
+/** @return the number of entries in the page */
+func (p *BPlusTreePage) GetSize() uint32 {
+	return p.getUint32(offsetSize)
+}
+
+func (p *BPlusTreePage) SetSize(size uint32) {
+	p.setUint32(offsetSize, size)
+}
+
+/** @return the number of entries the page holds before it has to split */
+func (p *BPlusTreePage) GetMaxSize() uint32 {
+	return p.getUint32(offsetMaxSize)
+}
+
+/** @return the number of entries a page other than the root needs to hold */
+func (p *BPlusTreePage) GetMinSize() uint32 {
+	return (p.GetMaxSize() + 1) / 2
+}
+
+/** @return the key of the entry at index i */
+func (p *BPlusTreePage) KeyAt(i uint32) []byte {
+	offset := p.entryOffset(i)
+	return p.data[offset : offset+p.keySize]
+}
+
+/** @return the RID of the entry at index i */
+func (p *BPlusTreePage) RIDAt(i uint32) common.RID {
+	offset := p.entryOffset(i) + p.keySize
+	return common.NewRID(common.PageID(p.getUint32(offset)), p.getUint32(offset+4))
+}
+
+/** Set the key and the RID of the entry at index i. */
+func (p *BPlusTreePage) SetKeyAt(i uint32, key []byte, rid common.RID) {
+	offset := p.entryOffset(i)
+	copy(p.data[offset:offset+p.keySize], key)
+	p.setUint32(offset+p.keySize, uint32(rid.GetPageId()))
+	p.setUint32(offset+p.keySize+4, rid.GetSlotNum())
+}
+
+/**
+ * Compare an entry with a key and a RID.
+ * @param rid nil to compare with the key only, as if rid were lower than any RID
+ * @return the order of the entry at index i relative to (key, rid)
+ */
+func (p *BPlusTreePage) CompareAt(i uint32, key []byte, rid *common.RID, cmp KeyComparator) int {
+	if c := cmp(p.KeyAt(i), key); c != 0 {
+		return c
+	}
+	if rid == nil {
+		return 1
+	}
+	return CompareRID(p.RIDAt(i), *rid)
+}
+
+/**
+ * @return the index of the first entry not lower than (key, rid), the size of the page if there is none
+ */
+func (p *BPlusTreePage) LowerBound(key []byte, rid *common.RID, cmp KeyComparator) uint32 {
+	lo, hi := uint32(0), p.GetSize()
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if p.CompareAt(mid, key, rid, cmp) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// open a gap at index i
+func (p *BPlusTreePage) insertAt(i uint32) {
+	size := p.GetSize()
+	copy(p.data[p.entryOffset(i+1):p.entryOffset(size+1)], p.data[p.entryOffset(i):p.entryOffset(size)])
+	p.SetSize(size + 1)
+}
+
+/** Remove the entry at index i, shifting the following ones. */
+func (p *BPlusTreePage) RemoveAt(i uint32) {
+	size := p.GetSize()
+	copy(p.data[p.entryOffset(i):], p.data[p.entryOffset(i+1):p.entryOffset(size)])
+	p.SetSize(size - 1)
+}
+
+/** Move the entries from index i on to the end of recipient. */
+func (p *BPlusTreePage) MoveTailTo(recipient *BPlusTreePage, i uint32) {
+	size, recipientSize := p.GetSize(), recipient.GetSize()
+	copy(recipient.data[recipient.entryOffset(recipientSize):], p.data[p.entryOffset(i):p.entryOffset(size)])
+	recipient.SetSize(recipientSize + size - i)
+	p.SetSize(i)
+}
+
+/** Move the entry at index i to index j of recipient. */
+func (p *BPlusTreePage) MoveEntryTo(recipient *BPlusTreePage, i uint32, j uint32) {
+	recipient.insertAt(j)
+	copy(recipient.data[recipient.entryOffset(j):recipient.entryOffset(j+1)], p.data[p.entryOffset(i):p.entryOffset(i+1)])
+	p.RemoveAt(i)
+}
+
+func (p *BPlusTreePage) init(pageId common.PageID, pageType IndexPageType, maxSize uint32) {
+	p.setUint32(offsetPageId, uint32(pageId))
+	p.setUint32(offsetPageType, uint32(pageType))
+	p.setUint32(offsetSize, 0)
+	p.setUint32(offsetMaxSize, maxSize)
+	next := common.InvalidPageID
+	p.setUint32(offsetNextPageId, uint32(next))
+}
+
+func (p *BPlusTreePage) entryOffset(i uint32) uint32 {
+	return SizeBPlusTreePageHeader + i*p.entrySize
+}
+
+func (p *BPlusTreePage) getUint32(offset uint32) uint32 {
+	return *(*uint32)(unsafe.Pointer(&p.data[offset]))
+}
+
+func (p *BPlusTreePage) setUint32(offset uint32, val uint32) {
+	*(*uint32)(unsafe.Pointer(&p.data[offset])) = val
+}
+
+/** @return the order of two RIDs, by page then by slot */
+func CompareRID(a common.RID, b common.RID) int {
+	if a.GetPageId() != b.GetPageId() {
+		if a.GetPageId() < b.GetPageId() {
+			return -1
+		}
+		return 1
+	}
+	if a.GetSlotNum() != b.GetSlotNum() {
+		if a.GetSlotNum() < b.GetSlotNum() {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// the number of entries of the given size that fit in a page
+func entriesPerPage(entrySize uint32) uint32 {
+	return (common.PageSize - SizeBPlusTreePageHeader) / entrySize
+}
@@ -6,9 +6,12 @@
 package htable
 
 import (
+	"bytes"
+	"fmt"
+	"github.com/go-kit/kit/log/level"
 	"goostub/common"
-	"goostub/hash"
 	"goostub/storage/page"
+	"math/bits"
 	"unsafe"
 )
 
@@ -49,8 +52,19 @@ func PageAsBucketPage(page page.Page, keySize uint32) *HashTableBucketPage {
  *
  * @return true if at least one key matched
  */
-func (h *HashTableBucketPage) GetValue(key []byte, result *[]common.RID) bool {
-	return false
+func (p *HashTableBucketPage) GetValue(key []byte, result *[]common.RID) bool {
+	found := false
+	for idx := uint32(0); idx < p.bucketArraySize(); idx++ {
+		if !p.IsOccupied(idx) {
+			// nothing was ever stored beyond the first unoccupied slot
+			break
+		}
+		if p.IsReadable(idx) && bytes.Equal(p.keyAt(idx), key) {
+			*result = append(*result, *p.valueAt(idx))
+			found = true
+		}
+	}
+	return found
 }
 
 /**
@@ -62,7 +76,46 @@ func (h *HashTableBucketPage) GetValue(key []byte, result *[]common.RID) bool {
  * @return true if inserted, false if duplicate KV pair or bucket is full
  */
 func (p *HashTableBucketPage) Insert(key []byte, value common.RID) bool {
-	return false
+	freeIdx := p.bucketArraySize()
+	for idx := uint32(0); idx < p.bucketArraySize(); idx++ {
+		if !p.IsReadable(idx) {
+			if freeIdx == p.bucketArraySize() {
+				freeIdx = idx
+			}
+			if !p.IsOccupied(idx) {
+				break
+			}
+			continue
+		}
+		if bytes.Equal(p.keyAt(idx), key) && p.valueAt(idx).IsEqual(value) {
+			return false
+		}
+	}
+
+	if freeIdx == p.bucketArraySize() {
+		return false
+	}
+
+	copy(p.keyAt(freeIdx), key)
+	*p.valueAt(freeIdx) = value
+	p.SetOccupied(freeIdx)
+	p.SetReadable(freeIdx)
+	return true
+}
+
+/**
+ * Put a key/value pair at bucket_idx, which must not be occupied. Duplicates
+ * aren't checked, this is meant to fill a new bucket in one go.
+ *
+ * @param bucket_idx index to put the pair at
+ * @param key key to insert
+ * @param value value to insert
+ */
+func (p *HashTableBucketPage) InsertAt(bucketIdx uint32, key []byte, value common.RID) {
+	copy(p.keyAt(bucketIdx), key)
+	*p.valueAt(bucketIdx) = value
+	p.SetOccupied(bucketIdx)
+	p.SetReadable(bucketIdx)
 }
 
 /**
@@ -71,6 +124,15 @@ func (p *HashTableBucketPage) Insert(key []byte, value common.RID) bool {
  * @return true if removed, false if not found
  */
 func (p *HashTableBucketPage) Remove(key []byte, value common.RID) bool {
+	for idx := uint32(0); idx < p.bucketArraySize(); idx++ {
+		if !p.IsOccupied(idx) {
+			break
+		}
+		if p.IsReadable(idx) && bytes.Equal(p.keyAt(idx), key) && p.valueAt(idx).IsEqual(value) {
+			p.RemoveAt(idx)
+			return true
+		}
+	}
 	return false
 }
 
@@ -78,6 +140,124 @@ func (p *HashTableBucketPage) Remove(key []byte, value common.RID) bool {
  * Remove the KV pair at bucket_idx
  */
 func (p *HashTableBucketPage) RemoveAt(bucketIdx uint32) {
+	p.readable[bucketIdx/8] &^= 1 << (bucketIdx % 8)
+}
+
+/**
+ * Gets the key at an index in the bucket.
+ *
+ * @param bucket_idx the index in the bucket to get the key at
+ * @return a copy of the key at index bucket_idx of the bucket
+ */
+func (p *HashTableBucketPage) KeyAt(bucketIdx uint32) []byte {
+	key := make([]byte, p.keySize)
+	copy(key, p.keyAt(bucketIdx))
+	return key
+}
+
+/**
+ * Gets the value at an index in the bucket.
+ *
+ * @param bucket_idx the index in the bucket to get the value at
+ * @return value at index bucket_idx of the bucket
+ */
+func (p *HashTableBucketPage) ValueAt(bucketIdx uint32) common.RID {
+	return *p.valueAt(bucketIdx)
+}
+
+/**
+ * Returns whether or not an index is occupied (key/value pair or tombstone)
+ */
+func (p *HashTableBucketPage) IsOccupied(bucketIdx uint32) bool {
+	return p.occupied[bucketIdx/8]&(1<<(bucketIdx%8)) != 0
+}
+
+/**
+ * SetOccupied - Updates the bitmap to indicate that the entry at
+ * bucket_idx is occupied.
+ */
+func (p *HashTableBucketPage) SetOccupied(bucketIdx uint32) {
+	p.occupied[bucketIdx/8] |= 1 << (bucketIdx % 8)
+}
+
+/**
+ * Returns whether or not an index is readable (valid key/value pair)
+ */
+func (p *HashTableBucketPage) IsReadable(bucketIdx uint32) bool {
+	return p.readable[bucketIdx/8]&(1<<(bucketIdx%8)) != 0
+}
+
+/**
+ * SetReadable - Updates the bitmap to indicate that the entry at
+ * bucket_idx is readable.
+ */
+func (p *HashTableBucketPage) SetReadable(bucketIdx uint32) {
+	p.readable[bucketIdx/8] |= 1 << (bucketIdx % 8)
+}
+
+/**
+ * @return the number of key/value pairs this bucket can hold
+ */
+func (p *HashTableBucketPage) BucketArraySize() uint32 {
+	return p.bucketArraySize()
+}
+
+/**
+ * @return the number of key/value pairs a bucket with keys of keySize bytes can hold
+ */
+func BucketArraySize(keySize uint32) uint32 {
+	//     2*((x-1)/8+1)      +   (kv size)*x   <=  pageSize
+	// occupied + readable         kvArray
+	return (4*common.PageSize - 7) / (4*(keySize+uint32(unsafe.Sizeof(common.RID{}))) + 1)
+}
+
+/**
+ * @return whether the bucket is full
+ */
+func (p *HashTableBucketPage) IsFull() bool {
+	return p.NumReadable() == p.bucketArraySize()
+}
+
+/**
+ * @return the number of readable elements, i.e. current size
+ */
+func (p *HashTableBucketPage) NumReadable() uint32 {
+	num := uint32(0)
+	for _, b := range p.readable {
+		num += uint32(bits.OnesCount8(b))
+	}
+	return num
+}
+
+/**
+ * @return whether the bucket is empty
+ */
+func (p *HashTableBucketPage) IsEmpty() bool {
+	for _, b := range p.readable {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * Prints the bucket's occupancy information
+ */
+func (p *HashTableBucketPage) PrintBucket() {
+	size, taken, free := uint32(0), uint32(0), uint32(0)
+	for idx := uint32(0); idx < p.bucketArraySize(); idx++ {
+		if !p.IsOccupied(idx) {
+			break
+		}
+		size++
+		if p.IsReadable(idx) {
+			taken++
+		} else {
+			free++
+		}
+	}
+	level.Debug(common.Logger).Log(fmt.Sprintf("Bucket Capacity: %d, Size: %d, Taken: %d, Free: %d", p.bucketArraySize(), size, taken, free))
 }
 
 // helper functions
@@ -87,9 +267,7 @@ func (p *HashTableBucketPage) kvSize() uint32 {
 }
 
 func (p *HashTableBucketPage) bucketArraySize() uint32 {
-	//     2*((x-1)/8+1)      +   (kv size)*x   <=  pageSize
-	// occupied + readable         kvArray
-	return (4*common.PageSize - 7) / (4*p.kvSize() + 1)
+	return BucketArraySize(p.keySize)
 }
 
 func (p *HashTableBucketPage) bitArraySize() uint32 {
@@ -9,26 +9,45 @@ import (
 	"fmt"
 	"github.com/go-kit/kit/log/level"
 	"goostub/common"
+	"goostub/storage/page"
+	"io"
 	"os"
+	"unsafe"
 )
 
-const directoryArraySize = 512
+// maximum number of directory slots, i.e. the directory size at the maximum global depth
+const DirectoryArraySize = 512
+
+// the largest global depth of a directory
+const DirectoryMaxDepth = 9
 
 /**
  *
  * Directory Page for extendible hash table.
  *
  * Directory format (size in byte):
- * --------------------------------------------------------------------------------------------
- * | PageId (4) | LSN(4) | GlobalDepth(4) | LocalDepths(512) | BucketPageIds(2048) | Free(1524)
- * --------------------------------------------------------------------------------------------
+ * ----------------------------------------------------------------------------------------
+ * | PageId (4) | LSN(4) | GlobalDepth(4) | MaxDepth(4) | LocalDepths(512) | BucketPageIds(2048) |
+ * ----------------------------------------------------------------------------------------
+ * -------------
+ * | Free(1520)
+ * -------------
+ *
+ * A directory covers the keys of one slot of the header page. MaxDepth caps
+ * its global depth, at most DirectoryMaxDepth.
  */
 type HashTableDirectoryPage struct {
 	pageId        common.PageID
 	lsn           common.LSN
 	globalDepth   uint32
-	localDepth    [directoryArraySize]uint8
-	bucketPageIds [directoryArraySize]common.PageID
+	maxDepth      uint32
+	localDepth    [DirectoryArraySize]uint8
+	bucketPageIds [DirectoryArraySize]common.PageID
+}
+
+// get a directory page pointer to existing page
+func PageAsDirectoryPage(page page.Page) *HashTableDirectoryPage {
+	return (*HashTableDirectoryPage)(unsafe.Pointer(&page.GetData()[0]))
 }
 
 func (p *HashTableDirectoryPage) GetPageId() common.PageID {
@@ -47,6 +66,26 @@ func (p *HashTableDirectoryPage) SetLSN(lsn common.LSN) {
 	p.lsn = lsn
 }
 
+/**
+ * Set the largest global depth the directory can grow to
+ * @param maxDepth at most DirectoryMaxDepth
+ */
+func (p *HashTableDirectoryPage) SetMaxDepth(maxDepth uint32) {
+	common.Assert.LessOrEqual(maxDepth, uint32(DirectoryMaxDepth), "directory max depth is too large")
+	p.maxDepth = maxDepth
+}
+
+func (p *HashTableDirectoryPage) GetMaxDepth() uint32 {
+	return p.maxDepth
+}
+
+/**
+ * @return the directory size at the max depth
+ */
+func (p *HashTableDirectoryPage) MaxSize() uint32 {
+	return 1 << p.maxDepth
+}
+
 /**
  * Lookup a bucket page using a directory index
  *
@@ -54,7 +93,7 @@ func (p *HashTableDirectoryPage) SetLSN(lsn common.LSN) {
  * @return bucket page id corresponding to bucketIdx
  */
 func (p *HashTableDirectoryPage) GetBucketPageId(bucketIdx uint32) common.PageID {
-	return 0
+	return p.bucketPageIds[bucketIdx]
 }
 
 /**
@@ -64,6 +103,7 @@ func (p *HashTableDirectoryPage) GetBucketPageId(bucketIdx uint32) common.PageID
  * @param bucket_page_id page_id to insert
  */
 func (p *HashTableDirectoryPage) SetBucketPageId(bucketIdx uint32, bucketPageId common.PageID) {
+	p.bucketPageIds[bucketIdx] = bucketPageId
 }
 
 /**
@@ -72,7 +112,8 @@ func (p *HashTableDirectoryPage) SetBucketPageId(bucketIdx uint32, bucketPageId
  * @param bucket_idx the directory index for which to find the split image
  * @return the directory index of the split image
  **/
-func (p *HashTableDirectoryPage) GetSplitImageIndex(bucketIdx uint32) {
+func (p *HashTableDirectoryPage) GetSplitImageIndex(bucketIdx uint32) uint32 {
+	return bucketIdx ^ p.GetLocalHighBit(bucketIdx)
 }
 
 /**
@@ -90,7 +131,7 @@ func (p *HashTableDirectoryPage) GetSplitImageIndex(bucketIdx uint32) {
  * @return mask of global_depth 1's and the rest 0's (with 1's from LSB upwards)
  */
 func (p *HashTableDirectoryPage) GetGlobalDepthMask() uint32 {
-	return 0
+	return (1 << p.globalDepth) - 1
 }
 
 /**
@@ -101,7 +142,7 @@ func (p *HashTableDirectoryPage) GetGlobalDepthMask() uint32 {
  * @return mask of local 1's and the rest 0's (with 1's from LSB upwards)
  */
 func (p *HashTableDirectoryPage) GetLocalDepthMask(bucketIdx uint32) uint32 {
-	return 0
+	return (1 << p.localDepth[bucketIdx]) - 1
 }
 
 /**
@@ -110,31 +151,50 @@ func (p *HashTableDirectoryPage) GetLocalDepthMask(bucketIdx uint32) uint32 {
  * @return the global depth of the directory
  */
 func (p *HashTableDirectoryPage) GetGlobalDepth() uint32 {
-	return 0
+	return p.globalDepth
 }
 
 /**
  * Increase the global depth of the directory
  */
-func (p *HashTableDirectoryPage) IncrGlobalDepth() {}
+func (p *HashTableDirectoryPage) IncrGlobalDepth() {
+	common.Assert.Less(p.globalDepth, p.maxDepth, "directory is full")
+	// the new half of the directory mirrors the old half
+	size := p.Size()
+	for idx := uint32(0); idx < size; idx++ {
+		p.bucketPageIds[idx+size] = p.bucketPageIds[idx]
+		p.localDepth[idx+size] = p.localDepth[idx]
+	}
+	p.globalDepth++
+}
 
 /**
  * Decrease the global depth of the directory
  */
-func (p *HashTableDirectoryPage) DecrGlobalDepth() {}
+func (p *HashTableDirectoryPage) DecrGlobalDepth() {
+	p.globalDepth--
+}
 
 /**
  * @return true if the directory can be shrunk
  */
 func (p *HashTableDirectoryPage) CanShrink() bool {
-	return false
+	if p.globalDepth == 0 {
+		return false
+	}
+	for idx := uint32(0); idx < p.Size(); idx++ {
+		if uint32(p.localDepth[idx]) == p.globalDepth {
+			return false
+		}
+	}
+	return true
 }
 
 /**
  * @return the current directory size
  */
 func (p *HashTableDirectoryPage) Size() uint32 {
-	return 0
+	return 1 << p.globalDepth
 }
 
 /**
@@ -144,7 +204,7 @@ func (p *HashTableDirectoryPage) Size() uint32 {
  * @return the local depth of the bucket at bucket_idx
  */
 func (p *HashTableDirectoryPage) GetLocalDepth(bucketIdx uint32) uint8 {
-	return 0
+	return p.localDepth[bucketIdx]
 }
 
 /**
@@ -153,19 +213,25 @@ func (p *HashTableDirectoryPage) GetLocalDepth(bucketIdx uint32) uint8 {
  * @param bucket_idx bucket index to update
  * @param local_depth new local depth
  */
-func (p *HashTableDirectoryPage) SetLocalDepth(bucketIdx uint32, localDepth uint8) {}
+func (p *HashTableDirectoryPage) SetLocalDepth(bucketIdx uint32, localDepth uint8) {
+	p.localDepth[bucketIdx] = localDepth
+}
 
 /**
  * Increase the local depth of the bucket at bucket_idx
  * @param bucket_idx bucket index to increment
  */
-func (p *HashTableDirectoryPage) IncrLocalDepth(bucketIdx uint32) {}
+func (p *HashTableDirectoryPage) IncrLocalDepth(bucketIdx uint32) {
+	p.localDepth[bucketIdx]++
+}
 
 /**
  * Decrease the local depth of the bucket at bucket_idx
  * @param bucket_idx bucket index to decrement
  */
-func (p *HashTableDirectoryPage) DecrLocalDepth(bucketIdx uint32) {}
+func (p *HashTableDirectoryPage) DecrLocalDepth(bucketIdx uint32) {
+	p.localDepth[bucketIdx]--
+}
 
 /**
  * Gets the high bit corresponding to the bucket's local depth.
@@ -176,7 +242,10 @@ func (p *HashTableDirectoryPage) DecrLocalDepth(bucketIdx uint32) {}
  * @return the high bit corresponding to the bucket's local depth
  */
 func (p *HashTableDirectoryPage) GetLocalHighBit(bucketIdx uint32) uint32 {
-	return 0
+	if p.localDepth[bucketIdx] == 0 {
+		return 0
+	}
+	return 1 << (p.localDepth[bucketIdx] - 1)
 }
 
 /**
@@ -195,10 +264,10 @@ func (p *HashTableDirectoryPage) VerifyIntegrity() {
 	pageId2Ld := make(map[common.PageID]uint8)
 
 	//  verify for each bucket_page_id
-	for curIdx := uint32(0); curIdx < directoryArraySize; curIdx++ {
+	for curIdx := uint32(0); curIdx < p.Size(); curIdx++ {
 		curPageId := p.bucketPageIds[curIdx]
 		curLd := p.localDepth[curIdx]
-		common.Assert.LessOrEqual(curLd, p.globalDepth)
+		common.Assert.LessOrEqual(uint32(curLd), p.globalDepth)
 		pageId2Count[curPageId]++
 
 		if oldLd, ok := pageId2Ld[curPageId]; ok && oldLd != curLd {
@@ -225,10 +294,27 @@ func (p *HashTableDirectoryPage) VerifyIntegrity() {
  * Prints the current directory
  */
 func (p *HashTableDirectoryPage) PrintDirectory() {
-	level.Debug(common.Logger).Log(fmt.Sprintf("======== DIRECTORY (global depth: %d) ========", p.globalDepth))
-	level.Debug(common.Logger).Log("| bucket idx | page id | local depth |")
+	for _, line := range p.directoryLines() {
+		level.Debug(common.Logger).Log(line)
+	}
+}
+
+/**
+ * Writes the current directory to w, in the same format as PrintDirectory
+ */
+func (p *HashTableDirectoryPage) FprintDirectory(w io.Writer) {
+	for _, line := range p.directoryLines() {
+		fmt.Fprintln(w, line)
+	}
+}
+
+func (p *HashTableDirectoryPage) directoryLines() []string {
+	lines := []string{
+		fmt.Sprintf("======== DIRECTORY (global depth: %d) ========", p.globalDepth),
+		"| bucket idx | page id | local depth |",
+	}
 	for idx := 0; idx < (1 << p.globalDepth); idx++ {
-		level.Debug(common.Logger).Log(fmt.Sprintf("|     %d     |     %d     |     %d     |", idx, p.bucketPageIds[idx], p.localDepth[idx]))
+		lines = append(lines, fmt.Sprintf("|     %d     |     %d     |     %d     |", idx, p.bucketPageIds[idx], p.localDepth[idx]))
 	}
-	level.Debug(common.Logger).Log("================ END DIRECTORY ================")
+	return append(lines, "================ END DIRECTORY ================")
 }
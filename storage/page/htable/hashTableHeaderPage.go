@@ -0,0 +1,134 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package htable
+
+import (
+	"goostub/common"
+	"goostub/hash"
+	"goostub/storage/page"
+	"unsafe"
+)
+
+// maximum number of directory slots, i.e. the header size at the maximum depth
+const HeaderArraySize = 512
+
+// the largest depth a header can be created with
+const HeaderMaxDepth = 9
+
+/**
+ *
+ * Header Page for extendible hash table.
+ *
+ * Header format (size in byte):
+ * ---------------------------------------------------------------------------------------
+ * | PageId (4) | LSN(4) | MaxDepth(4) | DirectoryMaxDepth(4) | HashSeed(8) | HashFunc(4) |
+ * ---------------------------------------------------------------------------------------
+ * -------------------------------------
+ * | DirectoryPageIds(2048) | Free(2020)
+ * -------------------------------------
+ *
+ * The header is the root of the table. The top MaxDepth bits of the hash of a
+ * key pick one of its directory pages, then the low bits pick a bucket in
+ * that directory, so the table isn't limited to the buckets of one directory.
+ * Directory pages are created the first time a key hashes to them, with a
+ * max depth of DirectoryMaxDepth.
+ *
+ * HashFunc and HashSeed record the hash function of the table, so that it
+ * hashes the same way when it is opened again.
+ */
+type HashTableHeaderPage struct {
+	pageId            common.PageID
+	lsn               common.LSN
+	maxDepth          uint32
+	directoryMaxDepth uint32
+	hashSeed          uint64
+	hashFunc          hash.HashFuncId
+	directoryPageIds  [HeaderArraySize]common.PageID
+}
+
+// get a header page pointer to existing page
+func PageAsHeaderPage(page page.Page) *HashTableHeaderPage {
+	return (*HashTableHeaderPage)(unsafe.Pointer(&page.GetData()[0]))
+}
+
+/**
+ * Initialize a new header page, without any directory.
+ * @param maxDepth number of hash bits used to pick a directory, at most HeaderMaxDepth
+ * @param directoryMaxDepth max depth of the directories, at most DirectoryMaxDepth
+ */
+func (p *HashTableHeaderPage) Init(pageId common.PageID, maxDepth uint32, directoryMaxDepth uint32) {
+	common.Assert.LessOrEqual(maxDepth, uint32(HeaderMaxDepth), "header max depth is too large")
+	common.Assert.LessOrEqual(directoryMaxDepth, uint32(DirectoryMaxDepth), "directory max depth is too large")
+	p.pageId = pageId
+	p.maxDepth = maxDepth
+	p.directoryMaxDepth = directoryMaxDepth
+	for idx := range p.directoryPageIds {
+		p.directoryPageIds[idx] = common.InvalidPageID
+	}
+}
+
+func (p *HashTableHeaderPage) GetPageId() common.PageID {
+	return p.pageId
+}
+
+func (p *HashTableHeaderPage) GetLSN() common.LSN {
+	return p.lsn
+}
+
+func (p *HashTableHeaderPage) SetLSN(lsn common.LSN) {
+	p.lsn = lsn
+}
+
+/** @return the hash function of the table */
+func (p *HashTableHeaderPage) GetHashSpec() hash.HashSpec {
+	return hash.HashSpec{Id: p.hashFunc, Seed: p.hashSeed}
+}
+
+func (p *HashTableHeaderPage) SetHashSpec(spec hash.HashSpec) {
+	p.hashFunc = spec.Id
+	p.hashSeed = spec.Seed
+}
+
+func (p *HashTableHeaderPage) GetMaxDepth() uint32 {
+	return p.maxDepth
+}
+
+/** @return the max depth of the directories of the table */
+func (p *HashTableHeaderPage) GetDirectoryMaxDepth() uint32 {
+	return p.directoryMaxDepth
+}
+
+/**
+ * @return the number of directory slots
+ */
+func (p *HashTableHeaderPage) MaxSize() uint32 {
+	return 1 << p.maxDepth
+}
+
+/**
+ * Get the directory slot of a hash, from its top MaxDepth bits.
+ *
+ * @param hash the hash of a key
+ * @return the directory index
+ */
+func (p *HashTableHeaderPage) HashToDirectoryIndex(hash uint32) uint32 {
+	if p.maxDepth == 0 {
+		return 0
+	}
+	return hash >> (32 - p.maxDepth)
+}
+
+/**
+ * @param directoryIdx the directory slot
+ * @return the directory page at directoryIdx, common.InvalidPageID if there is none yet
+ */
+func (p *HashTableHeaderPage) GetDirectoryPageId(directoryIdx uint32) common.PageID {
+	return p.directoryPageIds[directoryIdx]
+}
+
+func (p *HashTableHeaderPage) SetDirectoryPageId(directoryIdx uint32, directoryPageId common.PageID) {
+	p.directoryPageIds[directoryIdx] = directoryPageId
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package htable
+
+import (
+	"encoding/binary"
+	"goostub/common"
+	"goostub/storage/page"
+)
+
+const (
+	// size of the header of an overflow page
+	SizeOverflowHeader = 8
+	// key bytes an overflow page holds
+	OverflowCapacity = common.PageSize - SizeOverflowHeader
+	// size of the reference a bucket keeps to an overflowed key
+	SizeOverflowRef = 8
+
+	offsetOverflowNextPageId = 0
+	offsetOverflowLength     = 4
+)
+
+/**
+ * Overflow page of a key too long to be kept in a variable-length bucket. The
+ * key is cut into a chain of overflow pages, the bucket keeps an OverflowRef
+ * to the first one.
+ *
+ *  Format (size in bytes):
+ *  ------------------------------------------------
+ *  | NextPageId (4) | Length (4) | KEY BYTES ... |
+ *  ------------------------------------------------
+ */
+type HashTableOverflowPage struct {
+	data []byte
+}
+
+// get an overflow page pointer to existing page
+func PageAsOverflowPage(page page.Page) *HashTableOverflowPage {
+	return &HashTableOverflowPage{data: page.GetData()}
+}
+
+/** @return the next page of the chain, common.InvalidPageID for the last one */
+func (p *HashTableOverflowPage) GetNextPageId() common.PageID {
+	return common.PageID(binary.LittleEndian.Uint32(p.data[offsetOverflowNextPageId:]))
+}
+
+func (p *HashTableOverflowPage) SetNextPageId(pid common.PageID) {
+	binary.LittleEndian.PutUint32(p.data[offsetOverflowNextPageId:], uint32(pid))
+}
+
+/** @return a reference to the key bytes in this page */
+func (p *HashTableOverflowPage) GetKeyBytes() []byte {
+	length := binary.LittleEndian.Uint32(p.data[offsetOverflowLength:])
+	return p.data[SizeOverflowHeader : SizeOverflowHeader+length]
+}
+
+/**
+ * @param chunk the part of the key in this page, at most OverflowCapacity bytes
+ */
+func (p *HashTableOverflowPage) SetKeyBytes(chunk []byte) {
+	common.Assert.LessOrEqual(len(chunk), OverflowCapacity, "the chunk doesn't fit in an overflow page")
+	binary.LittleEndian.PutUint32(p.data[offsetOverflowLength:], uint32(len(chunk)))
+	copy(p.data[SizeOverflowHeader:], chunk)
+}
+
+/**
+ * Build the reference a bucket keeps to an overflowed key.
+ *  | FirstPageId (4) | KeyLength (4) |
+ */
+func OverflowRef(firstPageId common.PageID, keyLen uint32) []byte {
+	ref := make([]byte, SizeOverflowRef)
+	binary.LittleEndian.PutUint32(ref, uint32(firstPageId))
+	binary.LittleEndian.PutUint32(ref[4:], keyLen)
+	return ref
+}
+
+/** @return the first overflow page and the length of the key of a reference */
+func ParseOverflowRef(ref []byte) (common.PageID, uint32) {
+	return common.PageID(binary.LittleEndian.Uint32(ref)), binary.LittleEndian.Uint32(ref[4:])
+}
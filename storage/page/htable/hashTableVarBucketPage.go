@@ -0,0 +1,213 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package htable
+
+import (
+	"encoding/binary"
+	"goostub/common"
+	"goostub/storage/page"
+)
+
+const (
+	// size of the header and of one slot of a variable-length bucket page
+	SizeVarBucketHeader = 4
+	SizeVarBucketSlot   = 16
+
+	// keys longer than this are kept in overflow pages, so that a bucket always holds a few entries
+	MaxInlineKeySize = common.PageSize / 8
+
+	offsetVarSlotCount = 0
+	offsetVarFreeSpace = 2
+
+	offsetSlotKeyOffset = 0
+	offsetSlotKeyLength = 2
+	offsetSlotHash      = 4
+	offsetSlotPageId    = 8
+	offsetSlotSlotNum   = 12
+
+	overflowFlag = 1 << 15
+)
+
+/**
+ * Store variable-length keys and their values within a bucket page. Supports
+ * non-unique keys.
+ *
+ * Slotted page format, slots grow from the start and keys from the end:
+ *  ---------------------------------------------------------------------
+ *  | HEADER | SLOT(1) | ... | SLOT(n) | ... FREE SPACE ... | ... KEYS |
+ *  ---------------------------------------------------------------------
+ *                                                          ^
+ *                                                          free space pointer
+ *
+ *  Header format (size in bytes):
+ *  ----------------------------------------
+ *  | SlotCount (2) | FreeSpacePointer (2) |
+ *  ----------------------------------------
+ *  Slot format (size in bytes):
+ *  --------------------------------------------------------------------
+ *  | KeyOffset (2) | KeyLength (2) | Hash (4) | PageId (4) | SlotNum (4) |
+ *  --------------------------------------------------------------------
+ *
+ * A zeroed page is an empty bucket. Hash is the hash of the key in the table,
+ * so that entries can be split without reading their keys. The top bit of
+ * KeyLength marks a key that is kept in overflow pages, the slot then holds an
+ * OverflowRef instead of the key. Slots aren't ordered: removing one moves the
+ * last slot in its place and closes the gap its key leaves.
+ */
+type HashTableVarBucketPage struct {
+	data []byte
+}
+
+// get a variable-length bucket page pointer to existing page
+func PageAsVarBucketPage(page page.Page) *HashTableVarBucketPage {
+	return &HashTableVarBucketPage{data: page.GetData()}
+}
+
+/**
+ * Append an entry. Duplicates aren't checked, the caller has to look for them.
+ *
+ * @param key the key, or the OverflowRef of a key kept in overflow pages
+ * @param overflow whether key is an OverflowRef
+ * @param hash the hash of the whole key
+ * @param value value to insert
+ * @return false if the key is too long or the entry doesn't fit
+ */
+func (p *HashTableVarBucketPage) Insert(key []byte, overflow bool, hash uint32, value common.RID) bool {
+	if len(key) > MaxInlineKeySize || !p.Fits(uint32(len(key))) {
+		return false
+	}
+	n := p.NumSlots()
+	fsp := p.freeSpacePointer() - uint32(len(key))
+	copy(p.data[fsp:], key)
+
+	length := uint16(len(key))
+	if overflow {
+		length |= overflowFlag
+	}
+	slot := p.slot(n)
+	binary.LittleEndian.PutUint16(slot[offsetSlotKeyOffset:], uint16(fsp))
+	binary.LittleEndian.PutUint16(slot[offsetSlotKeyLength:], length)
+	binary.LittleEndian.PutUint32(slot[offsetSlotHash:], hash)
+	binary.LittleEndian.PutUint32(slot[offsetSlotPageId:], uint32(value.GetPageId()))
+	binary.LittleEndian.PutUint32(slot[offsetSlotSlotNum:], value.GetSlotNum())
+	p.setNumSlots(n + 1)
+	p.setFreeSpacePointer(fsp)
+	return true
+}
+
+/**
+ * Remove the entry at slotIdx. The last entry takes its slot.
+ */
+func (p *HashTableVarBucketPage) RemoveAt(slotIdx uint32) {
+	n := p.NumSlots()
+	offset, length := p.keyOffset(slotIdx), p.keyLength(slotIdx)
+	fsp := p.freeSpacePointer()
+
+	// the keys stored below the removed one move up over it
+	copy(p.data[fsp+length:offset+length], p.data[fsp:offset])
+	for idx := uint32(0); idx < n; idx++ {
+		if o := p.keyOffset(idx); o < offset {
+			binary.LittleEndian.PutUint16(p.slot(idx)[offsetSlotKeyOffset:], uint16(o+length))
+		}
+	}
+	if slotIdx != n-1 {
+		copy(p.slot(slotIdx), p.slot(n-1))
+	}
+	p.setNumSlots(n - 1)
+	p.setFreeSpacePointer(fsp + length)
+}
+
+/**
+ * Gets the key at a slot, or its OverflowRef if IsOverflowAt.
+ *
+ * @return a copy of the key at slot_idx of the bucket
+ */
+func (p *HashTableVarBucketPage) KeyAt(slotIdx uint32) []byte {
+	key := make([]byte, p.keyLength(slotIdx))
+	copy(key, p.keyAt(slotIdx))
+	return key
+}
+
+/** @return whether the key at slot_idx is kept in overflow pages */
+func (p *HashTableVarBucketPage) IsOverflowAt(slotIdx uint32) bool {
+	return binary.LittleEndian.Uint16(p.slot(slotIdx)[offsetSlotKeyLength:])&overflowFlag != 0
+}
+
+/** @return the hash of the key at slot_idx */
+func (p *HashTableVarBucketPage) HashAt(slotIdx uint32) uint32 {
+	return binary.LittleEndian.Uint32(p.slot(slotIdx)[offsetSlotHash:])
+}
+
+/** @return the value at slot_idx */
+func (p *HashTableVarBucketPage) ValueAt(slotIdx uint32) common.RID {
+	slot := p.slot(slotIdx)
+	return common.NewRID(common.PageID(binary.LittleEndian.Uint32(slot[offsetSlotPageId:])), binary.LittleEndian.Uint32(slot[offsetSlotSlotNum:]))
+}
+
+/**
+ * @return the number of entries in the bucket
+ */
+func (p *HashTableVarBucketPage) NumSlots() uint32 {
+	return uint32(binary.LittleEndian.Uint16(p.data[offsetVarSlotCount:]))
+}
+
+/**
+ * @return whether the bucket is empty
+ */
+func (p *HashTableVarBucketPage) IsEmpty() bool {
+	return p.NumSlots() == 0
+}
+
+/**
+ * @return the bytes left between the slots and the keys
+ */
+func (p *HashTableVarBucketPage) FreeSpace() uint32 {
+	return p.freeSpacePointer() - SizeVarBucketHeader - p.NumSlots()*SizeVarBucketSlot
+}
+
+/**
+ * @return whether an entry with a stored key of keyLen bytes fits in the bucket
+ */
+func (p *HashTableVarBucketPage) Fits(keyLen uint32) bool {
+	return keyLen+SizeVarBucketSlot <= p.FreeSpace()
+}
+
+// helper functions
+
+func (p *HashTableVarBucketPage) setNumSlots(n uint32) {
+	binary.LittleEndian.PutUint16(p.data[offsetVarSlotCount:], uint16(n))
+}
+
+// the free space pointer of a zeroed page is 0, which stands for the end of the page
+func (p *HashTableVarBucketPage) freeSpacePointer() uint32 {
+	if fsp := binary.LittleEndian.Uint16(p.data[offsetVarFreeSpace:]); fsp != 0 {
+		return uint32(fsp)
+	}
+	return common.PageSize
+}
+
+func (p *HashTableVarBucketPage) setFreeSpacePointer(fsp uint32) {
+	binary.LittleEndian.PutUint16(p.data[offsetVarFreeSpace:], uint16(fsp))
+}
+
+func (p *HashTableVarBucketPage) slot(slotIdx uint32) []byte {
+	offset := SizeVarBucketHeader + slotIdx*SizeVarBucketSlot
+	return p.data[offset : offset+SizeVarBucketSlot]
+}
+
+func (p *HashTableVarBucketPage) keyOffset(slotIdx uint32) uint32 {
+	return uint32(binary.LittleEndian.Uint16(p.slot(slotIdx)[offsetSlotKeyOffset:]))
+}
+
+func (p *HashTableVarBucketPage) keyLength(slotIdx uint32) uint32 {
+	return uint32(binary.LittleEndian.Uint16(p.slot(slotIdx)[offsetSlotKeyLength:]) &^ overflowFlag)
+}
+
+// get a reference to the key at slotIdx in this page
+func (p *HashTableVarBucketPage) keyAt(slotIdx uint32) []byte {
+	offset := p.keyOffset(slotIdx)
+	return p.data[offset : offset+p.keyLength(slotIdx)]
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package inverted
+
+import (
+	"goostub/common"
+	"goostub/storage/page"
+	"unsafe"
+)
+
+const (
+	// size of the header of a posting page
+	sizePostingHeader = 20
+	// size of a posting
+	sizePosting = 12
+	// postings a page holds
+	PostingsPerPage = (common.PageSize - sizePostingHeader) / sizePosting
+)
+
+/** An occurrence of a term: the tuple it is in and the position of the word in the text. */
+type Posting struct {
+	RID      common.RID
+	Position uint32
+}
+
+/**
+ * A page of the posting list of a term. The list is a chain of posting pages,
+ * new postings are appended to the last one, which the first page keeps track
+ * of.
+ *
+ *  Format (size in bytes):
+ *  -----------------------------------------------------------------------------------
+ *  | PageId (4) | LSN (4) | NextPageId (4) | LastPageId (4) | Size (4) | POSTINGS ... |
+ *  -----------------------------------------------------------------------------------
+ *
+ * A posting is | RID (8) | Position (4) |.
+ */
+type PostingPage struct {
+	pageId     common.PageID
+	lsn        common.LSN
+	nextPageId common.PageID
+	// only kept up to date in the first page of the list
+	lastPageId common.PageID
+	size       uint32
+	postings   [PostingsPerPage]Posting
+}
+
+// get a posting page pointer to existing page
+func PageAsPostingPage(page page.Page) *PostingPage {
+	return (*PostingPage)(unsafe.Pointer(&page.GetData()[0]))
+}
+
+/** Initialize an empty page, the last one of its list. */
+func (p *PostingPage) Init(pageId common.PageID) {
+	p.pageId = pageId
+	p.nextPageId = common.InvalidPageID
+	p.lastPageId = pageId
+	p.size = 0
+}
+
+func (p *PostingPage) GetPageId() common.PageID {
+	return p.pageId
+}
+
+func (p *PostingPage) GetLSN() common.LSN {
+	return p.lsn
+}
+
+func (p *PostingPage) SetLSN(lsn common.LSN) {
+	p.lsn = lsn
+}
+
+/** @return the next page of the list, common.InvalidPageID for the last one */
+func (p *PostingPage) GetNextPageId() common.PageID {
+	return p.nextPageId
+}
+
+func (p *PostingPage) SetNextPageId(pid common.PageID) {
+	p.nextPageId = pid
+}
+
+/** @return the last page of the list, only valid on the first page */
+func (p *PostingPage) GetLastPageId() common.PageID {
+	return p.lastPageId
+}
+
+func (p *PostingPage) SetLastPageId(pid common.PageID) {
+	p.lastPageId = pid
+}
+
+func (p *PostingPage) GetSize() uint32 {
+	return p.size
+}
+
+func (p *PostingPage) IsFull() bool {
+	return p.size == PostingsPerPage
+}
+
+func (p *PostingPage) GetPosting(idx uint32) Posting {
+	return p.postings[idx]
+}
+
+/** Add a posting after the others, the page must not be full. */
+func (p *PostingPage) Append(posting Posting) {
+	common.Assert.False(p.IsFull(), "the posting page is full")
+	p.postings[p.size] = posting
+	p.size++
+}
+
+/**
+ * Remove the postings of a tuple, the others keep their order.
+ * @return the number of postings removed
+ */
+func (p *PostingPage) RemoveRID(rid common.RID) uint32 {
+	kept := uint32(0)
+	for idx := uint32(0); idx < p.size; idx++ {
+		if p.postings[idx].RID != rid {
+			p.postings[kept] = p.postings[idx]
+			kept++
+		}
+	}
+	removed := p.size - kept
+	p.size = kept
+	return removed
+}
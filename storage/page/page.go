@@ -27,6 +27,7 @@ type Page interface {
 	WUnlatch()
 	RLatch()
 	RUnlatch()
+	TryRLatch() bool
 }
 
 // Clumsy Go implementation of
@@ -102,3 +103,7 @@ func (p *PageInstance) RLatch() {
 func (p *PageInstance) RUnlatch() {
 	p.RWLatch.RUnlock()
 }
+
+func (p *PageInstance) TryRLatch() bool {
+	return p.RWLatch.TryRLock()
+}
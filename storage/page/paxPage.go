@@ -0,0 +1,356 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package page
+
+import (
+	"goostub/common"
+	"unsafe"
+)
+
+const (
+	// size of the fixed part of the PAX page header and of one column entry
+	SizePaxPageHeader = 32
+	SizePaxColumn     = 8
+
+	offsetPaxPrevPageId  = 8
+	offsetPaxNextPageId  = 12
+	offsetPaxFreeSpace   = 16
+	offsetPaxTupleCount  = 20
+	offsetPaxCapacity    = 24
+	offsetPaxColumnCount = 28
+	offsetPaxColumns     = 32
+
+	paxVarlenFlag = 1 << 31
+	// a variable-length value is stored as offset (4) | size (4) in its minipage
+	paxVarlenSlotSize = 8
+	// space set aside for each variable-length value when sizing the minipages
+	paxVarlenReserve = 32
+)
+
+/**
+ * PAX (Partition Attributes Across) page format:
+ *  ------------------------------------------------------------------------------
+ *  | HEADER | DELETE BITMAP | MINIPAGE 1 | ... | MINIPAGE n | FREE | VARLEN DATA |
+ *  ------------------------------------------------------------------------------
+ *                                                            ^
+ *                                                            free space pointer
+ *
+ *  Header format (size in bytes):
+ *  ----------------------------------------------------------------------------
+ *  | PageId (4)| LSN (4)| PrevPageId (4)| NextPageId (4)| FreeSpacePointer(4) |
+ *  ----------------------------------------------------------------------------
+ *  -------------------------------------------------------------------------------------------
+ *  | TupleCount (4) | Capacity (4) | ColumnCount (4) | Column_1 width (4) | Column_1 offset (4) | ... |
+ *  -------------------------------------------------------------------------------------------
+ *
+ *  Minipage format:
+ *  --------------------------------------------------------
+ *  | NULL BITMAP | Value_1 | Value_2 | ... | Value_capacity |
+ *  --------------------------------------------------------
+ *
+ * A minipage holds the values of one column for all tuples of the page, so a
+ * scan of a few columns only touches their minipages. Capacity is fixed when
+ * the page is initialized. Variable-length values keep their offset and size
+ * in the minipage and their data at the end of the page, which grows down
+ * like the tuples of a slotted page. Deleted tuples only set their bit in the
+ * delete bitmap, their slots are not reused.
+ */
+type PaxPage interface {
+	Page
+	Init(pageId common.PageID, prevPageId common.PageID, columns []PaxColumn) bool
+
+	GetTablePageId() common.PageID
+	GetPrevPageId() common.PageID
+	GetNextPageId() common.PageID
+	SetPrevPageId(common.PageID)
+	SetNextPageId(common.PageID)
+
+	InsertTuple(values [][]byte, slot *uint32) bool
+	MarkDelete(slot uint32) bool
+	IsDeleted(slot uint32) bool
+	GetValue(slot uint32, colIdx uint32) []byte
+
+	GetTupleCount() uint32
+	GetCapacity() uint32
+	GetColumnCount() uint32
+	GetFreeSpaceRemaining() uint32
+}
+
+/** A column of a PAX page. */
+type PaxColumn struct {
+	// size of the values of the column, 0 if they have variable length
+	Width uint32
+}
+
+type paxPage struct {
+	Page
+}
+
+// get a PAX page pointer to existing page
+func PageAsPaxPage(p Page) PaxPage {
+	return &paxPage{Page: p}
+}
+
+/**
+ * Initialize the PAX page header and lay out the minipages.
+ * @param pageId the page ID of this page
+ * @param prevPageId the previous page ID
+ * @param columns the columns of the tuples
+ * @return false if there are no columns or not even one tuple fits in a page
+ */
+func (p *paxPage) Init(pageId common.PageID, prevPageId common.PageID, columns []PaxColumn) bool {
+	if len(columns) == 0 {
+		return false
+	}
+	headerSize := uint32(offsetPaxColumns + len(columns)*SizePaxColumn)
+	if headerSize >= common.PageSize {
+		return false
+	}
+	// bytes per tuple, bitmaps aside, with room for the variable-length data
+	rowSize := uint32(0)
+	for _, col := range columns {
+		if col.Width == 0 {
+			rowSize += paxVarlenSlotSize + paxVarlenReserve
+		} else {
+			rowSize += col.Width
+		}
+	}
+	// one delete bit and one null bit per column for each tuple
+	available := common.PageSize - headerSize
+	capacity := available * 8 / (rowSize*8 + 1 + uint32(len(columns)))
+	for capacity > 0 && paxLayoutSize(capacity, columns)+headerSize > common.PageSize {
+		capacity--
+	}
+	if capacity == 0 {
+		return false
+	}
+
+	p.setUint32(offsetPageStart, uint32(pageId))
+	p.SetPrevPageId(prevPageId)
+	p.SetNextPageId(common.InvalidPageID)
+	p.setUint32(offsetPaxFreeSpace, common.PageSize)
+	p.setUint32(offsetPaxTupleCount, 0)
+	p.setUint32(offsetPaxCapacity, capacity)
+	p.setUint32(offsetPaxColumnCount, uint32(len(columns)))
+
+	bitmapSize := (capacity + 7) / 8
+	offset := headerSize + bitmapSize
+	for i, col := range columns {
+		width := col.Width
+		if width == 0 {
+			width = paxVarlenSlotSize | paxVarlenFlag
+		}
+		p.setUint32(p.columnEntry(uint32(i)), width)
+		p.setUint32(p.columnEntry(uint32(i))+4, offset)
+		offset += bitmapSize + capacity*paxSlotSize(col)
+	}
+	// the bitmaps start cleared, the page may have held something else
+	data := p.GetData()
+	for i := headerSize; i < offset; i++ {
+		data[i] = 0
+	}
+	return true
+}
+
+// size of the delete bitmap and the minipages for capacity tuples
+func paxLayoutSize(capacity uint32, columns []PaxColumn) uint32 {
+	bitmapSize := (capacity + 7) / 8
+	size := bitmapSize
+	for _, col := range columns {
+		size += bitmapSize + capacity*paxSlotSize(col)
+		if col.Width == 0 {
+			size += capacity * paxVarlenReserve
+		}
+	}
+	return size
+}
+
+func paxSlotSize(col PaxColumn) uint32 {
+	if col.Width == 0 {
+		return paxVarlenSlotSize
+	}
+	return col.Width
+}
+
+/** @return the page ID of this page */
+func (p *paxPage) GetTablePageId() common.PageID {
+	return common.PageID(p.getUint32(offsetPageStart))
+}
+
+/** @return the page ID of the previous page */
+func (p *paxPage) GetPrevPageId() common.PageID {
+	return common.PageID(p.getUint32(offsetPaxPrevPageId))
+}
+
+/** @return the page ID of the next page */
+func (p *paxPage) GetNextPageId() common.PageID {
+	return common.PageID(p.getUint32(offsetPaxNextPageId))
+}
+
+/** Set the page id of the previous page in the table. */
+func (p *paxPage) SetPrevPageId(prevPageId common.PageID) {
+	p.setUint32(offsetPaxPrevPageId, uint32(prevPageId))
+}
+
+/** Set the page id of the next page in the table. */
+func (p *paxPage) SetNextPageId(nextPageId common.PageID) {
+	p.setUint32(offsetPaxNextPageId, uint32(nextPageId))
+}
+
+/** @return the number of tuples in the page, deleted ones included */
+func (p *paxPage) GetTupleCount() uint32 {
+	return p.getUint32(offsetPaxTupleCount)
+}
+
+/** @return the number of tuples the page can hold */
+func (p *paxPage) GetCapacity() uint32 {
+	return p.getUint32(offsetPaxCapacity)
+}
+
+func (p *paxPage) GetColumnCount() uint32 {
+	return p.getUint32(offsetPaxColumnCount)
+}
+
+/** @return the space left for variable-length data */
+func (p *paxPage) GetFreeSpaceRemaining() uint32 {
+	return p.getUint32(offsetPaxFreeSpace) - p.minipagesEnd()
+}
+
+/**
+ * Insert a tuple into the page.
+ * @param values the serialized value of each column, nil for NULL. Fixed-length
+ * values must have the width of their column.
+ * @param[out] slot slot of the inserted tuple
+ * @return true if the insert is successful (i.e. there is enough space)
+ */
+func (p *paxPage) InsertTuple(values [][]byte, slot *uint32) bool {
+	numColumns := p.GetColumnCount()
+	common.Assert.Equal(uint32(len(values)), numColumns, "Wrong number of values.")
+	count := p.GetTupleCount()
+	if count == p.GetCapacity() {
+		return false
+	}
+	varlenSize := uint32(0)
+	for i, val := range values {
+		if val != nil && p.isVarlen(uint32(i)) {
+			varlenSize += uint32(len(val))
+		}
+	}
+	if p.GetFreeSpaceRemaining() < varlenSize {
+		return false
+	}
+
+	data := p.GetData()
+	for i, val := range values {
+		col := uint32(i)
+		if val == nil {
+			setBit(data[p.minipage(col):], count)
+			continue
+		}
+		pos := p.valueOffset(col, count)
+		if p.isVarlen(col) {
+			free := p.getUint32(offsetPaxFreeSpace) - uint32(len(val))
+			copy(data[free:], val)
+			p.setUint32(offsetPaxFreeSpace, free)
+			p.setUint32(pos, free)
+			p.setUint32(pos+4, uint32(len(val)))
+		} else {
+			common.Assert.Equal(uint32(len(val)), p.width(col), "Wrong value size.")
+			copy(data[pos:], val)
+		}
+	}
+	*slot = count
+	p.setUint32(offsetPaxTupleCount, count+1)
+	return true
+}
+
+/**
+ * Mark a tuple as deleted.
+ * @param slot slot of the tuple
+ * @return false if there is no such tuple or it is already deleted
+ */
+func (p *paxPage) MarkDelete(slot uint32) bool {
+	if slot >= p.GetTupleCount() || p.IsDeleted(slot) {
+		return false
+	}
+	setBit(p.GetData()[p.deleteBitmap():], slot)
+	return true
+}
+
+/** @return true if the tuple is deleted or doesn't exist */
+func (p *paxPage) IsDeleted(slot uint32) bool {
+	if slot >= p.GetTupleCount() {
+		return true
+	}
+	return getBit(p.GetData()[p.deleteBitmap():], slot)
+}
+
+/**
+ * Read one value of a tuple, without looking at the other columns.
+ * @param slot slot of the tuple
+ * @param colIdx the column
+ * @return the serialized value, nil if it is NULL
+ */
+func (p *paxPage) GetValue(slot uint32, colIdx uint32) []byte {
+	data := p.GetData()
+	if getBit(data[p.minipage(colIdx):], slot) {
+		return nil
+	}
+	pos := p.valueOffset(colIdx, slot)
+	if p.isVarlen(colIdx) {
+		offset := p.getUint32(pos)
+		return data[offset : offset+p.getUint32(pos+4)]
+	}
+	return data[pos : pos+p.width(colIdx)]
+}
+
+func (p *paxPage) columnEntry(colIdx uint32) uint32 {
+	return offsetPaxColumns + colIdx*SizePaxColumn
+}
+
+func (p *paxPage) deleteBitmap() uint32 {
+	return p.columnEntry(p.GetColumnCount())
+}
+
+func (p *paxPage) width(colIdx uint32) uint32 {
+	return p.getUint32(p.columnEntry(colIdx)) &^ paxVarlenFlag
+}
+
+func (p *paxPage) isVarlen(colIdx uint32) bool {
+	return p.getUint32(p.columnEntry(colIdx))&paxVarlenFlag != 0
+}
+
+// start of the minipage of the column, i.e. of its null bitmap
+func (p *paxPage) minipage(colIdx uint32) uint32 {
+	return p.getUint32(p.columnEntry(colIdx) + 4)
+}
+
+func (p *paxPage) valueOffset(colIdx uint32, slot uint32) uint32 {
+	return p.minipage(colIdx) + (p.GetCapacity()+7)/8 + slot*p.width(colIdx)
+}
+
+func (p *paxPage) minipagesEnd() uint32 {
+	last := p.GetColumnCount() - 1
+	return p.valueOffset(last, p.GetCapacity())
+}
+
+func (p *paxPage) getUint32(offset uint32) uint32 {
+	data := p.GetData()
+	return *(*uint32)(unsafe.Pointer(&data[offset]))
+}
+
+func (p *paxPage) setUint32(offset uint32, val uint32) {
+	data := p.GetData()
+	*(*uint32)(unsafe.Pointer(&data[offset])) = val
+}
+
+func getBit(bitmap []byte, i uint32) bool {
+	return bitmap[i/8]&(1<<(i%8)) != 0
+}
+
+func setBit(bitmap []byte, i uint32) {
+	bitmap[i/8] |= 1 << (i % 8)
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package page
+
+import (
+	"github.com/stretchr/testify/assert"
+	"goostub/common"
+	"testing"
+)
+
+func TestPaxPage(t *testing.T) {
+	a := assert.New(t)
+	p := PageAsPaxPage(NewPage())
+	a.False(p.Init(0, common.InvalidPageID, nil))
+	a.True(p.Init(3, common.InvalidPageID, []PaxColumn{{Width: 4}, {Width: 0}, {Width: 1}}))
+	a.Equal(common.PageID(3), p.GetTablePageId())
+	a.Equal(uint32(3), p.GetColumnCount())
+	capacity := p.GetCapacity()
+	a.Greater(capacity, uint32(0))
+
+	// fill the page, every other tuple has a NULL and a long string
+	var slot uint32
+	n := uint32(0)
+	for ; ; n++ {
+		name := []byte("short")
+		if n%2 == 1 {
+			name = make([]byte, 80)
+		}
+		values := [][]byte{{byte(n), 0, 0, 0}, name, {1}}
+		if n%2 == 1 {
+			values[2] = nil
+		}
+		if !p.InsertTuple(values, &slot) {
+			break
+		}
+		a.Equal(n, slot)
+	}
+	// the long strings use more than their share of the space
+	a.Less(n, capacity)
+	a.Equal(n, p.GetTupleCount())
+
+	for i := uint32(0); i < n; i++ {
+		a.Equal([]byte{byte(i), 0, 0, 0}, p.GetValue(i, 0))
+		if i%2 == 1 {
+			a.Len(p.GetValue(i, 1), 80)
+			a.Nil(p.GetValue(i, 2))
+		} else {
+			a.Equal([]byte("short"), p.GetValue(i, 1))
+			a.Equal([]byte{1}, p.GetValue(i, 2))
+		}
+	}
+
+	a.True(p.MarkDelete(2))
+	a.False(p.MarkDelete(2))
+	a.False(p.MarkDelete(n))
+	a.True(p.IsDeleted(2))
+	a.False(p.IsDeleted(1))
+	a.True(p.IsDeleted(n))
+}
@@ -1,5 +1,5 @@
 // Copyright (c) 2021 Qitian Zeng
-// 
+//
 // This software is released under the MIT License.
 // https://opensource.org/licenses/MIT
 
@@ -7,10 +7,23 @@ package page
 
 import (
 	"goostub/common"
+	"goostub/concurrency"
+	"unsafe"
 )
 
 const (
 	deleteMask = (1 << 31)
+
+	// size of the fixed part of the table page header and of one slot entry
+	SizeTablePageHeader = 24
+	SizeTableSlot       = 8
+
+	offsetPrevPageId  = 8
+	offsetNextPageId  = 12
+	offsetFreeSpace   = 16
+	offsetTupleCount  = 20
+	offsetTupleOffset = 24
+	offsetTupleSize   = 28
 )
 
 /**
@@ -31,9 +44,69 @@ const (
  *
  */
 
+/**
+ * Different from BusTub: the page package can't import storage/table (table
+ * imports page), so tuples are passed around as raw byte sequences here and
+ * the TableHeap wraps them into table.Tuple.
+ */
 type TablePage interface {
 	Page
 	Init(common.PageID, uint32, common.PageID)
+
+	GetTablePageId() common.PageID
+	GetPrevPageId() common.PageID
+	GetNextPageId() common.PageID
+	SetPrevPageId(common.PageID)
+	SetNextPageId(common.PageID)
+
+	InsertTuple(tuple []byte, rid *common.RID, txn common.Transaction, lockManager *concurrency.LockManager) bool
+	MarkDelete(rid common.RID, txn common.Transaction, lockManager *concurrency.LockManager) bool
+	UpdateTuple(newTuple []byte, oldTuple *[]byte, rid common.RID, txn common.Transaction, lockManager *concurrency.LockManager) bool
+	ApplyDelete(rid common.RID, txn common.Transaction)
+	RollbackDelete(rid common.RID, txn common.Transaction)
+	GetTuple(rid common.RID, txn common.Transaction, lockManager *concurrency.LockManager) ([]byte, bool)
+	ReadTuple(rid common.RID) ([]byte, bool, bool)
+	GetFirstTupleRid(firstRid *common.RID) bool
+	GetNextTupleRid(curRid common.RID, nextRid *common.RID) bool
+	CompactSlots() ([]SlotMove, uint32)
+
+	GetFreeSpacePointer() uint32
+	GetTupleCount() uint32
+	GetTupleOffsetAtSlot(slotNum uint32) uint32
+	GetTupleSize(slotNum uint32) uint32
+	GetFreeSpaceRemaining() uint32
+}
+
+/** A tuple that changed slots during CompactSlots. */
+type SlotMove struct {
+	From uint32
+	To   uint32
+}
+
+type tablePage struct {
+	Page
+}
+
+// get a table page pointer to existing page
+func PageAsTablePage(p Page) TablePage {
+	return &tablePage{Page: p}
+}
+
+/**
+ * @return true if the tuple is deleted or empty
+ */
+func IsDeleted(tupleSize uint32) bool {
+	return tupleSize&deleteMask != 0 || tupleSize == 0
+}
+
+/** @return tuple size with the deleted flag set */
+func SetDeletedFlag(tupleSize uint32) uint32 {
+	return tupleSize | deleteMask
+}
+
+/** @return tuple size with the deleted flag unset */
+func UnsetDeletedFlag(tupleSize uint32) uint32 {
+	return tupleSize & (^uint32(deleteMask))
 }
 
 /**
@@ -41,6 +114,443 @@ type TablePage interface {
 * @param page_id the page ID of this table page
 * @param page_size the size of this table page
 * @param prev_page_id the previous table page ID
-* @param log_manager the log manager in use
-* @param txn the transaction that this page is created in
  */
+func (p *tablePage) Init(pageId common.PageID, pageSize uint32, prevPageId common.PageID) {
+	p.setUint32(offsetPageStart, uint32(pageId))
+	p.SetPrevPageId(prevPageId)
+	p.SetNextPageId(common.InvalidPageID)
+	p.setFreeSpacePointer(pageSize)
+	p.setTupleCount(0)
+}
+
+/** @return the page ID of this table page */
+func (p *tablePage) GetTablePageId() common.PageID {
+	return common.PageID(p.getUint32(offsetPageStart))
+}
+
+/** @return the page ID of the previous table page */
+func (p *tablePage) GetPrevPageId() common.PageID {
+	return common.PageID(p.getUint32(offsetPrevPageId))
+}
+
+/** @return the page ID of the next table page */
+func (p *tablePage) GetNextPageId() common.PageID {
+	return common.PageID(p.getUint32(offsetNextPageId))
+}
+
+/** Set the page id of the previous page in the table. */
+func (p *tablePage) SetPrevPageId(prevPageId common.PageID) {
+	p.setUint32(offsetPrevPageId, uint32(prevPageId))
+}
+
+/** Set the page id of the next page in the table. */
+func (p *tablePage) SetNextPageId(nextPageId common.PageID) {
+	p.setUint32(offsetNextPageId, uint32(nextPageId))
+}
+
+/**
+ * Insert a tuple into the table.
+ * @param tuple tuple to insert
+ * @param[out] rid rid of the inserted tuple
+ * @param txn transaction performing the insert
+ * @param lockManager the lock manager
+ * @return true if the insert is successful (i.e. there is enough space)
+ */
+func (p *tablePage) InsertTuple(tuple []byte, rid *common.RID, txn common.Transaction, lockManager *concurrency.LockManager) bool {
+	common.Assert.Greater(len(tuple), 0, "Cannot have empty tuples.")
+	size := uint32(len(tuple))
+
+	// If there is not enough space, then return false.
+	if p.GetFreeSpaceRemaining() < size+SizeTableSlot {
+		return false
+	}
+
+	// Try to find a free slot to reuse.
+	var i uint32
+	for i = 0; i < p.GetTupleCount(); i++ {
+		// If the slot is empty, i.e. its tuple has size 0,
+		if p.GetTupleSize(i) == 0 {
+			// Then we break out of the loop at index i.
+			break
+		}
+	}
+
+	// If there was no free slot left, and we cannot claim it from the free space, then we give up.
+	if i == p.GetTupleCount() && p.GetFreeSpaceRemaining() < size+SizeTableSlot {
+		return false
+	}
+
+	// Otherwise we claim available free space..
+	p.setFreeSpacePointer(p.GetFreeSpacePointer() - size)
+	copy(p.GetData()[p.GetFreeSpacePointer():], tuple)
+
+	// Set the tuple.
+	p.setTupleOffsetAtSlot(i, p.GetFreeSpacePointer())
+	p.setTupleSize(i, size)
+
+	rid.Set(p.GetTablePageId(), i)
+	if i == p.GetTupleCount() {
+		p.setTupleCount(p.GetTupleCount() + 1)
+	}
+
+	return true
+}
+
+/**
+ * Mark a tuple as deleted. This does not actually delete the tuple.
+ * @param rid rid of the tuple to mark as deleted
+ * @param txn transaction performing the delete
+ * @param lockManager the lock manager
+ * @return true if marking the tuple as deleted is successful (i.e the tuple exists)
+ */
+func (p *tablePage) MarkDelete(rid common.RID, txn common.Transaction, lockManager *concurrency.LockManager) bool {
+	slotNum := rid.GetSlotNum()
+	// If the slot number is invalid, abort the transaction.
+	if slotNum >= p.GetTupleCount() {
+		if txn != nil {
+			txn.SetState(common.Aborted)
+		}
+		return false
+	}
+
+	tupleSize := p.GetTupleSize(slotNum)
+	// If the tuple is already deleted, abort the transaction.
+	if IsDeleted(tupleSize) {
+		if txn != nil {
+			txn.SetState(common.Aborted)
+		}
+		return false
+	}
+
+	if !acquireExclusive(rid, txn, lockManager) {
+		return false
+	}
+
+	p.setTupleSize(slotNum, SetDeletedFlag(tupleSize))
+	return true
+}
+
+/**
+ * Update a tuple.
+ * @param newTuple new value of the tuple
+ * @param[out] oldTuple old value of the tuple
+ * @param rid rid of the tuple
+ * @param txn transaction performing the update
+ * @param lockManager the lock manager
+ * @return true if updating the tuple succeeded
+ */
+func (p *tablePage) UpdateTuple(newTuple []byte, oldTuple *[]byte, rid common.RID, txn common.Transaction, lockManager *concurrency.LockManager) bool {
+	common.Assert.Greater(len(newTuple), 0, "Cannot have empty tuples.")
+	slotNum := rid.GetSlotNum()
+	// If the slot number is invalid, abort the transaction.
+	if slotNum >= p.GetTupleCount() {
+		if txn != nil {
+			txn.SetState(common.Aborted)
+		}
+		return false
+	}
+
+	tupleSize := p.GetTupleSize(slotNum)
+	// If the tuple is deleted, abort the transaction.
+	if IsDeleted(tupleSize) {
+		if txn != nil {
+			txn.SetState(common.Aborted)
+		}
+		return false
+	}
+
+	newSize := uint32(len(newTuple))
+	// If there is not enough space to update, we need to update via delete followed by an insert (not enough space).
+	if p.GetFreeSpaceRemaining()+tupleSize < newSize {
+		return false
+	}
+
+	if !acquireExclusive(rid, txn, lockManager) {
+		return false
+	}
+
+	// Copy out the old value.
+	tupleOffset := p.GetTupleOffsetAtSlot(slotNum)
+	data := p.GetData()
+	if oldTuple != nil {
+		*oldTuple = make([]byte, tupleSize)
+		copy(*oldTuple, data[tupleOffset:tupleOffset+tupleSize])
+	}
+
+	// Perform the update.
+	freeSpacePointer := p.GetFreeSpacePointer()
+	common.Assert.LessOrEqual(tupleOffset, uint32(common.PageSize), "Offset should appear after current free space position.")
+	common.Assert.GreaterOrEqual(tupleOffset, freeSpacePointer, "Offset should appear after current free space position.")
+
+	copy(data[freeSpacePointer+tupleSize-newSize:], data[freeSpacePointer:tupleOffset])
+	p.setFreeSpacePointer(freeSpacePointer + tupleSize - newSize)
+	copy(data[tupleOffset+tupleSize-newSize:], newTuple)
+	p.setTupleSize(slotNum, newSize)
+
+	// Update all tuple offsets.
+	p.shiftTupleOffsets(tupleOffset, tupleSize-newSize)
+	p.setTupleOffsetAtSlot(slotNum, tupleOffset+tupleSize-newSize)
+	return true
+}
+
+/** To be called on commit or abort. Actually perform the delete or rollback an insert. */
+func (p *tablePage) ApplyDelete(rid common.RID, txn common.Transaction) {
+	slotNum := rid.GetSlotNum()
+	common.Assert.Less(slotNum, p.GetTupleCount(), "Cannot have more slots than tuples.")
+
+	tupleOffset := p.GetTupleOffsetAtSlot(slotNum)
+	tupleSize := p.GetTupleSize(slotNum)
+	// Check if this is a delete operation, i.e. commit a delete.
+	if tupleSize&deleteMask != 0 {
+		tupleSize = UnsetDeletedFlag(tupleSize)
+	}
+	// Otherwise we are rolling back an insert.
+
+	freeSpacePointer := p.GetFreeSpacePointer()
+	common.Assert.GreaterOrEqual(tupleOffset, freeSpacePointer, "Free space appears before tuples.")
+
+	data := p.GetData()
+	copy(data[freeSpacePointer+tupleSize:], data[freeSpacePointer:tupleOffset])
+	p.setFreeSpacePointer(freeSpacePointer + tupleSize)
+	p.setTupleSize(slotNum, 0)
+	p.setTupleOffsetAtSlot(slotNum, 0)
+
+	// Update all tuple offsets.
+	p.shiftTupleOffsets(tupleOffset, tupleSize)
+}
+
+/** To be called on abort. Rollback a delete, i.e. this reverses a MarkDelete. */
+func (p *tablePage) RollbackDelete(rid common.RID, txn common.Transaction) {
+	slotNum := rid.GetSlotNum()
+	common.Assert.Less(slotNum, p.GetTupleCount(), "We can't have more slots than tuples.")
+	tupleSize := p.GetTupleSize(slotNum)
+
+	// Unset the deleted flag.
+	if tupleSize&deleteMask != 0 {
+		p.setTupleSize(slotNum, UnsetDeletedFlag(tupleSize))
+	}
+}
+
+/**
+ * Read a tuple from a table.
+ * @param rid rid of the tuple to read
+ * @param txn transaction performing the read
+ * @param lockManager the lock manager
+ * @return the tuple data, and whether the read is successful (i.e. the tuple exists)
+ */
+func (p *tablePage) GetTuple(rid common.RID, txn common.Transaction, lockManager *concurrency.LockManager) ([]byte, bool) {
+	slotNum := rid.GetSlotNum()
+	// If somehow we have more slots than tuples, abort the transaction.
+	if slotNum >= p.GetTupleCount() {
+		if txn != nil {
+			txn.SetState(common.Aborted)
+		}
+		return nil, false
+	}
+
+	tupleSize := p.GetTupleSize(slotNum)
+	// If the tuple is deleted, abort the transaction that cannot read the tuple.
+	if IsDeleted(tupleSize) {
+		if txn != nil && !txn.IsExclusiveLocked(rid) {
+			txn.SetState(common.Aborted)
+		}
+		return nil, false
+	}
+
+	if !acquireShared(rid, txn, lockManager) {
+		return nil, false
+	}
+
+	tupleOffset := p.GetTupleOffsetAtSlot(slotNum)
+	tuple := make([]byte, tupleSize)
+	copy(tuple, p.GetData()[tupleOffset:tupleOffset+tupleSize])
+	return tuple, true
+}
+
+/**
+ * Read a tuple without taking locks, even if it is marked as deleted. This is
+ * for MVCC readers, which decide on their own which tuples they see.
+ * @param rid rid of the tuple to read
+ * @return the tuple data, whether it is marked as deleted, and false if the slot is empty
+ */
+func (p *tablePage) ReadTuple(rid common.RID) ([]byte, bool, bool) {
+	slotNum := rid.GetSlotNum()
+	if slotNum >= p.GetTupleCount() {
+		return nil, false, false
+	}
+	tupleSize := p.GetTupleSize(slotNum)
+	if tupleSize == 0 {
+		return nil, false, false
+	}
+
+	size := UnsetDeletedFlag(tupleSize)
+	tupleOffset := p.GetTupleOffsetAtSlot(slotNum)
+	tuple := make([]byte, size)
+	copy(tuple, p.GetData()[tupleOffset:tupleOffset+size])
+	return tuple, tupleSize&deleteMask != 0, true
+}
+
+/**
+ * @param[out] firstRid the RID of the first tuple in this page
+ * @return true if the first tuple exists, false otherwise
+ */
+func (p *tablePage) GetFirstTupleRid(firstRid *common.RID) bool {
+	// Find and return the first valid tuple.
+	for i := uint32(0); i < p.GetTupleCount(); i++ {
+		if !IsDeleted(p.GetTupleSize(i)) {
+			firstRid.Set(p.GetTablePageId(), i)
+			return true
+		}
+	}
+	firstRid.Set(common.InvalidPageID, 0)
+	return false
+}
+
+/**
+ * @param curRid the RID of the current tuple
+ * @param[out] nextRid the RID of the tuple following the current tuple
+ * @return true if the next tuple exists, false otherwise
+ */
+func (p *tablePage) GetNextTupleRid(curRid common.RID, nextRid *common.RID) bool {
+	common.Assert.Equal(p.GetTablePageId(), curRid.GetPageId(), "cur_rid must be in this table")
+	// Find and return the first valid tuple after our current slot number.
+	for i := curRid.GetSlotNum() + 1; i < p.GetTupleCount(); i++ {
+		if !IsDeleted(p.GetTupleSize(i)) {
+			nextRid.Set(p.GetTablePageId(), i)
+			return true
+		}
+	}
+	// Otherwise return false as there are no more tuples.
+	nextRid.Set(common.InvalidPageID, 0)
+	return false
+}
+
+/**
+ * Compact the slot array: live tuples at the end of the array move into the
+ * empty slots before them, then the empty slots left at the end are dropped.
+ * Only slot entries move, the tuple data stays where it is. Tuples marked as
+ * deleted keep their slots since a transaction still refers to them.
+ * The caller must make sure no one else refers to the RIDs of the moved tuples.
+ * @return the tuples that changed slots, and the number of slots dropped
+ */
+func (p *tablePage) CompactSlots() ([]SlotMove, uint32) {
+	var moves []SlotMove
+	count := p.GetTupleCount()
+	// hi only goes down, so a slot emptied by a move is never filled again
+	hi := count
+	for lo := uint32(0); lo < hi; lo++ {
+		if p.GetTupleSize(lo) != 0 {
+			continue
+		}
+		for hi > lo+1 && IsDeleted(p.GetTupleSize(hi-1)) {
+			hi--
+		}
+		if hi <= lo+1 {
+			break
+		}
+		hi--
+		p.setTupleOffsetAtSlot(lo, p.GetTupleOffsetAtSlot(hi))
+		p.setTupleSize(lo, p.GetTupleSize(hi))
+		p.setTupleOffsetAtSlot(hi, 0)
+		p.setTupleSize(hi, 0)
+		moves = append(moves, SlotMove{From: hi, To: lo})
+	}
+
+	newCount := count
+	for newCount > 0 && p.GetTupleSize(newCount-1) == 0 {
+		newCount--
+	}
+	p.setTupleCount(newCount)
+	return moves, count - newCount
+}
+
+/** @return pointer to the end of the current free space, see header comment */
+func (p *tablePage) GetFreeSpacePointer() uint32 {
+	return p.getUint32(offsetFreeSpace)
+}
+
+/** @return the number of tuples (slots, including empty ones) in this page */
+func (p *tablePage) GetTupleCount() uint32 {
+	return p.getUint32(offsetTupleCount)
+}
+
+/** @return tuple offset at slot slotNum */
+func (p *tablePage) GetTupleOffsetAtSlot(slotNum uint32) uint32 {
+	return p.getUint32(offsetTupleOffset + SizeTableSlot*slotNum)
+}
+
+/** @return tuple size at slot slotNum, with the deleted flag if any */
+func (p *tablePage) GetTupleSize(slotNum uint32) uint32 {
+	return p.getUint32(offsetTupleSize + SizeTableSlot*slotNum)
+}
+
+/** @return the amount of free space available */
+func (p *tablePage) GetFreeSpaceRemaining() uint32 {
+	return p.GetFreeSpacePointer() - SizeTablePageHeader - SizeTableSlot*p.GetTupleCount()
+}
+
+// helper functions
+
+func (p *tablePage) setFreeSpacePointer(freeSpacePointer uint32) {
+	p.setUint32(offsetFreeSpace, freeSpacePointer)
+}
+
+func (p *tablePage) setTupleCount(tupleCount uint32) {
+	p.setUint32(offsetTupleCount, tupleCount)
+}
+
+func (p *tablePage) setTupleOffsetAtSlot(slotNum uint32, offset uint32) {
+	p.setUint32(offsetTupleOffset+SizeTableSlot*slotNum, offset)
+}
+
+func (p *tablePage) setTupleSize(slotNum uint32, size uint32) {
+	p.setUint32(offsetTupleSize+SizeTableSlot*slotNum, size)
+}
+
+// every live tuple stored before the moved tuple slides by delta bytes
+func (p *tablePage) shiftTupleOffsets(movedOffset uint32, delta uint32) {
+	for i := uint32(0); i < p.GetTupleCount(); i++ {
+		offset := p.GetTupleOffsetAtSlot(i)
+		if p.GetTupleSize(i) != 0 && offset < movedOffset {
+			p.setTupleOffsetAtSlot(i, offset+delta)
+		}
+	}
+}
+
+func (p *tablePage) getUint32(offset uint32) uint32 {
+	data := p.GetData()
+	return *(*uint32)(unsafe.Pointer(&data[offset]))
+}
+
+func (p *tablePage) setUint32(offset uint32, val uint32) {
+	data := p.GetData()
+	*(*uint32)(unsafe.Pointer(&data[offset])) = val
+}
+
+// take an exclusive lock on rid unless the transaction already holds one
+func acquireExclusive(rid common.RID, txn common.Transaction, lockManager *concurrency.LockManager) bool {
+	if txn == nil || lockManager == nil {
+		return true
+	}
+	if txn.IsExclusiveLocked(rid) {
+		return true
+	}
+	if txn.IsSharedLocked(rid) {
+		return lockManager.LockUpgrade(txn, rid)
+	}
+	return lockManager.LockExclusive(txn, rid)
+}
+
+// take a shared lock on rid unless the isolation level or an existing lock makes it unnecessary
+func acquireShared(rid common.RID, txn common.Transaction, lockManager *concurrency.LockManager) bool {
+	if txn == nil || lockManager == nil {
+		return true
+	}
+	if txn.GetIsolationLevel() == common.ReadUncommitted {
+		return true
+	}
+	if txn.IsSharedLocked(rid) || txn.IsExclusiveLocked(rid) {
+		return true
+	}
+	return lockManager.LockShared(txn, rid)
+}
@@ -0,0 +1,316 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package table
+
+import (
+	"bytes"
+	"github.com/go-kit/kit/log/level"
+	"goostub/buffer"
+	"goostub/common"
+	"goostub/schema"
+	"goostub/storage/page"
+	"goostub/types"
+	"sync"
+)
+
+/**
+ * ColumnarTable stores a table in PAX pages, a doubly-linked list of them like
+ * TableHeap. Each page keeps the values of a column together, so a scan that
+ * projects a few columns of a wide table reads just those values.
+ *
+ * The table is meant for analytic data: tuples are appended and can be
+ * deleted, but not updated, and the space of deleted tuples isn't reused.
+ * There is no locking or versioning for transactions.
+ */
+type ColumnarTable struct {
+	bpm         *buffer.BufferPoolManager
+	schema      *schema.Schema
+	columns     []page.PaxColumn
+	firstPageId common.PageID
+	// serializes inserts, they all go to the last page
+	appendLatch sync.Mutex
+	lastPageId  common.PageID
+}
+
+/**
+ * Create a columnar table.
+ * @param bpm the buffer pool manager
+ * @param s the schema of the tuples
+ * @return the table, nil if a tuple of the schema doesn't fit in a page
+ */
+func NewColumnarTable(bpm *buffer.BufferPoolManager, s *schema.Schema) *ColumnarTable {
+	t := newColumnarTable(bpm, s)
+	pp := t.newPage(&t.firstPageId, common.InvalidPageID)
+	if pp == nil {
+		return nil
+	}
+	t.lastPageId = t.firstPageId
+	bpm.UnpinPage(t.firstPageId, true, nil)
+	return t
+}
+
+/**
+ * Open a columnar table, walking the page chain once to find its end.
+ * @param bpm the buffer pool manager
+ * @param s the schema of the tuples
+ * @param firstPageId the id of the first page
+ */
+func OpenColumnarTable(bpm *buffer.BufferPoolManager, s *schema.Schema, firstPageId common.PageID) *ColumnarTable {
+	t := newColumnarTable(bpm, s)
+	t.firstPageId = firstPageId
+	for pid := firstPageId; pid != common.InvalidPageID; {
+		p := bpm.FetchPage(pid, nil)
+		if p == nil {
+			return nil
+		}
+		pp := page.PageAsPaxPage(p)
+		pp.RLatch()
+		next := pp.GetNextPageId()
+		pp.RUnlatch()
+		bpm.UnpinPage(pid, false, nil)
+		t.lastPageId = pid
+		pid = next
+	}
+	return t
+}
+
+func newColumnarTable(bpm *buffer.BufferPoolManager, s *schema.Schema) *ColumnarTable {
+	t := &ColumnarTable{
+		bpm:     bpm,
+		schema:  s,
+		columns: make([]page.PaxColumn, s.GetColumnCount()),
+	}
+	for i := range t.columns {
+		if col := s.GetColumn(i); col.IsInlined() {
+			t.columns[i].Width = col.GetFixedLength()
+		}
+	}
+	return t
+}
+
+// allocate and initialize a page after prevPageId, it is returned pinned
+func (t *ColumnarTable) newPage(pid *common.PageID, prevPageId common.PageID) page.PaxPage {
+	p := t.bpm.NewPage(pid, nil)
+	if p == nil {
+		return nil
+	}
+	pp := page.PageAsPaxPage(p)
+	pp.WLatch()
+	ok := pp.Init(*pid, prevPageId, t.columns)
+	pp.WUnlatch()
+	if !ok {
+		t.bpm.UnpinPage(*pid, false, nil)
+		t.bpm.DeletePage(*pid, nil)
+		return nil
+	}
+	return pp
+}
+
+func (t *ColumnarTable) GetFirstPageId() common.PageID {
+	return t.firstPageId
+}
+
+func (t *ColumnarTable) GetSchema() *schema.Schema {
+	return t.schema
+}
+
+/**
+ * Append a tuple to the table.
+ * @param tuple tuple to insert, written with the schema of the table
+ * @param[out] rid the rid of the inserted tuple
+ * @return true iff the insert is successful
+ */
+func (t *ColumnarTable) InsertTuple(tuple *Tuple, rid *common.RID) bool {
+	values := make([][]byte, len(t.columns))
+	for i := range values {
+		val := tuple.GetValue(t.schema, i)
+		if val == nil {
+			return false
+		}
+		if !val.IsNull() {
+			buf := &bytes.Buffer{}
+			val.SerializeTo(buf)
+			values[i] = buf.Bytes()
+		}
+	}
+
+	t.appendLatch.Lock()
+	defer t.appendLatch.Unlock()
+
+	p := t.bpm.FetchPage(t.lastPageId, nil)
+	if p == nil {
+		return false
+	}
+	pp := page.PageAsPaxPage(p)
+	var slot uint32
+	pp.WLatch()
+	if pp.InsertTuple(values, &slot) {
+		pp.WUnlatch()
+		t.bpm.UnpinPage(t.lastPageId, true, nil)
+		rid.Set(t.lastPageId, slot)
+		return true
+	}
+
+	// the last page is full, start a new one, linked once the tuple is in
+	var newPageId common.PageID
+	newPage := t.newPage(&newPageId, t.lastPageId)
+	if newPage == nil {
+		pp.WUnlatch()
+		t.bpm.UnpinPage(t.lastPageId, false, nil)
+		return false
+	}
+	newPage.WLatch()
+	ok := newPage.InsertTuple(values, &slot)
+	newPage.WUnlatch()
+	if !ok {
+		// the variable-length values don't fit even in an empty page
+		pp.WUnlatch()
+		t.bpm.UnpinPage(t.lastPageId, false, nil)
+		t.bpm.UnpinPage(newPageId, false, nil)
+		t.bpm.DeletePage(newPageId, nil)
+		return false
+	}
+	pp.SetNextPageId(newPageId)
+	pp.WUnlatch()
+	t.bpm.UnpinPage(t.lastPageId, true, nil)
+	t.bpm.UnpinPage(newPageId, true, nil)
+	t.lastPageId = newPageId
+	rid.Set(newPageId, slot)
+	return true
+}
+
+/**
+ * Delete a tuple.
+ * @param rid the rid of the tuple
+ * @return false if there is no such tuple
+ */
+func (t *ColumnarTable) MarkDelete(rid common.RID) bool {
+	p := t.bpm.FetchPage(rid.GetPageId(), nil)
+	if p == nil {
+		return false
+	}
+	pp := page.PageAsPaxPage(p)
+	pp.WLatch()
+	ok := pp.MarkDelete(rid.GetSlotNum())
+	pp.WUnlatch()
+	t.bpm.UnpinPage(rid.GetPageId(), ok, nil)
+	return ok
+}
+
+/**
+ * Read all the columns of a tuple.
+ * @param rid the rid of the tuple
+ * @param[out] tuple the tuple, in the row layout of the schema
+ * @return false if there is no such tuple
+ */
+func (t *ColumnarTable) GetTuple(rid common.RID, tuple *Tuple) bool {
+	p := t.bpm.FetchPage(rid.GetPageId(), nil)
+	if p == nil {
+		return false
+	}
+	pp := page.PageAsPaxPage(p)
+	pp.RLatch()
+	defer func() {
+		pp.RUnlatch()
+		t.bpm.UnpinPage(rid.GetPageId(), false, nil)
+	}()
+
+	if pp.IsDeleted(rid.GetSlotNum()) {
+		return false
+	}
+	vals := make([]*types.Value, len(t.columns))
+	for i := range vals {
+		vals[i] = t.readValue(pp, rid.GetSlotNum(), i)
+	}
+	*tuple = *newTupleFromValues(vals, t.schema)
+	tuple.rid = rid
+	return true
+}
+
+// deserialize a value of a page, needs the page latch
+func (t *ColumnarTable) readValue(pp page.PaxPage, slot uint32, colIdx int) *types.Value {
+	colType := t.schema.GetColumn(colIdx).GetType()
+	data := pp.GetValue(slot, uint32(colIdx))
+	if data == nil {
+		return types.NewValue(colType)
+	}
+	val, err := types.GetInstance(colType).DeserializeFrom(bytes.NewBuffer(data))
+	if err != nil {
+		level.Error(common.Logger).Log("Value deserialization error: ", err)
+		return types.NewValue(colType)
+	}
+	return val
+}
+
+/**
+ * Scan the table, reading only some of the columns.
+ * @param colIdxs the indices of the columns to read, in the order they are returned
+ * @return an iterator over batches of the projected columns, one batch per page
+ */
+func (t *ColumnarTable) Scan(colIdxs []int) *ColumnarIterator {
+	return &ColumnarIterator{table: t, colIdxs: colIdxs, pageId: t.firstPageId}
+}
+
+/** The live tuples of one page, projected on the columns of the scan. */
+type ColumnBatch struct {
+	RIDs []common.RID
+	// Columns[i][j] is the value of the i-th scanned column of tuple j
+	Columns [][]*types.Value
+}
+
+type ColumnarIterator struct {
+	table   *ColumnarTable
+	colIdxs []int
+	pageId  common.PageID
+}
+
+/**
+ * @return the values of the next page with live tuples, and false at the end of the table
+ */
+func (it *ColumnarIterator) Next() (*ColumnBatch, bool) {
+	for it.pageId != common.InvalidPageID {
+		pid := it.pageId
+		p := it.table.bpm.FetchPage(pid, nil)
+		if p == nil {
+			return nil, false
+		}
+		pp := page.PageAsPaxPage(p)
+		pp.RLatch()
+		batch := it.readPage(pp)
+		it.pageId = pp.GetNextPageId()
+		pp.RUnlatch()
+		it.table.bpm.UnpinPage(pid, false, nil)
+		if len(batch.RIDs) > 0 {
+			return batch, true
+		}
+	}
+	return nil, false
+}
+
+// needs the page latch
+func (it *ColumnarIterator) readPage(pp page.PaxPage) *ColumnBatch {
+	count := pp.GetTupleCount()
+	batch := &ColumnBatch{
+		RIDs:    make([]common.RID, 0, count),
+		Columns: make([][]*types.Value, len(it.colIdxs)),
+	}
+	var live []uint32
+	for slot := uint32(0); slot < count; slot++ {
+		if !pp.IsDeleted(slot) {
+			live = append(live, slot)
+			batch.RIDs = append(batch.RIDs, common.NewRID(pp.GetTablePageId(), slot))
+		}
+	}
+	// one column at a time, so each scan goes through a single minipage
+	for i, colIdx := range it.colIdxs {
+		vals := make([]*types.Value, len(live))
+		for j, slot := range live {
+			vals[j] = it.table.readValue(pp, slot, colIdx)
+		}
+		batch.Columns[i] = vals
+	}
+	return batch
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package table
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"goostub/buffer"
+	"goostub/common"
+	"goostub/schema"
+	"goostub/storage/disk"
+	"goostub/types"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestColumnarTable(t *testing.T) {
+	a := assert.New(t)
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	bpm := buffer.NewBufferPoolManager(8, dm, nil)
+	s, err := schema.ParseSchema("id:INTEGER, name:VARCHAR, score:DECIMAL, big:BIGINT, flag:BOOLEAN, note:VARCHAR")
+	a.Nil(err)
+	ct := NewColumnarTable(bpm, s)
+	a.NotNil(ct)
+
+	const n = 3000
+	rids := make([]common.RID, n)
+	for i := range rids {
+		note := types.NewValue(types.VARCHAR)
+		if i%3 == 0 {
+			note = types.NewValue(types.VARCHAR, fmt.Sprintf("note %d", i))
+		}
+		tuple := NewTuple([]*types.Value{
+			types.NewValue(types.INTEGER, int32(i)),
+			types.NewValue(types.VARCHAR, fmt.Sprintf("user%d", i)),
+			types.NewValue(types.DECIMAL, float64(i)/2),
+			types.NewValue(types.BIGINT, int64(i)<<33),
+			types.NewValue(types.BOOLEAN, int8(i%2)),
+			note,
+		}, s)
+		a.True(ct.InsertTuple(tuple, &rids[i]))
+	}
+	a.NotEqual(rids[0].GetPageId(), rids[n-1].GetPageId())
+
+	// deleted tuples are skipped
+	for i := 0; i < n; i += 7 {
+		a.True(ct.MarkDelete(rids[i]))
+	}
+	a.False(ct.MarkDelete(rids[0]))
+
+	// a scan returns just the projected columns, in the requested order
+	var live []int
+	for i := 0; i < n; i++ {
+		if i%7 != 0 {
+			live = append(live, i)
+		}
+	}
+
+	// a scan returns just the projected columns, in the requested order
+	check := func(ct *ColumnarTable) {
+		seen := 0
+		it := ct.Scan([]int{5, 0})
+		for batch, ok := it.Next(); ok; batch, ok = it.Next() {
+			a.Len(batch.Columns, 2)
+			for j, rid := range batch.RIDs {
+				id := live[seen]
+				a.Equal(rids[id], rid)
+				a.Equal(fmt.Sprint(id), batch.Columns[1][j].String())
+				if id%3 == 0 {
+					a.Equal(fmt.Sprintf("note %d", id), batch.Columns[0][j].String())
+				} else {
+					a.True(batch.Columns[0][j].IsNull())
+				}
+				seen++
+			}
+		}
+		a.Equal(len(live), seen)
+	}
+	check(ct)
+
+	// whole tuples come back in the row layout
+	tuple := &Tuple{}
+	a.True(ct.GetTuple(rids[10], tuple))
+	a.Equal("(10, user10, 5, 85899345920, false, <NULL>)", tuple.String(s))
+	a.Equal(rids[10], tuple.GetRID())
+	a.False(ct.GetTuple(rids[14], tuple))
+
+	// and the table survives a reopen, appending after the last page
+	reopened := OpenColumnarTable(bpm, s, ct.GetFirstPageId())
+	check(reopened)
+	var rid common.RID
+	a.True(reopened.InsertTuple(NewTuple([]*types.Value{
+		types.NewValue(types.INTEGER, int32(n)),
+		types.NewValue(types.VARCHAR, "last"),
+		types.NewValue(types.DECIMAL, 0.5),
+		types.NewValue(types.BIGINT, int64(1)),
+		types.NewValue(types.BOOLEAN, int8(1)),
+		types.NewValue(types.VARCHAR, "x"),
+	}, s), &rid))
+	a.Equal(rids[n-1].GetPageId(), rid.GetPageId())
+
+	// a tuple that doesn't fit even in an empty page leaves no page behind
+	row := func(note string) *Tuple {
+		return NewTuple([]*types.Value{
+			types.NewValue(types.INTEGER, int32(n+1)),
+			types.NewValue(types.VARCHAR, "huge"),
+			types.NewValue(types.DECIMAL, 0.5),
+			types.NewValue(types.BIGINT, int64(1)),
+			types.NewValue(types.BOOLEAN, int8(1)),
+			types.NewValue(types.VARCHAR, note),
+		}, s)
+	}
+	a.False(reopened.InsertTuple(row(strings.Repeat("x", common.PageSize)), &rid))
+	a.Equal(1, dm.GetNumFreePages())
+	for _, table := range []*ColumnarTable{reopened, OpenColumnarTable(bpm, s, ct.GetFirstPageId())} {
+		a.True(table.InsertTuple(row("x"), &rid))
+		a.Equal(rids[n-1].GetPageId(), rid.GetPageId())
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package table
+
+import (
+	"goostub/common"
+	"sync"
+)
+
+const (
+	// a page with space class c has at least c*fsmClassSize free bytes
+	fsmClassSize = common.PageSize / 256
+	fsmMaxClass  = 255
+)
+
+/**
+ * FreeSpaceMap keeps track of how much room is left in each page of a table
+ * heap, so that an insert can go straight to a page that fits the tuple
+ * instead of walking the page chain.
+ *
+ * The free space of a page is rounded down to a space class that fits in a
+ * single byte, and the classes are kept in page chain order so that a search
+ * only scans one byte per page. The map is only a hint: a page may turn out
+ * to be fuller than its class says, in which case the caller reports the
+ * actual free space and searches again.
+ */
+type FreeSpaceMap struct {
+	latch sync.Mutex
+	// space class of each page, in page chain order
+	classes []uint8
+	// page id of each entry in classes
+	pageIds []common.PageID
+	// page id -> index into classes
+	index map[common.PageID]int
+	// where the next search starts, so that we don't rescan full pages at the front every time
+	hint int
+}
+
+func NewFreeSpaceMap() *FreeSpaceMap {
+	return &FreeSpaceMap{
+		index: make(map[common.PageID]int),
+	}
+}
+
+/**
+ * @param freeSpace free bytes of a page
+ * @return the space class of the page
+ */
+func SpaceClass(freeSpace uint32) uint8 {
+	class := freeSpace / fsmClassSize
+	if class > fsmMaxClass {
+		return fsmMaxClass
+	}
+	return uint8(class)
+}
+
+// the smallest space class that guarantees size free bytes, fsmMaxClass+1 if none does
+func requiredClass(size uint32) uint32 {
+	return (size + fsmClassSize - 1) / fsmClassSize
+}
+
+/**
+ * Add a page at the end of the page chain.
+ * @param pid id of the new page
+ * @param freeSpace free bytes of the new page
+ */
+func (m *FreeSpaceMap) Append(pid common.PageID, freeSpace uint32) {
+	m.latch.Lock()
+	defer m.latch.Unlock()
+
+	m.index[pid] = len(m.classes)
+	m.classes = append(m.classes, SpaceClass(freeSpace))
+	m.pageIds = append(m.pageIds, pid)
+}
+
+/**
+ * Record the free space of a page, to be called whenever a page gains or
+ * loses space. Pages not in the map are ignored.
+ * @param pid id of the page
+ * @param freeSpace free bytes of the page
+ */
+func (m *FreeSpaceMap) Update(pid common.PageID, freeSpace uint32) {
+	m.latch.Lock()
+	defer m.latch.Unlock()
+
+	idx, ok := m.index[pid]
+	if !ok {
+		return
+	}
+	class := SpaceClass(freeSpace)
+	if class > m.classes[idx] && idx < m.hint {
+		// the page got emptier, start the next search from it
+		m.hint = idx
+	}
+	m.classes[idx] = class
+}
+
+/**
+ * Drop a page that was unlinked from the page chain.
+ * @param pid id of the page
+ */
+func (m *FreeSpaceMap) Remove(pid common.PageID) {
+	m.latch.Lock()
+	defer m.latch.Unlock()
+
+	idx, ok := m.index[pid]
+	if !ok {
+		return
+	}
+	delete(m.index, pid)
+	m.classes = append(m.classes[:idx], m.classes[idx+1:]...)
+	m.pageIds = append(m.pageIds[:idx], m.pageIds[idx+1:]...)
+	for i := idx; i < len(m.pageIds); i++ {
+		m.index[m.pageIds[i]] = i
+	}
+	if m.hint > idx || m.hint >= len(m.classes) {
+		m.hint--
+	}
+	if m.hint < 0 {
+		m.hint = 0
+	}
+}
+
+/**
+ * Find a page that is likely to have room for size bytes.
+ * @param size number of bytes needed, including the slot
+ * @return the page id, and false if no page has enough room
+ */
+func (m *FreeSpaceMap) FindPage(size uint32) (common.PageID, bool) {
+	m.latch.Lock()
+	defer m.latch.Unlock()
+
+	required := requiredClass(size)
+	if required > fsmMaxClass || len(m.classes) == 0 {
+		return common.InvalidPageID, false
+	}
+
+	// scan from the hint to the end, then wrap around
+	n := len(m.classes)
+	for i := 0; i < n; i++ {
+		idx := (m.hint + i) % n
+		if uint32(m.classes[idx]) >= required {
+			m.hint = idx
+			return m.pageIds[idx], true
+		}
+	}
+	return common.InvalidPageID, false
+}
+
+/**
+ * @return the approximate free space of a page, i.e. the lower bound of its space class
+ */
+func (m *FreeSpaceMap) GetFreeSpace(pid common.PageID) uint32 {
+	m.latch.Lock()
+	defer m.latch.Unlock()
+
+	idx, ok := m.index[pid]
+	if !ok {
+		return 0
+	}
+	return uint32(m.classes[idx]) * fsmClassSize
+}
+
+/** @return the id of the last page in the page chain */
+func (m *FreeSpaceMap) GetLastPageId() common.PageID {
+	m.latch.Lock()
+	defer m.latch.Unlock()
+
+	if len(m.pageIds) == 0 {
+		return common.InvalidPageID
+	}
+	return m.pageIds[len(m.pageIds)-1]
+}
+
+/** @return the number of pages tracked by the map */
+func (m *FreeSpaceMap) GetPageCount() int {
+	m.latch.Lock()
+	defer m.latch.Unlock()
+	return len(m.classes)
+}
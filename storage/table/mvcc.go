@@ -0,0 +1,264 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package table
+
+import (
+	"goostub/common"
+	"goostub/storage/page"
+)
+
+/**
+ * Multi-version concurrency control.
+ *
+ * The page always holds the newest version of a tuple, so RIDs don't change
+ * on update and indexes don't need to know about versions. Every tuple
+ * written by a transaction gets an entry in the heap's version map: the
+ * newest version, stamped with the transaction that created it and the one
+ * that deleted it, linked to copies of the versions it replaced.
+ *
+ *   versions[rid] -> newest (creator, deleter) -> older (creator, deleter, data) -> ...
+ *
+ * The deleter of an older version is the creator of the version after it.
+ * Tuples without an entry were written long enough ago for everybody to see
+ * them, as were tuples written without a transaction.
+ *
+ * A SnapshotIsolation transaction walks the chain from the newest version and
+ * reads the first one whose creator it sees, unless it also sees its deleter.
+ * Other isolation levels read the page as before and rely on locks.
+ *
+ * Versions only live in memory: after a restart no transaction is running
+ * and the page holds the version everybody sees. Old versions are dropped by
+ * CollectGarbage once no running transaction can see them, which is also when
+ * deleted tuples are actually removed from the page.
+ */
+type tupleVersion struct {
+	creator common.TxnID
+	// InvalidTxnID while the version is live
+	deleter common.TxnID
+	// the deleter committed, only tracked on the newest version
+	deleteCommitted bool
+	// data of an older version, the newest one is in the page
+	data []byte
+	prev *tupleVersion
+}
+
+// the transaction as a snapshot reader, nil if it reads with locks
+func snapshotOf(txn common.Transaction) common.SnapshotTransaction {
+	if txn == nil || txn.GetIsolationLevel() != common.SnapshotIsolation {
+		return nil
+	}
+	snap, _ := txn.(common.SnapshotTransaction)
+	return snap
+}
+
+/**
+ * Pick the version of a tuple a snapshot sees. Needs the page latch.
+ * @param rid rid of the tuple
+ * @param data the newest version, as read from the page
+ * @param marked whether the page marks the tuple as deleted
+ * @param snap the reading transaction
+ * @return the visible data, and false if the snapshot doesn't see the tuple
+ */
+func (t *TableHeap) visibleVersion(rid common.RID, data []byte, marked bool, snap common.SnapshotTransaction) ([]byte, bool) {
+	t.versionLatch.RLock()
+	defer t.versionLatch.RUnlock()
+
+	v, ok := t.versions[rid]
+	if !ok {
+		return data, !marked
+	}
+	if snap.IsVisible(v.creator) {
+		if v.deleter != common.InvalidTxnID && snap.IsVisible(v.deleter) {
+			return nil, false
+		}
+		return data, true
+	}
+	for old := v.prev; old != nil; old = old.prev {
+		if snap.IsVisible(old.creator) {
+			return old.data, true
+		}
+	}
+	// inserted after the snapshot was taken
+	return nil, false
+}
+
+// whether snap may write the tuple: first updater wins. Needs the page latch.
+func (t *TableHeap) canWrite(rid common.RID, snap common.SnapshotTransaction) bool {
+	t.versionLatch.RLock()
+	defer t.versionLatch.RUnlock()
+
+	v, ok := t.versions[rid]
+	if !ok {
+		return true
+	}
+	self := snap.GetTransactionId()
+	if v.creator != self && !snap.IsVisible(v.creator) {
+		return false
+	}
+	return v.deleter == common.InvalidTxnID || v.deleter == self
+}
+
+// the functions below record a write of txn, they need the page latch
+
+func (t *TableHeap) recordInsertVersion(rid common.RID, txn common.Transaction) {
+	if txn == nil {
+		return
+	}
+	t.versionLatch.Lock()
+	t.versions[rid] = &tupleVersion{creator: txn.GetTransactionId(), deleter: common.InvalidTxnID}
+	t.versionLatch.Unlock()
+}
+
+func (t *TableHeap) recordDeleteVersion(rid common.RID, txn common.Transaction) {
+	if txn == nil {
+		return
+	}
+	t.versionLatch.Lock()
+	v, ok := t.versions[rid]
+	if !ok {
+		v = &tupleVersion{creator: common.InvalidTxnID}
+		t.versions[rid] = v
+	}
+	v.deleter = txn.GetTransactionId()
+	t.versionLatch.Unlock()
+}
+
+func (t *TableHeap) recordUpdateVersion(rid common.RID, txn common.Transaction, oldData []byte) {
+	if txn == nil {
+		return
+	}
+	t.versionLatch.Lock()
+	old := &tupleVersion{creator: common.InvalidTxnID}
+	if v, ok := t.versions[rid]; ok {
+		old.creator = v.creator
+		old.prev = v.prev
+	}
+	old.deleter = txn.GetTransactionId()
+	old.data = oldData
+	t.versions[rid] = &tupleVersion{creator: txn.GetTransactionId(), deleter: common.InvalidTxnID, prev: old}
+	t.versionLatch.Unlock()
+}
+
+func (t *TableHeap) commitDeleteVersion(rid common.RID, txn common.Transaction) {
+	t.versionLatch.Lock()
+	if v, ok := t.versions[rid]; ok && v.deleter == txn.GetTransactionId() {
+		v.deleteCommitted = true
+	}
+	t.versionLatch.Unlock()
+}
+
+// whether the tuple is marked as deleted by a transaction that hasn't committed yet, needs the page latch
+func (t *TableHeap) isDeletePending(rid common.RID) bool {
+	t.versionLatch.RLock()
+	defer t.versionLatch.RUnlock()
+	v, ok := t.versions[rid]
+	return ok && v.deleter != common.InvalidTxnID && !v.deleteCommitted
+}
+
+// the old version is back in the page, make it the newest one again
+func (t *TableHeap) rollbackUpdateVersion(rid common.RID) {
+	t.versionLatch.Lock()
+	if v, ok := t.versions[rid]; ok && v.prev != nil {
+		old := v.prev
+		old.data = nil
+		old.deleter = common.InvalidTxnID
+		t.versions[rid] = old
+	}
+	t.versionLatch.Unlock()
+}
+
+func (t *TableHeap) rollbackDeleteVersion(rid common.RID) {
+	t.versionLatch.Lock()
+	if v, ok := t.versions[rid]; ok {
+		v.deleter = common.InvalidTxnID
+	}
+	t.versionLatch.Unlock()
+}
+
+func (t *TableHeap) dropVersions(rid common.RID) {
+	t.versionLatch.Lock()
+	delete(t.versions, rid)
+	t.versionLatch.Unlock()
+}
+
+// the tuple moved to another slot, e.g. during vacuum
+func (t *TableHeap) moveVersions(from common.RID, to common.RID) {
+	t.versionLatch.Lock()
+	if v, ok := t.versions[from]; ok {
+		delete(t.versions, from)
+		t.versions[to] = v
+	}
+	t.versionLatch.Unlock()
+}
+
+/**
+ * Drop the versions no running transaction can see anymore and remove the
+ * tuples whose delete everybody sees from their pages.
+ * @param horizon every transaction before it is over and seen by all running
+ * transactions, see TransactionManager
+ * @return the number of tuples that still have versions
+ */
+func (t *TableHeap) CollectGarbage(horizon common.TxnID) int {
+	var deleted []common.RID
+	t.versionLatch.Lock()
+	for rid, v := range t.versions {
+		if v.deleter != common.InvalidTxnID && v.deleter < horizon {
+			// readers find the tuple marked as deleted, which is what they all should see now
+			delete(t.versions, rid)
+			deleted = append(deleted, rid)
+			continue
+		}
+		// everything before the first version all transactions see is unreachable
+		for cur := v; cur != nil; cur = cur.prev {
+			if cur.creator < horizon {
+				cur.prev = nil
+				break
+			}
+		}
+		if v.creator < horizon && v.deleter == common.InvalidTxnID && v.prev == nil {
+			delete(t.versions, rid)
+		}
+	}
+	t.versionLatch.Unlock()
+
+	// nobody can write the tuples anymore, and the entries are gone so no other collection picks them
+	for _, rid := range deleted {
+		t.ApplyDelete(rid, nil)
+	}
+
+	t.versionLatch.RLock()
+	defer t.versionLatch.RUnlock()
+	return len(t.versions)
+}
+
+/** @return the number of old versions kept for running transactions */
+func (t *TableHeap) GetVersionCount() int {
+	t.versionLatch.RLock()
+	defer t.versionLatch.RUnlock()
+
+	count := 0
+	for _, v := range t.versions {
+		for old := v.prev; old != nil; old = old.prev {
+			count++
+		}
+	}
+	return count
+}
+
+// read the tuples of a page a snapshot sees, needs the page latch
+func (t *TableHeap) readVisibleTuples(tp page.TablePage, snap common.SnapshotTransaction, tuples []*Tuple) []*Tuple {
+	for i := uint32(0); i < tp.GetTupleCount(); i++ {
+		rid := common.NewRID(tp.GetTablePageId(), i)
+		data, marked, ok := tp.ReadTuple(rid)
+		if !ok {
+			continue
+		}
+		if data, ok = t.visibleVersion(rid, data, marked, snap); ok {
+			tuples = append(tuples, &Tuple{allocated: true, rid: rid, data: data})
+		}
+	}
+	return tuples
+}
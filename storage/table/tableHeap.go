@@ -10,10 +10,39 @@ import (
 	"goostub/common"
 	"goostub/concurrency"
 	"goostub/recovery"
+	"goostub/storage/page"
+	"sync"
 )
 
+/**
+ * Write record of a table heap operation, kept in the transaction's write set
+ * so that the operation can be committed or rolled back. It lives here rather
+ * than in the transaction package because the table heap creates the records
+ * and the transaction package already imports this one.
+ */
+type TableWriteRecord struct {
+	Rid   common.RID
+	Wtype common.WType
+	Tuple Tuple      // only used for update operation
+	Table *TableHeap // specify which table the record is for
+}
+
+/**
+ * TableHeap represents a physical table on disk.
+ * This is just a doubly-linked list of pages.
+ */
 type TableHeap struct {
-	// TODO
+	bpm         *buffer.BufferPoolManager
+	lockManager *concurrency.LockManager
+	logManager  *recovery.LogManager
+	firstPageId common.PageID
+	fsm         *FreeSpaceMap
+	// serializes appending new pages to the end of the page chain
+	appendLatch sync.Mutex
+	// MVCC versions of the tuples written by transactions, see mvcc.go.
+	// Taken after the page latch of the tuple.
+	versionLatch sync.RWMutex
+	versions     map[common.RID]*tupleVersion
 }
 
 /**
@@ -24,17 +53,404 @@ type TableHeap struct {
 * @param txn the creating transaction
  */
 func NewTableHeap(bpm *buffer.BufferPoolManager, lockM *concurrency.LockManager, logM *recovery.LogManager, txn common.Transaction) *TableHeap {
-	return nil
+	t := &TableHeap{
+		bpm:         bpm,
+		lockManager: lockM,
+		logManager:  logM,
+		fsm:         NewFreeSpaceMap(),
+		versions:    make(map[common.RID]*tupleVersion),
+	}
+
+	// Initialize the first table page.
+	p := bpm.NewPage(&t.firstPageId, nil)
+	if p == nil {
+		return nil
+	}
+	tp := page.PageAsTablePage(p)
+	tp.WLatch()
+	tp.Init(t.firstPageId, common.PageSize, common.InvalidPageID)
+	t.fsm.Append(t.firstPageId, tp.GetFreeSpaceRemaining())
+	tp.WUnlatch()
+	bpm.UnpinPage(t.firstPageId, true, nil)
+	return t
+}
+
+/**
+* Open a table heap. (open table)
+* Walks the page chain once to rebuild the free space map.
+* @param buffer_pool_manager the buffer pool manager
+* @param lock_manager the lock manager
+* @param log_manager the log manager
+* @param first_page_id the id of the first page
+ */
+func OpenTableHeap(bpm *buffer.BufferPoolManager, lockM *concurrency.LockManager, logM *recovery.LogManager, firstPageId common.PageID) *TableHeap {
+	t := &TableHeap{
+		bpm:         bpm,
+		lockManager: lockM,
+		logManager:  logM,
+		firstPageId: firstPageId,
+		fsm:         NewFreeSpaceMap(),
+		versions:    make(map[common.RID]*tupleVersion),
+	}
+
+	for pid := firstPageId; pid != common.InvalidPageID; {
+		p := bpm.FetchPage(pid, nil)
+		if p == nil {
+			return nil
+		}
+		tp := page.PageAsTablePage(p)
+		tp.RLatch()
+		t.fsm.Append(pid, tp.GetFreeSpaceRemaining())
+		next := tp.GetNextPageId()
+		tp.RUnlatch()
+		bpm.UnpinPage(pid, false, nil)
+		pid = next
+	}
+	return t
+}
+
+/**
+ * Insert a tuple into the table. If the tuple is too large (>= page_size), return false.
+ * @param tuple tuple to insert
+ * @param[out] rid the rid of the inserted tuple
+ * @param txn the transaction performing the insert
+ * @return true iff the insert is successful
+ */
+func (t *TableHeap) InsertTuple(tuple *Tuple, rid *common.RID, txn common.Transaction) bool {
+	size := uint32(len(tuple.data))
+	if size+32 > common.PageSize {
+		// larger than one page size
+		setAborted(txn)
+		return false
+	}
+
+	if t.insertIntoFreePage(tuple, rid, txn) {
+		return true
+	}
+
+	t.appendLatch.Lock()
+	defer t.appendLatch.Unlock()
+
+	// somebody else may have made room while we were waiting
+	if t.insertIntoFreePage(tuple, rid, txn) {
+		return true
+	}
+
+	// Otherwise we have to create a new page at the end of the chain.
+	lastPageId := t.fsm.GetLastPageId()
+	lastPage := t.bpm.FetchPage(lastPageId, nil)
+	if lastPage == nil {
+		setAborted(txn)
+		return false
+	}
+	var newPageId common.PageID
+	newPage := t.bpm.NewPage(&newPageId, nil)
+	if newPage == nil {
+		t.bpm.UnpinPage(lastPageId, false, nil)
+		setAborted(txn)
+		return false
+	}
+
+	lastTp := page.PageAsTablePage(lastPage)
+	newTp := page.PageAsTablePage(newPage)
+	lastTp.WLatch()
+	newTp.WLatch()
+	newTp.Init(newPageId, common.PageSize, lastPageId)
+	// fill the page before linking it so that readers never see it half done
+	inserted := newTp.InsertTuple(tuple.data, rid, txn, t.lockManager)
+	if inserted {
+		t.recordInsertVersion(*rid, txn)
+	}
+	lastTp.SetNextPageId(newPageId)
+	t.fsm.Append(newPageId, newTp.GetFreeSpaceRemaining())
+	newTp.WUnlatch()
+	lastTp.WUnlatch()
+	t.bpm.UnpinPage(newPageId, true, nil)
+	t.bpm.UnpinPage(lastPageId, true, nil)
+
+	if inserted {
+		t.recordWrite(txn, *rid, common.Insert, Tuple{})
+	}
+	return inserted
+}
+
+/**
+ * Append tuples at the end of the table, filling one page before starting the next.
+ * This is the bulk load path for a table nobody else can see yet: the free
+ * space map isn't searched, no locks are taken, and nothing is added to a write
+ * set, so the inserts can't be rolled back.
+ * @param tuples tuples to append
+ * @param[out] rids the rids of the appended tuples, must be as long as tuples
+ * @return true iff all tuples were appended
+ */
+func (t *TableHeap) AppendTuples(tuples []*Tuple, rids []common.RID) bool {
+	t.appendLatch.Lock()
+	defer t.appendLatch.Unlock()
+
+	pid := t.fsm.GetLastPageId()
+	p := t.bpm.FetchPage(pid, nil)
+	if p == nil {
+		return false
+	}
+	tp := page.PageAsTablePage(p)
+	tp.WLatch()
+	// release whichever page is the current one
+	release := func() {
+		t.fsm.Update(pid, tp.GetFreeSpaceRemaining())
+		tp.WUnlatch()
+		t.bpm.UnpinPage(pid, true, nil)
+	}
+	defer release()
+
+	for i, tuple := range tuples {
+		if uint32(len(tuple.data))+32 > common.PageSize {
+			// larger than one page size
+			return false
+		}
+		for !tp.InsertTuple(tuple.data, &rids[i], nil, nil) {
+			// the page is full, continue on a new one
+			var newPageId common.PageID
+			newPage := t.bpm.NewPage(&newPageId, nil)
+			if newPage == nil {
+				return false
+			}
+			newTp := page.PageAsTablePage(newPage)
+			newTp.WLatch()
+			newTp.Init(newPageId, common.PageSize, pid)
+			tp.SetNextPageId(newPageId)
+			release()
+			t.fsm.Append(newPageId, newTp.GetFreeSpaceRemaining())
+			pid, tp = newPageId, newTp
+		}
+	}
+	return true
+}
+
+/**
+ * Mark the tuple as deleted. The actual delete will occur when ApplyDelete is called.
+ * @param rid rid of the tuple to be deleted
+ * @param txn transaction performing the delete
+ * @return true iff the delete is successful (i.e the tuple exists)
+ */
+func (t *TableHeap) MarkDelete(rid common.RID, txn common.Transaction) bool {
+	p := t.bpm.FetchPage(rid.GetPageId(), nil)
+	if p == nil {
+		setAborted(txn)
+		return false
+	}
+	tp := page.PageAsTablePage(p)
+	tp.WLatch()
+	marked := false
+	if snap := snapshotOf(txn); snap != nil && !t.canWrite(rid, snap) {
+		setAborted(txn)
+	} else if marked = tp.MarkDelete(rid, txn, t.lockManager); marked {
+		t.recordDeleteVersion(rid, txn)
+	}
+	tp.WUnlatch()
+	t.bpm.UnpinPage(tp.GetTablePageId(), true, nil)
+
+	if marked {
+		t.recordWrite(txn, rid, common.Delete, Tuple{})
+	}
+	return marked
 }
 
+/**
+ * if the new tuple is too large to fit in the old page, return false (will delete and insert)
+ * @param tuple new tuple
+ * @param rid rid of the old tuple
+ * @param txn transaction performing the update
+ * @return true is update is successful.
+ */
+func (t *TableHeap) UpdateTuple(tup *Tuple, rid common.RID, txn common.Transaction) bool {
+	p := t.bpm.FetchPage(rid.GetPageId(), nil)
+	if p == nil {
+		setAborted(txn)
+		return false
+	}
+	tp := page.PageAsTablePage(p)
+	var oldData []byte
+	// the transaction is aborted when we are rolling back
+	rollback := txn != nil && txn.GetState() == common.Aborted
+	updated := false
+	tp.WLatch()
+	if snap := snapshotOf(txn); snap != nil && !rollback && !t.canWrite(rid, snap) {
+		setAborted(txn)
+	} else if updated = tp.UpdateTuple(tup.data, &oldData, rid, txn, t.lockManager); updated {
+		if rollback {
+			t.rollbackUpdateVersion(rid)
+		} else {
+			t.recordUpdateVersion(rid, txn, oldData)
+		}
+	}
+	t.fsm.Update(rid.GetPageId(), tp.GetFreeSpaceRemaining())
+	tp.WUnlatch()
+	t.bpm.UnpinPage(tp.GetTablePageId(), updated, nil)
+
+	// Update the transaction's write set, unless we are rolling back.
+	if updated && !rollback {
+		t.recordWrite(txn, rid, common.Update, Tuple{allocated: true, rid: rid, data: oldData})
+	}
+	return updated
+}
+
+/**
+ * Called on Commit/Abort to actually delete a tuple or rollback an insert.
+ * @param rid rid of the tuple to delete
+ * @param txn transaction performing the delete
+ */
 func (t *TableHeap) ApplyDelete(rid common.RID, txn common.Transaction) {
-	// TODO
+	p := t.bpm.FetchPage(rid.GetPageId(), nil)
+	common.Assert.NotNil(p, "Couldn't find a page containing that RID.")
+	tp := page.PageAsTablePage(p)
+	tp.WLatch()
+	tp.ApplyDelete(rid, txn)
+	// the slot may be reused by another tuple
+	t.dropVersions(rid)
+	t.fsm.Update(rid.GetPageId(), tp.GetFreeSpaceRemaining())
+	tp.WUnlatch()
+	t.bpm.UnpinPage(tp.GetTablePageId(), true, nil)
 }
 
+/**
+ * Called on abort to rollback a delete.
+ * @param rid rid of the deleted tuple.
+ * @param txn transaction performing the rollback
+ */
 func (t *TableHeap) RollbackDelete(rid common.RID, txn common.Transaction) {
-	// TODO
+	p := t.bpm.FetchPage(rid.GetPageId(), nil)
+	common.Assert.NotNil(p, "Couldn't find a page containing that RID.")
+	tp := page.PageAsTablePage(p)
+	tp.WLatch()
+	tp.RollbackDelete(rid, txn)
+	t.rollbackDeleteVersion(rid)
+	tp.WUnlatch()
+	t.bpm.UnpinPage(tp.GetTablePageId(), true, nil)
+}
+
+/**
+ * Read a tuple from the table.
+ * @param rid rid of the tuple to read
+ * @param[out] result output tuple
+ * @param txn transaction performing the read
+ * @return true if the read was successful (i.e. the tuple exists)
+ */
+func (t *TableHeap) GetTuple(rid common.RID, result *Tuple, txn common.Transaction) bool {
+	// Attempt to get a shared lock on the page. Note that this is done in the page itself.
+	p := t.bpm.FetchPage(rid.GetPageId(), nil)
+	if p == nil {
+		setAborted(txn)
+		return false
+	}
+	tp := page.PageAsTablePage(p)
+	tp.RLatch()
+	var data []byte
+	var ok bool
+	if snap := snapshotOf(txn); snap != nil {
+		var marked bool
+		if data, marked, ok = tp.ReadTuple(rid); ok {
+			data, ok = t.visibleVersion(rid, data, marked, snap)
+		}
+	} else {
+		data, ok = tp.GetTuple(rid, txn, t.lockManager)
+	}
+	tp.RUnlatch()
+	t.bpm.UnpinPage(rid.GetPageId(), false, nil)
+
+	if ok {
+		result.allocated = true
+		result.rid = rid
+		result.data = data
+	}
+	return ok
+}
+
+/**
+ * Check that a tuple is in the table and not deleted, without locking it.
+ * A tuple marked as deleted by a transaction that hasn't committed counts as
+ * live, the transaction may still abort and bring it back.
+ * @param rid rid of the tuple
+ */
+func (t *TableHeap) IsLive(rid common.RID) bool {
+	p := t.bpm.FetchPage(rid.GetPageId(), nil)
+	if p == nil {
+		return false
+	}
+	tp := page.PageAsTablePage(p)
+	tp.RLatch()
+	_, marked, ok := tp.ReadTuple(rid)
+	live := ok && (!marked || t.isDeletePending(rid))
+	tp.RUnlatch()
+	t.bpm.UnpinPage(rid.GetPageId(), false, nil)
+	return live
+}
+
+/**
+ * Called on commit for each tuple the transaction deleted, from then on the
+ * tuple counts as deleted even before the garbage collection removes it.
+ * @param rid rid of the deleted tuple
+ * @param txn transaction that deleted it
+ */
+func (t *TableHeap) CommitDelete(rid common.RID, txn common.Transaction) {
+	t.commitDeleteVersion(rid, txn)
+}
+
+/** @return the id of the first page of this table */
+func (t *TableHeap) GetFirstPageId() common.PageID {
+	return t.firstPageId
+}
+
+/** @return the free space map of this table */
+func (t *TableHeap) GetFreeSpaceMap() *FreeSpaceMap {
+	return t.fsm
+}
+
+// try the pages the free space map suggests until one of them takes the tuple
+func (t *TableHeap) insertIntoFreePage(tuple *Tuple, rid *common.RID, txn common.Transaction) bool {
+	size := uint32(len(tuple.data)) + page.SizeTableSlot
+	for {
+		pid, ok := t.fsm.FindPage(size)
+		if !ok {
+			return false
+		}
+
+		p := t.bpm.FetchPage(pid, nil)
+		if p == nil {
+			return false
+		}
+		tp := page.PageAsTablePage(p)
+		tp.WLatch()
+		inserted := tp.InsertTuple(tuple.data, rid, txn, t.lockManager)
+		if inserted {
+			t.recordInsertVersion(*rid, txn)
+		}
+		// the map may have been stale, correct it either way
+		t.fsm.Update(pid, tp.GetFreeSpaceRemaining())
+		tp.WUnlatch()
+		t.bpm.UnpinPage(pid, inserted, nil)
+
+		if inserted {
+			t.recordWrite(txn, *rid, common.Insert, Tuple{})
+			return true
+		}
+	}
+}
+
+func (t *TableHeap) recordWrite(txn common.Transaction, rid common.RID, wtype common.WType, tuple Tuple) {
+	if txn == nil {
+		return
+	}
+	txn.GetWriteSet().PushBack(TableWriteRecord{
+		Rid:   rid,
+		Wtype: wtype,
+		Tuple: tuple,
+		Table: t,
+	})
 }
 
-func (t *TableHeap) UpdateTuple(tup *Tuple, rid common.RID, txn common.Transaction) {
-	// TODO
+// a nil transaction is allowed for internal maintenance work that needs neither locks nor rollback
+func setAborted(txn common.Transaction) {
+	if txn != nil {
+		txn.SetState(common.Aborted)
+	}
 }
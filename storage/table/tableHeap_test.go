@@ -0,0 +1,195 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package table
+
+import (
+	"github.com/gammazero/deque"
+	"github.com/go-kit/kit/log/level"
+	"github.com/stretchr/testify/assert"
+	"goostub/buffer"
+	"goostub/common"
+	"goostub/concurrency"
+	"goostub/storage/disk"
+	"path/filepath"
+	"testing"
+)
+
+func init() {
+	common.InitLogger(level.AllowNone())
+}
+
+func newTestHeap(t *testing.T, poolSize uint32) (*TableHeap, *buffer.BufferPoolManager) {
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	bpm := buffer.NewBufferPoolManager(poolSize, dm, nil)
+	return NewTableHeap(bpm, nil, nil, nil), bpm
+}
+
+func rawTuple(size int, fill byte) *Tuple {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = fill
+	}
+	return &Tuple{allocated: true, data: data}
+}
+
+func TestFreeSpaceMapClasses(t *testing.T) {
+	a := assert.New(t)
+	a.Equal(uint8(0), SpaceClass(0))
+	a.Equal(uint8(0), SpaceClass(fsmClassSize-1))
+	a.Equal(uint8(1), SpaceClass(fsmClassSize))
+	a.Equal(uint8(fsmMaxClass), SpaceClass(common.PageSize*2))
+
+	fsm := NewFreeSpaceMap()
+	fsm.Append(1, 100)
+	fsm.Append(2, 2000)
+	pid, ok := fsm.FindPage(1000)
+	a.True(ok)
+	a.Equal(common.PageID(2), pid)
+
+	fsm.Update(2, 10)
+	_, ok = fsm.FindPage(1000)
+	a.False(ok)
+
+	// a page that becomes emptier is found again even if it is before the hint
+	fsm.Update(1, 3000)
+	pid, ok = fsm.FindPage(1000)
+	a.True(ok)
+	a.Equal(common.PageID(1), pid)
+	a.Equal(uint32(2992), fsm.GetFreeSpace(1))
+}
+
+func TestTableHeapInsertUsesFreeSpace(t *testing.T) {
+	a := assert.New(t)
+	heap, bpm := newTestHeap(t, 10)
+
+	// 1000 tuples of 200 bytes are spread over about 50 pages
+	rids := make([]common.RID, 1000)
+	for i := range rids {
+		a.True(heap.InsertTuple(rawTuple(200, byte(i)), &rids[i], nil))
+	}
+	numPages := heap.GetFreeSpaceMap().GetPageCount()
+	a.Equal(rids[len(rids)-1].GetPageId(), heap.GetFreeSpaceMap().GetLastPageId())
+
+	// free up the first page
+	firstPage := rids[0].GetPageId()
+	for _, rid := range rids {
+		if rid.GetPageId() == firstPage {
+			a.True(heap.MarkDelete(rid, nil))
+			heap.ApplyDelete(rid, nil)
+		}
+	}
+
+	// the next insert goes to the freed page instead of a new one
+	var rid common.RID
+	a.True(heap.InsertTuple(rawTuple(200, 0xff), &rid, nil))
+	a.Equal(firstPage, rid.GetPageId())
+	a.Equal(numPages, heap.GetFreeSpaceMap().GetPageCount())
+
+	result := &Tuple{}
+	a.True(heap.GetTuple(rid, result, nil))
+	a.Equal(rawTuple(200, 0xff).GetData(), result.GetData())
+	last := len(rids) - 1
+	a.True(heap.GetTuple(rids[last], result, nil))
+	a.Equal(rawTuple(200, byte(last)).GetData(), result.GetData())
+
+	// reopening the heap rebuilds the same map
+	bpm.FlushAllPages(nil)
+	reopened := OpenTableHeap(bpm, nil, nil, heap.GetFirstPageId())
+	a.Equal(numPages, reopened.GetFreeSpaceMap().GetPageCount())
+	a.Equal(heap.GetFreeSpaceMap().GetFreeSpace(firstPage), reopened.GetFreeSpaceMap().GetFreeSpace(firstPage))
+}
+
+func TestTableHeapUpdateTracksFreeSpace(t *testing.T) {
+	a := assert.New(t)
+	heap, _ := newTestHeap(t, 10)
+
+	var rid common.RID
+	a.True(heap.InsertTuple(rawTuple(1000, 1), &rid, nil))
+	// 4072 bytes after the header, minus the tuple and its slot, rounded down to a class
+	a.Equal(uint32(3056), heap.GetFreeSpaceMap().GetFreeSpace(rid.GetPageId()))
+	a.True(heap.UpdateTuple(rawTuple(100, 2), rid, nil))
+	a.Equal(uint32(3952), heap.GetFreeSpaceMap().GetFreeSpace(rid.GetPageId()))
+}
+
+// minimal transaction, the transaction package can't be imported from here
+type testTxn struct {
+	isolationLevel common.IsolationLevel
+	state          common.TransactionState
+	writeSet       *deque.Deque
+	sharedLocks    map[common.RID]struct{}
+	exclusiveLocks map[common.RID]struct{}
+}
+
+func newTestTxn(isolationLevel common.IsolationLevel) *testTxn {
+	return &testTxn{
+		isolationLevel: isolationLevel,
+		writeSet:       &deque.Deque{},
+		sharedLocks:    map[common.RID]struct{}{},
+		exclusiveLocks: map[common.RID]struct{}{},
+	}
+}
+
+func (t *testTxn) GetTransactionId() common.TxnID               { return 0 }
+func (t *testTxn) GetIsolationLevel() common.IsolationLevel     { return t.isolationLevel }
+func (t *testTxn) GetWriteSet() *deque.Deque                    { return t.writeSet }
+func (t *testTxn) GetIndexWriteSet() *deque.Deque               { return &deque.Deque{} }
+func (t *testTxn) GetSharedLockSet() map[common.RID]struct{}    { return t.sharedLocks }
+func (t *testTxn) GetExclusiveLockSet() map[common.RID]struct{} { return t.exclusiveLocks }
+func (t *testTxn) IsSharedLocked(rid common.RID) bool {
+	_, ok := t.sharedLocks[rid]
+	return ok
+}
+func (t *testTxn) IsExclusiveLocked(rid common.RID) bool {
+	_, ok := t.exclusiveLocks[rid]
+	return ok
+}
+func (t *testTxn) GetState() common.TransactionState  { return t.state }
+func (t *testTxn) SetState(s common.TransactionState) { t.state = s }
+func (t *testTxn) GetPrevLSN() common.LSN             { return common.InvalidLSN }
+func (t *testTxn) SetPrevLSN(common.LSN)              {}
+
+func TestTableIterator(t *testing.T) {
+	a := assert.New(t)
+	heap, _ := newTestHeap(t, 10)
+	heap.lockManager = &concurrency.LockManager{}
+
+	rids := make([]common.RID, 300)
+	for i := range rids {
+		a.True(heap.InsertTuple(rawTuple(100, byte(i)), &rids[i], nil))
+	}
+	a.Greater(heap.GetFreeSpaceMap().GetPageCount(), 1)
+
+	// every third tuple is gone, either applied or only marked deleted
+	for i := 0; i < len(rids); i += 3 {
+		a.True(heap.MarkDelete(rids[i], nil))
+		if i%2 == 0 {
+			heap.ApplyDelete(rids[i], nil)
+		}
+	}
+
+	for _, isolationLevel := range []common.IsolationLevel{common.ReadUncommitted, common.ReadCommitted, common.RepeatableRead} {
+		txn := newTestTxn(isolationLevel)
+		it := heap.Begin(txn)
+		count := 0
+		for tuple, ok := it.Next(); ok; tuple, ok = it.Next() {
+			a.Equal(rids[count/2*3+count%2+1], tuple.GetRID())
+			count++
+		}
+		a.Equal(200, count)
+
+		switch isolationLevel {
+		case common.ReadUncommitted, common.ReadCommitted:
+			a.Empty(txn.GetSharedLockSet())
+		case common.RepeatableRead:
+			a.Len(txn.GetSharedLockSet(), 200)
+		}
+	}
+
+	empty, _ := newTestHeap(t, 10)
+	_, ok := empty.Begin(nil).Next()
+	a.False(ok)
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package table
+
+import (
+	"goostub/common"
+	"goostub/storage/page"
+)
+
+/**
+ * TableIterator enables the sequential scan of a TableHeap.
+ *
+ * The iterator works one page at a time: it takes the page's read latch,
+ * copies out every live tuple, and releases the latch before handing the
+ * tuples out, so no latch is held between two calls to Next. Slots that are
+ * empty or marked deleted are skipped.
+ *
+ * Locking follows the transaction's isolation level:
+ * ReadUncommitted takes no shared locks, ReadCommitted releases each shared
+ * lock right after the tuple is read, and RepeatableRead keeps them until the
+ * transaction ends. A nil transaction reads without any locks.
+ * SnapshotIsolation takes no locks either and returns the versions of the
+ * tuples its snapshot sees, see mvcc.go.
+ */
+type TableIterator struct {
+	table *TableHeap
+	txn   common.Transaction
+	// next page to read, InvalidPageID when the last page has been read
+	nextPageId common.PageID
+	// tuples read from the current page that haven't been returned yet
+	buffered []*Tuple
+}
+
+/**
+ * @param txn the transaction performing the scan
+ * @return an iterator positioned before the first tuple of the table
+ */
+func (t *TableHeap) Begin(txn common.Transaction) *TableIterator {
+	return &TableIterator{
+		table:      t,
+		txn:        txn,
+		nextPageId: t.firstPageId,
+	}
+}
+
+/**
+ * Advance the iterator.
+ * @return the next tuple, and false if the scan is over or the transaction got aborted
+ */
+func (it *TableIterator) Next() (*Tuple, bool) {
+	for len(it.buffered) == 0 {
+		if it.nextPageId == common.InvalidPageID {
+			return nil, false
+		}
+		if !it.readPage() {
+			it.nextPageId = common.InvalidPageID
+			return nil, false
+		}
+	}
+
+	tuple := it.buffered[0]
+	it.buffered[0] = nil
+	it.buffered = it.buffered[1:]
+	return tuple, true
+}
+
+// read all live tuples of the next page into the buffer and move on to the page after it
+func (it *TableIterator) readPage() bool {
+	bpm := it.table.bpm
+	pid := it.nextPageId
+	p := bpm.FetchPage(pid, nil)
+	if p == nil {
+		return false
+	}
+	tp := page.PageAsTablePage(p)
+	tp.RLatch()
+	defer func() {
+		tp.RUnlatch()
+		bpm.UnpinPage(pid, false, nil)
+	}()
+
+	if snap := snapshotOf(it.txn); snap != nil {
+		it.buffered = it.table.readVisibleTuples(tp, snap, it.buffered)
+		it.nextPageId = tp.GetNextPageId()
+		return true
+	}
+
+	var rid common.RID
+	for found := tp.GetFirstTupleRid(&rid); found; found = tp.GetNextTupleRid(rid, &rid) {
+		data, ok := it.readTuple(tp, rid)
+		if !ok {
+			return false
+		}
+		it.buffered = append(it.buffered, &Tuple{allocated: true, rid: rid, data: data})
+	}
+	it.nextPageId = tp.GetNextPageId()
+	return true
+}
+
+func (it *TableIterator) readTuple(tp page.TablePage, rid common.RID) ([]byte, bool) {
+	txn := it.txn
+	lockManager := it.table.lockManager
+	if txn == nil || lockManager == nil {
+		return tp.GetTuple(rid, nil, nil)
+	}
+
+	alreadyLocked := txn.IsSharedLocked(rid) || txn.IsExclusiveLocked(rid)
+	data, ok := tp.GetTuple(rid, txn, lockManager)
+	if ok && !alreadyLocked && txn.GetIsolationLevel() == common.ReadCommitted {
+		// read committed only needs the lock while reading
+		lockManager.Unlock(txn, rid)
+	}
+	return data, ok && txn.GetState() != common.Aborted
+}
@@ -13,16 +13,20 @@ import (
 	"goostub/schema"
 	"goostub/types"
 	"log"
-	"reflect"
+	"strings"
+	"unsafe"
 )
 
+// the null bitmap follows the schema version at the start of the tuple
+const nullBitmapOffset = schema.TupleVersionSize
+
 type Tuple struct {
 	allocated bool       // is allocated?
 	rid       common.RID // if pointing to the table heap, the rid is valid
 	data      []byte
 }
 
-// valid args: NewTuple(), NewTuple(rid), NewTuple(tuple), NewTuple(values, schema)
+// valid args: NewTuple(), NewTuple(rid), NewTuple(tuple), NewTuple(data), NewTuple(values, schema)
 func NewTuple(args ...interface{}) *Tuple {
 	switch len(args) {
 	case 0:
@@ -34,6 +38,10 @@ func NewTuple(args ...interface{}) *Tuple {
 		if t, ok := args[0].(*Tuple); ok {
 			return CopyTuple(t)
 		}
+		if data, ok := args[0].([]byte); ok {
+			// serialized tuple data, e.g. a key stored in an index page, not copied
+			return &Tuple{rid: common.DefaultRID(), data: data}
+		}
 	case 2:
 		if vals, ok := args[0].([]*types.Value); ok {
 			if schema, ok := args[1].(*schema.Schema); ok {
@@ -87,27 +95,38 @@ func newTupleFromValues(vals []*types.Value, schema *schema.Schema) *Tuple {
 
 	tupleSize := schema.GetLength()
 
-	for i := range schema.GetUninlinedColumns() {
+	for _, i := range schema.GetUninlinedColumns() {
 		// uninlined are varchar columns, need extra bytes to indicate length
-		tupleSize += vals[i].GetLength() + uint32(reflect.TypeOf(tupleSize).Size())
+		tupleSize += varlenSize(vals[i])
 	}
 
 	t.data = make([]byte, tupleSize)
 
-	buf := bytes.NewBuffer(t.data[:0])
+	// the schema version and the null bitmap come first, the fixed-length columns start after them
+	binary.LittleEndian.PutUint16(t.data, schema.GetVersion())
+	for i, val := range vals {
+		if val.IsNull() {
+			t.data[nullBitmapOffset+i/8] |= 1 << (i % 8)
+		}
+	}
+
+	buf := bytes.NewBuffer(t.data[:nullBitmapOffset+schema.GetNullBitmapSize()])
 	// varchar data offset
 	offset := schema.GetLength()
 	varcharbuf := bytes.NewBuffer(t.data[offset:offset])
 
 	for i := 0; i < schema.GetColumnCount(); i++ {
 		col := schema.GetColumn(i)
-		if !col.IsInlined() {
+		if vals[i].IsNull() {
+			// nulls are only recorded in the bitmap, leave the column zeroed
+			buf.Write(make([]byte, col.GetFixedLength()))
+		} else if !col.IsInlined() {
 			// write data offset to current column position
 			binary.Write(buf, binary.LittleEndian, offset)
 			// write actual data to offset
 			vals[i].SerializeTo(varcharbuf)
 			// advance offset for the next varchar column
-			offset += vals[i].GetLength() + uint32(reflect.TypeOf(tupleSize).Size())
+			offset += varlenSize(vals[i])
 		} else {
 			// just write to current column position
 			vals[i].SerializeTo(buf)
@@ -117,6 +136,14 @@ func newTupleFromValues(vals []*types.Value, schema *schema.Schema) *Tuple {
 	return t
 }
 
+// serialized size of an uninlined value: length prefix + data, a null takes no space
+func varlenSize(v *types.Value) uint32 {
+	if v.IsNull() {
+		return 0
+	}
+	return uint32(unsafe.Sizeof(uint32(0))) + v.GetLength()
+}
+
 func (t *Tuple) SerializeTo(storage *bytes.Buffer) {
 	binary.Write(storage, binary.LittleEndian, uint32(len(t.data)))
 	binary.Write(storage, binary.LittleEndian, t.data)
@@ -133,3 +160,152 @@ func (t *Tuple) DeserializeFrom(storage *bytes.Buffer) {
 func (t *Tuple) GetData() []byte {
 	return t.data
 }
+
+func (t *Tuple) GetRID() common.RID {
+	return t.rid
+}
+
+func (t *Tuple) SetRID(rid common.RID) {
+	t.rid = rid
+}
+
+// size of the serialized tuple in bytes
+func (t *Tuple) GetLength() uint32 {
+	return uint32(len(t.data))
+}
+
+func (t *Tuple) IsAllocated() bool {
+	return t.allocated
+}
+
+/** @return the version of the schema the tuple was written with */
+func (t *Tuple) GetSchemaVersion() uint16 {
+	return schema.TupleVersion(t.data)
+}
+
+/**
+ * Get the value of a specified column (const)
+ * @param schema the schema of the tuple, or a later version of it
+ * @param colIdx index of the column
+ * @return the value of the column, its default value if the tuple was written before the column was added
+ */
+func (t *Tuple) GetValue(schema *schema.Schema, colIdx int) *types.Value {
+	col := schema.GetColumn(colIdx)
+	layout, idx, err := schema.MapColumn(t.GetSchemaVersion(), colIdx)
+	if err != nil {
+		level.Error(common.Logger).Log("Value deserialization error: ", err)
+		return nil
+	}
+	if idx < 0 {
+		if def := col.GetDefaultValue(); def != nil {
+			return def.Copy()
+		}
+		return types.NewValue(col.GetType())
+	}
+	if t.isNull(idx) {
+		return types.NewValue(col.GetType())
+	}
+	val, err := types.GetInstance(col.GetType()).DeserializeFrom(bytes.NewBuffer(t.getDataPtr(layout, idx)))
+	if err != nil {
+		level.Error(common.Logger).Log("Value deserialization error: ", err)
+		return nil
+	}
+	return val
+}
+
+/**
+ * @param schema the schema of the tuple, or a later version of it
+ * @param colIdx index of the column
+ * @return true if the column is NULL according to the tuple's null bitmap, or if it can't be read
+ */
+func (t *Tuple) IsNull(schema *schema.Schema, colIdx int) bool {
+	_, idx, err := schema.MapColumn(t.GetSchemaVersion(), colIdx)
+	if err != nil {
+		// the column can't be read
+		level.Error(common.Logger).Log("Null bitmap error: ", err)
+		return true
+	}
+	if idx < 0 {
+		return schema.GetColumn(colIdx).GetDefaultValue() == nil
+	}
+	return t.isNull(idx)
+}
+
+func (t *Tuple) isNull(idx int) bool {
+	return t.data[nullBitmapOffset+idx/8]&(1<<(idx%8)) != 0
+}
+
+/**
+ * Rewrite a tuple written with an older version of the schema in the layout of the schema.
+ * @param schema the current schema of the tuple
+ * @return the tuple itself if it already has the layout, otherwise a new tuple with the same rid
+ */
+func (t *Tuple) Upgrade(schema *schema.Schema) *Tuple {
+	if t.GetSchemaVersion() == schema.GetVersion() {
+		return t
+	}
+	return t.Relayout(schema, schema)
+}
+
+/**
+ * Copy the values of a tuple into another layout with the same columns.
+ * @param from the schema of the tuple
+ * @param to the schema of the new tuple
+ * @return a new tuple with the same rid
+ */
+func (t *Tuple) Relayout(from *schema.Schema, to *schema.Schema) *Tuple {
+	vals := make([]*types.Value, from.GetColumnCount())
+	for i := range vals {
+		vals[i] = t.GetValue(from, i)
+	}
+	relaid := newTupleFromValues(vals, to)
+	relaid.rid = t.rid
+	return relaid
+}
+
+/**
+ * Generates a key tuple given schemas and attributes
+ * @param schema the schema of the tuple
+ * @param keySchema the schema of the key
+ * @param keyAttrs indices of the key columns in schema
+ * @return the key tuple
+ */
+func (t *Tuple) KeyFromTuple(schema *schema.Schema, keySchema *schema.Schema, keyAttrs []uint32) *Tuple {
+	vals := make([]*types.Value, len(keyAttrs))
+	for i, idx := range keyAttrs {
+		vals[i] = t.GetValue(schema, int(idx))
+	}
+	return newTupleFromValues(vals, keySchema)
+}
+
+/**
+ * @return a string of the values of the tuple, for debugging purposes
+ */
+func (t *Tuple) String(schema *schema.Schema) string {
+	b := &strings.Builder{}
+	b.WriteString("(")
+	for i := 0; i < schema.GetColumnCount(); i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		val := t.GetValue(schema, i)
+		if val.IsNull() {
+			b.WriteString("<NULL>")
+		} else {
+			b.WriteString(val.String())
+		}
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// get the serialized data of the column, following the offset for uninlined columns
+func (t *Tuple) getDataPtr(schema *schema.Schema, colIdx int) []byte {
+	col := schema.GetColumn(colIdx)
+	if col.IsInlined() {
+		return t.data[col.GetOffset():]
+	}
+	// the column position holds the offset of the actual data
+	offset := binary.LittleEndian.Uint32(t.data[col.GetOffset():])
+	return t.data[offset:]
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package table
+
+import (
+	"github.com/stretchr/testify/assert"
+	"goostub/schema"
+	"goostub/types"
+	"testing"
+)
+
+func newTestSchema() *schema.Schema {
+	return schema.NewSchema([]schema.Column{
+		*schema.NewColumn("id", types.INTEGER, nil),
+		*schema.NewColumn("name", types.VARCHAR, nil),
+		*schema.NewColumn("flag", types.BOOLEAN, nil),
+		*schema.NewColumn("tiny", types.TINYINT, nil),
+		*schema.NewColumn("small", types.SMALLINT, nil),
+		*schema.NewColumn("big", types.BIGINT, nil),
+		*schema.NewColumn("price", types.DECIMAL, nil),
+		*schema.NewColumn("email", types.VARCHAR, nil),
+		*schema.NewColumn("ts", types.TIMESTAMP, nil),
+	})
+}
+
+func newTestValues() []*types.Value {
+	return []*types.Value{
+		types.NewValue(types.INTEGER, int32(42)),
+		types.NewValue(types.VARCHAR, "goose"),
+		types.NewValue(types.BOOLEAN, int8(1)),
+		types.NewValue(types.TINYINT, int8(-3)),
+		types.NewValue(types.SMALLINT, int16(300)),
+		types.NewValue(types.BIGINT, int64(1)<<40),
+		types.NewValue(types.DECIMAL, 3.25),
+		types.NewValue(types.VARCHAR, "goose@goostub.org"),
+		types.NewValue(types.TIMESTAMP, uint64(1650000000000000000)),
+	}
+}
+
+func TestTupleGetValue(t *testing.T) {
+	a := assert.New(t)
+	s := newTestSchema()
+	vals := newTestValues()
+	tuple := NewTuple(vals, s)
+
+	for i, expected := range vals {
+		actual := tuple.GetValue(s, i)
+		a.Equal(expected.GetTypeID(), actual.GetTypeID(), s.GetColumn(i).GetName())
+		res, err := actual.CompareTo(expected)
+		a.Nil(err)
+		a.Equal(types.CmpEqual, res, s.GetColumn(i).GetName())
+	}
+
+	a.Equal("(42, goose, true, -3, 300, 1099511627776, 3.25, goose@goostub.org, "+vals[8].String()+")", tuple.String(s))
+}
+
+func TestTupleKeyFromTuple(t *testing.T) {
+	a := assert.New(t)
+	s := newTestSchema()
+	tuple := NewTuple(newTestValues(), s)
+
+	keyAttrs := []uint32{7, 0}
+	keySchema := schema.CopySchema(s, keyAttrs)
+	key := tuple.KeyFromTuple(s, keySchema, keyAttrs)
+	a.Equal("(goose@goostub.org, 42)", key.String(keySchema))
+
+	expected := NewTuple([]*types.Value{
+		types.NewValue(types.VARCHAR, "goose@goostub.org"),
+		types.NewValue(types.INTEGER, int32(42)),
+	}, keySchema)
+	a.Equal(expected.GetData(), key.GetData())
+}
+
+func TestTupleNullBitmap(t *testing.T) {
+	a := assert.New(t)
+	s := newTestSchema()
+	a.Equal(uint32(2), s.GetNullBitmapSize())
+
+	// every column NULL
+	nulls := make([]*types.Value, s.GetColumnCount())
+	for i := range nulls {
+		nulls[i] = types.NewValue(s.GetColumn(i).GetType())
+	}
+	tuple := NewTuple(nulls, s)
+	a.Equal(s.GetLength(), tuple.GetLength())
+	for i := range nulls {
+		a.True(tuple.IsNull(s, i))
+		val := tuple.GetValue(s, i)
+		a.True(val.IsNull(), s.GetColumn(i).GetName())
+		a.Equal(s.GetColumn(i).GetType(), val.GetTypeID())
+	}
+
+	// NULLs mixed with values, including a varchar after a NULL varchar
+	vals := newTestValues()
+	vals[1] = types.NewValue(types.VARCHAR)
+	vals[8] = types.NewValue(types.TIMESTAMP)
+	tuple = NewTuple(vals, s)
+	a.Equal("(42, <NULL>, true, -3, 300, 1099511627776, 3.25, goose@goostub.org, <NULL>)", tuple.String(s))
+	a.False(tuple.IsNull(s, 7))
+
+	// the values that used to mean NULL can be stored
+	sentinels := []*types.Value{
+		types.NewValue(types.INTEGER, types.GOOSTUB_INT32_NULL),
+		types.NewValue(types.VARCHAR, ""),
+		types.NewValue(types.BOOLEAN, types.GOOSTUB_BOOLEAN_NULL),
+		types.NewValue(types.TINYINT, types.GOOSTUB_INT8_NULL),
+		types.NewValue(types.SMALLINT, types.GOOSTUB_INT16_NULL),
+		types.NewValue(types.BIGINT, types.GOOSTUB_INT64_NULL),
+		types.NewValue(types.DECIMAL, types.GOOSTUB_DECIMAL_NULL),
+		types.NewValue(types.VARCHAR, "x"),
+		types.NewValue(types.TIMESTAMP, types.GOOSTUB_TIMESTAMP_NULL),
+	}
+	tuple = NewTuple(sentinels, s)
+	for i, expected := range sentinels {
+		actual := tuple.GetValue(s, i)
+		a.False(actual.IsNull(), s.GetColumn(i).GetName())
+		if i != 2 {
+			res, err := actual.CompareTo(expected)
+			a.Nil(err)
+			a.Equal(types.CmpEqual, res, s.GetColumn(i).GetName())
+		}
+	}
+}
+
+func TestTupleSchemaVersions(t *testing.T) {
+	a := assert.New(t)
+	v0, _ := schema.ParseSchema("id:INTEGER, name:VARCHAR, score:BIGINT")
+	old := NewTuple([]*types.Value{
+		types.NewValue(types.INTEGER, int32(1)),
+		types.NewValue(types.VARCHAR, "goose"),
+		types.NewValue(types.BIGINT, int64(7)),
+	}, v0)
+	a.Equal(uint16(0), old.GetSchemaVersion())
+
+	// an added column reads as its default, or NULL without one
+	v1, err := v0.AddColumn("level", types.SMALLINT, types.NewValue(types.INTEGER, int32(3)))
+	a.Nil(err)
+	v2, err := v1.AddColumn("note", types.VARCHAR, nil)
+	a.Nil(err)
+	a.Equal("(1, goose, 7, 3, <NULL>)", old.String(v2))
+	a.False(old.IsNull(v2, 3))
+	a.True(old.IsNull(v2, 4))
+
+	// a dropped column is gone, also when a column with the same name comes back
+	v3, err := v2.DropColumn("name")
+	a.Nil(err)
+	v4, err := v3.AddColumn("name", types.VARCHAR, nil)
+	a.Nil(err)
+	a.Equal(uint16(4), v4.GetVersion())
+	a.Equal("(1, 7, 3, <NULL>, <NULL>)", old.String(v4))
+	mid := NewTuple([]*types.Value{
+		types.NewValue(types.INTEGER, int32(2)),
+		types.NewValue(types.BIGINT, int64(8)),
+		types.NewValue(types.SMALLINT, int16(4)),
+		types.NewValue(types.VARCHAR, "note"),
+	}, v3)
+	a.Equal("(2, 8, 4, note, <NULL>)", mid.String(v4))
+
+	// upgrading rewrites the tuple in the current layout
+	upgraded := old.Upgrade(v4)
+	a.Equal(uint16(4), upgraded.GetSchemaVersion())
+	a.Equal(old.String(v4), upgraded.String(v4))
+	a.Same(upgraded, upgraded.Upgrade(v4))
+	a.Equal(v0.GetColumns(), v4.GetVersionSchema(0).GetColumns())
+
+	// only the latest version can be altered
+	_, err = v2.AddColumn("late", types.INTEGER, nil)
+	a.NotNil(err)
+	_, err = v4.AddColumn("id", types.INTEGER, nil)
+	a.NotNil(err)
+	_, err = v4.DropColumn("missing")
+	a.NotNil(err)
+
+	// an earlier version can't read the tuples of a later one
+	_, _, err = v2.MapColumn(upgraded.GetSchemaVersion(), 0)
+	a.NotNil(err)
+	a.Nil(upgraded.GetValue(v2, 0))
+	a.True(upgraded.IsNull(v2, 0))
+	layout, idx, err := v4.MapColumn(mid.GetSchemaVersion(), 2)
+	a.Nil(err)
+	a.Same(v3, layout)
+	a.Equal(2, idx)
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package table
+
+import (
+	"goostub/common"
+	"goostub/storage/page"
+)
+
+/**
+ * Vacuum compacts a table heap. The data of a deleted tuple is already
+ * reclaimed when the delete is applied on commit, but its slot stays behind
+ * and a page never leaves the chain. Vacuum
+ *
+ *  1. compacts the slot array of each page, see TablePage.CompactSlots,
+ *  2. moves the tuples of a nearly empty page into the page before it and
+ *  3. unlinks the pages left empty and frees them through the buffer pool.
+ *
+ * Moving a tuple changes its RID, so this can't happen under a running
+ * transaction. The heap is vacuumed one page at a time, each step with all
+ * transactions blocked, so that ordinary transactions can run in between.
+ * Work done without a transaction (a nil txn) isn't blocked and must not run
+ * concurrently.
+ */
+
+// a page is merged into the one before it when its tuples take at most this many bytes
+const vacuumMergeThreshold = common.PageSize / 4
+
+/**
+ * Stops transactions while vacuum moves tuples, implemented by the transaction manager.
+ */
+type TransactionBlocker interface {
+	// wait for the running transactions to finish and keep new ones from starting
+	BlockAllTransactions()
+	ResumeTransactions()
+}
+
+/**
+ * Called for every tuple vacuum moves, while transactions are still blocked,
+ * so that the RIDs stored elsewhere (e.g. in indexes) can be fixed up. The
+ * tuple has moved whatever the callback returns, an error stops vacuum once
+ * the page being vacuumed is done.
+ */
+type TupleMoveCallback func(from common.RID, to common.RID, tuple *Tuple) error
+
+type VacuumStats struct {
+	PagesScanned   int
+	PagesFreed     int
+	TuplesMoved    int
+	SlotsReclaimed int
+	// bytes of the freed pages plus the slot entries dropped
+	BytesReclaimed int
+	// the first error of the move callback, vacuum stopped after it
+	Err error
+}
+
+/**
+ * Compact the table, see above.
+ * @param blocker stops transactions while tuples move, may be nil if nobody else uses the table
+ * @param onMove called for every moved tuple, may be nil
+ * @return what vacuum did
+ */
+func (t *TableHeap) Vacuum(blocker TransactionBlocker, onMove TupleMoveCallback) *VacuumStats {
+	stats := &VacuumStats{}
+	for pid := t.firstPageId; pid != common.InvalidPageID; {
+		if blocker != nil {
+			blocker.BlockAllTransactions()
+		}
+		t.appendLatch.Lock()
+		next := t.vacuumPage(pid, stats, onMove)
+		t.appendLatch.Unlock()
+		if blocker != nil {
+			blocker.ResumeTransactions()
+		}
+		if stats.Err != nil {
+			break
+		}
+		pid = next
+	}
+	return stats
+}
+
+// compact one page and absorb the nearly empty pages following it, return the next page to vacuum
+func (t *TableHeap) vacuumPage(pid common.PageID, stats *VacuumStats, onMove TupleMoveCallback) common.PageID {
+	p := t.bpm.FetchPage(pid, nil)
+	common.Assert.NotNil(p, "Couldn't fetch a table page.")
+	tp := page.PageAsTablePage(p)
+	tp.WLatch()
+	stats.PagesScanned++
+
+	moves, dropped := tp.CompactSlots()
+	for _, m := range moves {
+		t.tupleMoved(tp, common.NewRID(pid, m.From), common.NewRID(pid, m.To), stats, onMove)
+	}
+	stats.SlotsReclaimed += int(dropped)
+	stats.BytesReclaimed += int(dropped) * page.SizeTableSlot
+
+	for {
+		nextId := tp.GetNextPageId()
+		if nextId == common.InvalidPageID {
+			break
+		}
+		np := t.bpm.FetchPage(nextId, nil)
+		common.Assert.NotNil(np, "Couldn't fetch a table page.")
+		ntp := page.PageAsTablePage(np)
+		ntp.WLatch()
+		if !canMerge(tp, ntp) {
+			ntp.WUnlatch()
+			t.bpm.UnpinPage(nextId, false, nil)
+			break
+		}
+
+		for i := uint32(0); i < ntp.GetTupleCount(); i++ {
+			if page.IsDeleted(ntp.GetTupleSize(i)) {
+				continue
+			}
+			from := common.NewRID(nextId, i)
+			data, _ := ntp.GetTuple(from, nil, nil)
+			var to common.RID
+			common.Assert.True(tp.InsertTuple(data, &to, nil, nil), "A merged tuple didn't fit.")
+			t.tupleMoved(tp, from, to, stats, onMove)
+		}
+
+		// unlink the empty page
+		nextNextId := ntp.GetNextPageId()
+		tp.SetNextPageId(nextNextId)
+		if nextNextId != common.InvalidPageID {
+			nnp := t.bpm.FetchPage(nextNextId, nil)
+			common.Assert.NotNil(nnp, "Couldn't fetch a table page.")
+			nntp := page.PageAsTablePage(nnp)
+			nntp.WLatch()
+			nntp.SetPrevPageId(pid)
+			nntp.WUnlatch()
+			t.bpm.UnpinPage(nextNextId, true, nil)
+		}
+		ntp.WUnlatch()
+		t.bpm.UnpinPage(nextId, false, nil)
+		t.fsm.Remove(nextId)
+		t.bpm.DeletePage(nextId, nil)
+		stats.PagesScanned++
+		stats.PagesFreed++
+		stats.BytesReclaimed += common.PageSize
+	}
+
+	t.fsm.Update(pid, tp.GetFreeSpaceRemaining())
+	next := tp.GetNextPageId()
+	tp.WUnlatch()
+	t.bpm.UnpinPage(pid, true, nil)
+	return next
+}
+
+func (t *TableHeap) tupleMoved(tp page.TablePage, from common.RID, to common.RID, stats *VacuumStats, onMove TupleMoveCallback) {
+	stats.TuplesMoved++
+	t.moveVersions(from, to)
+	if onMove == nil {
+		return
+	}
+	data, _ := tp.GetTuple(to, nil, nil)
+	if err := onMove(from, to, &Tuple{allocated: true, rid: to, data: data}); err != nil && stats.Err == nil {
+		stats.Err = err
+	}
+}
+
+// whether all tuples of next fit in prev, and are few enough to be worth moving
+func canMerge(prev page.TablePage, next page.TablePage) bool {
+	needed := uint32(0)
+	for i := uint32(0); i < next.GetTupleCount(); i++ {
+		size := next.GetTupleSize(i)
+		if size == 0 {
+			continue
+		}
+		if page.IsDeleted(size) {
+			// marked as deleted, somebody still refers to it
+			return false
+		}
+		needed += size + page.SizeTableSlot
+	}
+	return needed <= vacuumMergeThreshold && needed <= prev.GetFreeSpaceRemaining()
+}
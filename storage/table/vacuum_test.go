@@ -0,0 +1,187 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package table
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"goostub/common"
+	"sync"
+	"testing"
+)
+
+// a tuple of size bytes starting with its id
+func idTuple(id uint32, size int) *Tuple {
+	tuple := rawTuple(size, byte(id))
+	binary.LittleEndian.PutUint32(tuple.data, id)
+	return tuple
+}
+
+// the ids of all tuples in the heap, in scan order
+func scanIds(heap *TableHeap) []uint32 {
+	var ids []uint32
+	it := heap.Begin(nil)
+	for tuple, ok := it.Next(); ok; tuple, ok = it.Next() {
+		ids = append(ids, binary.LittleEndian.Uint32(tuple.data))
+	}
+	return ids
+}
+
+// a blocker the way the transaction manager implements it
+type testBlocker struct {
+	latch sync.RWMutex
+}
+
+func (b *testBlocker) BlockAllTransactions() { b.latch.Lock() }
+func (b *testBlocker) ResumeTransactions()   { b.latch.Unlock() }
+
+func TestVacuum(t *testing.T) {
+	a := assert.New(t)
+	heap, bpm := newTestHeap(t, 10)
+
+	rids := make([]common.RID, 600)
+	for i := range rids {
+		a.True(heap.InsertTuple(idTuple(uint32(i), 100), &rids[i], nil))
+	}
+	pagesBefore := heap.GetFreeSpaceMap().GetPageCount()
+	a.Greater(pagesBefore, 10)
+	lastPageId := heap.GetFreeSpaceMap().GetLastPageId()
+
+	// keep every tenth tuple, and one tuple only marked as deleted
+	var kept []uint32
+	for i := range rids {
+		if i%10 == 0 {
+			kept = append(kept, uint32(i))
+			continue
+		}
+		a.True(heap.MarkDelete(rids[i], nil))
+		if i != 55 {
+			heap.ApplyDelete(rids[i], nil)
+		}
+	}
+
+	where := make(map[common.RID]uint32)
+	for _, id := range kept {
+		where[rids[id]] = id
+	}
+	stats := heap.Vacuum(&testBlocker{}, func(from common.RID, to common.RID, tuple *Tuple) error {
+		id, ok := where[from]
+		a.True(ok, from)
+		a.Equal(id, binary.LittleEndian.Uint32(tuple.data))
+		a.Equal(to, tuple.GetRID())
+		delete(where, from)
+		where[to] = id
+		return nil
+	})
+
+	a.Equal(pagesBefore, stats.PagesScanned)
+	a.Greater(stats.PagesFreed, 0)
+	a.Greater(stats.TuplesMoved, 0)
+	a.Equal(stats.PagesFreed*common.PageSize+stats.SlotsReclaimed*8, stats.BytesReclaimed)
+	a.Equal(pagesBefore-stats.PagesFreed, heap.GetFreeSpaceMap().GetPageCount())
+	a.ElementsMatch(kept, scanIds(heap))
+
+	// the RIDs handed to the callback are where the tuples are now
+	a.Len(where, len(kept))
+	for rid, id := range where {
+		var tuple Tuple
+		a.True(heap.GetTuple(rid, &tuple, nil))
+		a.Equal(id, binary.LittleEndian.Uint32(tuple.data))
+	}
+
+	// the tuple marked as deleted didn't move and can come back
+	heap.RollbackDelete(rids[55], nil)
+	var tuple Tuple
+	a.True(heap.GetTuple(rids[55], &tuple, nil))
+	a.Equal(uint32(55), binary.LittleEndian.Uint32(tuple.data))
+
+	// the freed pages are reused
+	var pid common.PageID
+	a.NotNil(bpm.NewPage(&pid, nil))
+	a.LessOrEqual(pid, lastPageId)
+	bpm.UnpinPage(pid, false, nil)
+
+	// nothing left to do
+	stats = heap.Vacuum(nil, nil)
+	a.Equal(0, stats.PagesFreed)
+	a.Equal(0, stats.TuplesMoved)
+	a.Equal(0, stats.BytesReclaimed)
+}
+
+func TestVacuumEmptiesTable(t *testing.T) {
+	a := assert.New(t)
+	heap, _ := newTestHeap(t, 10)
+
+	rids := make([]common.RID, 200)
+	for i := range rids {
+		a.True(heap.InsertTuple(idTuple(uint32(i), 200), &rids[i], nil))
+	}
+	for _, rid := range rids {
+		a.True(heap.MarkDelete(rid, nil))
+		heap.ApplyDelete(rid, nil)
+	}
+
+	pagesBefore := heap.GetFreeSpaceMap().GetPageCount()
+	stats := heap.Vacuum(nil, nil)
+	// only the first page is left, with no slots
+	a.Equal(pagesBefore-1, stats.PagesFreed)
+	a.Greater(stats.SlotsReclaimed, 0)
+	a.Equal(stats.PagesFreed*common.PageSize+stats.SlotsReclaimed*8, stats.BytesReclaimed)
+	a.Equal(1, heap.GetFreeSpaceMap().GetPageCount())
+	a.Equal(heap.GetFirstPageId(), heap.GetFreeSpaceMap().GetLastPageId())
+	a.Empty(scanIds(heap))
+
+	// the table is usable again
+	var rid common.RID
+	a.True(heap.InsertTuple(idTuple(7, 200), &rid, nil))
+	a.Equal(common.NewRID(heap.GetFirstPageId(), 0), rid)
+	a.Equal([]uint32{7}, scanIds(heap))
+}
+
+func TestVacuumConcurrentReaders(t *testing.T) {
+	a := assert.New(t)
+	heap, _ := newTestHeap(t, 32)
+
+	rids := make([]common.RID, 1000)
+	for i := range rids {
+		a.True(heap.InsertTuple(idTuple(uint32(i), 64), &rids[i], nil))
+	}
+	for i := range rids {
+		if i%7 != 0 {
+			a.True(heap.MarkDelete(rids[i], nil))
+			heap.ApplyDelete(rids[i], nil)
+		}
+	}
+	expected := len(scanIds(heap))
+
+	// every scan runs as a "transaction" and must see a consistent table
+	blocker := &testBlocker{}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				blocker.latch.RLock()
+				count := len(scanIds(heap))
+				blocker.latch.RUnlock()
+				a.Equal(expected, count)
+			}
+		}()
+	}
+
+	stats := heap.Vacuum(blocker, nil)
+	close(done)
+	wg.Wait()
+	a.Greater(stats.PagesFreed, 0)
+	a.Equal(expected, len(scanIds(heap)))
+}
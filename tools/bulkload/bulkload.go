@@ -0,0 +1,383 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package bulkload
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"goostub/catalog"
+	"goostub/common"
+	"goostub/schema"
+	"goostub/storage/table"
+	"goostub/tools/export"
+	"goostub/types"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/**
+ * bulkload fills a table from a CSV file, or from a binary dump written by
+ * the export tool.
+ *
+ * The first record of the file is a header naming the columns of the table,
+ * in any order. Columns of the table missing from the header are loaded as
+ * NULL. Each field is converted to the type of its column, and a field equal
+ * to Options.NullString is loaded as NULL.
+ *
+ * When the table is empty, the tuples are appended page by page without any
+ * locking, since nobody else can see them yet. Otherwise every tuple goes
+ * through the regular insert path of the table heap within Options.Txn.
+ * Indexes of the table are filled at the end, in one pass over the loaded
+ * tuples, rather than once per tuple.
+ */
+
+// number of tuples appended to the table heap at once when the table is new
+const batchSize = 1024
+
+type Options struct {
+	// field delimiter, ',' if unset
+	Comma rune
+	// fields equal to this string are NULL
+	NullString string
+	// skip rows that fail to convert instead of stopping the load
+	SkipBadRows bool
+	// transaction for loading into a table that already has tuples, may be nil
+	Txn common.Transaction
+}
+
+type Stats struct {
+	// number of tuples inserted
+	Rows int
+	// rows that were skipped, only with Options.SkipBadRows
+	BadRows []*RowError
+	// whether the table was new and got filled page by page
+	Appended bool
+}
+
+// RowError describes a record of the CSV file that couldn't be loaded
+type RowError struct {
+	Line   int    // line of the record in the CSV file
+	Column string // column that failed to convert, empty if the whole record is bad
+	Err    error
+}
+
+func (e *RowError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d, column %s: %v", e.Line, e.Column, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+/**
+ * Load the CSV data of r into a table of the catalog and update the table's indexes.
+ * @param cat catalog containing the table
+ * @param tableName name of the table to load into
+ * @param r the CSV data, starting with a header
+ * @param opts load options
+ * @return what was loaded, and the first error that stopped the load. Rows
+ * loaded before the error stay in the table and in its indexes.
+ */
+func Load(cat *catalog.Catalog, tableName string, r io.Reader, opts Options) (*Stats, error) {
+	info := cat.GetTableByName(tableName)
+	if info == nil {
+		return nil, fmt.Errorf("table %q doesn't exist", tableName)
+	}
+
+	reader := csv.NewReader(r)
+	if opts.Comma != 0 {
+		reader.Comma = opts.Comma
+	}
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	colMap, err := mapHeader(header, &info.Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	l := newLoader(info, opts)
+	l.colMap = colMap
+	return l.run(cat, func() error { return l.readRecords(reader) })
+}
+
+/**
+ * Load a binary dump written by the export tool into a table of the catalog.
+ * The tuples are stored exactly as they were dumped.
+ * @param cat catalog containing the table
+ * @param tableName name of the table to load into, its columns must match the dump's
+ * @param dump the dump to load
+ * @param opts load options, only Txn is used
+ * @return what was loaded, and the error that stopped the load
+ */
+func LoadDump(cat *catalog.Catalog, tableName string, dump *export.DumpReader, opts Options) (*Stats, error) {
+	info := cat.GetTableByName(tableName)
+	if info == nil {
+		return nil, fmt.Errorf("table %q doesn't exist", tableName)
+	}
+	if err := matchSchema(dump.Schema(), &info.Schema); err != nil {
+		return nil, err
+	}
+
+	l := newLoader(info, opts)
+	return l.run(cat, func() error {
+		for {
+			tuple, err := dump.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if info.Schema.GetVersion() != 0 {
+				// the dump has the layout of a table that was never altered
+				tuple = tuple.Relayout(dump.Schema(), &info.Schema)
+			}
+			if err := l.insert(tuple); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+type loader struct {
+	info *catalog.TableInfo
+	opts Options
+	// colMap[i] is the field index of column i, -1 if the column isn't in the file
+	colMap []int
+	stats  *Stats
+	// rids of the loaded tuples, in load order
+	rids []common.RID
+	// tuples waiting to be appended when the table is new
+	batch []*table.Tuple
+}
+
+func newLoader(info *catalog.TableInfo, opts Options) *loader {
+	return &loader{
+		info:  info,
+		opts:  opts,
+		stats: &Stats{Appended: isEmpty(info.Table)},
+	}
+}
+
+// load the tuples produced by read, the rows read before an error are still
+// added to the table and to its indexes
+func (l *loader) run(cat *catalog.Catalog, read func() error) (*Stats, error) {
+	err := read()
+	if flushErr := l.flush(); err == nil {
+		err = flushErr
+	}
+	if indexErr := l.buildIndexes(cat); err == nil {
+		err = indexErr
+	}
+	return l.stats, err
+}
+
+func (l *loader) readRecords(reader *csv.Reader) error {
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// the reader knows no field positions for a row it couldn't parse
+			rowErr := &RowError{Err: err}
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				rowErr.Line = parseErr.Line
+			}
+			if err := l.badRow(rowErr); err != nil {
+				return err
+			}
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+
+		tuple, rowErr := l.makeTuple(record)
+		if rowErr != nil {
+			rowErr.Line = line
+			if err := l.badRow(rowErr); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := l.insert(tuple); err != nil {
+			return &RowError{Line: line, Err: err}
+		}
+	}
+	return nil
+}
+
+// record a bad row, the error is returned unless bad rows are skipped
+func (l *loader) badRow(err *RowError) error {
+	if !l.opts.SkipBadRows {
+		return err
+	}
+	l.stats.BadRows = append(l.stats.BadRows, err)
+	return nil
+}
+
+// convert the fields of a record into a tuple of the table
+func (l *loader) makeTuple(record []string) (*table.Tuple, *RowError) {
+	s := &l.info.Schema
+	vals := make([]*types.Value, s.GetColumnCount())
+	for i, fieldIdx := range l.colMap {
+		col := s.GetColumn(i)
+		if fieldIdx < 0 {
+			vals[i] = types.NewValue(col.GetType())
+			continue
+		}
+		if fieldIdx >= len(record) {
+			return nil, &RowError{Err: fmt.Errorf("expected at least %d fields, got %d", fieldIdx+1, len(record))}
+		}
+		val, err := convert(record[fieldIdx], col.GetType(), l.opts.NullString)
+		if err != nil {
+			return nil, &RowError{Column: col.GetName(), Err: err}
+		}
+		vals[i] = val
+	}
+	return table.NewTuple(vals, s), nil
+}
+
+func (l *loader) insert(tuple *table.Tuple) error {
+	if l.stats.Appended {
+		l.batch = append(l.batch, tuple)
+		if len(l.batch) == batchSize {
+			return l.flush()
+		}
+		return nil
+	}
+
+	var rid common.RID
+	if !l.info.Table.InsertTuple(tuple, &rid, l.opts.Txn) {
+		return errors.New("the table heap rejected the tuple")
+	}
+	l.rids = append(l.rids, rid)
+	l.stats.Rows++
+	return nil
+}
+
+// append the pending batch to the table
+func (l *loader) flush() error {
+	if len(l.batch) == 0 {
+		return nil
+	}
+	rids := make([]common.RID, len(l.batch))
+	ok := l.info.Table.AppendTuples(l.batch, rids)
+	l.batch = l.batch[:0]
+	if !ok {
+		return errors.New("failed to append tuples to the table heap")
+	}
+	l.rids = append(l.rids, rids...)
+	l.stats.Rows += len(rids)
+	return nil
+}
+
+// insert the loaded tuples into every index of the table, reading each tuple once.
+// Returns the first entry a unique index rejected, the other entries are still inserted.
+func (l *loader) buildIndexes(cat *catalog.Catalog) error {
+	indexes := cat.GetTableIndexes(l.info.Name)
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	s := &l.info.Schema
+	txn := l.opts.Txn
+	if l.stats.Appended {
+		txn = nil
+	}
+	var err error
+	tuple := &table.Tuple{}
+	for _, rid := range l.rids {
+		if !l.info.Table.GetTuple(rid, tuple, txn) {
+			continue
+		}
+		for _, idx := range indexes {
+			keyAttrs := idx.Index.GetKeyAttrs()
+			if insertErr := idx.InsertEntry(tuple.KeyFromTuple(s, &idx.KeySchema, keyAttrs), rid, txn); err == nil {
+				err = insertErr
+			}
+		}
+	}
+	return err
+}
+
+// the columns of the dump have to be the table's
+func matchSchema(dumped *schema.Schema, s *schema.Schema) error {
+	if dumped.GetColumnCount() != s.GetColumnCount() {
+		return fmt.Errorf("the dump has %d columns, the table has %d", dumped.GetColumnCount(), s.GetColumnCount())
+	}
+	for i := 0; i < s.GetColumnCount(); i++ {
+		from, to := dumped.GetColumn(i), s.GetColumn(i)
+		if from.GetName() != to.GetName() || from.GetType() != to.GetType() {
+			return fmt.Errorf("column %d of the dump is %s %s, the table has %s %s", i,
+				from.GetName(), types.TypeIDToString(from.GetType()), to.GetName(), types.TypeIDToString(to.GetType()))
+		}
+	}
+	return nil
+}
+
+// map every column of the schema to its field in the header
+func mapHeader(header []string, s *schema.Schema) ([]int, error) {
+	colMap := make([]int, s.GetColumnCount())
+	for i := range colMap {
+		colMap[i] = -1
+	}
+	for fieldIdx, name := range header {
+		name = strings.TrimSpace(name)
+		colIdx := s.GetColIdx(name)
+		if colIdx < 0 {
+			return nil, fmt.Errorf("header: unknown column %q", name)
+		}
+		if colMap[colIdx] >= 0 {
+			return nil, fmt.Errorf("header: duplicate column %q", name)
+		}
+		colMap[colIdx] = fieldIdx
+	}
+	return colMap, nil
+}
+
+/**
+ * Convert a CSV field into a value of the given type.
+ * @param field the field as read from the file
+ * @param id type of the column
+ * @param nullString fields equal to it are NULL
+ */
+func convert(field string, id types.TypeID, nullString string) (*types.Value, error) {
+	if field == nullString {
+		return types.NewValue(id), nil
+	}
+	if id != types.VARCHAR {
+		field = strings.TrimSpace(field)
+	}
+	val, err := types.NewValue(types.VARCHAR, field).CastAs(id)
+	if err != nil {
+		var numErr *strconv.NumError
+		if errors.As(err, &numErr) {
+			// the field is already part of the message
+			err = numErr.Err
+		}
+		return nil, fmt.Errorf("can't convert %q to %s: %w", field, types.TypeIDToString(id), err)
+	}
+	return val, nil
+}
+
+// a new table has only its first page and no tuple on it
+func isEmpty(heap *table.TableHeap) bool {
+	if heap.GetFreeSpaceMap().GetPageCount() != 1 {
+		return false
+	}
+	_, ok := heap.Begin(nil).Next()
+	return !ok
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package bulkload
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log/level"
+	"github.com/stretchr/testify/assert"
+	"goostub/buffer"
+	"goostub/catalog"
+	"goostub/common"
+	"goostub/schema"
+	"goostub/storage/disk"
+	"goostub/storage/table"
+	"goostub/tools/export"
+	"goostub/types"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func init() {
+	common.InitLogger(level.AllowNone())
+}
+
+func newTestCatalog(t *testing.T) *catalog.Catalog {
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	bpm := buffer.NewBufferPoolManager(16, dm, nil)
+	return catalog.NewCatalog(bpm, nil, nil)
+}
+
+func newTestTable(t *testing.T, cat *catalog.Catalog) *catalog.TableInfo {
+	s, err := schema.ParseSchema("id:INTEGER, name:VARCHAR, score:DECIMAL, ok:BOOLEAN")
+	assert.Nil(t, err)
+	info := cat.CreateTable(nil, "t", s)
+	idx := cat.CreateIndex(nil, "idx_id", "t", s, schema.CopySchema(s, []uint32{0}), []string{"id"}, 8, catalog.IndexOptions{})
+	assert.NotNil(t, idx)
+	return info
+}
+
+func TestLoad(t *testing.T) {
+	a := assert.New(t)
+	cat := newTestCatalog(t)
+	info := newTestTable(t, cat)
+
+	// columns in another order than the table, ok is missing
+	b := &strings.Builder{}
+	b.WriteString("name,score,id\n")
+	for i := 0; i < 5000; i++ {
+		if i%10 == 0 {
+			fmt.Fprintf(b, "\"user, %d\",,%d\n", i, i)
+		} else {
+			fmt.Fprintf(b, "user%d,%d.5,%d\n", i, i, i)
+		}
+	}
+
+	stats, err := Load(cat, "t", strings.NewReader(b.String()), Options{})
+	a.Nil(err)
+	a.Equal(5000, stats.Rows)
+	a.True(stats.Appended)
+	a.Greater(info.Table.GetFreeSpaceMap().GetPageCount(), 10)
+
+	count := 0
+	it := info.Table.Begin(nil)
+	for tuple, ok := it.Next(); ok; tuple, ok = it.Next() {
+		a.Equal(int32(count), tupleID(tuple, &info.Schema))
+		a.True(tuple.IsNull(&info.Schema, 3))
+		a.Equal(count%10 == 0, tuple.IsNull(&info.Schema, 2))
+		if count%10 == 0 {
+			a.Equal(fmt.Sprintf("user, %d", count), tuple.GetValue(&info.Schema, 1).String())
+		}
+		count++
+	}
+	a.Equal(5000, count)
+
+	// the index was built at the end
+	idx := cat.GetIndexByName("idx_id", "t")
+	keySchema := &idx.KeySchema
+	for _, id := range []int32{0, 1234, 4999} {
+		var rids []common.RID
+		key := table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, id)}, keySchema)
+		idx.Index.ScanKey(key, &rids, nil)
+		a.Len(rids, 1)
+		tuple := &table.Tuple{}
+		a.True(info.Table.GetTuple(rids[0], tuple, nil))
+		a.Equal(id, tupleID(tuple, &info.Schema))
+	}
+
+	// a second load goes through the regular insert path
+	stats, err = Load(cat, "t", strings.NewReader("id\n5000\n"), Options{})
+	a.Nil(err)
+	a.False(stats.Appended)
+	a.Equal(1, stats.Rows)
+	var rids []common.RID
+	idx.Index.ScanKey(table.NewTuple([]*types.Value{types.NewValue(types.INTEGER, int32(5000))}, keySchema), &rids, nil)
+	a.Len(rids, 1)
+}
+
+func TestLoadBadRows(t *testing.T) {
+	a := assert.New(t)
+	data := "id;ok;name\n1;true;a\n2;maybe;b\n3;f\n99999999999;t;c\n4;1;NULL\n"
+
+	cat := newTestCatalog(t)
+	newTestTable(t, cat)
+	stats, err := Load(cat, "t", strings.NewReader(data), Options{Comma: ';'})
+	var rowErr *RowError
+	a.True(errors.As(err, &rowErr))
+	a.Equal(3, rowErr.Line)
+	a.Equal("ok", rowErr.Column)
+	a.Contains(err.Error(), "line 3, column ok")
+	// what was read before the bad row is in the table
+	a.Equal(1, stats.Rows)
+
+	cat = newTestCatalog(t)
+	info := newTestTable(t, cat)
+	stats, err = Load(cat, "t", strings.NewReader(data), Options{Comma: ';', NullString: "NULL", SkipBadRows: true})
+	a.Nil(err)
+	a.Equal(2, stats.Rows)
+	a.Len(stats.BadRows, 3)
+	a.Equal([]int{3, 4, 5}, []int{stats.BadRows[0].Line, stats.BadRows[1].Line, stats.BadRows[2].Line})
+	a.Equal("", stats.BadRows[1].Column)
+	a.Equal("id", stats.BadRows[2].Column)
+	a.Contains(stats.BadRows[2].Error(), "value out of range")
+
+	tuple, ok := info.Table.Begin(nil).Next()
+	a.True(ok)
+	a.Equal("(1, a, <NULL>, true)", tuple.String(&info.Schema))
+
+	// a row the CSV reader can't parse
+	cat = newTestCatalog(t)
+	newTestTable(t, cat)
+	stats, err = Load(cat, "t", strings.NewReader("id,name\n1,a\nx\"y,b\n2,c\n"), Options{SkipBadRows: true})
+	a.Nil(err)
+	a.Equal(2, stats.Rows)
+	a.Len(stats.BadRows, 1)
+	a.Equal(3, stats.BadRows[0].Line)
+	var parseErr *csv.ParseError
+	a.True(errors.As(stats.BadRows[0], &parseErr))
+
+	_, err = Load(cat, "t", strings.NewReader("id,nope\n"), Options{})
+	a.EqualError(err, `header: unknown column "nope"`)
+	_, err = Load(cat, "missing", strings.NewReader("id\n"), Options{})
+	a.Error(err)
+}
+
+func tupleID(tuple *table.Tuple, s *schema.Schema) int32 {
+	var id int32
+	fmt.Sscan(tuple.GetValue(s, 0).String(), &id)
+	return id
+}
+
+func TestLoadExported(t *testing.T) {
+	a := assert.New(t)
+	src := newTestCatalog(t)
+	srcInfo := newTestTable(t, src)
+	data := "id,name,score,ok\n1,x,0.1,t\n\\N,\\N,\\N,\\N\n-2147483648,,1e300,0\n"
+	_, err := Load(src, "t", strings.NewReader(data), Options{NullString: `\N`})
+	a.Nil(err)
+
+	for _, format := range []export.Format{export.CSV, export.Dump} {
+		out := &bytes.Buffer{}
+		_, err := export.Export(src, "t", out, format, export.Options{NullString: `\N`})
+		a.Nil(err)
+
+		dst := newTestCatalog(t)
+		dstInfo := newTestTable(t, dst)
+		var stats *Stats
+		if format == export.Dump {
+			dump, err := export.NewDumpReader(out)
+			a.Nil(err)
+			stats, err = LoadDump(dst, "t", dump, Options{})
+			a.Nil(err)
+		} else {
+			stats, err = Load(dst, "t", out, Options{NullString: `\N`})
+			a.Nil(err)
+		}
+		a.Equal(3, stats.Rows)
+
+		// the tuples are identical, NULLs included
+		srcIt, dstIt := srcInfo.Table.Begin(nil), dstInfo.Table.Begin(nil)
+		for expected, ok := srcIt.Next(); ok; expected, ok = srcIt.Next() {
+			tuple, ok := dstIt.Next()
+			a.True(ok)
+			a.Equal(expected.GetData(), tuple.GetData(), format.String())
+		}
+		_, ok := dstIt.Next()
+		a.False(ok)
+	}
+
+	// a dump only goes into a table with the same columns
+	out := &bytes.Buffer{}
+	_, err = export.Export(src, "t", out, export.Dump, export.Options{})
+	a.Nil(err)
+	dst := newTestCatalog(t)
+	s, _ := schema.ParseSchema("id:BIGINT, name:VARCHAR, score:DECIMAL, ok:BOOLEAN")
+	dst.CreateTable(nil, "t", s)
+	dump, err := export.NewDumpReader(out)
+	a.Nil(err)
+	_, err = LoadDump(dst, "t", dump, Options{})
+	a.EqualError(err, "column 0 of the dump is id INTEGER, the table has id BIGINT")
+}
+
+func TestLoadExportedAltered(t *testing.T) {
+	a := assert.New(t)
+	src := newTestCatalog(t)
+	srcInfo := newTestTable(t, src)
+	_, err := Load(src, "t", strings.NewReader("id,name,score,ok\n1,x,0.5,t\n"), Options{})
+	a.Nil(err)
+	// the tuple in the table keeps the old layout
+	a.Nil(src.AlterTableDropColumn("t", "name", nil))
+	a.Nil(src.AlterTableAddColumn("t", "level", types.INTEGER, types.NewValue(types.INTEGER, int32(7)), nil))
+
+	out := &bytes.Buffer{}
+	_, err = export.Export(src, "t", out, export.Dump, export.Options{})
+	a.Nil(err)
+	dump, err := export.NewDumpReader(out)
+	a.Nil(err)
+
+	// into a table that was altered the same way
+	dst := newTestCatalog(t)
+	dstInfo := newTestTable(t, dst)
+	a.Nil(dst.AlterTableDropColumn("t", "name", nil))
+	a.Nil(dst.AlterTableAddColumn("t", "level", types.INTEGER, nil, nil))
+	stats, err := LoadDump(dst, "t", dump, Options{})
+	a.Nil(err)
+	a.Equal(1, stats.Rows)
+
+	expected, _ := srcInfo.Table.Begin(nil).Next()
+	tuple, ok := dstInfo.Table.Begin(nil).Next()
+	a.True(ok)
+	a.Equal("(1, 0.5, true, 7)", tuple.String(&dstInfo.Schema))
+	a.Equal(expected.String(&srcInfo.Schema), tuple.String(&dstInfo.Schema))
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"goostub/schema"
+	"goostub/storage/table"
+	"goostub/types"
+	"io"
+)
+
+/**
+ * Binary dump format, all integers are little endian:
+ *
+ * ------------------------------------------------------------------------
+ * | Magic (8) | Version (4) | ColumnCount (4) | Column(1) | ... | Column(n) |
+ * ------------------------------------------------------------------------
+ * | Tuple(1) | ... | Tuple(m) | EndMarker (4) | TupleCount (8) |
+ * ------------------------------------------------------------------------
+ *
+ * Column: NameLength (4) | Name | TypeID (4)
+ * Tuple: the output of Tuple.SerializeTo, i.e. Length (4) | Data
+ *
+ * The tuple data is kept as laid out by the schema, null bitmap included, so
+ * values come back bit for bit. Tuples of an altered table are rewritten in
+ * the layout of a new table with the same columns, the one the reader uses.
+ * The end marker can't be a tuple length and the tuple count detects a
+ * truncated file.
+ */
+
+const (
+	dumpMagic   = "GOOSDUMP"
+	dumpVersion = uint32(2)
+	dumpEnd     = ^uint32(0)
+)
+
+var ErrCorruptDump = errors.New("corrupt dump")
+
+type DumpWriter struct {
+	w *bufio.Writer
+	// schema of the tuples, and the layout they are dumped in if it was altered
+	schema *schema.Schema
+	layout *schema.Schema
+	buf    bytes.Buffer
+	count  uint64
+	err    error
+}
+
+/**
+ * Create a dump writer and write the header with the schema.
+ * @param w where the dump is written
+ * @param s schema of the dumped tuples
+ */
+func NewDumpWriter(w io.Writer, s *schema.Schema) *DumpWriter {
+	dw := &DumpWriter{w: bufio.NewWriter(w), schema: s, layout: s}
+	if s.GetVersion() != 0 {
+		dw.layout = schema.NewSchema(s.GetColumns())
+	}
+	dw.buf.WriteString(dumpMagic)
+	binary.Write(&dw.buf, binary.LittleEndian, dumpVersion)
+	binary.Write(&dw.buf, binary.LittleEndian, uint32(s.GetColumnCount()))
+	for _, col := range s.GetColumns() {
+		binary.Write(&dw.buf, binary.LittleEndian, uint32(len(col.GetName())))
+		dw.buf.WriteString(col.GetName())
+		binary.Write(&dw.buf, binary.LittleEndian, uint32(col.GetType()))
+	}
+	_, dw.err = dw.w.Write(dw.buf.Bytes())
+	return dw
+}
+
+func (dw *DumpWriter) Write(tuple *table.Tuple) error {
+	if dw.err != nil {
+		return dw.err
+	}
+	dw.buf.Reset()
+	if dw.layout != dw.schema {
+		tuple = tuple.Relayout(dw.schema, dw.layout)
+	}
+	tuple.SerializeTo(&dw.buf)
+	if _, err := dw.w.Write(dw.buf.Bytes()); err != nil {
+		dw.err = err
+		return err
+	}
+	dw.count++
+	return nil
+}
+
+// write the end of the dump and flush
+func (dw *DumpWriter) Close() error {
+	if dw.err != nil {
+		return dw.err
+	}
+	binary.Write(dw.w, binary.LittleEndian, dumpEnd)
+	binary.Write(dw.w, binary.LittleEndian, dw.count)
+	return dw.w.Flush()
+}
+
+type DumpReader struct {
+	r      *bufio.Reader
+	schema *schema.Schema
+	count  uint64
+	done   bool
+}
+
+/**
+ * Open a dump and read its header.
+ * @param r the dump, as written by DumpWriter
+ * @return a reader positioned before the first tuple
+ */
+func NewDumpReader(r io.Reader) (*DumpReader, error) {
+	dr := &DumpReader{r: bufio.NewReader(r)}
+
+	magic := make([]byte, len(dumpMagic))
+	if _, err := io.ReadFull(dr.r, magic); err != nil || string(magic) != dumpMagic {
+		return nil, fmt.Errorf("%w: not a dump file", ErrCorruptDump)
+	}
+	var version, numColumns uint32
+	if err := dr.read(&version); err != nil {
+		return nil, err
+	}
+	if version != dumpVersion {
+		return nil, fmt.Errorf("unsupported dump version %d", version)
+	}
+	if err := dr.read(&numColumns); err != nil {
+		return nil, err
+	}
+
+	columns := make([]schema.Column, numColumns)
+	for i := range columns {
+		var nameLen, typeId uint32
+		if err := dr.read(&nameLen); err != nil {
+			return nil, err
+		}
+		name := make([]byte, nameLen)
+		if _, err := io.ReadFull(dr.r, name); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptDump, err)
+		}
+		if err := dr.read(&typeId); err != nil {
+			return nil, err
+		}
+		id := types.TypeID(typeId)
+		if types.TypeIDToString(id) == "INVALID" {
+			return nil, fmt.Errorf("%w: column %s has unknown type %d", ErrCorruptDump, name, typeId)
+		}
+		columns[i] = *schema.NewColumn(string(name), id, nil)
+	}
+	dr.schema = schema.NewSchema(columns)
+	return dr, nil
+}
+
+// the schema of the dumped table
+func (dr *DumpReader) Schema() *schema.Schema {
+	return dr.schema
+}
+
+/**
+ * Read the next tuple of the dump.
+ * @return the tuple, or io.EOF after the last one
+ */
+func (dr *DumpReader) Next() (*table.Tuple, error) {
+	if dr.done {
+		return nil, io.EOF
+	}
+
+	var size uint32
+	if err := dr.read(&size); err != nil {
+		return nil, err
+	}
+	if size == dumpEnd {
+		var count uint64
+		if err := dr.read(&count); err != nil {
+			return nil, err
+		}
+		if count != dr.count {
+			return nil, fmt.Errorf("%w: expected %d tuples, read %d", ErrCorruptDump, count, dr.count)
+		}
+		dr.done = true
+		return nil, io.EOF
+	}
+	if size < dr.schema.GetLength() {
+		return nil, fmt.Errorf("%w: tuple %d is too short", ErrCorruptDump, dr.count)
+	}
+
+	data := make([]byte, 4+size)
+	binary.LittleEndian.PutUint32(data, size)
+	if _, err := io.ReadFull(dr.r, data[4:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptDump, err)
+	}
+	tuple := &table.Tuple{}
+	tuple.DeserializeFrom(bytes.NewBuffer(data))
+	dr.count++
+	return tuple, nil
+}
+
+// read a fixed size value, running out of data means the dump is truncated
+func (dr *DumpReader) read(v interface{}) error {
+	if err := binary.Read(dr.r, binary.LittleEndian, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptDump, err)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"goostub/catalog"
+	"goostub/common"
+	"goostub/schema"
+	"goostub/storage/table"
+	"goostub/types"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/**
+ * export writes every tuple of a table in one of three formats:
+ *
+ * CSV: a header with the column names, then one record per tuple. Values
+ * are written with Value.ToString, which the bulk loader can parse back, and
+ * NULL is written as Options.NullString.
+ *
+ * JSON Lines: one object per tuple mapping column names to values. Numbers
+ * and booleans are JSON numbers and booleans, NULL is null, and varchars and
+ * timestamps are strings.
+ *
+ * Dump: a compact binary format carrying the schema and the tuples as stored
+ * in the table heap, so that it can be re-imported exactly. See DumpWriter.
+ */
+
+type Format int
+
+const (
+	CSV Format = iota
+	JSONLines
+	Dump
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "csv":
+		return CSV, nil
+	case "jsonl", "json":
+		return JSONLines, nil
+	case "dump", "binary":
+		return Dump, nil
+	}
+	return 0, fmt.Errorf("unknown export format %q", s)
+}
+
+func (f Format) String() string {
+	switch f {
+	case CSV:
+		return "csv"
+	case JSONLines:
+		return "jsonl"
+	case Dump:
+		return "dump"
+	}
+	return "unknown"
+}
+
+type Options struct {
+	// field delimiter of CSV, ',' if unset
+	Comma rune
+	// how NULL is written in CSV
+	NullString string
+	// transaction performing the scan, may be nil
+	Txn common.Transaction
+}
+
+/**
+ * Write every tuple of a catalog table to w.
+ * @param cat catalog containing the table
+ * @param tableName name of the table to export
+ * @param w where the table is written
+ * @param format output format
+ * @param opts export options
+ * @return the number of tuples written
+ */
+func Export(cat *catalog.Catalog, tableName string, w io.Writer, format Format, opts Options) (int, error) {
+	info := cat.GetTableByName(tableName)
+	if info == nil {
+		return 0, fmt.Errorf("table %q doesn't exist", tableName)
+	}
+	return ExportTable(info.Table, &info.Schema, w, format, opts)
+}
+
+/**
+ * Same as Export, for a table heap that isn't in a catalog.
+ * @param heap the table to export
+ * @param s schema of the tuples of the table
+ */
+func ExportTable(heap *table.TableHeap, s *schema.Schema, w io.Writer, format Format, opts Options) (int, error) {
+	var tw tupleWriter
+	switch format {
+	case CSV:
+		tw = newCSVWriter(w, s, opts)
+	case JSONLines:
+		tw = newJSONWriter(w, s)
+	case Dump:
+		tw = NewDumpWriter(w, s)
+	default:
+		return 0, fmt.Errorf("unknown export format %d", format)
+	}
+
+	count := 0
+	it := heap.Begin(opts.Txn)
+	for tuple, ok := it.Next(); ok; tuple, ok = it.Next() {
+		if err := tw.Write(tuple); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if opts.Txn != nil && opts.Txn.GetState() == common.Aborted {
+		return count, fmt.Errorf("transaction %d got aborted during the scan", opts.Txn.GetTransactionId())
+	}
+	return count, tw.Close()
+}
+
+// the writer of one format, the header is written when the writer is created
+type tupleWriter interface {
+	Write(tuple *table.Tuple) error
+	// write whatever the format needs at the end and flush
+	Close() error
+}
+
+type csvWriter struct {
+	w          *csv.Writer
+	schema     *schema.Schema
+	nullString string
+	record     []string
+	err        error
+}
+
+func newCSVWriter(w io.Writer, s *schema.Schema, opts Options) *csvWriter {
+	cw := &csvWriter{
+		w:          csv.NewWriter(w),
+		schema:     s,
+		nullString: opts.NullString,
+		record:     make([]string, s.GetColumnCount()),
+	}
+	if opts.Comma != 0 {
+		cw.w.Comma = opts.Comma
+	}
+	for i := range cw.record {
+		cw.record[i] = s.GetColumn(i).GetName()
+	}
+	cw.err = cw.w.Write(cw.record)
+	return cw
+}
+
+func (cw *csvWriter) Write(tuple *table.Tuple) error {
+	if cw.err != nil {
+		return cw.err
+	}
+	for i := range cw.record {
+		val := tuple.GetValue(cw.schema, i)
+		if val.IsNull() {
+			cw.record[i] = cw.nullString
+		} else {
+			cw.record[i] = val.String()
+		}
+	}
+	return cw.w.Write(cw.record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type jsonWriter struct {
+	w      *bufio.Writer
+	schema *schema.Schema
+	// column names, already encoded as JSON strings
+	names [][]byte
+	line  bytes.Buffer
+}
+
+func newJSONWriter(w io.Writer, s *schema.Schema) *jsonWriter {
+	jw := &jsonWriter{
+		w:      bufio.NewWriter(w),
+		schema: s,
+		names:  make([][]byte, s.GetColumnCount()),
+	}
+	for i := range jw.names {
+		jw.names[i], _ = json.Marshal(s.GetColumn(i).GetName())
+	}
+	return jw
+}
+
+func (jw *jsonWriter) Write(tuple *table.Tuple) error {
+	// build the object by hand to keep the column order of the schema
+	jw.line.Reset()
+	jw.line.WriteByte('{')
+	for i, name := range jw.names {
+		if i > 0 {
+			jw.line.WriteByte(',')
+		}
+		jw.line.Write(name)
+		jw.line.WriteByte(':')
+		jw.line.Write(jsonValue(tuple.GetValue(jw.schema, i)))
+	}
+	jw.line.WriteString("}\n")
+	_, err := jw.w.Write(jw.line.Bytes())
+	return err
+}
+
+func (jw *jsonWriter) Close() error {
+	return jw.w.Flush()
+}
+
+func jsonValue(val *types.Value) []byte {
+	if val.IsNull() {
+		return []byte("null")
+	}
+	s := val.String()
+	switch val.GetTypeID() {
+	case types.VARCHAR, types.TIMESTAMP:
+		b, _ := json.Marshal(s)
+		return b
+	case types.DECIMAL:
+		// JSON has no NaN or infinity
+		if f, err := strconv.ParseFloat(s, 64); err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			b, _ := json.Marshal(s)
+			return b
+		}
+	}
+	return []byte(s)
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package export
+
+import (
+	"bytes"
+	"errors"
+	"github.com/go-kit/kit/log/level"
+	"github.com/stretchr/testify/assert"
+	"goostub/buffer"
+	"goostub/catalog"
+	"goostub/common"
+	"goostub/schema"
+	"goostub/storage/disk"
+	"goostub/storage/table"
+	"goostub/types"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func init() {
+	common.InitLogger(level.AllowNone())
+}
+
+func newTestTable(t *testing.T) (*catalog.Catalog, *catalog.TableInfo) {
+	dm := disk.NewDiskManager(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(dm.ShutDown)
+	cat := catalog.NewCatalog(buffer.NewBufferPoolManager(16, dm, nil), nil, nil)
+	s, err := schema.ParseSchema("id:INTEGER, name:VARCHAR, price:DECIMAL, ok:BOOLEAN, ts:TIMESTAMP")
+	assert.Nil(t, err)
+	info := cat.CreateTable(nil, "t", s)
+
+	rows := [][]*types.Value{
+		{
+			types.NewValue(types.INTEGER, int32(1)),
+			types.NewValue(types.VARCHAR, "say \"hi\", bye"),
+			types.NewValue(types.DECIMAL, 0.1),
+			types.NewValue(types.BOOLEAN, int8(1)),
+			types.NewValue(types.TIMESTAMP, uint64(1650000000123456789)),
+		},
+		{
+			types.NewValue(types.INTEGER),
+			types.NewValue(types.VARCHAR),
+			types.NewValue(types.DECIMAL),
+			types.NewValue(types.BOOLEAN),
+			types.NewValue(types.TIMESTAMP),
+		},
+		{
+			types.NewValue(types.INTEGER, types.GOOSTUB_INT32_NULL),
+			types.NewValue(types.VARCHAR, ""),
+			types.NewValue(types.DECIMAL, 1e300),
+			types.NewValue(types.BOOLEAN, int8(0)),
+			types.NewValue(types.TIMESTAMP, uint64(0)),
+		},
+	}
+	for _, row := range rows {
+		var rid common.RID
+		assert.True(t, info.Table.InsertTuple(table.NewTuple(row, s), &rid, nil))
+	}
+	return cat, info
+}
+
+func TestExportText(t *testing.T) {
+	a := assert.New(t)
+	cat, _ := newTestTable(t)
+
+	out := &bytes.Buffer{}
+	count, err := Export(cat, "t", out, CSV, Options{NullString: `\N`})
+	a.Nil(err)
+	a.Equal(3, count)
+	a.Equal("id,name,price,ok,ts\n"+
+		"1,\"say \"\"hi\"\", bye\",0.1,true,2022-04-15T05:20:00.123456789Z\n"+
+		"\\N,\\N,\\N,\\N,\\N\n"+
+		"-2147483648,,1E+300,false,1970-01-01T00:00:00Z\n", out.String())
+
+	out.Reset()
+	_, err = Export(cat, "t", out, JSONLines, Options{})
+	a.Nil(err)
+	a.Equal(`{"id":1,"name":"say \"hi\", bye","price":0.1,"ok":true,"ts":"2022-04-15T05:20:00.123456789Z"}`+"\n"+
+		`{"id":null,"name":null,"price":null,"ok":null,"ts":null}`+"\n"+
+		`{"id":-2147483648,"name":"","price":1E+300,"ok":false,"ts":"1970-01-01T00:00:00Z"}`+"\n", out.String())
+
+	_, err = Export(cat, "missing", out, CSV, Options{})
+	a.Error(err)
+}
+
+func TestExportDump(t *testing.T) {
+	a := assert.New(t)
+	cat, info := newTestTable(t)
+
+	out := &bytes.Buffer{}
+	count, err := Export(cat, "t", out, Dump, Options{})
+	a.Nil(err)
+	a.Equal(3, count)
+	dump := out.Bytes()
+
+	dr, err := NewDumpReader(bytes.NewReader(dump))
+	a.Nil(err)
+	a.Equal(info.Schema.String(), dr.Schema().String())
+	it := info.Table.Begin(nil)
+	for expected, ok := it.Next(); ok; expected, ok = it.Next() {
+		tuple, err := dr.Next()
+		a.Nil(err)
+		a.Equal(expected.GetData(), tuple.GetData())
+	}
+	_, err = dr.Next()
+	a.Equal(io.EOF, err)
+
+	// a truncated dump is detected, whether the cut is within a tuple or at its end
+	for _, size := range []int{len(dump) - 12, len(dump) - 20} {
+		dr, err = NewDumpReader(bytes.NewReader(dump[:size]))
+		a.Nil(err)
+		for err == nil {
+			_, err = dr.Next()
+		}
+		a.True(errors.Is(err, ErrCorruptDump), size)
+	}
+
+	_, err = NewDumpReader(bytes.NewReader([]byte("id,name\n")))
+	a.True(errors.Is(err, ErrCorruptDump))
+}
@@ -0,0 +1,453 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package pageinspect
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"goostub/common"
+	"goostub/storage/page"
+	"goostub/storage/page/htable"
+	"io"
+	"strings"
+	"unsafe"
+)
+
+/**
+ * pageinspect decodes raw pages of a database file for debugging, in the
+ * spirit of postgres' pageinspect extension. Pages carry no type tag, so the
+ * caller has to say what kind of page it expects to find.
+ */
+
+type PageType int
+
+const (
+	TablePageType PageType = iota
+	HashDirectoryPageType
+	HashBucketPageType
+	HashHeaderPageType
+)
+
+func ParsePageType(s string) (PageType, error) {
+	switch strings.ToLower(s) {
+	case "table":
+		return TablePageType, nil
+	case "directory", "hash_directory":
+		return HashDirectoryPageType, nil
+	case "bucket", "hash_bucket":
+		return HashBucketPageType, nil
+	case "header", "hash_header":
+		return HashHeaderPageType, nil
+	}
+	return 0, fmt.Errorf("unknown page type %q", s)
+}
+
+func (t PageType) String() string {
+	switch t {
+	case TablePageType:
+		return "table"
+	case HashDirectoryPageType:
+		return "directory"
+	case HashBucketPageType:
+		return "bucket"
+	case HashHeaderPageType:
+		return "header"
+	}
+	return "unknown"
+}
+
+// every decoded page can be rendered as text, and as json through its struct tags
+type PageInfo interface {
+	WriteText(w io.Writer)
+}
+
+/**
+ * Read a page directly from a database file. We don't go through the
+ * DiskManager because it creates a log file next to the database file,
+ * which an inspection tool has no business doing.
+ *
+ * @param r the database file
+ * @param pid id of the page to read
+ * @return the page, or an error if the page is not in the file
+ */
+func ReadPage(r io.ReaderAt, pid common.PageID) (page.Page, error) {
+	p := page.NewPage()
+	n, err := r.ReadAt(p.GetData(), int64(pid)*common.PageSize)
+	if n < common.PageSize {
+		if err == nil || err == io.EOF {
+			err = fmt.Errorf("page %d is past the end of file", pid)
+		}
+		return nil, err
+	}
+	p.(*page.PageInstance).PageID = pid
+	return p, nil
+}
+
+/**
+ * Decode a page.
+ *
+ * @param p the page to decode
+ * @param t the type of the page
+ * @param keySize size of a key in bytes, only used for hash bucket pages, 0 for variable-length keys
+ * @return the decoded page
+ */
+func Inspect(p page.Page, t PageType, keySize uint32) (PageInfo, error) {
+	switch t {
+	case TablePageType:
+		return InspectTablePage(p)
+	case HashDirectoryPageType:
+		return InspectDirectoryPage(p)
+	case HashBucketPageType:
+		if keySize == 0 {
+			return InspectVarBucketPage(p)
+		}
+		return InspectBucketPage(p, keySize)
+	case HashHeaderPageType:
+		return InspectHeaderPage(p)
+	}
+	return nil, fmt.Errorf("unknown page type %d", t)
+}
+
+func WriteJSON(w io.Writer, info PageInfo) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(info)
+}
+
+/*****************************/
+/********Table Page***********/
+/*****************************/
+
+type SlotInfo struct {
+	Slot    uint32 `json:"slot"`
+	Offset  uint32 `json:"offset"`
+	Size    uint32 `json:"size"`
+	Deleted bool   `json:"deleted"` // marked deleted but not yet applied
+	Empty   bool   `json:"empty"`   // free slot that can be reused
+	Data    string `json:"data,omitempty"`
+}
+
+type TablePageInfo struct {
+	PageID             common.PageID `json:"page_id"`
+	LSN                common.LSN    `json:"lsn"`
+	PrevPageID         common.PageID `json:"prev_page_id"`
+	NextPageID         common.PageID `json:"next_page_id"`
+	FreeSpacePointer   uint32        `json:"free_space_pointer"`
+	FreeSpaceRemaining uint32        `json:"free_space_remaining"`
+	TupleCount         uint32        `json:"tuple_count"`
+	Slots              []SlotInfo    `json:"slots"`
+}
+
+func InspectTablePage(p page.Page) (*TablePageInfo, error) {
+	tp := page.PageAsTablePage(p)
+	info := &TablePageInfo{
+		PageID:           tp.GetTablePageId(),
+		LSN:              tp.GetLSN(),
+		PrevPageID:       tp.GetPrevPageId(),
+		NextPageID:       tp.GetNextPageId(),
+		FreeSpacePointer: tp.GetFreeSpacePointer(),
+		TupleCount:       tp.GetTupleCount(),
+	}
+
+	slotArrayEnd := page.SizeTablePageHeader + page.SizeTableSlot*uint64(info.TupleCount)
+	if info.FreeSpacePointer > common.PageSize || slotArrayEnd > uint64(info.FreeSpacePointer) {
+		return nil, fmt.Errorf("page %d is not a table page: tuple count %d, free space pointer %d",
+			p.GetPageID(), info.TupleCount, info.FreeSpacePointer)
+	}
+	info.FreeSpaceRemaining = tp.GetFreeSpaceRemaining()
+
+	data := p.GetData()
+	for i := uint32(0); i < info.TupleCount; i++ {
+		size := tp.GetTupleSize(i)
+		slot := SlotInfo{
+			Slot:    i,
+			Offset:  tp.GetTupleOffsetAtSlot(i),
+			Size:    page.UnsetDeletedFlag(size),
+			Deleted: size != page.UnsetDeletedFlag(size),
+			Empty:   size == 0,
+		}
+		if !slot.Empty {
+			end := uint64(slot.Offset) + uint64(slot.Size)
+			if end > common.PageSize {
+				return nil, fmt.Errorf("slot %d of page %d points past the end of the page", i, p.GetPageID())
+			}
+			slot.Data = hex.EncodeToString(data[slot.Offset:end])
+		}
+		info.Slots = append(info.Slots, slot)
+	}
+	return info, nil
+}
+
+func (info *TablePageInfo) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "======== TABLE PAGE (page id: %d) ========\n", info.PageID)
+	fmt.Fprintf(w, "LSN: %d, PrevPageId: %d, NextPageId: %d\n", info.LSN, info.PrevPageID, info.NextPageID)
+	fmt.Fprintf(w, "FreeSpacePointer: %d, FreeSpaceRemaining: %d, TupleCount: %d\n",
+		info.FreeSpacePointer, info.FreeSpaceRemaining, info.TupleCount)
+	fmt.Fprintln(w, "| slot | offset | size | state |")
+	for _, slot := range info.Slots {
+		state := "live"
+		if slot.Empty {
+			state = "empty"
+		} else if slot.Deleted {
+			state = "deleted"
+		}
+		fmt.Fprintf(w, "|  %d  |  %d  |  %d  |  %s  | %s\n", slot.Slot, slot.Offset, slot.Size, state, slot.Data)
+	}
+	fmt.Fprintln(w, "================ END TABLE PAGE ================")
+}
+
+/*****************************/
+/*****Hash Directory Page*****/
+/*****************************/
+
+type DirectoryEntry struct {
+	BucketIdx    uint32        `json:"bucket_idx"`
+	BucketPageID common.PageID `json:"bucket_page_id"`
+	LocalDepth   uint8         `json:"local_depth"`
+}
+
+type DirectoryPageInfo struct {
+	PageID      common.PageID    `json:"page_id"`
+	LSN         common.LSN       `json:"lsn"`
+	GlobalDepth uint32           `json:"global_depth"`
+	MaxDepth    uint32           `json:"max_depth"`
+	BucketIDs   []common.PageID  `json:"bucket_ids"` // distinct bucket pages, in directory order
+	Entries     []DirectoryEntry `json:"entries"`
+
+	dir *htable.HashTableDirectoryPage
+}
+
+func InspectDirectoryPage(p page.Page) (*DirectoryPageInfo, error) {
+	dir := htable.PageAsDirectoryPage(p)
+	if dir.GetGlobalDepth() > dir.GetMaxDepth() || dir.GetMaxDepth() > htable.DirectoryMaxDepth {
+		return nil, fmt.Errorf("page %d is not a hash directory page: global depth %d", p.GetPageID(), dir.GetGlobalDepth())
+	}
+
+	info := &DirectoryPageInfo{
+		PageID:      dir.GetPageId(),
+		LSN:         dir.GetLSN(),
+		GlobalDepth: dir.GetGlobalDepth(),
+		MaxDepth:    dir.GetMaxDepth(),
+		dir:         dir,
+	}
+	seen := make(map[common.PageID]struct{})
+	for idx := uint32(0); idx < dir.Size(); idx++ {
+		entry := DirectoryEntry{
+			BucketIdx:    idx,
+			BucketPageID: dir.GetBucketPageId(idx),
+			LocalDepth:   dir.GetLocalDepth(idx),
+		}
+		if _, ok := seen[entry.BucketPageID]; !ok {
+			seen[entry.BucketPageID] = struct{}{}
+			info.BucketIDs = append(info.BucketIDs, entry.BucketPageID)
+		}
+		info.Entries = append(info.Entries, entry)
+	}
+	return info, nil
+}
+
+func (info *DirectoryPageInfo) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "page id: %d, LSN: %d, max depth: %d, buckets: %v\n", info.PageID, info.LSN, info.MaxDepth, info.BucketIDs)
+	info.dir.FprintDirectory(w)
+}
+
+/*****************************/
+/******Hash Header Page*******/
+/*****************************/
+
+type HeaderEntry struct {
+	DirectoryIdx    uint32        `json:"directory_idx"`
+	DirectoryPageID common.PageID `json:"directory_page_id"`
+}
+
+type HeaderPageInfo struct {
+	PageID            common.PageID `json:"page_id"`
+	LSN               common.LSN    `json:"lsn"`
+	MaxDepth          uint32        `json:"max_depth"`
+	DirectoryMaxDepth uint32        `json:"directory_max_depth"`
+	HashFunc          string        `json:"hash_func"`
+	Entries           []HeaderEntry `json:"entries"` // the slots that have a directory
+}
+
+func InspectHeaderPage(p page.Page) (*HeaderPageInfo, error) {
+	header := htable.PageAsHeaderPage(p)
+	if header.GetMaxDepth() > htable.HeaderMaxDepth || header.GetDirectoryMaxDepth() > htable.DirectoryMaxDepth {
+		return nil, fmt.Errorf("page %d is not a hash header page: max depth %d, directory max depth %d",
+			p.GetPageID(), header.GetMaxDepth(), header.GetDirectoryMaxDepth())
+	}
+
+	info := &HeaderPageInfo{
+		PageID:            header.GetPageId(),
+		LSN:               header.GetLSN(),
+		MaxDepth:          header.GetMaxDepth(),
+		DirectoryMaxDepth: header.GetDirectoryMaxDepth(),
+		HashFunc:          header.GetHashSpec().String(),
+	}
+	for idx := uint32(0); idx < header.MaxSize(); idx++ {
+		if pid := header.GetDirectoryPageId(idx); pid != common.InvalidPageID {
+			info.Entries = append(info.Entries, HeaderEntry{DirectoryIdx: idx, DirectoryPageID: pid})
+		}
+	}
+	return info, nil
+}
+
+func (info *HeaderPageInfo) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "======== HEADER (page id: %d) ========\n", info.PageID)
+	fmt.Fprintf(w, "LSN: %d, MaxDepth: %d, DirectoryMaxDepth: %d, hash: %s\n", info.LSN, info.MaxDepth, info.DirectoryMaxDepth, info.HashFunc)
+	fmt.Fprintln(w, "| directory idx | page id |")
+	for _, e := range info.Entries {
+		fmt.Fprintf(w, "|     %d     |     %d     |\n", e.DirectoryIdx, e.DirectoryPageID)
+	}
+	fmt.Fprintln(w, "================ END HEADER ================")
+}
+
+/*****************************/
+/******Hash Bucket Page*******/
+/*****************************/
+
+type RIDInfo struct {
+	PageID  common.PageID `json:"page_id"`
+	SlotNum uint32        `json:"slot_num"`
+}
+
+type BucketEntry struct {
+	BucketIdx uint32  `json:"bucket_idx"`
+	Readable  bool    `json:"readable"` // false for tombstones
+	Key       string  `json:"key"`
+	Value     RIDInfo `json:"value"`
+}
+
+type BucketPageInfo struct {
+	PageID      common.PageID `json:"page_id"`
+	KeySize     uint32        `json:"key_size"`
+	Capacity    uint32        `json:"capacity"`
+	NumReadable uint32        `json:"num_readable"`
+	Occupied    string        `json:"occupied"` // one character per slot, 1 = set
+	Readable    string        `json:"readable"`
+	Entries     []BucketEntry `json:"entries"`
+}
+
+func InspectBucketPage(p page.Page, keySize uint32) (*BucketPageInfo, error) {
+	kvSize := keySize + uint32(unsafe.Sizeof(common.RID{}))
+	if keySize == 0 || 2+kvSize > common.PageSize {
+		return nil, fmt.Errorf("invalid key size %d for a hash bucket page", keySize)
+	}
+
+	bucket := htable.PageAsBucketPage(p, keySize)
+	info := &BucketPageInfo{
+		PageID:      p.GetPageID(),
+		KeySize:     keySize,
+		Capacity:    bucket.BucketArraySize(),
+		NumReadable: bucket.NumReadable(),
+	}
+
+	occupied, readable := &strings.Builder{}, &strings.Builder{}
+	for idx := uint32(0); idx < info.Capacity; idx++ {
+		occupied.WriteString(bitString(bucket.IsOccupied(idx)))
+		readable.WriteString(bitString(bucket.IsReadable(idx)))
+		if !bucket.IsOccupied(idx) {
+			continue
+		}
+		rid := bucket.ValueAt(idx)
+		info.Entries = append(info.Entries, BucketEntry{
+			BucketIdx: idx,
+			Readable:  bucket.IsReadable(idx),
+			Key:       hex.EncodeToString(bucket.KeyAt(idx)),
+			Value:     RIDInfo{PageID: rid.GetPageId(), SlotNum: rid.GetSlotNum()},
+		})
+	}
+	info.Occupied = occupied.String()
+	info.Readable = readable.String()
+	return info, nil
+}
+
+func (info *BucketPageInfo) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "======== BUCKET (page id: %d) ========\n", info.PageID)
+	fmt.Fprintf(w, "KeySize: %d, Capacity: %d, Readable: %d\n", info.KeySize, info.Capacity, info.NumReadable)
+	fmt.Fprintf(w, "occupied: %s\n", info.Occupied)
+	fmt.Fprintf(w, "readable: %s\n", info.Readable)
+	fmt.Fprintln(w, "| bucket idx | key | page id | slot num |")
+	for _, e := range info.Entries {
+		if !e.Readable {
+			fmt.Fprintf(w, "|     %d     | (tombstone) |\n", e.BucketIdx)
+			continue
+		}
+		fmt.Fprintf(w, "|     %d     | %s |     %d     |     %d     |\n", e.BucketIdx, e.Key, e.Value.PageID, e.Value.SlotNum)
+	}
+	fmt.Fprintln(w, "================ END BUCKET ================")
+}
+
+func bitString(set bool) string {
+	if set {
+		return "1"
+	}
+	return "0"
+}
+
+/*****************************/
+/****Hash Var Bucket Page*****/
+/*****************************/
+
+type VarBucketEntry struct {
+	SlotIdx uint32 `json:"slot_idx"`
+	Hash    uint32 `json:"hash"`
+	Key     string `json:"key"` // empty for overflowed keys
+	// the first overflow page and the length of an overflowed key
+	OverflowPageID common.PageID `json:"overflow_page_id"`
+	OverflowLength uint32        `json:"overflow_length"`
+	Value          RIDInfo       `json:"value"`
+}
+
+type VarBucketPageInfo struct {
+	PageID    common.PageID    `json:"page_id"`
+	NumSlots  uint32           `json:"num_slots"`
+	FreeSpace uint32           `json:"free_space"`
+	Entries   []VarBucketEntry `json:"entries"`
+}
+
+func InspectVarBucketPage(p page.Page) (*VarBucketPageInfo, error) {
+	bucket := htable.PageAsVarBucketPage(p)
+	if htable.SizeVarBucketHeader+bucket.NumSlots()*htable.SizeVarBucketSlot > common.PageSize {
+		return nil, fmt.Errorf("page %d is not a variable-length bucket page: %d slots", p.GetPageID(), bucket.NumSlots())
+	}
+
+	info := &VarBucketPageInfo{
+		PageID:    p.GetPageID(),
+		NumSlots:  bucket.NumSlots(),
+		FreeSpace: bucket.FreeSpace(),
+	}
+	for idx := uint32(0); idx < info.NumSlots; idx++ {
+		rid := bucket.ValueAt(idx)
+		entry := VarBucketEntry{
+			SlotIdx:        idx,
+			Hash:           bucket.HashAt(idx),
+			OverflowPageID: common.InvalidPageID,
+			Value:          RIDInfo{PageID: rid.GetPageId(), SlotNum: rid.GetSlotNum()},
+		}
+		if bucket.IsOverflowAt(idx) {
+			entry.OverflowPageID, entry.OverflowLength = htable.ParseOverflowRef(bucket.KeyAt(idx))
+		} else {
+			entry.Key = hex.EncodeToString(bucket.KeyAt(idx))
+		}
+		info.Entries = append(info.Entries, entry)
+	}
+	return info, nil
+}
+
+func (info *VarBucketPageInfo) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "======== VARIABLE-LENGTH BUCKET (page id: %d) ========\n", info.PageID)
+	fmt.Fprintf(w, "Slots: %d, FreeSpace: %d\n", info.NumSlots, info.FreeSpace)
+	fmt.Fprintln(w, "| slot idx | hash | key | page id | slot num |")
+	for _, e := range info.Entries {
+		key := e.Key
+		if e.OverflowPageID != common.InvalidPageID {
+			key = fmt.Sprintf("(%d bytes from page %d)", e.OverflowLength, e.OverflowPageID)
+		}
+		fmt.Fprintf(w, "|     %d     | %08x | %s |     %d     |     %d     |\n", e.SlotIdx, e.Hash, key, e.Value.PageID, e.Value.SlotNum)
+	}
+	fmt.Fprintln(w, "================ END BUCKET ================")
+}
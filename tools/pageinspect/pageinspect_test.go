@@ -0,0 +1,186 @@
+// Copyright (c) 2022 Qitian Zeng
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package pageinspect
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"goostub/common"
+	"goostub/hash"
+	"goostub/storage/page"
+	"goostub/storage/page/htable"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInspectTablePage(t *testing.T) {
+	a := assert.New(t)
+	p := page.NewPage()
+	tp := page.PageAsTablePage(p)
+	tp.Init(3, common.PageSize, 2)
+
+	var rid common.RID
+	for i := 0; i < 3; i++ {
+		a.True(tp.InsertTuple([]byte{byte(i), 0xab, 0xcd}, &rid, nil, nil))
+	}
+	a.True(tp.MarkDelete(common.NewRID(3, 1), nil, nil))
+	tp.ApplyDelete(common.NewRID(3, 2), nil)
+
+	info, err := InspectTablePage(p)
+	a.Nil(err)
+	a.Equal(common.PageID(3), info.PageID)
+	a.Equal(common.PageID(2), info.PrevPageID)
+	a.Equal(common.PageID(common.InvalidPageID), info.NextPageID)
+	a.Equal(uint32(3), info.TupleCount)
+	a.Equal(uint32(common.PageSize-6), info.FreeSpacePointer)
+	a.Len(info.Slots, 3)
+	a.Equal("00abcd", info.Slots[0].Data)
+	a.False(info.Slots[0].Deleted)
+	a.True(info.Slots[1].Deleted)
+	a.Equal(uint32(3), info.Slots[1].Size)
+	a.True(info.Slots[2].Empty)
+
+	buf := &bytes.Buffer{}
+	info.WriteText(buf)
+	a.Contains(buf.String(), "deleted")
+	a.Contains(buf.String(), "empty")
+}
+
+func TestInspectGarbageAsTablePage(t *testing.T) {
+	p := page.NewPage()
+	for i := range p.GetData() {
+		p.GetData()[i] = 0xff
+	}
+	_, err := InspectTablePage(p)
+	assert.Error(t, err)
+}
+
+func TestInspectDirectoryPage(t *testing.T) {
+	a := assert.New(t)
+	p := page.NewPage()
+	dir := htable.PageAsDirectoryPage(p)
+	dir.SetPageId(7)
+	dir.SetMaxDepth(htable.DirectoryMaxDepth)
+	dir.SetBucketPageId(0, 8)
+	dir.IncrGlobalDepth()
+	dir.SetBucketPageId(1, 9)
+	dir.SetLocalDepth(0, 1)
+	dir.SetLocalDepth(1, 1)
+
+	info, err := InspectDirectoryPage(p)
+	a.Nil(err)
+	a.Equal(common.PageID(7), info.PageID)
+	a.Equal(uint32(1), info.GlobalDepth)
+	a.Equal([]common.PageID{8, 9}, info.BucketIDs)
+	a.Equal(uint32(htable.DirectoryMaxDepth), info.MaxDepth)
+	a.Equal(DirectoryEntry{BucketIdx: 1, BucketPageID: 9, LocalDepth: 1}, info.Entries[1])
+
+	buf := &bytes.Buffer{}
+	info.WriteText(buf)
+	a.Contains(buf.String(), "DIRECTORY (global depth: 1)")
+}
+
+func TestInspectHeaderPage(t *testing.T) {
+	a := assert.New(t)
+	p := page.NewPage()
+	header := htable.PageAsHeaderPage(p)
+	header.Init(3, 2, 5)
+	header.SetHashSpec(hash.HashSpec{Id: hash.SeededXXHash, Seed: 42})
+	header.SetDirectoryPageId(header.HashToDirectoryIndex(0xC0000000), 11)
+
+	info, err := InspectHeaderPage(p)
+	a.Nil(err)
+	a.Equal(common.PageID(3), info.PageID)
+	a.Equal(uint32(5), info.DirectoryMaxDepth)
+	a.Equal("xxhash(seed=42)", info.HashFunc)
+	a.Equal([]HeaderEntry{{DirectoryIdx: 3, DirectoryPageID: 11}}, info.Entries)
+
+	buf := &bytes.Buffer{}
+	info.WriteText(buf)
+	a.Contains(buf.String(), "hash: xxhash(seed=42)")
+
+	// a max depth of 10 isn't a header
+	p.GetData()[8] = 10
+	_, err = InspectHeaderPage(p)
+	a.Error(err)
+}
+
+func TestInspectBucketPage(t *testing.T) {
+	a := assert.New(t)
+	p := page.NewPage()
+	bucket := htable.PageAsBucketPage(p, 4)
+	a.True(bucket.Insert([]byte{1, 2, 3, 4}, common.NewRID(5, 6)))
+	a.True(bucket.Insert([]byte{4, 3, 2, 1}, common.NewRID(5, 7)))
+	a.True(bucket.Remove([]byte{1, 2, 3, 4}, common.NewRID(5, 6)))
+
+	info, err := InspectBucketPage(p, 4)
+	a.Nil(err)
+	a.Equal(uint32(1), info.NumReadable)
+	a.True(strings.HasPrefix(info.Occupied, "110"))
+	a.True(strings.HasPrefix(info.Readable, "010"))
+	a.Len(info.Entries, 2)
+	a.False(info.Entries[0].Readable)
+	a.Equal("04030201", info.Entries[1].Key)
+	a.Equal(RIDInfo{PageID: 5, SlotNum: 7}, info.Entries[1].Value)
+
+	buf := &bytes.Buffer{}
+	a.Nil(WriteJSON(buf, info))
+	decoded := &BucketPageInfo{}
+	a.Nil(json.Unmarshal(buf.Bytes(), decoded))
+	a.Equal(info, decoded)
+}
+
+func TestInspectVarBucketPage(t *testing.T) {
+	a := assert.New(t)
+	p := page.NewPage()
+	bucket := htable.PageAsVarBucketPage(p)
+	a.True(bucket.Insert([]byte("alice"), false, 0xabcd, common.NewRID(5, 6)))
+	a.True(bucket.Insert(htable.OverflowRef(9, 1000), true, 0x1234, common.NewRID(5, 7)))
+	a.True(bucket.Insert([]byte("bob"), false, 1, common.NewRID(5, 8)))
+	bucket.RemoveAt(0)
+
+	info, err := Inspect(p, HashBucketPageType, 0)
+	a.Nil(err)
+	varInfo := info.(*VarBucketPageInfo)
+	a.Equal(uint32(2), varInfo.NumSlots)
+	a.Equal(uint32(common.PageSize-htable.SizeVarBucketHeader-2*htable.SizeVarBucketSlot-3-htable.SizeOverflowRef), varInfo.FreeSpace)
+	// the last entry took the slot of the removed one
+	a.Equal(hex.EncodeToString([]byte("bob")), varInfo.Entries[0].Key)
+	a.Equal(RIDInfo{PageID: 5, SlotNum: 8}, varInfo.Entries[0].Value)
+	a.Equal(common.PageID(9), varInfo.Entries[1].OverflowPageID)
+	a.Equal(uint32(1000), varInfo.Entries[1].OverflowLength)
+
+	buf := &bytes.Buffer{}
+	info.WriteText(buf)
+	a.Contains(buf.String(), "(1000 bytes from page 9)")
+}
+
+func TestReadPage(t *testing.T) {
+	a := assert.New(t)
+	p := page.NewPage()
+	page.PageAsTablePage(p).Init(1, common.PageSize, common.InvalidPageID)
+
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	data := make([]byte, 2*common.PageSize)
+	copy(data[common.PageSize:], p.GetData())
+	a.Nil(os.WriteFile(fileName, data, 0666))
+
+	f, err := os.Open(fileName)
+	a.Nil(err)
+	defer f.Close()
+
+	read, err := ReadPage(f, 1)
+	a.Nil(err)
+	a.Equal(p.GetData(), read.GetData())
+	a.Equal(common.PageID(1), read.GetPageID())
+
+	_, err = ReadPage(f, 2)
+	a.Error(err)
+}
@@ -61,13 +61,15 @@ func (t *BooleanType) ToString(v *Value) (string, error) {
 		log.Fatalln("BooleanType member function called from non-boolean type")
 	}
 
-	if v.val.(int8) == 1 {
-		return "true", nil
-	} else if v.val.(int8) == 0 {
+	if v.IsNull() {
+		return "boolean_null", nil
+	}
+
+	if v.val.(int8) == 0 {
 		return "false", nil
 	}
 
-	return "boolean_null", nil
+	return "true", nil
 }
 
 func (t *BooleanType) SerializeTo(v *Value, storage *bytes.Buffer) error {
@@ -13,11 +13,11 @@ const (
 	DBL_LOWEST = -math.MaxFloat64
 	FLT_LOWEST = -math.MaxFloat32
 
-	// Min values
-	GOOSTUB_INT8_MIN      int8    = math.MinInt8 + 1
-	GOOSTUB_INT16_MIN     int16   = math.MinInt16 + 1
-	GOOSTUB_INT32_MIN     int32   = math.MinInt32 + 1
-	GOOSTUB_INT64_MIN     int64   = math.MinInt64 + 1
+	// Min values, the whole range is usable since NULL is not stored in-band
+	GOOSTUB_INT8_MIN      int8    = math.MinInt8
+	GOOSTUB_INT16_MIN     int16   = math.MinInt16
+	GOOSTUB_INT32_MIN     int32   = math.MinInt32
+	GOOSTUB_INT64_MIN     int64   = math.MinInt64
 	GOOSTUB_DECIMAL_MIN   float64 = FLT_LOWEST
 	GOOSTUB_TIMESTAMP_MIN uint64  = 0
 	GOOSTUB_DATE_MIN      uint32  = 0
@@ -34,7 +34,8 @@ const (
 	GOOSTUB_DATE_MAX      uint64  = math.MaxInt32
 	GOOSTUB_BOOLEAN_MAX   int8    = 1
 
-	// Null values
+	// Null values, only the placeholder held by a NULL Value. NULL is tracked
+	// by Value.IsNull and by the tuple's null bitmap, not by these values.
 	GOOSTUB_VALUE_NULL     uint32  = math.MaxUint32
 	GOOSTUB_INT8_NULL      int8    = math.MinInt8
 	GOOSTUB_INT16_NULL     int16   = math.MinInt16
@@ -70,7 +70,7 @@ func (t *TimestampType) Compare(l, r *Value) (CmpResult, error) {
 			"Null Value is not comparable")
 	}
 
-	lval := r.val.(uint64)
+	lval := l.val.(uint64)
 	rval := r.val.(uint64)
 
 	var ret CmpResult
@@ -91,7 +91,21 @@ func (t *TimestampType) ToString(v *Value) (string, error) {
 	if v.IsNull() {
 		return "timestamp_null", nil
 	}
-	return time.Unix(0, int64(v.val.(uint64))).String(), nil
+	return FormatTimestamp(v.val.(uint64)), nil
+}
+
+// timestamps are nanoseconds since the unix epoch, written as RFC 3339 in UTC
+func FormatTimestamp(ts uint64) string {
+	return time.Unix(int64(ts/uint64(time.Second)), int64(ts%uint64(time.Second))).UTC().Format(time.RFC3339Nano)
+}
+
+// the reverse of FormatTimestamp, ok is false if s isn't an RFC 3339 time in the timestamp range
+func ParseTimestamp(s string) (ts uint64, ok bool) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil || t.Unix() < 0 {
+		return 0, false
+	}
+	return uint64(t.Unix())*uint64(time.Second) + uint64(t.Nanosecond()), true
 }
 
 func (t *TimestampType) SerializeTo(v *Value, storage *bytes.Buffer) error {
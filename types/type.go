@@ -10,6 +10,7 @@ import (
 	"errors"
 	"github.com/go-kit/kit/log/level"
 	"goostub/common"
+	"strings"
 )
 
 type TypeID int
@@ -114,6 +115,17 @@ func TypeIDToString(id TypeID) string {
 	return "INVALID"
 }
 
+// the reverse of TypeIDToString, case insensitive, INVALID if the name is unknown
+func StringToTypeID(name string) TypeID {
+	name = strings.ToUpper(name)
+	for id := BOOLEAN; id <= TIMESTAMP; id++ {
+		if TypeIDToString(id) == name {
+			return id
+		}
+	}
+	return INVALID
+}
+
 func GetMinValue(id TypeID) *Value {
 	switch id {
 	case BOOLEAN:
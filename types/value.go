@@ -154,7 +154,7 @@ func (v *Value) SerializeTo(storage *bytes.Buffer) error {
 }
 
 func (v *Value) DeserializeFrom(storage *bytes.Buffer, id TypeID) (*Value, error) {
-	return GetInstance(v.typeID).DeserializeFrom(storage)
+	return GetInstance(id).DeserializeFrom(storage)
 }
 
 func (v *Value) ToString() (string, error) {
@@ -169,6 +169,31 @@ func (v *Value) IsNumeric() bool {
 	return v.typeID >= TINYINT && v.typeID <= DECIMAL
 }
 
+/**
+ * @return the number a numeric value holds, a timestamp as its integer value,
+ * false for NULL and the other types
+ */
+func (v *Value) ToFloat64() (float64, bool) {
+	if v.isNull {
+		return 0, false
+	}
+	switch v.typeID {
+	case TINYINT:
+		return float64(v.val.(int8)), true
+	case SMALLINT:
+		return float64(v.val.(int16)), true
+	case INTEGER:
+		return float64(v.val.(int32)), true
+	case BIGINT:
+		return float64(v.val.(int64)), true
+	case DECIMAL:
+		return v.val.(float64), true
+	case TIMESTAMP:
+		return float64(v.val.(uint64)), true
+	}
+	return 0, false
+}
+
 // stringer for go
 func (v Value) String() string {
 	s, err := v.ToString()
@@ -316,78 +341,58 @@ func newValueFromInt32(id TypeID, i int32) *Value {
 	return nil
 }
 
+// NULL is tracked by the isNull flag only, so every value of the domain,
+// including the old in-band sentinels, can be stored. Use NewValue(id) to
+// construct a NULL.
+
 func newValueFromInt64(id TypeID, i int64) *Value {
-	value := newNullValue(id)
-	var nullval interface{}
+	value := &Value{typeID: id}
 	switch id {
 	case BOOLEAN:
 		value.val = int8(i)
-		nullval = GOOSTUB_BOOLEAN_NULL
 	case TINYINT:
 		value.val = int8(i)
-		nullval = GOOSTUB_INT8_NULL
 	case SMALLINT:
 		value.val = int16(i)
-		nullval = GOOSTUB_INT16_NULL
 	case INTEGER:
 		value.val = int32(i)
-		nullval = GOOSTUB_INT32_NULL
 	case BIGINT:
 		value.val = int64(i)
-		nullval = GOOSTUB_INT64_NULL
 	case TIMESTAMP:
 		value.val = uint64(i)
-		nullval = GOOSTUB_TIMESTAMP_NULL
 	default:
 		level.Error(common.Logger).Log("Invalid Type for 8-byte Value constructor")
 		return nil
 	}
 
-	if value.val != nullval {
-		value.isNull = false
-	}
-
 	return value
 }
 
 func newValueFromUint64(id TypeID, i uint64) *Value {
-	value := newNullValue(id)
-	var nullval interface{}
+	value := &Value{typeID: id}
 	switch id {
 	case BIGINT:
 		value.val = int64(i)
-		nullval = GOOSTUB_INT64_NULL
 	case TIMESTAMP:
 		value.val = uint64(i)
-		nullval = GOOSTUB_TIMESTAMP_NULL
 	default:
 		level.Error(common.Logger).Log("Invalid Type for 8-byte Value constructor")
 		return nil
 	}
 
-	if value.val != nullval {
-		value.isNull = false
-	}
-
 	return value
 }
 
 func newValueFromFloat(id TypeID, d float64) *Value {
-	value := newNullValue(id)
-	var nullval interface{}
+	value := &Value{typeID: id}
 	switch id {
 	case DECIMAL:
 		value.val = d
-		nullval = GOOSTUB_DECIMAL_NULL
 	default:
 		level.Error(common.Logger).Log("Invalid Type for float Value constructor")
 		return nil
 	}
 
-	if value.val != nullval {
-		value.isNull = false
-	}
-
 	return value
 }
 
@@ -56,3 +56,54 @@ func TestStringIntCmp(t *testing.T) {
 	a.Nil(err)
 	a.Equal(CmpEqual, res)
 }
+
+func TestSentinelIsNotNull(t *testing.T) {
+	a := assert.New(t)
+	for _, tid := range testTypes {
+		a.True(NewValue(tid).IsNull())
+		// the old in-band NULL markers are ordinary values now
+		a.False(NewValue(tid, GetNull(tid)).IsNull(), tid)
+	}
+	a.False(NewValue(TIMESTAMP, GOOSTUB_TIMESTAMP_NULL).IsNull())
+	a.True(NewValue(TIMESTAMP).IsNull())
+}
+
+func TestTimestampString(t *testing.T) {
+	a := assert.New(t)
+	for _, ts := range []uint64{0, 1650000000123456789, GOOSTUB_TIMESTAMP_MAX} {
+		v := NewValue(TIMESTAMP, ts)
+		s := v.String()
+		back, err := NewValue(VARCHAR, s).CastAs(TIMESTAMP)
+		a.Nil(err, s)
+		res, err := back.CompareTo(v)
+		a.Nil(err)
+		a.Equal(CmpEqual, res, s)
+	}
+	a.Equal("2022-04-15T05:20:00.123456789Z", NewValue(TIMESTAMP, uint64(1650000000123456789)).String())
+	_, err := NewValue(VARCHAR, "yesterday").CastAs(TIMESTAMP)
+	a.Error(err)
+}
+
+func TestToFloat64(t *testing.T) {
+	a := assert.New(t)
+	for _, v := range []*Value{
+		NewValue(TINYINT, int8(-7)),
+		NewValue(SMALLINT, int16(-7)),
+		NewValue(INTEGER, int32(-7)),
+		NewValue(BIGINT, int64(-7)),
+		NewValue(DECIMAL, -7.0),
+	} {
+		f, ok := v.ToFloat64()
+		a.True(ok, v.GetTypeID())
+		a.Equal(-7.0, f)
+	}
+	// a timestamp is its integer value, in nanoseconds
+	f, ok := NewValue(TIMESTAMP, uint64(1650000000123456789)).ToFloat64()
+	a.True(ok)
+	a.Equal(1650000000123456789.0, f)
+
+	for _, v := range []*Value{NewValue(INTEGER), NewValue(TIMESTAMP), NewValue(VARCHAR, "7"), NewValue(BOOLEAN, int8(1))} {
+		_, ok := v.ToFloat64()
+		a.False(ok, v.GetTypeID())
+	}
+}
@@ -109,10 +109,13 @@ func (t *VarcharType) SerializeTo(v *Value, storage *bytes.Buffer) error {
 }
 
 func (t *VarcharType) DeserializeFrom(storage *bytes.Buffer) (*Value, error) {
-	var l int32
+	var l uint32
 	if err := binary.Read(storage, binary.LittleEndian, &l); err != nil {
 		return nil, err
 	}
+	if l == GOOSTUB_VALUE_NULL {
+		return NewValue(VARCHAR, ([]byte)(nil), false), nil
+	}
 	data := make([]byte, l)
 	if err := binary.Read(storage, binary.LittleEndian, data); err != nil {
 		return nil, err
@@ -168,9 +171,14 @@ func (t *VarcharType) CastAs(v *Value, id TypeID) (*Value, error) {
 		return NewValue(id, flval), nil
 	case TIMESTAMP:
 		str = v.String()
+		// either nanoseconds since the epoch or the format of ToString
 		intval, err := strconv.ParseUint(str, 0, 64)
 		if err != nil {
-			return nil, err
+			ts, ok := ParseTimestamp(str)
+			if !ok {
+				return nil, err
+			}
+			intval = ts
 		}
 		if intval > GOOSTUB_TIMESTAMP_MAX || intval < GOOSTUB_TIMESTAMP_MIN {
 			return nil, common.NewError(common.OUT_OF_RANGE, "Timestamp value out of range")